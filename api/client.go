@@ -0,0 +1,137 @@
+package api
+
+import (
+	"fmt"
+
+	"github.com/curtisnewbie/miso/miso"
+)
+
+const (
+	ServiceName = "vfm"
+)
+
+// Service-to-service endpoints.
+
+func FetchFileInfo(rail miso.Rail, req FetchFileInfoReq) (FileInfoResp, error) {
+	var r miso.GnResp[FileInfoResp]
+	err := miso.NewDynTClient(rail, "/remote/user/file/info", ServiceName).
+		AddQueryParams("fileKey", req.FileKey).
+		Get().
+		Json(&r)
+	if err != nil {
+		return FileInfoResp{}, fmt.Errorf("failed to fetch file info (vfm), fileKey: %v, %w", req.FileKey, err)
+	}
+	return r.Res()
+}
+
+func ValidateFileOwner(rail miso.Rail, req ValidateFileOwnerReq) (bool, error) {
+	var r miso.GnResp[bool]
+	err := miso.NewDynTClient(rail, "/remote/user/file/owner/validation", ServiceName).
+		AddQueryParams("fileKey", req.FileKey).
+		AddQueryParams("userNo", req.UserNo).
+		Get().
+		Json(&r)
+	if err != nil {
+		return false, fmt.Errorf("failed to validate file owner (vfm), req: %+v, %w", req, err)
+	}
+	return r.Res()
+}
+
+// List file keys of files (directories excluded) in the directory.
+func ListFilesInDir(rail miso.Rail, req ListFilesInDirReq) ([]string, error) {
+	var r miso.GnResp[[]string]
+	err := miso.NewDynTClient(rail, "/remote/user/file/indir/list", ServiceName).
+		AddQueryParams("fileKey", req.FileKey).
+		AddQueryParams("limit", fmt.Sprint(req.Limit)).
+		AddQueryParams("page", fmt.Sprint(req.Page)).
+		Get().
+		Json(&r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list files in dir (vfm), req: %+v, %w", req, err)
+	}
+	return r.Res()
+}
+
+// Open endpoints, these are called on behalf of the user propagated by the rail.
+
+func ListFiles(rail miso.Rail, req ListFileReq) (miso.PageRes[ListedFile], error) {
+	var r miso.GnResp[miso.PageRes[ListedFile]]
+	err := miso.NewDynTClient(rail, "/open/api/file/list", ServiceName).
+		PostJson(req).
+		Json(&r)
+	if err != nil {
+		return miso.PageRes[ListedFile]{}, fmt.Errorf("failed to list files (vfm), %w", err)
+	}
+	return r.Res()
+}
+
+// Make directory, the file key of the directory is returned.
+func MakeDir(rail miso.Rail, req MakeDirReq) (string, error) {
+	var r miso.GnResp[string]
+	err := miso.NewDynTClient(rail, "/open/api/file/make-dir", ServiceName).
+		PostJson(req).
+		Json(&r)
+	if err != nil {
+		return "", fmt.Errorf("failed to make dir (vfm), req: %+v, %w", req, err)
+	}
+	return r.Res()
+}
+
+// Create file using the file uploaded to mini-fstore, the file key is returned.
+func CreateFile(rail miso.Rail, req CreateFileReq) (string, error) {
+	var r miso.GnResp[string]
+	err := miso.NewDynTClient(rail, "/open/api/file/create", ServiceName).
+		PostJson(req).
+		Json(&r)
+	if err != nil {
+		return "", fmt.Errorf("failed to create file (vfm), req: %+v, %w", req, err)
+	}
+	return r.Res()
+}
+
+func MoveFileToDir(rail miso.Rail, req MoveIntoDirReq) error {
+	var r miso.GnResp[any]
+	err := miso.NewDynTClient(rail, "/open/api/file/move-to-dir", ServiceName).
+		PostJson(req).
+		Json(&r)
+	if err != nil {
+		return fmt.Errorf("failed to move file to dir (vfm), req: %+v, %w", req, err)
+	}
+	return r.Err()
+}
+
+func DeleteFile(rail miso.Rail, req DeleteFileReq) error {
+	var r miso.GnResp[any]
+	err := miso.NewDynTClient(rail, "/open/api/file/delete", ServiceName).
+		PostJson(req).
+		Json(&r)
+	if err != nil {
+		return fmt.Errorf("failed to delete file (vfm), req: %+v, %w", req, err)
+	}
+	return r.Err()
+}
+
+// Generate mini-fstore temporary token for downloading the file.
+func GenFileTempToken(rail miso.Rail, req GenerateTempTokenReq) (string, error) {
+	var r miso.GnResp[string]
+	err := miso.NewDynTClient(rail, "/open/api/file/token/generate", ServiceName).
+		PostJson(req).
+		Json(&r)
+	if err != nil {
+		return "", fmt.Errorf("failed to generate file token (vfm), req: %+v, %w", req, err)
+	}
+	return r.Res()
+}
+
+// Fetch parent directory of the file, nil is returned if the file is at root.
+func FetchParentFile(rail miso.Rail, fileKey string) (*ParentFileInfo, error) {
+	var r miso.GnResp[*ParentFileInfo]
+	err := miso.NewDynTClient(rail, "/open/api/file/parent", ServiceName).
+		AddQueryParams("fileKey", fileKey).
+		Get().
+		Json(&r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch parent file (vfm), fileKey: %v, %w", fileKey, err)
+	}
+	return r.Res()
+}
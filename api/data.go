@@ -0,0 +1,106 @@
+package api
+
+import (
+	"github.com/curtisnewbie/miso/miso"
+	"github.com/curtisnewbie/miso/util"
+)
+
+const (
+	FileTypeFile = "FILE" // file_info.file_type - file
+	FileTypeDir  = "DIR"  // file_info.file_type - directory
+)
+
+type FetchFileInfoReq struct {
+	FileKey string `form:"fileKey" desc:"file key"`
+}
+
+type FileInfoResp struct {
+	Name         string `json:"name" desc:"file name"`
+	Uuid         string `json:"uuid" desc:"file key"`
+	SizeInBytes  int64  `json:"sizeInBytes" desc:"size in bytes"`
+	UploaderNo   string `json:"uploaderNo" desc:"uploader user_no"`
+	UploaderName string `json:"uploaderName" desc:"uploader username"`
+	IsDeleted    bool   `json:"isDeleted" desc:"whether the file is logically deleted"`
+	FileType     string `json:"fileType" desc:"file type: FILE, DIR"`
+	ParentFile   string `json:"parentFile" desc:"parent directory file key"`
+	LocalPath    string `json:"localPath" desc:"deprecated, always empty"`
+	FstoreFileId string `json:"fstoreFileId" desc:"mini-fstore file id"`
+	Thumbnail    string `json:"thumbnail" desc:"mini-fstore file id of the thumbnail"`
+	MimeType     string `json:"mimeType" desc:"media type detected from the content, empty if not yet detected"`
+}
+
+type ValidateFileOwnerReq struct {
+	FileKey string `form:"fileKey" desc:"file key"`
+	UserNo  string `form:"userNo" desc:"user_no of the expected owner"`
+}
+
+type ListFilesInDirReq struct {
+	FileKey string `form:"fileKey" desc:"file key of the directory"`
+	Limit   int    `form:"limit" desc:"page size, at most 100"`
+	Page    int    `form:"page" desc:"page number, starts from 1"`
+}
+
+type ListFileReq struct {
+	Page       miso.Paging `json:"paging"`
+	Filename   *string     `json:"filename"`
+	FolderNo   *string     `json:"folderNo"`
+	FileType   *string     `json:"fileType"`
+	ParentFile *string     `json:"parentFile"`
+	Sensitive  *bool       `json:"sensitive"`
+}
+
+type ListedFile struct {
+	Id              int                `json:"id"`
+	Uuid            string             `json:"uuid" desc:"file key"`
+	Name            string             `json:"name"`
+	UploadTime      util.ETime         `json:"uploadTime"`
+	UploaderName    string             `json:"uploaderName"`
+	SizeInBytes     int64              `json:"sizeInBytes"`
+	FileCount       int                `json:"fileCount" desc:"recursive number of files (for dir)"`
+	DirCount        int                `json:"dirCount" desc:"recursive number of sub-directories (for dir)"`
+	FileType        string             `json:"fileType"`
+	UpdateTime      util.ETime         `json:"updateTime"`
+	ParentFileName  string             `json:"parentFileName"`
+	SensitiveMode   string             `json:"sensitiveMode"`
+	ThumbnailToken  string             `json:"thumbnailToken"`
+	MimeType        string             `json:"mimeType" desc:"media type detected from the content"`
+	ThumbnailStatus string             `json:"thumbnailStatus" desc:"thumbnail status: PENDING, DONE, FAILED, UNSUPPORTED"`
+	Excerpt         string             `json:"excerpt" desc:"short text excerpt of pdf or text-like file"`
+	Variants        []ThumbnailVariant `json:"variants" desc:"thumbnail variants in different sizes, ordered by width"`
+}
+
+type ThumbnailVariant struct {
+	Name   string `json:"name" desc:"variant name: small, medium, large"`
+	Width  int    `json:"width"`
+	Height int    `json:"height"`
+	Token  string `json:"token" desc:"mini-fstore temporary token for downloading the variant"`
+}
+
+type MakeDirReq struct {
+	ParentFile string `json:"parentFile" desc:"parent directory file key"`
+	Name       string `json:"name" desc:"name of the directory"`
+}
+
+type CreateFileReq struct {
+	Filename     string `json:"filename"`
+	FstoreFileId string `json:"fstoreFileId" desc:"mini-fstore upload file id (the one returned by mini-fstore after upload)"`
+	ParentFile   string `json:"parentFile" desc:"parent directory file key"`
+}
+
+type MoveIntoDirReq struct {
+	Uuid           string `json:"uuid" desc:"file key"`
+	ParentFileUuid string `json:"parentFileUuid" desc:"file key of the target directory, empty for root"`
+}
+
+type DeleteFileReq struct {
+	Uuid string `json:"uuid" desc:"file key"`
+}
+
+type GenerateTempTokenReq struct {
+	FileKey string `json:"fileKey"`
+}
+
+type ParentFileInfo struct {
+	FileKey  string `json:"fileKey"`
+	Filename string `json:"fileName"`
+}
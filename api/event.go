@@ -0,0 +1,140 @@
+// Package api contains the public types of vfm that other services may import.
+package api
+
+import (
+	"github.com/curtisnewbie/miso/middleware/rabbit"
+)
+
+// Domain events published by vfm.
+//
+// Each pipeline name is suffixed with the version of the payload, a new pipeline is created when a payload is changed
+// in an incompatible way, so that consumers can migrate at their own pace.
+var (
+	FileCreatedPipeline = rabbit.NewEventPipeline[FileCreatedEvent]("event.bus.vfm.domain.file.created.v1").
+				LogPayload().
+				MaxRetry(10).
+				Document("FileCreatedPipeline", "Published when a file or a directory is created.", "vfm")
+
+	FileMovedPipeline = rabbit.NewEventPipeline[FileMovedEvent]("event.bus.vfm.domain.file.moved.v1").
+				LogPayload().
+				MaxRetry(10).
+				Document("FileMovedPipeline", "Published when a file or a directory is moved to another directory.", "vfm")
+
+	FileRenamedPipeline = rabbit.NewEventPipeline[FileRenamedEvent]("event.bus.vfm.domain.file.renamed.v1").
+				LogPayload().
+				MaxRetry(10).
+				Document("FileRenamedPipeline", "Published when a file or a directory is renamed.", "vfm")
+
+	FileDeletedPipeline = rabbit.NewEventPipeline[FileDeletedEvent]("event.bus.vfm.domain.file.deleted.v1").
+				LogPayload().
+				MaxRetry(10).
+				Document("FileDeletedPipeline", "Published when a file or a directory is logically deleted.", "vfm")
+
+	VFolderSharedPipeline = rabbit.NewEventPipeline[VFolderSharedEvent]("event.bus.vfm.domain.vfolder.shared.v1").
+				LogPayload().
+				MaxRetry(10).
+				Document("VFolderSharedPipeline", "Published when a vfolder is shared to another user.", "vfm")
+
+	VFolderFileAddedPipeline = rabbit.NewEventPipeline[VFolderFileAddedEvent]("event.bus.vfm.domain.vfolder.file.added.v1").
+					LogPayload().
+					MaxRetry(10).
+					Document("VFolderFileAddedPipeline", "Published when files are added to a vfolder.", "vfm")
+
+	GalleryImageAddedPipeline = rabbit.NewEventPipeline[GalleryImageAddedEvent]("event.bus.vfm.domain.gallery.image.added.v1").
+					LogPayload().
+					MaxRetry(10).
+					Document("GalleryImageAddedPipeline", "Published when an image is added to a gallery.", "vfm")
+
+	GalleryImageRemovedPipeline = rabbit.NewEventPipeline[GalleryImageRemovedEvent]("event.bus.vfm.domain.gallery.image.removed.v1").
+					LogPayload().
+					MaxRetry(10).
+					Document("GalleryImageRemovedPipeline", "Published when images are removed from a gallery by the owner.", "vfm")
+
+	VersionedFileUpdatedPipeline = rabbit.NewEventPipeline[VersionedFileUpdatedEvent]("event.bus.vfm.domain.versioned.file.updated.v1").
+					LogPayload().
+					MaxRetry(10).
+					Document("VersionedFileUpdatedPipeline", "Published when a new version of a versioned file is uploaded.", "vfm")
+)
+
+// File info carried by the file events.
+type FileSnapshot struct {
+	FileKey      string `desc:"file key"`
+	Name         string `desc:"file name"`
+	FileType     string `desc:"file type: FILE, DIR"`
+	SizeInBytes  int64  `desc:"size in bytes"`
+	ParentFile   string `desc:"parent directory file key"`
+	UploaderNo   string `desc:"uploader user_no"`
+	UploaderName string `desc:"uploader username"`
+	FstoreFileId string `desc:"mini-fstore file id"`
+}
+
+type FileCreatedEvent struct {
+	File FileSnapshot `desc:"created file"`
+	Time int64        `desc:"when the event happened (epoch milliseconds)"`
+}
+
+type FileMovedEvent struct {
+	File           FileSnapshot `desc:"moved file"`
+	PrevParentFile string       `desc:"parent directory file key before the file is moved"`
+	Time           int64        `desc:"when the event happened (epoch milliseconds)"`
+}
+
+type FileRenamedEvent struct {
+	File     FileSnapshot `desc:"renamed file"`
+	PrevName string       `desc:"file name before the file is renamed"`
+	Time     int64        `desc:"when the event happened (epoch milliseconds)"`
+}
+
+type FileDeletedEvent struct {
+	File FileSnapshot `desc:"deleted file"`
+	Time int64        `desc:"when the event happened (epoch milliseconds)"`
+}
+
+type VFolderSharedEvent struct {
+	FolderNo         string `desc:"vfolder no"`
+	FolderName       string `desc:"vfolder name"`
+	SharedToUserNo   string `desc:"user_no of the user that the vfolder is shared to"`
+	SharedToUsername string `desc:"username of the user that the vfolder is shared to"`
+	OperatorUserNo   string `desc:"user_no of the operator"`
+	OperatorUsername string `desc:"username of the operator"`
+	Time             int64  `desc:"when the event happened (epoch milliseconds)"`
+}
+
+type VFolderFileAddedEvent struct {
+	FolderNo         string   `desc:"vfolder no"`
+	FolderName       string   `desc:"vfolder name"`
+	FileKeys         []string `desc:"file keys of the added files"`
+	OperatorUserNo   string   `desc:"user_no of the operator"`
+	OperatorUsername string   `desc:"username of the operator"`
+	Time             int64    `desc:"when the event happened (epoch milliseconds)"`
+}
+
+type GalleryImageAddedEvent struct {
+	GalleryNo        string `desc:"gallery no"`
+	ImageNo          string `desc:"image no"`
+	ImageName        string `desc:"image name"`
+	FileKey          string `desc:"file key of the image"`
+	OperatorUserNo   string `desc:"user_no of the operator"`
+	OperatorUsername string `desc:"username of the operator"`
+	Time             int64  `desc:"when the event happened (epoch milliseconds)"`
+}
+
+type GalleryImageRemovedEvent struct {
+	GalleryNo        string   `desc:"gallery no"`
+	ImageNos         []string `desc:"image no of the removed images"`
+	FileKeys         []string `desc:"file keys of the removed images"`
+	OperatorUserNo   string   `desc:"user_no of the operator"`
+	OperatorUsername string   `desc:"username of the operator"`
+	Time             int64    `desc:"when the event happened (epoch milliseconds)"`
+}
+
+type VersionedFileUpdatedEvent struct {
+	VerFileId        string `desc:"versioned file id"`
+	Name             string `desc:"file name"`
+	FileKey          string `desc:"file key of the new version"`
+	PrevFileKey      string `desc:"file key of the previous version"`
+	SizeInBytes      int64  `desc:"size in bytes of the new version"`
+	OperatorUserNo   string `desc:"user_no of the operator"`
+	OperatorUsername string `desc:"username of the operator"`
+	Time             int64  `desc:"when the event happened (epoch milliseconds)"`
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+)
+
+func init() {
+	register("login", command{usage: "[-u username]", desc: "login using user-vault, the token is saved in the config file", run: runLogin})
+	register("logout", command{desc: "remove the saved token", run: runLogout})
+	register("config", command{usage: "set|show", desc: "manage the config file", run: subcommands("config", map[string]command{
+		"set":  {usage: "<server|fstore|user-vault|admin-server|token> <value>", desc: "set config value", run: runConfigSet},
+		"show": {desc: "show config values", run: runConfigShow},
+	})})
+}
+
+func runLogin(ctx *cmdContext, args []string) error {
+	fs := ctx.flags("login", "[-u username]")
+	username := fs.String("u", ctx.conf.Username, "username")
+	_ = fs.Parse(args)
+
+	in := bufio.NewReader(os.Stdin)
+	if *username == "" {
+		fmt.Fprint(os.Stderr, "Username: ")
+		line, _ := in.ReadString('\n')
+		*username = strings.TrimSpace(line)
+	}
+	password := os.Getenv(envPassword)
+	if password == "" {
+		// the password is echoed, set VFMCTL_PASSWORD or pipe the password through stdin in scripts
+		fmt.Fprint(os.Stderr, "Password: ")
+		line, _ := in.ReadString('\n')
+		password = strings.TrimRight(line, "\r\n")
+	}
+	if *username == "" || password == "" {
+		return fmt.Errorf("username and password are required")
+	}
+
+	token, err := ctx.client.Login(*username, password)
+	if err != nil {
+		return fmt.Errorf("login failed, %v", err)
+	}
+	ctx.conf.Username = *username
+	ctx.conf.Token = token
+	if err := ctx.conf.Save(); err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "Logged in as %v\n", *username)
+	return nil
+}
+
+func runLogout(ctx *cmdContext, args []string) error {
+	ctx.conf.Token = ""
+	return ctx.conf.Save()
+}
+
+func runConfigSet(ctx *cmdContext, args []string) error {
+	if len(args) != 2 {
+		return fmt.Errorf("usage: vfmctl config set <key> <value>")
+	}
+	f, ok := configKeys[args[0]]
+	if !ok {
+		return fmt.Errorf("unknown config key: %v", args[0])
+	}
+	*f(ctx.conf) = args[1]
+	return ctx.conf.Save()
+}
+
+func runConfigShow(ctx *cmdContext, args []string) error {
+	vals := map[string]string{"username": ctx.conf.Username, "path": ctx.conf.path}
+	for k, f := range configKeys {
+		vals[k] = *f(ctx.conf)
+	}
+	if vals["token"] != "" {
+		vals["token"] = "(hidden)"
+	}
+	return ctx.output(vals, func(w *tabwriter.Writer) {
+		keys := make([]string, 0, len(vals))
+		for k := range vals {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			fmt.Fprintf(w, "%v\t%v\n", k, vals[k])
+		}
+	})
+}
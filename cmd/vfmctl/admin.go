@@ -0,0 +1,114 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"text/tabwriter"
+
+	"github.com/curtisnewbie/miso/miso"
+)
+
+// Maintenance endpoints are sent to admin-server (defaults to server), they are not expected to be exposed publicly.
+func init() {
+	register("compensate", command{usage: "<sub-command>", desc: "maintenance: compensation and reconciliation", run: subcommands("compensate", map[string]command{
+		"thumbnail":          {desc: "compensate thumbnail generation", run: runCompensateThumbnail},
+		"thumbnail-failures": {desc: "list files that have failed thumbnail generation permanently", run: adminList("/compensate/thumbnail/failure/report")},
+		"thumbnail-variant":  {desc: "compensate thumbnail variants generation for images without variants", run: runCompensateThumbnailVariant},
+		"preview":            {desc: "compensate PDF previews and excerpts of text files", run: runCompensatePreview},
+		"image-metadata":     {desc: "compensate EXIF/XMP metadata extraction for images", run: runCompensateImageMetadata},
+		"dir-gallery":        {desc: "sync directory galleries with their directories", run: adminTrigger("/compensate/gallery/dir")},
+		"dir-size":           {usage: "[-verify]", desc: "recompute size and counts of all directories, only compare them with -verify", run: runCompensateDirSize},
+		"reconcile":          {desc: "trigger reconciliation between file_info and mini-fstore", run: adminTrigger("/compensate/reconcile/fstore")},
+		"reconcile-runs":     {desc: "list reconciliation reports", run: adminList("/compensate/reconcile/fstore/report")},
+		"gc":                 {usage: "[-dry-run]", desc: "trigger physical deletion GC", run: runGc},
+		"gc-runs":            {desc: "list physical deletion GC reports", run: adminList("/gc/physic-delete/report")},
+	})})
+}
+
+func (ctx *cmdContext) printRaw(v json.RawMessage) error {
+	var pretty any
+	if err := json.Unmarshal(v, &pretty); err != nil {
+		return err
+	}
+	// these reports are printed as json regardless of the -json flag
+	ctx.json = true
+	return ctx.output(pretty, nil)
+}
+
+func runCompensateThumbnail(ctx *cmdContext, args []string) error {
+	_ = ctx.flags("compensate thumbnail", "").Parse(args)
+	return ctx.client.AdminPost("/compensate/thumbnail", nil, nil)
+}
+
+func runCompensateThumbnailVariant(ctx *cmdContext, args []string) error {
+	_ = ctx.flags("compensate thumbnail-variant", "").Parse(args)
+	return ctx.client.AdminPost("/compensate/thumbnail/variant", nil, nil)
+}
+
+func runCompensatePreview(ctx *cmdContext, args []string) error {
+	_ = ctx.flags("compensate preview", "").Parse(args)
+	return ctx.client.AdminPost("/compensate/preview", nil, nil)
+}
+
+func runCompensateImageMetadata(ctx *cmdContext, args []string) error {
+	_ = ctx.flags("compensate image-metadata", "").Parse(args)
+	return ctx.client.AdminPost("/compensate/image/metadata", nil, nil)
+}
+
+func runCompensateDirSize(ctx *cmdContext, args []string) error {
+	fs := ctx.flags("compensate dir-size", "[-verify]")
+	verify := fs.Bool("verify", false, "only compare the stored values with a full recompute, nothing is changed")
+	_ = fs.Parse(args)
+	if !*verify {
+		return ctx.client.AdminPost("/compensate/dir/calculate-size", nil, nil)
+	}
+	var report json.RawMessage
+	if err := ctx.client.AdminPost("/compensate/dir/verify-size", nil, &report); err != nil {
+		return err
+	}
+	return ctx.printRaw(report)
+}
+
+func runGc(ctx *cmdContext, args []string) error {
+	fs := ctx.flags("compensate gc", "[-dry-run]")
+	dryRun := fs.Bool("dry-run", false, "report the files that would be processed, nothing is changed")
+	_ = fs.Parse(args)
+	if !*dryRun {
+		return adminTrigger("/gc/physic-delete/run")(ctx, nil)
+	}
+	var report json.RawMessage
+	if err := ctx.client.AdminPost("/gc/physic-delete/dry-run", nil, &report); err != nil {
+		return err
+	}
+	return ctx.printRaw(report)
+}
+
+// Trigger asynchronous maintenance task, the runNo is printed.
+func adminTrigger(url string) func(ctx *cmdContext, args []string) error {
+	return func(ctx *cmdContext, args []string) error {
+		var runNo string
+		if err := ctx.client.AdminPost(url, nil, &runNo); err != nil {
+			return err
+		}
+		return ctx.output(map[string]string{"runNo": runNo}, func(w *tabwriter.Writer) {
+			fmt.Fprintln(w, runNo)
+		})
+	}
+}
+
+// List maintenance reports.
+func adminList(url string) func(ctx *cmdContext, args []string) error {
+	return func(ctx *cmdContext, args []string) error {
+		fs := ctx.flags("compensate", "[-page n]")
+		page := fs.Int("page", 1, "page number")
+		_ = fs.Parse(args)
+		var res json.RawMessage
+		err := ctx.client.AdminPost(url, struct {
+			Paging miso.Paging `json:"paging"`
+		}{miso.Paging{Limit: 20, Page: *page}}, &res)
+		if err != nil {
+			return err
+		}
+		return ctx.printRaw(res)
+	}
+}
@@ -0,0 +1,373 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/curtisnewbie/miso/miso"
+)
+
+const (
+	headerAuthorization = "Authorization"
+	listPageLimit       = 100
+)
+
+var errNotLoggedIn = errors.New("not logged in, run 'vfmctl login' first")
+
+type Client struct {
+	conf *Config
+	http *http.Client
+}
+
+func newClient(conf *Config) *Client {
+	// no timeout, uploads and downloads may take a while
+	return &Client{conf: conf, http: &http.Client{}}
+}
+
+type apiError struct {
+	status    int
+	errorCode string
+	msg       string
+}
+
+func (e *apiError) Error() string {
+	if e.msg != "" {
+		return e.msg
+	}
+	return fmt.Sprintf("request failed, status: %d", e.status)
+}
+
+func (c *Client) newRequest(method string, u string, body io.Reader) (*http.Request, error) {
+	r, err := http.NewRequest(method, u, body)
+	if err != nil {
+		return nil, err
+	}
+	if c.conf.Token != "" {
+		r.Header.Set(headerAuthorization, c.conf.Token)
+	}
+	return r, nil
+}
+
+// Send request and parse the miso.GnResp, data is unmarshalled into res if res is not nil.
+func (c *Client) do(r *http.Request, res any) error {
+	resp, err := c.http.Do(r)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return parseResp(resp, res)
+}
+
+func parseResp(resp *http.Response, res any) error {
+	if resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden {
+		return &apiError{status: resp.StatusCode, msg: "not authorized, the token may be expired, run 'vfmctl login' again"}
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return &apiError{status: resp.StatusCode, msg: fmt.Sprintf("request failed, status: %d, body: %s", resp.StatusCode, truncate(string(body), 200))}
+	}
+	var gr miso.GnResp[json.RawMessage]
+	if err := json.Unmarshal(body, &gr); err != nil {
+		return fmt.Errorf("failed to parse response, %v, body: %s", err, truncate(string(body), 200))
+	}
+	if gr.Error {
+		return &apiError{status: resp.StatusCode, errorCode: gr.ErrorCode, msg: gr.Msg}
+	}
+	if res == nil || len(gr.Data) == 0 || string(gr.Data) == "null" {
+		return nil
+	}
+	if err := json.Unmarshal(gr.Data, res); err != nil {
+		return fmt.Errorf("failed to parse response data, %v", err)
+	}
+	return nil
+}
+
+func truncate(s string, n int) string {
+	if len(s) > n {
+		return s[:n] + "..."
+	}
+	return s
+}
+
+func (c *Client) postJson(base string, path string, req any, res any) error {
+	b, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+	r, err := c.newRequest(http.MethodPost, base+path, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	r.Header.Set("Content-Type", "application/json")
+	return c.do(r, res)
+}
+
+func (c *Client) get(base string, path string, query url.Values, res any) error {
+	u := base + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	r, err := c.newRequest(http.MethodGet, u, nil)
+	if err != nil {
+		return err
+	}
+	return c.do(r, res)
+}
+
+// POST /open/api/... on vfm.
+func (c *Client) Post(path string, req any, res any) error {
+	if c.conf.Token == "" {
+		return errNotLoggedIn
+	}
+	server, err := c.conf.serverUrl()
+	if err != nil {
+		return err
+	}
+	return c.postJson(server, path, req, res)
+}
+
+// GET /open/api/... on vfm.
+func (c *Client) Get(path string, query url.Values, res any) error {
+	if c.conf.Token == "" {
+		return errNotLoggedIn
+	}
+	server, err := c.conf.serverUrl()
+	if err != nil {
+		return err
+	}
+	return c.get(server, path, query, res)
+}
+
+// POST maintenance endpoint on vfm.
+func (c *Client) AdminPost(path string, req any, res any) error {
+	server, err := c.conf.adminServerUrl()
+	if err != nil {
+		return err
+	}
+	return c.postJson(server, path, req, res)
+}
+
+// Login using user-vault, the token is returned.
+func (c *Client) Login(username string, password string) (string, error) {
+	base, err := c.conf.userVaultUrl()
+	if err != nil {
+		return "", err
+	}
+	var token string
+	err = c.postJson(base, "/open/api/user/login", struct {
+		Username string `json:"username"`
+		Password string `json:"password"`
+	}{username, password}, &token)
+	return token, err
+}
+
+// Upload file to mini-fstore, the temporary file id is returned.
+func (c *Client) UploadFstore(name string, body io.Reader, size int64) (string, error) {
+	base, err := c.conf.fstoreUrl()
+	if err != nil {
+		return "", err
+	}
+	r, err := c.newRequest(http.MethodPut, base+"/file", body)
+	if err != nil {
+		return "", err
+	}
+	r.ContentLength = size
+	r.Header.Set("filename", name)
+	var fileId string
+	if err := c.do(r, &fileId); err != nil {
+		return "", fmt.Errorf("failed to upload file to mini-fstore, %v", err)
+	}
+	return fileId, nil
+}
+
+// Url of mini-fstore to download file using the temporary token.
+func (c *Client) FstoreDownloadUrl(token string) (string, error) {
+	base, err := c.conf.fstoreUrl()
+	if err != nil {
+		return "", err
+	}
+	return base + "/file/raw?key=" + url.QueryEscape(token), nil
+}
+
+// Download file from mini-fstore using the temporary token.
+func (c *Client) DownloadFstore(token string, w io.Writer) (int64, error) {
+	u, err := c.FstoreDownloadUrl(token)
+	if err != nil {
+		return 0, err
+	}
+	resp, err := c.http.Get(u)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("failed to download file, status: %d", resp.StatusCode)
+	}
+	return io.Copy(w, resp.Body)
+}
+
+type ListedFile struct {
+	Id             int    `json:"id"`
+	Uuid           string `json:"uuid"`
+	Name           string `json:"name"`
+	UploadTime     int64  `json:"uploadTime"`
+	UploaderName   string `json:"uploaderName"`
+	SizeInBytes    int64  `json:"sizeInBytes"`
+	FileCount      int    `json:"fileCount"`
+	DirCount       int    `json:"dirCount"`
+	FileType       string `json:"fileType"`
+	UpdateTime     int64  `json:"updateTime"`
+	SensitiveMode  string `json:"sensitiveMode"`
+	ThumbnailToken string `json:"thumbnailToken"`
+}
+
+func (f ListedFile) IsDir() bool {
+	return f.FileType == fileTypeDir
+}
+
+func (f ListedFile) UpdatedAt() time.Time {
+	return time.UnixMilli(f.UpdateTime)
+}
+
+type listFileReq struct {
+	Paging     miso.Paging `json:"paging"`
+	ParentFile *string     `json:"parentFile"`
+	FolderNo   *string     `json:"folderNo,omitempty"`
+}
+
+// List all files in the directory, dirKey is empty for the top-level files.
+func (c *Client) ListDir(dirKey string) ([]ListedFile, error) {
+	var files []ListedFile
+	for page := 1; ; page++ {
+		var res miso.PageRes[ListedFile]
+		err := c.Post("/open/api/file/list", listFileReq{
+			Paging:     miso.Paging{Limit: listPageLimit, Page: page},
+			ParentFile: &dirKey,
+		}, &res)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, res.Payload...)
+		if len(res.Payload) < listPageLimit || len(files) >= res.Page.Total {
+			return files, nil
+		}
+	}
+}
+
+// List all files in the vfolder.
+func (c *Client) ListVFolderFiles(folderNo string) ([]ListedFile, error) {
+	var files []ListedFile
+	for page := 1; ; page++ {
+		var res miso.PageRes[ListedFile]
+		err := c.Post("/open/api/file/list", listFileReq{
+			Paging:   miso.Paging{Limit: listPageLimit, Page: page},
+			FolderNo: &folderNo,
+		}, &res)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, res.Payload...)
+		if len(res.Payload) < listPageLimit || len(files) >= res.Page.Total {
+			return files, nil
+		}
+	}
+}
+
+func isNotFound(err error) bool {
+	var pe *pathError
+	return errors.As(err, &pe)
+}
+
+type pathError struct {
+	path string
+}
+
+func (e *pathError) Error() string {
+	return "no such file or directory: " + e.path
+}
+
+// Resolve remote path to file.
+//
+// Paths are separated by '/' starting from user's top-level files, e.g., /photos/2024/a.jpg, or '@' followed by
+// the file key, e.g., @ZZZ123. If multiple files in the directory share the same name, the latest one is returned.
+// The root directory is returned as a zero value with IsDir() being true.
+func (c *Client) Resolve(p string) (ListedFile, error) {
+	root := ListedFile{FileType: fileTypeDir, Name: "/"}
+	if strings.HasPrefix(p, "@") {
+		return c.findByKey(p[1:])
+	}
+	cur := root
+	for _, seg := range splitPath(p) {
+		if !cur.IsDir() {
+			return root, &pathError{path: p}
+		}
+		files, err := c.ListDir(cur.Uuid)
+		if err != nil {
+			return root, err
+		}
+		found := false
+		for _, f := range files {
+			if f.Name == seg {
+				cur, found = f, true
+				break
+			}
+		}
+		if !found {
+			return root, &pathError{path: p}
+		}
+	}
+	return cur, nil
+}
+
+// Find file by key, only the file name and file type are known.
+func (c *Client) findByKey(key string) (ListedFile, error) {
+	var parent *struct {
+		FileKey string `json:"fileKey"`
+	}
+	if err := c.Get("/open/api/file/parent", url.Values{"fileKey": {key}}, &parent); err != nil {
+		return ListedFile{}, err
+	}
+	dirKey := ""
+	if parent != nil {
+		dirKey = parent.FileKey
+	}
+	files, err := c.ListDir(dirKey)
+	if err != nil {
+		return ListedFile{}, err
+	}
+	for _, f := range files {
+		if f.Uuid == key {
+			return f, nil
+		}
+	}
+	return ListedFile{}, &pathError{path: "@" + key}
+}
+
+func splitPath(p string) []string {
+	var segs []string
+	for _, s := range strings.Split(p, "/") {
+		if s != "" && s != "." {
+			segs = append(segs, s)
+		}
+	}
+	return segs
+}
+
+// Split path into parent path and base name.
+func splitParent(p string) (string, string) {
+	segs := splitPath(p)
+	if len(segs) < 1 {
+		return "/", ""
+	}
+	return "/" + strings.Join(segs[:len(segs)-1], "/"), segs[len(segs)-1]
+}
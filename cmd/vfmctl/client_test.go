@@ -0,0 +1,67 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/curtisnewbie/miso/miso"
+)
+
+func TestResolve(t *testing.T) {
+	tree := map[string][]ListedFile{
+		"":   {{Uuid: "d1", Name: "photos", FileType: fileTypeDir}},
+		"d1": {{Uuid: "f2", Name: "a.jpg", FileType: fileTypeFile}, {Uuid: "f1", Name: "a.jpg", FileType: fileTypeFile}},
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get(headerAuthorization) != "tkn" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		var req listFileReq
+		_ = json.NewDecoder(r.Body).Decode(&req)
+		files := tree[*req.ParentFile]
+		_ = json.NewEncoder(w).Encode(miso.GnResp[miso.PageRes[ListedFile]]{
+			Data: miso.PageRes[ListedFile]{Page: miso.Paging{Total: len(files)}, Payload: files},
+		})
+	}))
+	defer srv.Close()
+
+	c := newClient(&Config{Server: srv.URL, Token: "tkn"})
+	f, err := c.Resolve("/photos/a.jpg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.Uuid != "f2" {
+		t.Fatalf("expected the latest file, got %v", f.Uuid)
+	}
+
+	root, err := c.Resolve("/")
+	if err != nil || !root.IsDir() || root.Uuid != "" {
+		t.Fatalf("unexpected root: %+v, %v", root, err)
+	}
+
+	if _, err := c.Resolve("photos/b.jpg"); !isNotFound(err) {
+		t.Fatalf("expected not found, got %v", err)
+	}
+
+	c.conf.Token = "expired"
+	if _, err := c.Resolve("/photos"); err == nil || isNotFound(err) {
+		t.Fatalf("expected unauthorized error, got %v", err)
+	}
+}
+
+func TestSplitParent(t *testing.T) {
+	for p, want := range map[string][2]string{
+		"/a/b/c.txt": {"/a/b", "c.txt"},
+		"a":          {"/", "a"},
+		"/a/./b/":    {"/a", "b"},
+		"/":          {"/", ""},
+	} {
+		parent, name := splitParent(p)
+		if parent != want[0] || name != want[1] {
+			t.Fatalf("%v, got %v %v", p, parent, name)
+		}
+	}
+}
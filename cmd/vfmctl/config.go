@@ -0,0 +1,118 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	envConfig   = "VFMCTL_CONFIG"
+	envPassword = "VFMCTL_PASSWORD"
+	envToken    = "VFMCTL_TOKEN"
+)
+
+// vfmctl configuration, saved as json in the config file.
+type Config struct {
+	Server      string `json:"server"`      // base url of vfm behind the gateway, e.g., https://example.com/vfm
+	Fstore      string `json:"fstore"`      // base url of mini-fstore, defaults to ${server origin}/fstore
+	UserVault   string `json:"userVault"`   // base url of user-vault, defaults to ${server origin}/user-vault
+	AdminServer string `json:"adminServer"` // base url of vfm for maintenance endpoints, defaults to server
+	Username    string `json:"username"`
+	Token       string `json:"token"`
+
+	path string
+}
+
+var configKeys = map[string]func(c *Config) *string{
+	"server":       func(c *Config) *string { return &c.Server },
+	"fstore":       func(c *Config) *string { return &c.Fstore },
+	"user-vault":   func(c *Config) *string { return &c.UserVault },
+	"admin-server": func(c *Config) *string { return &c.AdminServer },
+	"token":        func(c *Config) *string { return &c.Token },
+}
+
+func defaultConfigPath() string {
+	if p := os.Getenv(envConfig); p != "" {
+		return p
+	}
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		dir = "."
+	}
+	return filepath.Join(dir, "vfmctl", "config.json")
+}
+
+// Load config file, empty config is returned if the file doesn't exist, $VFMCTL_TOKEN overrides the token in either case.
+func loadConfig(path string) (*Config, error) {
+	c := &Config{path: path}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("failed to read config file %v, %v", path, err)
+		}
+	} else if err := json.Unmarshal(b, c); err != nil {
+		return nil, fmt.Errorf("failed to parse config file %v, %v", path, err)
+	}
+	if t := os.Getenv(envToken); t != "" {
+		c.Token = t
+	}
+	return c, nil
+}
+
+// Save config file, the file is only readable by current user since it contains the token.
+func (c *Config) Save() error {
+	if err := os.MkdirAll(filepath.Dir(c.path), 0o700); err != nil {
+		return fmt.Errorf("failed to create config dir, %v", err)
+	}
+	b, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(c.path, b, 0o600); err != nil {
+		return fmt.Errorf("failed to write config file %v, %v", c.path, err)
+	}
+	return nil
+}
+
+func (c *Config) serverUrl() (string, error) {
+	if c.Server == "" {
+		return "", errors.New("server is not configured, run 'vfmctl config set server <url>' first")
+	}
+	return strings.TrimSuffix(c.Server, "/"), nil
+}
+
+// Base url of service deployed next to vfm behind the same gateway.
+func (c *Config) siblingUrl(configured string, service string) (string, error) {
+	if configured != "" {
+		return strings.TrimSuffix(configured, "/"), nil
+	}
+	server, err := c.serverUrl()
+	if err != nil {
+		return "", err
+	}
+	u, err := url.Parse(server)
+	if err != nil {
+		return "", fmt.Errorf("invalid server url %v, %v", server, err)
+	}
+	return u.Scheme + "://" + u.Host + "/" + service, nil
+}
+
+func (c *Config) fstoreUrl() (string, error) {
+	return c.siblingUrl(c.Fstore, "fstore")
+}
+
+func (c *Config) userVaultUrl() (string, error) {
+	return c.siblingUrl(c.UserVault, "user-vault")
+}
+
+func (c *Config) adminServerUrl() (string, error) {
+	if c.AdminServer != "" {
+		return strings.TrimSuffix(c.AdminServer, "/"), nil
+	}
+	return c.serverUrl()
+}
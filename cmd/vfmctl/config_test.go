@@ -0,0 +1,32 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadConfigEnvToken(t *testing.T) {
+	t.Setenv(envToken, "env-tkn")
+	dir := t.TempDir()
+
+	c, err := loadConfig(filepath.Join(dir, "missing.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Token != "env-tkn" {
+		t.Fatalf("expected token from env for missing config file, got %v", c.Token)
+	}
+
+	p := filepath.Join(dir, "config.json")
+	if err := os.WriteFile(p, []byte(`{"server":"http://localhost","token":"file-tkn"}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	c, err = loadConfig(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Token != "env-tkn" || c.Server != "http://localhost" {
+		t.Fatalf("unexpected config: %+v", c)
+	}
+}
@@ -0,0 +1,598 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+)
+
+const (
+	batchModeAllOrNothing = "ALL_OR_NOTHING"
+	maxBatchItems         = 200
+)
+
+func init() {
+	register("ls", command{usage: "[-l] [path]", desc: "list files in directory", run: runLs})
+	register("tree", command{usage: "[-depth n] [path]", desc: "list files in directory recursively", run: runTree})
+	register("mkdir", command{usage: "[-p] <path>...", desc: "make directories", run: runMkdir})
+	register("mv", command{usage: "<src>... <dst>", desc: "move files into directory, or move and rename file", run: runMv})
+	register("rm", command{usage: "[-r] <path>...", desc: "delete files, directories are deleted recursively with -r", run: runRm})
+	register("upload", command{usage: "[-r] <local>... <dir>", desc: "upload local files into directory through mini-fstore", run: runUpload})
+	register("download", command{usage: "[-r] [-o local] <path>", desc: "download file, or directory with -r", run: runDownload})
+	register("share", command{usage: "<path>", desc: "generate temporary download link of the file", run: runShare})
+	register("thumbnail", command{usage: "<path>...", desc: "regenerate thumbnails of images or videos", run: runThumbnail})
+}
+
+type batchItemResult struct {
+	FileKey string `json:"fileKey"`
+	Success bool   `json:"success"`
+	ErrCode string `json:"errCode"`
+	ErrMsg  string `json:"errMsg"`
+}
+
+type batchRes struct {
+	Success bool              `json:"success"`
+	Results []batchItemResult `json:"results"`
+}
+
+func (r batchRes) firstErr() error {
+	for _, it := range r.Results {
+		if !it.Success && it.ErrMsg != "" {
+			return fmt.Errorf("%v: %v", it.FileKey, it.ErrMsg)
+		}
+	}
+	if !r.Success {
+		return fmt.Errorf("batch operation failed")
+	}
+	return nil
+}
+
+func printFiles(w *tabwriter.Writer, files []ListedFile, long bool) {
+	for _, f := range files {
+		name := f.Name
+		if f.IsDir() {
+			name += "/"
+		}
+		if !long {
+			fmt.Fprintln(w, name)
+			continue
+		}
+		size := humanSize(f.SizeInBytes)
+		fmt.Fprintf(w, "%v\t%v\t%v\t%v\n", f.FileType, size, f.UpdatedAt().Format("2006-01-02 15:04"), name)
+	}
+}
+
+func runLs(ctx *cmdContext, args []string) error {
+	fs := ctx.flags("ls", "[-l] [path]")
+	long := fs.Bool("l", false, "long format")
+	_ = fs.Parse(args)
+
+	f, err := ctx.client.Resolve(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	files := []ListedFile{f}
+	if f.IsDir() {
+		if files, err = ctx.client.ListDir(f.Uuid); err != nil {
+			return err
+		}
+	}
+	return ctx.output(files, func(w *tabwriter.Writer) { printFiles(w, files, *long) })
+}
+
+type treeNode struct {
+	ListedFile
+	Children []treeNode `json:"children,omitempty"`
+}
+
+func (ctx *cmdContext) walkTree(dir ListedFile, depth int) ([]treeNode, error) {
+	files, err := ctx.client.ListDir(dir.Uuid)
+	if err != nil {
+		return nil, err
+	}
+	nodes := make([]treeNode, 0, len(files))
+	for _, f := range files {
+		n := treeNode{ListedFile: f}
+		if f.IsDir() && depth != 1 {
+			if n.Children, err = ctx.walkTree(f, depth-1); err != nil {
+				return nil, err
+			}
+		}
+		nodes = append(nodes, n)
+	}
+	return nodes, nil
+}
+
+func printTree(w io.Writer, nodes []treeNode, indent string) {
+	for i, n := range nodes {
+		branch, next := "├── ", "│   "
+		if i == len(nodes)-1 {
+			branch, next = "└── ", "    "
+		}
+		name := n.Name
+		if n.IsDir() {
+			name += "/"
+		}
+		fmt.Fprintf(w, "%v%v%v\n", indent, branch, name)
+		printTree(w, n.Children, indent+next)
+	}
+}
+
+func runTree(ctx *cmdContext, args []string) error {
+	fs := ctx.flags("tree", "[-depth n] [path]")
+	depth := fs.Int("depth", 0, "max depth, 0 for unlimited")
+	_ = fs.Parse(args)
+
+	dir, err := ctx.client.Resolve(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	if !dir.IsDir() {
+		return fmt.Errorf("not a directory: %v", fs.Arg(0))
+	}
+	nodes, err := ctx.walkTree(dir, *depth)
+	if err != nil {
+		return err
+	}
+	return ctx.output(nodes, func(w *tabwriter.Writer) {
+		fmt.Fprintln(w, dir.Name)
+		printTree(w, nodes, "")
+	})
+}
+
+// Make directory under the parent directory, the file key is returned.
+func (c *Client) MakeDir(parentKey string, name string) (string, error) {
+	var fileKey string
+	err := c.Post("/open/api/file/make-dir", struct {
+		ParentFile string `json:"parentFile"`
+		Name       string `json:"name"`
+	}{parentKey, name}, &fileKey)
+	return fileKey, err
+}
+
+// Resolve directory, the missing ones are created if parents is true.
+func (c *Client) ResolveDir(p string, parents bool) (ListedFile, error) {
+	if strings.HasPrefix(p, "@") || !parents {
+		d, err := c.Resolve(p)
+		if err == nil && !d.IsDir() {
+			return d, fmt.Errorf("not a directory: %v", p)
+		}
+		return d, err
+	}
+	cur := ListedFile{FileType: fileTypeDir, Name: "/"}
+	for _, seg := range splitPath(p) {
+		files, err := c.ListDir(cur.Uuid)
+		if err != nil {
+			return cur, err
+		}
+		found := false
+		for _, f := range files {
+			if f.Name == seg {
+				if !f.IsDir() {
+					return cur, fmt.Errorf("not a directory: %v", f.Name)
+				}
+				cur, found = f, true
+				break
+			}
+		}
+		if !found {
+			key, err := c.MakeDir(cur.Uuid, seg)
+			if err != nil {
+				return cur, err
+			}
+			cur = ListedFile{Uuid: key, Name: seg, FileType: fileTypeDir}
+		}
+	}
+	return cur, nil
+}
+
+func runMkdir(ctx *cmdContext, args []string) error {
+	fs := ctx.flags("mkdir", "[-p] <path>...")
+	parents := fs.Bool("p", false, "make parent directories as needed, no error if existing")
+	_ = fs.Parse(args)
+	if err := requireArgs(fs, 1); err != nil {
+		return err
+	}
+
+	created := map[string]string{}
+	for _, p := range fs.Args() {
+		if *parents {
+			d, err := ctx.client.ResolveDir(p, true)
+			if err != nil {
+				return err
+			}
+			created[p] = d.Uuid
+			continue
+		}
+		parentPath, name := splitParent(p)
+		if name == "" {
+			return fmt.Errorf("invalid path: %v", p)
+		}
+		parent, err := ctx.client.ResolveDir(parentPath, false)
+		if err != nil {
+			return err
+		}
+		key, err := ctx.client.MakeDir(parent.Uuid, name)
+		if err != nil {
+			return err
+		}
+		created[p] = key
+	}
+	return ctx.output(created, func(w *tabwriter.Writer) {
+		for p, k := range created {
+			fmt.Fprintf(w, "%v\t%v\n", p, k)
+		}
+	})
+}
+
+func (c *Client) MoveToDir(fileKey string, dirKey string) error {
+	return c.Post("/open/api/file/move-to-dir", struct {
+		Uuid           string `json:"uuid"`
+		ParentFileUuid string `json:"parentFileUuid"`
+	}{fileKey, dirKey}, nil)
+}
+
+func (c *Client) Rename(fileKey string, name string) error {
+	type item struct {
+		FileKey string `json:"fileKey"`
+		Name    string `json:"name"`
+	}
+	var res batchRes
+	err := c.Post("/open/api/file/batch/rename", struct {
+		Mode  string `json:"mode"`
+		Items []item `json:"items"`
+	}{batchModeAllOrNothing, []item{{fileKey, name}}}, &res)
+	if err != nil {
+		return err
+	}
+	return res.firstErr()
+}
+
+func runMv(ctx *cmdContext, args []string) error {
+	fs := ctx.flags("mv", "<src>... <dst>")
+	_ = fs.Parse(args)
+	if err := requireArgs(fs, 2); err != nil {
+		return err
+	}
+	srcs, dstPath := fs.Args()[:fs.NArg()-1], fs.Arg(fs.NArg()-1)
+
+	dst, err := ctx.client.Resolve(dstPath)
+	if err != nil && !isNotFound(err) {
+		return err
+	}
+	if err == nil && dst.IsDir() {
+		for _, s := range srcs {
+			src, err := ctx.client.Resolve(s)
+			if err != nil {
+				return err
+			}
+			if err := ctx.client.MoveToDir(src.Uuid, dst.Uuid); err != nil {
+				return fmt.Errorf("failed to move %v, %v", s, err)
+			}
+		}
+		return nil
+	}
+
+	// move and rename
+	if len(srcs) > 1 {
+		return fmt.Errorf("target is not a directory: %v", dstPath)
+	}
+	if err == nil {
+		return fmt.Errorf("target already exists: %v", dstPath)
+	}
+	parentPath, name := splitParent(dstPath)
+	parent, err := ctx.client.ResolveDir(parentPath, false)
+	if err != nil {
+		return err
+	}
+	src, err := ctx.client.Resolve(srcs[0])
+	if err != nil {
+		return err
+	}
+	srcParentPath, _ := splitParent(srcs[0])
+	if strings.HasPrefix(srcs[0], "@") || srcParentPath != parentPath {
+		if err := ctx.client.MoveToDir(src.Uuid, parent.Uuid); err != nil {
+			return err
+		}
+	}
+	if src.Name != name {
+		return ctx.client.Rename(src.Uuid, name)
+	}
+	return nil
+}
+
+// Delete files in batch, directories must be empty when it's their turn.
+func (c *Client) DeleteFiles(fileKeys []string) error {
+	for len(fileKeys) > 0 {
+		n := len(fileKeys)
+		if n > maxBatchItems {
+			n = maxBatchItems
+		}
+		var res batchRes
+		err := c.Post("/open/api/file/batch/delete", struct {
+			Mode     string   `json:"mode"`
+			FileKeys []string `json:"fileKeys"`
+		}{batchModeAllOrNothing, fileKeys[:n]}, &res)
+		if err != nil {
+			return err
+		}
+		if err := res.firstErr(); err != nil {
+			return err
+		}
+		fileKeys = fileKeys[n:]
+	}
+	return nil
+}
+
+// Collect file keys in the directory recursively, children come before their parent.
+func (c *Client) collectPostOrder(dir ListedFile, keys []string) ([]string, error) {
+	files, err := c.ListDir(dir.Uuid)
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range files {
+		if f.IsDir() {
+			if keys, err = c.collectPostOrder(f, keys); err != nil {
+				return nil, err
+			}
+		} else {
+			keys = append(keys, f.Uuid)
+		}
+	}
+	return append(keys, dir.Uuid), nil
+}
+
+func runRm(ctx *cmdContext, args []string) error {
+	fs := ctx.flags("rm", "[-r] <path>...")
+	recursive := fs.Bool("r", false, "delete directories and their contents recursively")
+	_ = fs.Parse(args)
+	if err := requireArgs(fs, 1); err != nil {
+		return err
+	}
+
+	for _, p := range fs.Args() {
+		f, err := ctx.client.Resolve(p)
+		if err != nil {
+			return err
+		}
+		if f.Uuid == "" {
+			return fmt.Errorf("refusing to delete root directory")
+		}
+		keys := []string{f.Uuid}
+		if f.IsDir() && *recursive {
+			if keys, err = ctx.client.collectPostOrder(f, nil); err != nil {
+				return err
+			}
+		}
+		if err := ctx.client.DeleteFiles(keys); err != nil {
+			return fmt.Errorf("failed to delete %v, %v", p, err)
+		}
+	}
+	return nil
+}
+
+// Upload local file into the directory, the file key is returned.
+func (c *Client) UploadFile(local string, name string, dirKey string) (string, error) {
+	name, fileId, err := c.uploadLocal(local, name)
+	if err != nil {
+		return "", err
+	}
+	var fileKey string
+	err = c.Post("/open/api/file/create", struct {
+		Filename         string `json:"filename"`
+		FakeFstoreFileId string `json:"fstoreFileId"`
+		ParentFile       string `json:"parentFile"`
+	}{name, fileId, dirKey}, &fileKey)
+	return fileKey, err
+}
+
+func (ctx *cmdContext) uploadDir(local string, dirKey string) error {
+	d, err := ctx.client.MakeDir(dirKey, filepath.Base(local))
+	if err != nil {
+		return err
+	}
+	entries, err := os.ReadDir(local)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		p := filepath.Join(local, e.Name())
+		if e.IsDir() {
+			err = ctx.uploadDir(p, d)
+		} else if e.Type().IsRegular() {
+			fmt.Fprintf(os.Stderr, "Uploading %v\n", p)
+			_, err = ctx.client.UploadFile(p, "", d)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func runUpload(ctx *cmdContext, args []string) error {
+	fs := ctx.flags("upload", "[-r] <local>... <dir>")
+	recursive := fs.Bool("r", false, "upload directories recursively")
+	mkdir := fs.Bool("p", false, "make the target directory and its parents as needed")
+	_ = fs.Parse(args)
+	if err := requireArgs(fs, 2); err != nil {
+		return err
+	}
+	locals, dirPath := fs.Args()[:fs.NArg()-1], fs.Arg(fs.NArg()-1)
+
+	dir, err := ctx.client.ResolveDir(dirPath, *mkdir)
+	if err != nil {
+		return err
+	}
+	for _, l := range locals {
+		st, err := os.Stat(l)
+		if err != nil {
+			return err
+		}
+		if st.IsDir() {
+			if !*recursive {
+				return fmt.Errorf("%v is a directory, use -r to upload directories", l)
+			}
+			err = ctx.uploadDir(l, dir.Uuid)
+		} else {
+			fmt.Fprintf(os.Stderr, "Uploading %v\n", l)
+			_, err = ctx.client.UploadFile(l, "", dir.Uuid)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to upload %v, %v", l, err)
+		}
+	}
+	return nil
+}
+
+// Generate temporary token for downloading the file.
+func (c *Client) GenFileToken(fileKey string) (string, error) {
+	var token string
+	err := c.Post("/open/api/file/token/generate", struct {
+		FileKey string `json:"fileKey"`
+	}{fileKey}, &token)
+	return token, err
+}
+
+func (c *Client) DownloadFile(fileKey string, local string) error {
+	token, err := c.GenFileToken(fileKey)
+	if err != nil {
+		return err
+	}
+	tmp := local + ".vfmctl-part"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	_, err = c.DownloadFstore(token, f)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, local)
+}
+
+func (ctx *cmdContext) downloadDir(dir ListedFile, local string) error {
+	if err := os.MkdirAll(local, 0o755); err != nil {
+		return err
+	}
+	files, err := ctx.client.ListDir(dir.Uuid)
+	if err != nil {
+		return err
+	}
+	seen := map[string]bool{}
+	for _, f := range files {
+		if seen[f.Name] {
+			continue // shadowed by the latest one with the same name
+		}
+		seen[f.Name] = true
+		p := filepath.Join(local, f.Name)
+		if f.IsDir() {
+			err = ctx.downloadDir(f, p)
+		} else {
+			fmt.Fprintf(os.Stderr, "Downloading %v\n", p)
+			err = ctx.client.DownloadFile(f.Uuid, p)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func runDownload(ctx *cmdContext, args []string) error {
+	fs := ctx.flags("download", "[-r] [-o local] <path>")
+	recursive := fs.Bool("r", false, "download directory recursively")
+	out := fs.String("o", "", "local path, defaults to the file name in current directory")
+	_ = fs.Parse(args)
+	if err := requireArgs(fs, 1); err != nil {
+		return err
+	}
+
+	f, err := ctx.client.Resolve(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	local := *out
+	if local == "" {
+		local = f.Name
+		if f.Uuid == "" {
+			local = "."
+		}
+	}
+	if f.IsDir() {
+		if !*recursive {
+			return fmt.Errorf("%v is a directory, use -r to download directories", fs.Arg(0))
+		}
+		return ctx.downloadDir(f, local)
+	}
+	if local == "-" {
+		token, err := ctx.client.GenFileToken(f.Uuid)
+		if err != nil {
+			return err
+		}
+		_, err = ctx.client.DownloadFstore(token, os.Stdout)
+		return err
+	}
+	if st, err := os.Stat(local); err == nil && st.IsDir() {
+		local = filepath.Join(local, f.Name)
+	}
+	return ctx.client.DownloadFile(f.Uuid, local)
+}
+
+func runShare(ctx *cmdContext, args []string) error {
+	fs := ctx.flags("share", "<path>")
+	_ = fs.Parse(args)
+	if err := requireArgs(fs, 1); err != nil {
+		return err
+	}
+
+	f, err := ctx.client.Resolve(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	if f.IsDir() {
+		return fmt.Errorf("%v is a directory, share it using vfolder instead", fs.Arg(0))
+	}
+	token, err := ctx.client.GenFileToken(f.Uuid)
+	if err != nil {
+		return err
+	}
+	u, err := ctx.client.FstoreDownloadUrl(token)
+	if err != nil {
+		return err
+	}
+	return ctx.output(map[string]string{"token": token, "url": u}, func(w *tabwriter.Writer) {
+		fmt.Fprintln(w, u)
+	})
+}
+
+func runThumbnail(ctx *cmdContext, args []string) error {
+	fs := ctx.flags("thumbnail", "<path>...")
+	_ = fs.Parse(args)
+	if err := requireArgs(fs, 1); err != nil {
+		return err
+	}
+	for _, p := range fs.Args() {
+		f, err := ctx.client.Resolve(p)
+		if err != nil {
+			return err
+		}
+		if f.IsDir() {
+			return fmt.Errorf("%v is a directory", p)
+		}
+		err = ctx.client.Post("/open/api/file/thumbnail/regenerate", struct {
+			FileKey string `json:"fileKey"`
+		}{f.Uuid}, nil)
+		if err != nil {
+			return fmt.Errorf("failed to regenerate thumbnail of %v, %v", p, err)
+		}
+	}
+	return nil
+}
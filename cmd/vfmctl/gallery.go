@@ -0,0 +1,285 @@
+package main
+
+import (
+	"fmt"
+	"text/tabwriter"
+	"time"
+
+	"github.com/curtisnewbie/miso/miso"
+)
+
+func init() {
+	register("gallery", command{usage: "<sub-command>", desc: "manage galleries", run: subcommands("gallery", map[string]command{
+		"ls":     {desc: "list galleries", run: runGalleryLs},
+		"create": {usage: "<name>", desc: "create gallery", run: runGalleryCreate},
+		"rename": {usage: "<gallery> <name>", desc: "rename gallery", run: runGalleryRename},
+		"rm":     {usage: "<gallery>", desc: "delete gallery", run: runGalleryRm},
+		"images": {usage: "[-urls] <gallery>", desc: "list images in gallery", run: runGalleryImages},
+		"add":    {usage: "<gallery> <path>...", desc: "host images (or images in directories) on gallery", run: runGalleryAdd},
+		"grant":  {usage: "<gallery> <username>", desc: "grant user access to gallery", run: runGalleryGrant},
+		"revoke": {usage: "<gallery> <userNo>", desc: "remove user's access to gallery", run: runGalleryRevoke},
+		"access": {usage: "<gallery>", desc: "list users granted access to gallery", run: runGalleryAccess},
+	})})
+}
+
+type ListedGallery struct {
+	GalleryNo  string `json:"galleryNo"`
+	Name       string `json:"name"`
+	CreateBy   string `json:"createBy"`
+	IsOwner    bool   `json:"isOwner"`
+	CreateTime string `json:"createTime"`
+	UpdateTime string `json:"updateTime"`
+}
+
+func (c *Client) ListGalleries() ([]ListedGallery, error) {
+	var galleries []ListedGallery
+	for page := 1; ; page++ {
+		var res miso.PageRes[ListedGallery]
+		err := c.Post("/open/api/gallery/list", struct {
+			Paging miso.Paging `json:"paging"`
+		}{miso.Paging{Limit: listPageLimit, Page: page}}, &res)
+		if err != nil {
+			return nil, err
+		}
+		galleries = append(galleries, res.Payload...)
+		if len(res.Payload) < listPageLimit || len(galleries) >= res.Page.Total {
+			return galleries, nil
+		}
+	}
+}
+
+// Resolve gallery by galleryNo or name.
+func (c *Client) ResolveGallery(gallery string) (ListedGallery, error) {
+	galleries, err := c.ListGalleries()
+	if err != nil {
+		return ListedGallery{}, err
+	}
+	for _, g := range galleries {
+		if g.GalleryNo == gallery {
+			return g, nil
+		}
+	}
+	for _, g := range galleries {
+		if g.Name == gallery {
+			return g, nil
+		}
+	}
+	return ListedGallery{}, fmt.Errorf("gallery not found: %v", gallery)
+}
+
+type galleryNoReq struct {
+	GalleryNo string `json:"galleryNo"`
+}
+
+func runGalleryLs(ctx *cmdContext, args []string) error {
+	_ = ctx.flags("gallery ls", "").Parse(args)
+	galleries, err := ctx.client.ListGalleries()
+	if err != nil {
+		return err
+	}
+	return ctx.output(galleries, func(w *tabwriter.Writer) {
+		for _, g := range galleries {
+			owner := "GRANTED"
+			if g.IsOwner {
+				owner = "OWNER"
+			}
+			fmt.Fprintf(w, "%v\t%v\t%v\t%v\n", g.GalleryNo, owner, g.UpdateTime, g.Name)
+		}
+	})
+}
+
+func runGalleryCreate(ctx *cmdContext, args []string) error {
+	fs := ctx.flags("gallery create", "<name>")
+	_ = fs.Parse(args)
+	if err := requireArgs(fs, 1); err != nil {
+		return err
+	}
+	var g struct {
+		GalleryNo string `json:"galleryNo"`
+		Name      string `json:"name"`
+	}
+	err := ctx.client.Post("/open/api/gallery/new", struct {
+		Name string `json:"name"`
+	}{fs.Arg(0)}, &g)
+	if err != nil {
+		return err
+	}
+	return ctx.output(g, func(w *tabwriter.Writer) {
+		fmt.Fprintln(w, g.GalleryNo)
+	})
+}
+
+func runGalleryRename(ctx *cmdContext, args []string) error {
+	fs := ctx.flags("gallery rename", "<gallery> <name>")
+	_ = fs.Parse(args)
+	if err := requireArgs(fs, 2); err != nil {
+		return err
+	}
+	g, err := ctx.client.ResolveGallery(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	return ctx.client.Post("/open/api/gallery/update", struct {
+		GalleryNo string `json:"galleryNo"`
+		Name      string `json:"name"`
+	}{g.GalleryNo, fs.Arg(1)}, nil)
+}
+
+func runGalleryRm(ctx *cmdContext, args []string) error {
+	fs := ctx.flags("gallery rm", "<gallery>")
+	_ = fs.Parse(args)
+	if err := requireArgs(fs, 1); err != nil {
+		return err
+	}
+	g, err := ctx.client.ResolveGallery(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	return ctx.client.Post("/open/api/gallery/delete", galleryNoReq{g.GalleryNo}, nil)
+}
+
+type galleryImage struct {
+	ThumbnailToken string `json:"thumbnailToken"`
+	FileTempToken  string `json:"fileTempToken"`
+	ThumbnailUrl   string `json:"thumbnailUrl,omitempty"`
+	FileUrl        string `json:"fileUrl,omitempty"`
+}
+
+func runGalleryImages(ctx *cmdContext, args []string) error {
+	fs := ctx.flags("gallery images", "[-urls] <gallery>")
+	urls := fs.Bool("urls", false, "print download urls instead of the tokens")
+	_ = fs.Parse(args)
+	if err := requireArgs(fs, 1); err != nil {
+		return err
+	}
+	g, err := ctx.client.ResolveGallery(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	var images []galleryImage
+	for page := 1; ; page++ {
+		var res struct {
+			Images []galleryImage `json:"images"`
+			Paging miso.Paging    `json:"paging"`
+		}
+		err := ctx.client.Post("/open/api/gallery/images", struct {
+			GalleryNo string      `json:"galleryNo"`
+			Paging    miso.Paging `json:"paging"`
+		}{g.GalleryNo, miso.Paging{Limit: listPageLimit, Page: page}}, &res)
+		if err != nil {
+			return err
+		}
+		images = append(images, res.Images...)
+		if len(res.Images) < listPageLimit || len(images) >= res.Paging.Total {
+			break
+		}
+	}
+	if *urls {
+		for i := range images {
+			images[i].FileUrl, _ = ctx.client.FstoreDownloadUrl(images[i].FileTempToken)
+			images[i].ThumbnailUrl, _ = ctx.client.FstoreDownloadUrl(images[i].ThumbnailToken)
+		}
+	}
+	return ctx.output(images, func(w *tabwriter.Writer) {
+		for _, im := range images {
+			if *urls {
+				fmt.Fprintf(w, "%v\t%v\n", im.FileUrl, im.ThumbnailUrl)
+			} else {
+				fmt.Fprintf(w, "%v\t%v\n", im.FileTempToken, im.ThumbnailToken)
+			}
+		}
+	})
+}
+
+func runGalleryAdd(ctx *cmdContext, args []string) error {
+	fs := ctx.flags("gallery add", "<gallery> <path>...")
+	_ = fs.Parse(args)
+	if err := requireArgs(fs, 2); err != nil {
+		return err
+	}
+	g, err := ctx.client.ResolveGallery(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	type image struct {
+		GalleryNo string `json:"galleryNo"`
+		Name      string `json:"name"`
+		FileKey   string `json:"fileKey"`
+	}
+	var images []image
+	for _, p := range fs.Args()[1:] {
+		f, err := ctx.client.Resolve(p)
+		if err != nil {
+			return err
+		}
+		images = append(images, image{GalleryNo: g.GalleryNo, Name: f.Name, FileKey: f.Uuid})
+	}
+	return ctx.client.Post("/open/api/gallery/image/transfer", struct {
+		Images []image `json:"images"`
+	}{images}, nil)
+}
+
+func runGalleryGrant(ctx *cmdContext, args []string) error {
+	fs := ctx.flags("gallery grant", "<gallery> <username>")
+	_ = fs.Parse(args)
+	if err := requireArgs(fs, 2); err != nil {
+		return err
+	}
+	g, err := ctx.client.ResolveGallery(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	return ctx.client.Post("/open/api/gallery/access/grant", struct {
+		GalleryNo string `json:"galleryNo"`
+		Username  string `json:"username"`
+	}{g.GalleryNo, fs.Arg(1)}, nil)
+}
+
+func runGalleryRevoke(ctx *cmdContext, args []string) error {
+	fs := ctx.flags("gallery revoke", "<gallery> <userNo>")
+	_ = fs.Parse(args)
+	if err := requireArgs(fs, 2); err != nil {
+		return err
+	}
+	g, err := ctx.client.ResolveGallery(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	return ctx.client.Post("/open/api/gallery/access/remove", struct {
+		GalleryNo string `json:"galleryNo"`
+		UserNo    string `json:"userNo"`
+	}{g.GalleryNo, fs.Arg(1)}, nil)
+}
+
+func runGalleryAccess(ctx *cmdContext, args []string) error {
+	fs := ctx.flags("gallery access", "<gallery>")
+	_ = fs.Parse(args)
+	if err := requireArgs(fs, 1); err != nil {
+		return err
+	}
+	g, err := ctx.client.ResolveGallery(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	type access struct {
+		UserNo     string `json:"userNo"`
+		Username   string `json:"username"`
+		CreateTime int64  `json:"createTime"`
+	}
+	var res miso.PageRes[access]
+	err = ctx.client.Post("/open/api/gallery/access/list", struct {
+		GalleryNo string      `json:"galleryNo"`
+		Paging    miso.Paging `json:"paging"`
+	}{g.GalleryNo, miso.Paging{Limit: listPageLimit, Page: 1}}, &res)
+	if err != nil {
+		return err
+	}
+	return ctx.output(res.Payload, func(w *tabwriter.Writer) {
+		for _, a := range res.Payload {
+			fmt.Fprintf(w, "%v\t%v\t%v\n", a.UserNo, a.Username, time.UnixMilli(a.CreateTime).Format("2006-01-02 15:04"))
+		}
+	})
+}
@@ -0,0 +1,155 @@
+// vfmctl is the command-line client of vfm.
+//
+// The config file (default: ${UserConfigDir}/vfmctl/config.json) stores the urls and the token, run
+// 'vfmctl config set server https://example.com/vfm' and 'vfmctl login -u <username>' to get started.
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+)
+
+const (
+	fileTypeDir  = "DIR"
+	fileTypeFile = "FILE"
+)
+
+type command struct {
+	usage string
+	desc  string
+	run   func(ctx *cmdContext, args []string) error
+}
+
+type cmdContext struct {
+	conf   *Config
+	client *Client
+	json   bool
+}
+
+var commands = map[string]command{}
+
+func register(name string, c command) {
+	commands[name] = c
+}
+
+func main() {
+	global := flag.NewFlagSet("vfmctl", flag.ExitOnError)
+	configPath := global.String("config", defaultConfigPath(), "path of the config file")
+	jsonOut := global.Bool("json", false, "print output as json")
+	global.Usage = printUsage
+	_ = global.Parse(os.Args[1:])
+
+	args := global.Args()
+	if len(args) < 1 {
+		printUsage()
+		os.Exit(2)
+	}
+
+	cmd, ok := commands[args[0]]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "unknown command: %v\n\n", args[0])
+		printUsage()
+		os.Exit(2)
+	}
+
+	conf, err := loadConfig(*configPath)
+	if err != nil {
+		fatal(err)
+	}
+	ctx := &cmdContext{conf: conf, client: newClient(conf), json: *jsonOut}
+	if err := cmd.run(ctx, args[1:]); err != nil {
+		fatal(err)
+	}
+}
+
+func fatal(err error) {
+	fmt.Fprintf(os.Stderr, "vfmctl: %v\n", err)
+	os.Exit(1)
+}
+
+func printUsage() {
+	fmt.Fprintf(os.Stderr, "Usage: vfmctl [-config path] [-json] <command> [args]\n\nCommands:\n")
+	names := make([]string, 0, len(commands))
+	for n := range commands {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	w := tabwriter.NewWriter(os.Stderr, 0, 4, 2, ' ', 0)
+	for _, n := range names {
+		fmt.Fprintf(w, "  %v %v\t%v\n", n, commands[n].usage, commands[n].desc)
+	}
+	w.Flush()
+	fmt.Fprintf(os.Stderr, "\nRemote paths start from user's top-level files (e.g., /photos/2024/a.jpg), or '@' followed by the file key.\n")
+}
+
+// Create flag set for the command, the global -json flag is also accepted after the command.
+func (ctx *cmdContext) flags(name string, usage string) *flag.FlagSet {
+	fs := flag.NewFlagSet("vfmctl "+name, flag.ExitOnError)
+	fs.BoolVar(&ctx.json, "json", ctx.json, "print output as json")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: vfmctl %v %v\n", name, usage)
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+// Print v as json if -json is set, otherwise text() is called.
+func (ctx *cmdContext) output(v any, text func(w *tabwriter.Writer)) error {
+	if ctx.json {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(v)
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	text(w)
+	return w.Flush()
+}
+
+// Dispatch sub-commands, e.g., 'vfmctl vfolder ls'.
+func subcommands(group string, subs map[string]command) func(ctx *cmdContext, args []string) error {
+	return func(ctx *cmdContext, args []string) error {
+		if len(args) > 0 {
+			if c, ok := subs[args[0]]; ok {
+				return c.run(ctx, args[1:])
+			}
+		}
+		names := make([]string, 0, len(subs))
+		for n := range subs {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+		var b strings.Builder
+		w := tabwriter.NewWriter(&b, 0, 4, 2, ' ', 0)
+		for _, n := range names {
+			fmt.Fprintf(w, "  %v %v %v\t%v\n", group, n, subs[n].usage, subs[n].desc)
+		}
+		w.Flush()
+		return fmt.Errorf("expected sub-command:\n%v", strings.TrimRight(b.String(), "\n"))
+	}
+}
+
+func requireArgs(fs *flag.FlagSet, n int) error {
+	if fs.NArg() < n {
+		fs.Usage()
+		return fmt.Errorf("expected at least %d argument(s)", n)
+	}
+	return nil
+}
+
+func humanSize(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%dB", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f%c", float64(n)/float64(div), "KMGTPE"[exp])
+}
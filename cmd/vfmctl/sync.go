@@ -0,0 +1,704 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+const (
+	syncMetaDir    = ".vfmsync"
+	syncStateFile  = "state.json"
+	syncLockFile   = "lock"
+	syncTrashDir   = "trash"
+	syncPartSuffix = ".vfmsync-part"
+
+	syncOpUpload       = "UPLOAD"
+	syncOpDownload     = "DOWNLOAD"
+	syncOpDeleteRemote = "DELETE_REMOTE"
+	syncOpDeleteLocal  = "DELETE_LOCAL"
+	syncOpMkdirRemote  = "MKDIR_REMOTE"
+	syncOpMkdirLocal   = "MKDIR_LOCAL"
+	syncOpConflict     = "CONFLICT"
+)
+
+func init() {
+	register("sync", command{usage: "[-dry-run] [-interval duration] <local-dir> <remote-dir>",
+		desc: "two-way sync between local directory and remote directory", run: runSync})
+}
+
+// State of last sync, saved in ${local-dir}/.vfmsync/state.json.
+//
+// Entry of each path records the local file and the remote file that were identical when they were last synced.
+// Remote files are immutable, an updated remote file always comes with a new file key.
+type syncState struct {
+	RemoteDir string               `json:"remoteDir"` // file key of the remote dir
+	Entries   map[string]syncEntry `json:"entries"`   // key is the slash-separated relative path
+}
+
+type syncEntry struct {
+	Dir     bool   `json:"dir"`
+	Size    int64  `json:"size"`
+	ModTime int64  `json:"modTime"` // local modification time in unix nano
+	Hash    string `json:"hash"`    // sha256 of the content
+	FileKey string `json:"fileKey"`
+}
+
+type localEntry struct {
+	dir     bool
+	size    int64
+	modTime int64
+	hash    string
+}
+
+type syncAction struct {
+	Op     string `json:"op"`
+	Path   string `json:"path"`
+	Reason string `json:"reason,omitempty"`
+}
+
+type syncer struct {
+	client    *Client
+	localRoot string
+	dryRun    bool
+
+	state  syncState
+	local  map[string]*localEntry
+	remote map[string]ListedFile
+
+	// file keys of remote dirs and existing local dirs, key is the relative path, root is ""
+	remoteDirs map[string]string
+	localDirs  map[string]bool
+
+	next      map[string]syncEntry
+	actions   []syncAction
+	trashPath string
+
+	// paths not yet processed when run() fails, they keep the previous state
+	unprocessed []string
+}
+
+func runSync(ctx *cmdContext, args []string) error {
+	fs := ctx.flags("sync", "[-dry-run] [-interval duration] <local-dir> <remote-dir>")
+	dryRun := fs.Bool("dry-run", false, "only print the planned actions, nothing is changed")
+	interval := fs.Duration("interval", 0, "keep syncing with the interval, e.g., 5m, sync only once if it's 0")
+	_ = fs.Parse(args)
+	if err := requireArgs(fs, 2); err != nil {
+		return err
+	}
+	localRoot, err := filepath.Abs(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	for {
+		actions, err := syncOnce(ctx.client, localRoot, fs.Arg(1), *dryRun)
+		if err != nil && *interval < 1 {
+			return err
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "vfmctl: sync failed, %v\n", err)
+		} else if oerr := printSyncActions(ctx, actions); oerr != nil {
+			return oerr
+		}
+		if *interval < 1 {
+			for _, a := range actions {
+				if a.Op == syncOpConflict {
+					return errors.New("sync finished with conflicts, resolve them manually and sync again")
+				}
+			}
+			return nil
+		}
+		time.Sleep(*interval)
+	}
+}
+
+func printSyncActions(ctx *cmdContext, actions []syncAction) error {
+	return ctx.output(actions, func(w *tabwriter.Writer) {
+		for _, a := range actions {
+			if a.Reason != "" {
+				fmt.Fprintf(w, "%v\t%v\t(%v)\n", a.Op, a.Path, a.Reason)
+			} else {
+				fmt.Fprintf(w, "%v\t%v\n", a.Op, a.Path)
+			}
+		}
+		if len(actions) < 1 {
+			fmt.Fprintln(w, "Already in sync")
+		}
+	})
+}
+
+func syncOnce(client *Client, localRoot string, remotePath string, dryRun bool) ([]syncAction, error) {
+	st, err := os.Stat(localRoot)
+	if err != nil {
+		return nil, err
+	}
+	if !st.IsDir() {
+		return nil, fmt.Errorf("not a directory: %v", localRoot)
+	}
+	remoteRoot, err := client.ResolveDir(remotePath, false)
+	if err != nil {
+		return nil, err
+	}
+
+	meta := filepath.Join(localRoot, syncMetaDir)
+	if err := os.MkdirAll(meta, 0o700); err != nil {
+		return nil, err
+	}
+	unlock, err := lockSync(meta)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	s := &syncer{
+		client:     client,
+		localRoot:  localRoot,
+		dryRun:     dryRun,
+		local:      map[string]*localEntry{},
+		remote:     map[string]ListedFile{},
+		remoteDirs: map[string]string{"": remoteRoot.Uuid},
+		localDirs:  map[string]bool{"": true},
+		next:       map[string]syncEntry{},
+		trashPath:  filepath.Join(meta, syncTrashDir, time.Now().Format("20060102-150405")),
+	}
+	if err := s.loadState(remoteRoot.Uuid); err != nil {
+		return nil, err
+	}
+	if err := s.walkLocal(); err != nil {
+		return nil, err
+	}
+	if err := s.walkRemote(remoteRoot.Uuid, ""); err != nil {
+		return nil, err
+	}
+	if err := s.run(); err != nil {
+		// state of the applied actions is still saved, the paths not yet processed keep the previous state
+		if !dryRun {
+			for _, rel := range s.unprocessed {
+				if _, ok := s.next[rel]; ok {
+					continue
+				}
+				if b, ok := s.state.Entries[rel]; ok {
+					s.next[rel] = b
+				}
+			}
+			_ = s.saveState()
+		}
+		return s.actions, err
+	}
+	if dryRun {
+		return s.actions, nil
+	}
+	return s.actions, s.saveState()
+}
+
+// Lock the local dir, so that only one sync process is running.
+func lockSync(meta string) (func(), error) {
+	p := filepath.Join(meta, syncLockFile)
+	f, err := os.OpenFile(p, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o600)
+	if err != nil {
+		if errors.Is(err, os.ErrExist) {
+			return nil, fmt.Errorf("another sync is running, remove %v if it's not", p)
+		}
+		return nil, err
+	}
+	fmt.Fprintf(f, "%d", os.Getpid())
+	f.Close()
+	return func() { os.Remove(p) }, nil
+}
+
+func (s *syncer) loadState(remoteDir string) error {
+	p := filepath.Join(s.localRoot, syncMetaDir, syncStateFile)
+	b, err := os.ReadFile(p)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			s.state = syncState{RemoteDir: remoteDir, Entries: map[string]syncEntry{}}
+			return nil
+		}
+		return err
+	}
+	if err := json.Unmarshal(b, &s.state); err != nil {
+		return fmt.Errorf("failed to parse sync state %v, %v", p, err)
+	}
+	if s.state.RemoteDir != remoteDir {
+		return fmt.Errorf("%v was synced with another remote dir (%v), remove %v to start over", s.localRoot, s.state.RemoteDir, p)
+	}
+	if s.state.Entries == nil {
+		s.state.Entries = map[string]syncEntry{}
+	}
+	return nil
+}
+
+func (s *syncer) saveState() error {
+	s.state.Entries = s.next
+	b, err := json.Marshal(s.state)
+	if err != nil {
+		return err
+	}
+	p := filepath.Join(s.localRoot, syncMetaDir, syncStateFile)
+	tmp := p + ".tmp"
+	if err := os.WriteFile(tmp, b, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, p)
+}
+
+func (s *syncer) localPath(rel string) string {
+	return filepath.Join(s.localRoot, filepath.FromSlash(rel))
+}
+
+func (s *syncer) walkLocal() error {
+	return filepath.WalkDir(s.localRoot, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(s.localRoot, p)
+		if err != nil || rel == "." {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if rel == syncMetaDir {
+			return filepath.SkipDir
+		}
+		if d.IsDir() {
+			s.local[rel] = &localEntry{dir: true}
+			s.localDirs[rel] = true
+			return nil
+		}
+		if !d.Type().IsRegular() || strings.HasSuffix(rel, syncPartSuffix) {
+			return nil // symlinks, special files and partially downloaded files are ignored
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		s.local[rel] = &localEntry{size: info.Size(), modTime: info.ModTime().UnixNano()}
+		return nil
+	})
+}
+
+func (s *syncer) walkRemote(dirKey string, dirRel string) error {
+	files, err := s.client.ListDir(dirKey)
+	if err != nil {
+		return err
+	}
+	for _, f := range files {
+		rel := path.Join(dirRel, f.Name)
+		if _, ok := s.remote[rel]; ok {
+			continue // shadowed by the latest one with the same name
+		}
+		if dirRel == "" && f.Name == syncMetaDir {
+			continue
+		}
+		s.remote[rel] = f
+		if f.IsDir() {
+			s.remoteDirs[rel] = f.Uuid
+			if err := s.walkRemote(f.Uuid, rel); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Hash of local file, the hash in state is reused if the size and the modification time are not changed.
+func (s *syncer) localHash(rel string, l *localEntry) (string, error) {
+	if l.hash != "" {
+		return l.hash, nil
+	}
+	if b, ok := s.state.Entries[rel]; ok && !b.Dir && b.Size == l.size && b.ModTime == l.modTime {
+		l.hash = b.Hash
+		return l.hash, nil
+	}
+	f, err := os.Open(s.localPath(rel))
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	l.hash = hex.EncodeToString(h.Sum(nil))
+	return l.hash, nil
+}
+
+// Hash of remote file, the file is downloaded.
+func (s *syncer) remoteHash(r ListedFile) (string, error) {
+	token, err := s.client.GenFileToken(r.Uuid)
+	if err != nil {
+		return "", err
+	}
+	h := sha256.New()
+	if _, err := s.client.DownloadFstore(token, h); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// Check whether the local file and the remote file have the same content, remote file is only downloaded when
+// they have the same size.
+func (s *syncer) sameContent(rel string, l *localEntry, r ListedFile) (bool, error) {
+	if l.size != r.SizeInBytes {
+		return false, nil
+	}
+	lh, err := s.localHash(rel, l)
+	if err != nil {
+		return false, err
+	}
+	rh, err := s.remoteHash(r)
+	if err != nil {
+		return false, err
+	}
+	return lh == rh, nil
+}
+
+func (s *syncer) record(rel string, l *localEntry, r ListedFile) {
+	if l.dir {
+		s.next[rel] = syncEntry{Dir: true, FileKey: r.Uuid}
+		return
+	}
+	s.next[rel] = syncEntry{Size: l.size, ModTime: l.modTime, Hash: l.hash, FileKey: r.Uuid}
+}
+
+func (s *syncer) act(op string, rel string, reason string) {
+	s.actions = append(s.actions, syncAction{Op: op, Path: rel, Reason: reason})
+}
+
+func (s *syncer) conflict(rel string, reason string) {
+	s.act(syncOpConflict, rel, reason)
+	// keep the previous state, so that the conflict is reported again until it's resolved
+	if b, ok := s.state.Entries[rel]; ok {
+		s.next[rel] = b
+	}
+}
+
+// Compare both sides with the last synced state and apply the changes, conflicts are reported without overwriting
+// anything.
+func (s *syncer) run() error {
+	paths := syncPaths(s.local, s.remote, s.state.Entries)
+
+	// descendants of the conflicted paths are skipped
+	var skipped []string
+	isSkipped := func(rel string) bool {
+		for _, p := range skipped {
+			if strings.HasPrefix(rel, p+"/") {
+				return true
+			}
+		}
+		return false
+	}
+
+	// directories deleted on one side, they are deleted on the other side after their children
+	var deletedDirs []syncAction
+
+	for i, rel := range paths {
+		s.unprocessed = paths[i:]
+		if isSkipped(rel) {
+			if b, ok := s.state.Entries[rel]; ok {
+				s.next[rel] = b
+			}
+			continue
+		}
+		l := s.local[rel]
+		r, rok := s.remote[rel]
+		b, bok := s.state.Entries[rel]
+
+		switch {
+		case l != nil && rok && l.dir != r.IsDir():
+			s.conflict(rel, "file on one side, directory on the other side")
+			skipped = append(skipped, rel)
+
+		case l != nil && l.dir && rok:
+			s.record(rel, l, r)
+
+		case l != nil && l.dir && !rok:
+			if bok && b.Dir {
+				deletedDirs = append(deletedDirs, syncAction{Op: syncOpDeleteLocal, Path: rel, Reason: "deleted remotely"})
+				s.next[rel] = b
+				continue
+			}
+			if _, ok := s.remoteDirs[parentRel(rel)]; !ok {
+				s.conflict(rel, "created locally, parent deleted remotely")
+				skipped = append(skipped, rel)
+				continue
+			}
+			if err := s.mkdirRemote(rel); err != nil {
+				return err
+			}
+			s.next[rel] = syncEntry{Dir: true, FileKey: s.remoteDirs[rel]}
+
+		case l == nil && rok && r.IsDir():
+			if bok && b.Dir {
+				deletedDirs = append(deletedDirs, syncAction{Op: syncOpDeleteRemote, Path: rel, Reason: "deleted locally"})
+				s.next[rel] = b
+				continue
+			}
+			if !s.localDirs[parentRel(rel)] {
+				s.conflict(rel, "created remotely, parent deleted locally")
+				skipped = append(skipped, rel)
+				continue
+			}
+			if err := s.mkdirLocal(rel); err != nil {
+				return err
+			}
+			s.next[rel] = syncEntry{Dir: true, FileKey: r.Uuid}
+
+		case l == nil && !rok:
+			// deleted on both sides
+
+		default:
+			if err := s.syncFile(rel, l, r, rok, b, bok); err != nil {
+				return fmt.Errorf("failed to sync %v, %v", rel, err)
+			}
+		}
+	}
+
+	s.unprocessed = nil
+
+	// deepest first
+	for i := len(deletedDirs) - 1; i >= 0; i-- {
+		d := deletedDirs[i]
+		if err := s.deleteDir(d); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *syncer) syncFile(rel string, l *localEntry, r ListedFile, rok bool, b syncEntry, bok bool) error {
+	localChanged := false
+	if l != nil {
+		if bok && !b.Dir {
+			h, err := s.localHash(rel, l)
+			if err != nil {
+				return err
+			}
+			localChanged = h != b.Hash
+		} else {
+			localChanged = true
+		}
+	}
+	remoteChanged := rok && (!bok || b.Dir || r.Uuid != b.FileKey)
+
+	switch {
+	case l != nil && rok:
+		if !localChanged && !remoteChanged {
+			s.record(rel, l, r)
+			return nil
+		}
+		if localChanged && !remoteChanged {
+			return s.upload(rel, l, r.Uuid)
+		}
+		if !localChanged && remoteChanged {
+			return s.download(rel, r)
+		}
+		same, err := s.sameContent(rel, l, r)
+		if err != nil {
+			return err
+		}
+		if same {
+			s.record(rel, l, r)
+			return nil
+		}
+		s.conflict(rel, "changed on both sides")
+
+	case l != nil: // missing remotely
+		if !bok || b.Dir {
+			if _, ok := s.remoteDirs[parentRel(rel)]; !ok {
+				s.conflict(rel, "created locally, parent deleted remotely")
+				return nil
+			}
+			return s.upload(rel, l, "")
+		}
+		if localChanged {
+			s.conflict(rel, "changed locally, deleted remotely")
+			return nil
+		}
+		s.act(syncOpDeleteLocal, rel, "deleted remotely")
+		return s.trashLocal(rel)
+
+	default: // missing locally
+		if !bok || b.Dir {
+			if !s.localDirs[parentRel(rel)] {
+				s.conflict(rel, "created remotely, parent deleted locally")
+				return nil
+			}
+			return s.download(rel, r)
+		}
+		if remoteChanged {
+			s.conflict(rel, "deleted locally, changed remotely")
+			return nil
+		}
+		s.act(syncOpDeleteRemote, rel, "deleted locally")
+		if s.dryRun {
+			return nil
+		}
+		return s.client.DeleteFiles([]string{r.Uuid})
+	}
+	return nil
+}
+
+func parentRel(rel string) string {
+	parent, _ := path.Split(rel)
+	return strings.TrimSuffix(parent, "/")
+}
+
+func (s *syncer) mkdirRemote(rel string) error {
+	s.act(syncOpMkdirRemote, rel, "")
+	if s.dryRun {
+		s.remoteDirs[rel] = ""
+		return nil
+	}
+	key, err := s.client.MakeDir(s.remoteDirs[parentRel(rel)], path.Base(rel))
+	if err != nil {
+		return fmt.Errorf("failed to make remote dir %v, %v", rel, err)
+	}
+	s.remoteDirs[rel] = key
+	return nil
+}
+
+func (s *syncer) mkdirLocal(rel string) error {
+	s.act(syncOpMkdirLocal, rel, "")
+	s.localDirs[rel] = true
+	if s.dryRun {
+		return nil
+	}
+	return os.MkdirAll(s.localPath(rel), 0o755)
+}
+
+// Upload local file, the previous remote file (if any) is deleted afterwards.
+func (s *syncer) upload(rel string, l *localEntry, prevKey string) error {
+	s.act(syncOpUpload, rel, "")
+	if s.dryRun {
+		return nil
+	}
+	if _, err := s.localHash(rel, l); err != nil {
+		return err
+	}
+	fileKey, err := s.client.UploadFile(s.localPath(rel), path.Base(rel), s.remoteDirs[parentRel(rel)])
+	if err != nil {
+		return err
+	}
+	// the file may be changed while it's being uploaded, it will be uploaded again in next sync
+	s.next[rel] = syncEntry{Size: l.size, ModTime: l.modTime, Hash: l.hash, FileKey: fileKey}
+	if prevKey != "" {
+		return s.client.DeleteFiles([]string{prevKey})
+	}
+	return nil
+}
+
+// Download remote file, the local file is replaced atomically.
+func (s *syncer) download(rel string, r ListedFile) error {
+	s.act(syncOpDownload, rel, "")
+	if s.dryRun {
+		return nil
+	}
+	local := s.localPath(rel)
+	token, err := s.client.GenFileToken(r.Uuid)
+	if err != nil {
+		return err
+	}
+	tmp := filepath.Join(filepath.Dir(local), "."+filepath.Base(local)+syncPartSuffix)
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	h := sha256.New()
+	_, err = s.client.DownloadFstore(token, io.MultiWriter(f, h))
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp, local)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	info, err := os.Stat(local)
+	if err != nil {
+		return err
+	}
+	s.next[rel] = syncEntry{Size: info.Size(), ModTime: info.ModTime().UnixNano(), Hash: hex.EncodeToString(h.Sum(nil)),
+		FileKey: r.Uuid}
+	return nil
+}
+
+// Move local file or dir to ${local-dir}/.vfmsync/trash.
+func (s *syncer) trashLocal(rel string) error {
+	if s.dryRun {
+		return nil
+	}
+	dst := filepath.Join(s.trashPath, filepath.FromSlash(rel))
+	if err := os.MkdirAll(filepath.Dir(dst), 0o700); err != nil {
+		return err
+	}
+	return os.Rename(s.localPath(rel), dst)
+}
+
+// Delete dir that is deleted on the other side, it's only deleted when it becomes empty.
+func (s *syncer) deleteDir(d syncAction) error {
+	if d.Op == syncOpDeleteLocal {
+		if !s.dryRun {
+			entries, err := os.ReadDir(s.localPath(d.Path))
+			if err != nil {
+				return err
+			}
+			if len(entries) > 0 {
+				return nil
+			}
+		}
+		s.actions = append(s.actions, d)
+		delete(s.next, d.Path)
+		return s.trashLocal(d.Path)
+	}
+
+	key := s.remoteDirs[d.Path]
+	if !s.dryRun {
+		files, err := s.client.ListDir(key)
+		if err != nil {
+			return err
+		}
+		if len(files) > 0 {
+			return nil
+		}
+	}
+	s.actions = append(s.actions, d)
+	delete(s.next, d.Path)
+	if s.dryRun {
+		return nil
+	}
+	return s.client.DeleteFiles([]string{key})
+}
+
+// Sorted union of the keys, parents always come before their children.
+func syncPaths(local map[string]*localEntry, remote map[string]ListedFile, state map[string]syncEntry) []string {
+	set := map[string]struct{}{}
+	for k := range local {
+		set[k] = struct{}{}
+	}
+	for k := range remote {
+		set[k] = struct{}{}
+	}
+	for k := range state {
+		set[k] = struct{}{}
+	}
+	keys := make([]string, 0, len(set))
+	for k := range set {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
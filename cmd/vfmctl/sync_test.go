@@ -0,0 +1,310 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/curtisnewbie/miso/miso"
+)
+
+// In-memory vfm and mini-fstore.
+type fakeVfm struct {
+	mu      sync.Mutex
+	seq     int
+	files   []*fakeFile
+	content map[string][]byte // fstore file id -> content
+
+	failUpload bool
+}
+
+type fakeFile struct {
+	ListedFile
+	parent  string
+	fileId  string
+	deleted bool
+}
+
+func (f *fakeVfm) nextKey(prefix string) string {
+	f.seq++
+	return fmt.Sprintf("%v%d", prefix, f.seq)
+}
+
+func (f *fakeVfm) add(parent string, name string, fileType string, content []byte) string {
+	key := f.nextKey("file_")
+	ff := &fakeFile{ListedFile: ListedFile{Id: f.seq, Uuid: key, Name: name, FileType: fileType, SizeInBytes: int64(len(content))}, parent: parent}
+	if fileType == fileTypeFile {
+		ff.fileId = f.nextKey("fstore_")
+		f.content[ff.fileId] = content
+	}
+	f.files = append(f.files, ff)
+	return key
+}
+
+func (f *fakeVfm) find(key string) *fakeFile {
+	for _, ff := range f.files {
+		if ff.Uuid == key && !ff.deleted {
+			return ff
+		}
+	}
+	return nil
+}
+
+func (f *fakeVfm) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	reply := func(data any, errMsg string) {
+		_ = json.NewEncoder(w).Encode(miso.GnResp[any]{Data: data, Error: errMsg != "", Msg: errMsg})
+	}
+	switch r.URL.Path {
+	case "/vfm/open/api/file/list":
+		var req listFileReq
+		_ = json.NewDecoder(r.Body).Decode(&req)
+		var files []ListedFile
+		for i := len(f.files) - 1; i >= 0; i-- { // id desc
+			if ff := f.files[i]; !ff.deleted && ff.parent == *req.ParentFile {
+				files = append(files, ff.ListedFile)
+			}
+		}
+		reply(miso.PageRes[ListedFile]{Page: miso.Paging{Total: len(files)}, Payload: files}, "")
+	case "/vfm/open/api/file/make-dir":
+		var req struct{ ParentFile, Name string }
+		_ = json.NewDecoder(r.Body).Decode(&req)
+		reply(f.add(req.ParentFile, req.Name, fileTypeDir, nil), "")
+	case "/vfm/open/api/file/create":
+		var req struct {
+			Filename     string
+			FstoreFileId string
+			ParentFile   string
+		}
+		_ = json.NewDecoder(r.Body).Decode(&req)
+		reply(f.add(req.ParentFile, req.Filename, fileTypeFile, f.content[req.FstoreFileId]), "")
+	case "/vfm/open/api/file/batch/delete":
+		var req struct{ FileKeys []string }
+		_ = json.NewDecoder(r.Body).Decode(&req)
+		for _, k := range req.FileKeys {
+			ff := f.find(k)
+			for _, c := range f.files {
+				if ff != nil && c.parent == k && !c.deleted {
+					reply(nil, "directory is not empty")
+					return
+				}
+			}
+			if ff != nil {
+				ff.deleted = true
+			}
+		}
+		reply(batchRes{Success: true}, "")
+	case "/vfm/open/api/file/token/generate":
+		var req struct{ FileKey string }
+		_ = json.NewDecoder(r.Body).Decode(&req)
+		reply(f.find(req.FileKey).fileId, "")
+	case "/fstore/file":
+		if f.failUpload {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		b, _ := io.ReadAll(r.Body)
+		id := f.nextKey("fstore_")
+		f.content[id] = b
+		reply(id, "")
+	case "/fstore/file/raw":
+		_, _ = w.Write(f.content[r.URL.Query().Get("key")])
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func (f *fakeVfm) tree() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var paths []string
+	var walk func(parent string, prefix string)
+	walk = func(parent string, prefix string) {
+		for _, ff := range f.files {
+			if ff.deleted || ff.parent != parent {
+				continue
+			}
+			if ff.IsDir() {
+				paths = append(paths, prefix+ff.Name+"/")
+				walk(ff.Uuid, prefix+ff.Name+"/")
+			} else {
+				paths = append(paths, prefix+ff.Name+"="+string(f.content[ff.fileId]))
+			}
+		}
+	}
+	walk("", "")
+	sort.Strings(paths)
+	return paths
+}
+
+func localTree(t *testing.T, root string) []string {
+	var paths []string
+	err := filepath.WalkDir(root, func(p string, d os.DirEntry, err error) error {
+		if err != nil || p == root {
+			return err
+		}
+		rel, _ := filepath.Rel(root, p)
+		rel = filepath.ToSlash(rel)
+		if rel == syncMetaDir {
+			return filepath.SkipDir
+		}
+		if d.IsDir() {
+			paths = append(paths, rel+"/")
+			return nil
+		}
+		b, _ := os.ReadFile(p)
+		paths = append(paths, rel+"="+string(b))
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+func writeLocal(t *testing.T, root string, rel string, content string) {
+	p := filepath.Join(root, filepath.FromSlash(rel))
+	if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(p, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSync(t *testing.T) {
+	fake := &fakeVfm{content: map[string][]byte{}}
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+	c := newClient(&Config{Server: srv.URL + "/vfm", Token: "tkn"})
+
+	backup := fake.add("", "backup", fileTypeDir, nil)
+	fake.add(backup, "remote.txt", fileTypeFile, []byte("r1"))
+	local := t.TempDir()
+	writeLocal(t, local, "docs/a.txt", "a1")
+	writeLocal(t, local, "b.txt", "b1")
+
+	mustSync := func(dryRun bool) []syncAction {
+		actions, err := syncOnce(c, local, "/backup", dryRun)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return actions
+	}
+	assertTrees := func(want string) {
+		t.Helper()
+		lt, rt := strings.Join(localTree(t, local), ","), strings.Join(fake.tree(), ",")
+		if lt != want || rt != "backup/,backup/"+strings.ReplaceAll(want, ",", ",backup/") {
+			t.Fatalf("trees mismatch\nlocal:  %v\nremote: %v\nwant:   %v", lt, rt, want)
+		}
+	}
+
+	// dry-run changes nothing
+	if actions := mustSync(true); len(actions) != 4 {
+		t.Fatalf("unexpected dry-run actions: %+v", actions)
+	}
+	if _, err := os.Stat(filepath.Join(local, "remote.txt")); err == nil {
+		t.Fatal("dry-run should not download files")
+	}
+
+	mustSync(false)
+	assertTrees("b.txt=b1,docs/,docs/a.txt=a1,remote.txt=r1")
+	if actions := mustSync(false); len(actions) != 0 {
+		t.Fatalf("expected in sync, got %+v", actions)
+	}
+
+	// change and delete on both sides
+	writeLocal(t, local, "b.txt", "b2")
+	if err := os.Remove(filepath.Join(local, "docs/a.txt")); err != nil {
+		t.Fatal(err)
+	}
+	for _, ff := range fake.files {
+		if ff.Name == "remote.txt" {
+			ff.deleted = true
+			fake.add(ff.parent, "remote.txt", fileTypeFile, []byte("r2"))
+			break
+		}
+	}
+	mustSync(false)
+	assertTrees("b.txt=b2,docs/,remote.txt=r2")
+
+	// conflict is reported without overwriting
+	writeLocal(t, local, "b.txt", "local")
+	for _, ff := range fake.files {
+		if ff.Name == "b.txt" && !ff.deleted {
+			ff.deleted = true
+			fake.add(ff.parent, "b.txt", fileTypeFile, []byte("remote"))
+			break
+		}
+	}
+	actions := mustSync(false)
+	if len(actions) != 1 || actions[0].Op != syncOpConflict || actions[0].Path != "b.txt" {
+		t.Fatalf("expected conflict, got %+v", actions)
+	}
+	if b, _ := os.ReadFile(filepath.Join(local, "b.txt")); string(b) != "local" {
+		t.Fatalf("local file is overwritten: %s", b)
+	}
+
+	// resolve the conflict by making both sides identical
+	writeLocal(t, local, "b.txt", "remote")
+	if actions := mustSync(false); len(actions) != 0 {
+		t.Fatalf("expected in sync, got %+v", actions)
+	}
+
+	// dir deleted remotely, local files are moved to trash
+	for _, ff := range fake.files {
+		if ff.Name == "docs" {
+			ff.deleted = true
+		}
+	}
+	mustSync(false)
+	assertTrees("b.txt=remote,remote.txt=r2")
+}
+
+func TestSyncKeepStateOnError(t *testing.T) {
+	fake := &fakeVfm{content: map[string][]byte{}}
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+	c := newClient(&Config{Server: srv.URL + "/vfm", Token: "tkn"})
+
+	fake.add("", "backup", fileTypeDir, nil)
+	local := t.TempDir()
+	writeLocal(t, local, "a.txt", "a1")
+	writeLocal(t, local, "z.txt", "z1")
+	if _, err := syncOnce(c, local, "/backup", false); err != nil {
+		t.Fatal(err)
+	}
+
+	// upload of a.txt fails before z.txt is processed
+	writeLocal(t, local, "a.txt", "a2")
+	for _, ff := range fake.files {
+		if ff.Name == "z.txt" {
+			ff.deleted = true
+		}
+	}
+	fake.failUpload = true
+	if _, err := syncOnce(c, local, "/backup", false); err == nil {
+		t.Fatal("expected sync to fail")
+	}
+
+	// z.txt is still deleted locally instead of being uploaded again
+	fake.failUpload = false
+	if _, err := syncOnce(c, local, "/backup", false); err != nil {
+		t.Fatal(err)
+	}
+	lt, rt := strings.Join(localTree(t, local), ","), strings.Join(fake.tree(), ",")
+	if lt != "a.txt=a2" || rt != "backup/,backup/a.txt=a2" {
+		t.Fatalf("unexpected trees\nlocal:  %v\nremote: %v", lt, rt)
+	}
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"text/tabwriter"
+	"time"
+
+	"github.com/curtisnewbie/miso/miso"
+)
+
+func init() {
+	register("verfile", command{usage: "<sub-command>", desc: "manage versioned files", run: subcommands("verfile", map[string]command{
+		"ls":       {usage: "[-name name]", desc: "list versioned files", run: runVerFileLs},
+		"history":  {usage: "<verFileId>", desc: "list history of versioned file", run: runVerFileHistory},
+		"size":     {usage: "<verFileId>", desc: "show accumulated size of versioned file", run: runVerFileSize},
+		"create":   {usage: "[-name name] <local>", desc: "create versioned file", run: runVerFileCreate},
+		"update":   {usage: "[-name name] <verFileId> <local>", desc: "upload new version of versioned file", run: runVerFileUpdate},
+		"download": {usage: "[-o local] <verFileId>", desc: "download latest version of versioned file", run: runVerFileDownload},
+		"rm":       {usage: "<verFileId>", desc: "delete versioned file", run: runVerFileRm},
+	})})
+}
+
+type ListedVerFile struct {
+	VerFileId   string `json:"verFileId"`
+	Name        string `json:"name"`
+	FileKey     string `json:"fileKey"`
+	SizeInBytes int64  `json:"sizeInBytes"`
+	UploadTime  int64  `json:"uploadTime"`
+	CreateTime  int64  `json:"createTime"`
+	UpdateTime  int64  `json:"updateTime"`
+}
+
+func (c *Client) ListVerFiles(name string) ([]ListedVerFile, error) {
+	var namePtr *string
+	if name != "" {
+		namePtr = &name
+	}
+	var files []ListedVerFile
+	for page := 1; ; page++ {
+		var res miso.PageRes[ListedVerFile]
+		err := c.Post("/open/api/versioned-file/list", struct {
+			Paging miso.Paging `json:"paging"`
+			Name   *string     `json:"name"`
+		}{miso.Paging{Limit: listPageLimit, Page: page}, namePtr}, &res)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, res.Payload...)
+		if len(res.Payload) < listPageLimit || len(files) >= res.Page.Total {
+			return files, nil
+		}
+	}
+}
+
+type verFileIdReq struct {
+	VerFileId string `json:"verFileId"`
+}
+
+func runVerFileLs(ctx *cmdContext, args []string) error {
+	fs := ctx.flags("verfile ls", "[-name name]")
+	name := fs.String("name", "", "filter by file name")
+	_ = fs.Parse(args)
+	files, err := ctx.client.ListVerFiles(*name)
+	if err != nil {
+		return err
+	}
+	return ctx.output(files, func(w *tabwriter.Writer) {
+		for _, f := range files {
+			fmt.Fprintf(w, "%v\t%v\t%v\t%v\n", f.VerFileId, humanSize(f.SizeInBytes),
+				time.UnixMilli(f.UploadTime).Format("2006-01-02 15:04"), f.Name)
+		}
+	})
+}
+
+func runVerFileHistory(ctx *cmdContext, args []string) error {
+	fs := ctx.flags("verfile history", "<verFileId>")
+	_ = fs.Parse(args)
+	if err := requireArgs(fs, 1); err != nil {
+		return err
+	}
+	type history struct {
+		Name        string `json:"name"`
+		FileKey     string `json:"fileKey"`
+		SizeInBytes int64  `json:"sizeInBytes"`
+		UploadTime  int64  `json:"uploadTime"`
+	}
+	var hist []history
+	for page := 1; ; page++ {
+		var res miso.PageRes[history]
+		err := ctx.client.Post("/open/api/versioned-file/history", struct {
+			Paging    miso.Paging `json:"paging"`
+			VerFileId string      `json:"verFileId"`
+		}{miso.Paging{Limit: listPageLimit, Page: page}, fs.Arg(0)}, &res)
+		if err != nil {
+			return err
+		}
+		hist = append(hist, res.Payload...)
+		if len(res.Payload) < listPageLimit || len(hist) >= res.Page.Total {
+			break
+		}
+	}
+	return ctx.output(hist, func(w *tabwriter.Writer) {
+		for _, h := range hist {
+			fmt.Fprintf(w, "%v\t%v\t%v\t%v\n", h.FileKey, humanSize(h.SizeInBytes),
+				time.UnixMilli(h.UploadTime).Format("2006-01-02 15:04"), h.Name)
+		}
+	})
+}
+
+func runVerFileSize(ctx *cmdContext, args []string) error {
+	fs := ctx.flags("verfile size", "<verFileId>")
+	_ = fs.Parse(args)
+	if err := requireArgs(fs, 1); err != nil {
+		return err
+	}
+	var res struct {
+		SizeInBytes int64 `json:"sizeInBytes"`
+	}
+	if err := ctx.client.Post("/open/api/versioned-file/accumulated-size", verFileIdReq{fs.Arg(0)}, &res); err != nil {
+		return err
+	}
+	return ctx.output(res, func(w *tabwriter.Writer) {
+		fmt.Fprintln(w, humanSize(res.SizeInBytes))
+	})
+}
+
+// Upload local file to mini-fstore, the name and the temporary file id are returned.
+func (c *Client) uploadLocal(local string, name string) (string, string, error) {
+	f, err := os.Open(local)
+	if err != nil {
+		return "", "", err
+	}
+	defer f.Close()
+	st, err := f.Stat()
+	if err != nil {
+		return "", "", err
+	}
+	if name == "" {
+		name = filepath.Base(local)
+	}
+	fileId, err := c.UploadFstore(name, f, st.Size())
+	return name, fileId, err
+}
+
+func runVerFileCreate(ctx *cmdContext, args []string) error {
+	fs := ctx.flags("verfile create", "[-name name] <local>")
+	name := fs.String("name", "", "file name, defaults to the local file name")
+	_ = fs.Parse(args)
+	if err := requireArgs(fs, 1); err != nil {
+		return err
+	}
+	filename, fileId, err := ctx.client.uploadLocal(fs.Arg(0), *name)
+	if err != nil {
+		return err
+	}
+	var res struct {
+		VerFileId string `json:"verFileId"`
+	}
+	err = ctx.client.Post("/open/api/versioned-file/create", struct {
+		Filename         string `json:"filename"`
+		FakeFstoreFileId string `json:"fstoreFileId"`
+	}{filename, fileId}, &res)
+	if err != nil {
+		return err
+	}
+	return ctx.output(res, func(w *tabwriter.Writer) {
+		fmt.Fprintln(w, res.VerFileId)
+	})
+}
+
+func runVerFileUpdate(ctx *cmdContext, args []string) error {
+	fs := ctx.flags("verfile update", "[-name name] <verFileId> <local>")
+	name := fs.String("name", "", "file name, defaults to the local file name")
+	_ = fs.Parse(args)
+	if err := requireArgs(fs, 2); err != nil {
+		return err
+	}
+	filename, fileId, err := ctx.client.uploadLocal(fs.Arg(1), *name)
+	if err != nil {
+		return err
+	}
+	return ctx.client.Post("/open/api/versioned-file/update", struct {
+		VerFileId        string `json:"verFileId"`
+		Filename         string `json:"filename"`
+		FakeFstoreFileId string `json:"fstoreFileId"`
+	}{fs.Arg(0), filename, fileId}, nil)
+}
+
+func runVerFileDownload(ctx *cmdContext, args []string) error {
+	fs := ctx.flags("verfile download", "[-o local] <verFileId>")
+	out := fs.String("o", "", "local path, defaults to the file name in current directory")
+	_ = fs.Parse(args)
+	if err := requireArgs(fs, 1); err != nil {
+		return err
+	}
+	files, err := ctx.client.ListVerFiles("")
+	if err != nil {
+		return err
+	}
+	for _, f := range files {
+		if f.VerFileId != fs.Arg(0) {
+			continue
+		}
+		local := *out
+		if local == "" {
+			local = f.Name
+		}
+		return ctx.client.DownloadFile(f.FileKey, local)
+	}
+	return fmt.Errorf("versioned file not found: %v", fs.Arg(0))
+}
+
+func runVerFileRm(ctx *cmdContext, args []string) error {
+	fs := ctx.flags("verfile rm", "<verFileId>")
+	_ = fs.Parse(args)
+	if err := requireArgs(fs, 1); err != nil {
+		return err
+	}
+	return ctx.client.Post("/open/api/versioned-file/delete", verFileIdReq{fs.Arg(0)}, nil)
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"fmt"
+	"text/tabwriter"
+	"time"
+
+	"github.com/curtisnewbie/miso/miso"
+)
+
+func init() {
+	register("vfolder", command{usage: "<sub-command>", desc: "manage virtual folders", run: subcommands("vfolder", map[string]command{
+		"ls":      {desc: "list virtual folders", run: runVFolderLs},
+		"create":  {usage: "<name>", desc: "create virtual folder", run: runVFolderCreate},
+		"files":   {usage: "<folder>", desc: "list files in virtual folder", run: runVFolderFiles},
+		"add":     {usage: "<folder> <path>...", desc: "add files to virtual folder", run: runVFolderAdd},
+		"remove":  {usage: "<folder> <path>...", desc: "remove files from virtual folder", run: runVFolderRemoveFiles},
+		"share":   {usage: "<folder> <username>", desc: "share virtual folder with user", run: runVFolderShare},
+		"unshare": {usage: "<folder> <userNo>", desc: "remove user's access to virtual folder", run: runVFolderUnshare},
+		"rm":      {usage: "<folder>", desc: "remove virtual folder", run: runVFolderRm},
+	})})
+}
+
+type ListedVFolder struct {
+	FolderNo   string `json:"folderNo"`
+	Name       string `json:"name"`
+	CreateTime int64  `json:"createTime"`
+	CreateBy   string `json:"createBy"`
+	UpdateTime int64  `json:"updateTime"`
+	Ownership  string `json:"ownership"`
+}
+
+func (c *Client) ListVFolders(name string) ([]ListedVFolder, error) {
+	var folders []ListedVFolder
+	for page := 1; ; page++ {
+		var res miso.PageRes[ListedVFolder]
+		err := c.Post("/open/api/vfolder/list", struct {
+			Paging miso.Paging `json:"paging"`
+			Name   string      `json:"name"`
+		}{miso.Paging{Limit: listPageLimit, Page: page}, name}, &res)
+		if err != nil {
+			return nil, err
+		}
+		folders = append(folders, res.Payload...)
+		if len(res.Payload) < listPageLimit || len(folders) >= res.Page.Total {
+			return folders, nil
+		}
+	}
+}
+
+// Resolve vfolder by folderNo or name.
+func (c *Client) ResolveVFolder(folder string) (ListedVFolder, error) {
+	folders, err := c.ListVFolders("")
+	if err != nil {
+		return ListedVFolder{}, err
+	}
+	for _, f := range folders {
+		if f.FolderNo == folder {
+			return f, nil
+		}
+	}
+	for _, f := range folders {
+		if f.Name == folder {
+			return f, nil
+		}
+	}
+	return ListedVFolder{}, fmt.Errorf("virtual folder not found: %v", folder)
+}
+
+func (ctx *cmdContext) resolveFileKeys(paths []string) ([]string, error) {
+	keys := make([]string, 0, len(paths))
+	for _, p := range paths {
+		f, err := ctx.client.Resolve(p)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, f.Uuid)
+	}
+	return keys, nil
+}
+
+func runVFolderLs(ctx *cmdContext, args []string) error {
+	_ = ctx.flags("vfolder ls", "").Parse(args)
+	folders, err := ctx.client.ListVFolders("")
+	if err != nil {
+		return err
+	}
+	return ctx.output(folders, func(w *tabwriter.Writer) {
+		for _, f := range folders {
+			fmt.Fprintf(w, "%v\t%v\t%v\t%v\n", f.FolderNo, f.Ownership, time.UnixMilli(f.UpdateTime).Format("2006-01-02 15:04"), f.Name)
+		}
+	})
+}
+
+func runVFolderCreate(ctx *cmdContext, args []string) error {
+	fs := ctx.flags("vfolder create", "<name>")
+	_ = fs.Parse(args)
+	if err := requireArgs(fs, 1); err != nil {
+		return err
+	}
+	var folderNo string
+	err := ctx.client.Post("/open/api/vfolder/create", struct {
+		Name string `json:"name"`
+	}{fs.Arg(0)}, &folderNo)
+	if err != nil {
+		return err
+	}
+	return ctx.output(map[string]string{"folderNo": folderNo}, func(w *tabwriter.Writer) {
+		fmt.Fprintln(w, folderNo)
+	})
+}
+
+func runVFolderFiles(ctx *cmdContext, args []string) error {
+	fs := ctx.flags("vfolder files", "[-l] <folder>")
+	long := fs.Bool("l", false, "long format")
+	_ = fs.Parse(args)
+	if err := requireArgs(fs, 1); err != nil {
+		return err
+	}
+	vf, err := ctx.client.ResolveVFolder(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	files, err := ctx.client.ListVFolderFiles(vf.FolderNo)
+	if err != nil {
+		return err
+	}
+	return ctx.output(files, func(w *tabwriter.Writer) { printFiles(w, files, *long) })
+}
+
+type vfolderFilesReq struct {
+	FolderNo string   `json:"folderNo"`
+	FileKeys []string `json:"fileKeys"`
+}
+
+func (ctx *cmdContext) vfolderFiles(name string, url string, args []string) error {
+	fs := ctx.flags(name, "<folder> <path>...")
+	_ = fs.Parse(args)
+	if err := requireArgs(fs, 2); err != nil {
+		return err
+	}
+	vf, err := ctx.client.ResolveVFolder(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	keys, err := ctx.resolveFileKeys(fs.Args()[1:])
+	if err != nil {
+		return err
+	}
+	return ctx.client.Post(url, vfolderFilesReq{FolderNo: vf.FolderNo, FileKeys: keys}, nil)
+}
+
+func runVFolderAdd(ctx *cmdContext, args []string) error {
+	return ctx.vfolderFiles("vfolder add", "/open/api/vfolder/file/add", args)
+}
+
+func runVFolderRemoveFiles(ctx *cmdContext, args []string) error {
+	return ctx.vfolderFiles("vfolder remove", "/open/api/vfolder/file/remove", args)
+}
+
+func runVFolderShare(ctx *cmdContext, args []string) error {
+	fs := ctx.flags("vfolder share", "<folder> <username>")
+	_ = fs.Parse(args)
+	if err := requireArgs(fs, 2); err != nil {
+		return err
+	}
+	vf, err := ctx.client.ResolveVFolder(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	return ctx.client.Post("/open/api/vfolder/share", struct {
+		FolderNo string `json:"folderNo"`
+		Username string `json:"username"`
+	}{vf.FolderNo, fs.Arg(1)}, nil)
+}
+
+func runVFolderUnshare(ctx *cmdContext, args []string) error {
+	fs := ctx.flags("vfolder unshare", "<folder> <userNo>")
+	_ = fs.Parse(args)
+	if err := requireArgs(fs, 2); err != nil {
+		return err
+	}
+	vf, err := ctx.client.ResolveVFolder(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	return ctx.client.Post("/open/api/vfolder/access/remove", struct {
+		FolderNo string `json:"folderNo"`
+		UserNo   string `json:"userNo"`
+	}{vf.FolderNo, fs.Arg(1)}, nil)
+}
+
+func runVFolderRm(ctx *cmdContext, args []string) error {
+	fs := ctx.flags("vfolder rm", "<folder>")
+	_ = fs.Parse(args)
+	if err := requireArgs(fs, 1); err != nil {
+		return err
+	}
+	vf, err := ctx.client.ResolveVFolder(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	return ctx.client.Post("/open/api/vfolder/remove", struct {
+		FolderNo string `json:"folderNo"`
+	}{vf.FolderNo}, nil)
+}
@@ -25,10 +25,19 @@ CREATE TABLE `file_info` (
   `uploader_no` varchar(32) NOT NULL DEFAULT '' COMMENT 'user no of uploader',
   `sensitive_mode` varchar(1) NOT NULL DEFAULT 'N' COMMENT 'sensitive file, Y/N',
   `hidden` tinyint(4) NOT NULL DEFAULT '0' COMMENT 'whether the file is hidden',
+  `file_count` int NOT NULL DEFAULT '0' COMMENT 'recursive number of files (for dir)',
+  `dir_count` int NOT NULL DEFAULT '0' COMMENT 'recursive number of sub-directories (for dir)',
+  `mime_type` varchar(128) NOT NULL DEFAULT '' COMMENT 'media type detected from the content, empty if not yet detected',
+  `thumbnail_status` varchar(16) NOT NULL DEFAULT '' COMMENT 'thumbnail status: PENDING, DONE, FAILED, UNSUPPORTED, empty if thumbnail generation is never triggered',
+  `thumbnail_attempts` int NOT NULL DEFAULT '0' COMMENT 'number of thumbnail generation attempts',
+  `thumbnail_err` varchar(1000) NOT NULL DEFAULT '' COMMENT 'last thumbnail generation error',
+  `thumbnail_retry_time` timestamp NULL DEFAULT NULL COMMENT 'when thumbnail generation is retried (or considered timed out if pending), null if no retry is needed',
+  `excerpt` varchar(1000) NOT NULL DEFAULT '' COMMENT 'short text excerpt of pdf or text-like file',
   PRIMARY KEY (`id`),
   UNIQUE KEY `uuid_uk` (`uuid`),
   KEY `parent_file_type_idx` (`parent_file`,`file_type`),
   KEY `uploader_no_idx` (`uploader_no`),
+  KEY `thumbnail_status_retry_idx` (`thumbnail_status`,`thumbnail_retry_time`),
   FULLTEXT KEY `name_idx` (`name`)
 ) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
 
@@ -85,9 +94,12 @@ CREATE TABLE IF NOT EXISTS gallery (
   `update_by` varchar(255) NOT NULL DEFAULT '' COMMENT 'who updated this record',
   `is_del` tinyint NOT NULL DEFAULT '0' COMMENT '0-normal, 1-deleted',
   `dir_file_key` varchar(64) NOT NULL DEFAULT '' COMMENT 'directory file_key (vfm)',
+  `cover_image_no` varchar(32) NOT NULL DEFAULT '' COMMENT 'image_no of the cover image, the latest added image is used if empty',
+  `auto_created` tinyint NOT NULL DEFAULT '0' COMMENT 'whether the gallery is created automatically for the directory, 0-false, 1-true',
   PRIMARY KEY (`id`),
   UNIQUE KEY `gallery_no_uniq` (`gallery_no`),
-  KEY `idx_dir_file_key` (`dir_file_key`)
+  KEY `idx_dir_file_key` (`dir_file_key`),
+  KEY `user_no_idx` (`user_no`)
 ) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COMMENT='Gallery';
 
 CREATE TABLE IF NOT EXISTS gallery_image (
@@ -102,10 +114,14 @@ CREATE TABLE IF NOT EXISTS gallery_image (
   `update_by` varchar(255) NOT NULL DEFAULT '' COMMENT 'who updated this record',
   `is_del` tinyint NOT NULL DEFAULT '0' COMMENT '0-normal, 1-deleted',
   `status` varchar(20) NOT NULL DEFAULT 'NORMAL' COMMENT 'status',
+  `position` int DEFAULT NULL COMMENT 'position chosen by the owner, null if the image is ordered by capture time',
+  `added_by_no` varchar(64) NOT NULL DEFAULT '' COMMENT 'user_no of the user who added the image',
+  `dir_synced` tinyint NOT NULL DEFAULT '0' COMMENT 'whether the image is added by directory gallery sync, 0-false, 1-true',
   PRIMARY KEY (`id`),
   UNIQUE KEY `image_no_uniq` (`image_no`),
   UNIQUE KEY `gallery_no_file_key_uk` (`gallery_no`,`file_key`),
-  KEY `gallery_no_idx` (`gallery_no`)
+  KEY `gallery_no_idx` (`gallery_no`),
+  KEY `gallery_position_idx` (`gallery_no`,`position`)
 ) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COMMENT='Gallery Image';
 
 CREATE TABLE IF NOT EXISTS gallery_user_access (
@@ -117,8 +133,10 @@ CREATE TABLE IF NOT EXISTS gallery_user_access (
   `update_time` timestamp NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP COMMENT 'when the record is updated',
   `update_by` varchar(255) NOT NULL DEFAULT '' COMMENT 'who updated this record',
   `is_del` tinyint NOT NULL DEFAULT '0' COMMENT '0-normal, 1-deleted',
+  `role` varchar(16) NOT NULL DEFAULT 'VIEWER' COMMENT 'role of the user: VIEWER, CONTRIBUTOR',
   PRIMARY KEY (`id`),
-  UNIQUE KEY `gallery_user` (`gallery_no`,`user_no`)
+  UNIQUE KEY `gallery_user` (`gallery_no`,`user_no`),
+  KEY `user_no_idx` (`user_no`)
 ) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COMMENT='User access to gallery';
 
 CREATE TABLE IF NOT EXISTS versioned_file (
@@ -163,7 +181,7 @@ CREATE TABLE `bookmark` (
   PRIMARY KEY (`id`),
   UNIQUE KEY `uk_user_no_md5` (`user_no`,`md5`),
   KEY `idx_name` (`name`)
-) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COMMENT='Bookmark'
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COMMENT='Bookmark';
 
 CREATE TABLE `bookmark_blacklist` (
   `id` int(10) unsigned NOT NULL AUTO_INCREMENT COMMENT 'primary key',
@@ -175,4 +193,251 @@ CREATE TABLE `bookmark_blacklist` (
   PRIMARY KEY (`id`),
   UNIQUE KEY `uk_user_no_md5` (`user_no`,`md5`),
   KEY `idx_name` (`name`)
-) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COMMENT='Blacklisted Bookmark'
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COMMENT='Blacklisted Bookmark';
+
+CREATE TABLE IF NOT EXISTS notification (
+    id INT UNSIGNED PRIMARY KEY AUTO_INCREMENT COMMENT 'primary key',
+    notifi_no VARCHAR(32) NOT NULL COMMENT 'notification no',
+    user_no VARCHAR(32) NOT NULL COMMENT 'receiver user_no',
+    type VARCHAR(32) NOT NULL COMMENT 'notification type',
+    title VARCHAR(255) NOT NULL DEFAULT '' COMMENT 'title',
+    message VARCHAR(1000) NOT NULL DEFAULT '' COMMENT 'message',
+    ref_type VARCHAR(32) NOT NULL DEFAULT '' COMMENT 'type of the referenced resource: FILE, VFOLDER',
+    ref_key VARCHAR(64) NOT NULL DEFAULT '' COMMENT 'key of the referenced resource',
+    merged INT NOT NULL DEFAULT 0 COMMENT 'number of notifications merged into this one',
+    is_read TINYINT NOT NULL DEFAULT 0 COMMENT 'whether the notification is read, 0-unread, 1-read',
+    read_time DATETIME DEFAULT NULL COMMENT 'when the notification is read',
+    create_time TIMESTAMP DEFAULT CURRENT_TIMESTAMP COMMENT 'created at',
+    create_by VARCHAR(255) NOT NULL DEFAULT '' COMMENT 'created by',
+    update_time TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP COMMENT 'updated at',
+    update_by VARCHAR(255) NOT NULL DEFAULT '' COMMENT 'updated by',
+    is_del TINYINT NOT NULL DEFAULT 0 COMMENT '0-normal, 1-deleted',
+    UNIQUE KEY notifi_no_uk (notifi_no),
+    KEY user_no_read_idx (user_no, is_read),
+    KEY user_no_type_ref_idx (user_no, type, ref_key)
+) ENGINE=INNODB DEFAULT CHARSET=utf8mb4 COMMENT='Notification';
+
+CREATE TABLE IF NOT EXISTS notification_pref (
+    id INT UNSIGNED PRIMARY KEY AUTO_INCREMENT COMMENT 'primary key',
+    user_no VARCHAR(32) NOT NULL COMMENT 'user_no',
+    type VARCHAR(32) NOT NULL COMMENT 'notification type',
+    muted TINYINT NOT NULL DEFAULT 0 COMMENT 'whether the notification type is muted, 0-false, 1-true',
+    create_time TIMESTAMP DEFAULT CURRENT_TIMESTAMP COMMENT 'created at',
+    update_time TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP COMMENT 'updated at',
+    UNIQUE KEY user_no_type_uk (user_no, type)
+) ENGINE=INNODB DEFAULT CHARSET=utf8mb4 COMMENT='Notification Preference';
+
+CREATE TABLE IF NOT EXISTS notification_sub (
+    id INT UNSIGNED PRIMARY KEY AUTO_INCREMENT COMMENT 'primary key',
+    user_no VARCHAR(32) NOT NULL COMMENT 'subscriber user_no',
+    target_type VARCHAR(32) NOT NULL COMMENT 'target type: DIR, VFOLDER',
+    target_key VARCHAR(64) NOT NULL COMMENT 'directory file key or vfolder no',
+    create_time TIMESTAMP DEFAULT CURRENT_TIMESTAMP COMMENT 'created at',
+    create_by VARCHAR(255) NOT NULL DEFAULT '' COMMENT 'created by',
+    update_time TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP COMMENT 'updated at',
+    update_by VARCHAR(255) NOT NULL DEFAULT '' COMMENT 'updated by',
+    is_del TINYINT NOT NULL DEFAULT 0 COMMENT '0-normal, 1-deleted',
+    UNIQUE KEY user_no_target_uk (user_no, target_type, target_key),
+    KEY target_idx (target_type, target_key)
+) ENGINE=INNODB DEFAULT CHARSET=utf8mb4 COMMENT='Notification Subscription';
+
+CREATE TABLE IF NOT EXISTS activity_log (
+    id BIGINT UNSIGNED PRIMARY KEY AUTO_INCREMENT COMMENT 'primary key',
+    ref_type VARCHAR(32) NOT NULL COMMENT 'type of the resource being operated: FILE, VFOLDER, GALLERY, VERSIONED_FILE',
+    ref_key VARCHAR(64) NOT NULL COMMENT 'key of the resource being operated',
+    file_key VARCHAR(64) NOT NULL DEFAULT '' COMMENT 'file involved in the operation',
+    action VARCHAR(32) NOT NULL COMMENT 'action',
+    detail VARCHAR(1000) NOT NULL DEFAULT '' COMMENT 'detail',
+    user_no VARCHAR(32) NOT NULL DEFAULT '' COMMENT 'operator user_no',
+    username VARCHAR(255) NOT NULL DEFAULT '' COMMENT 'operator username',
+    create_time DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP COMMENT 'when the operation happened',
+    KEY ref_idx (ref_type, ref_key),
+    KEY file_key_idx (file_key),
+    KEY user_no_idx (user_no)
+) ENGINE=INNODB DEFAULT CHARSET=utf8mb4 COMMENT='Append-only activity log';
+
+CREATE TABLE IF NOT EXISTS webhook (
+    id INT UNSIGNED PRIMARY KEY AUTO_INCREMENT COMMENT 'primary key',
+    webhook_no VARCHAR(32) NOT NULL COMMENT 'webhook no',
+    user_no VARCHAR(32) NOT NULL COMMENT 'owner user_no',
+    scope_type VARCHAR(32) NOT NULL COMMENT 'scope type: USER, DIR, VFOLDER',
+    scope_key VARCHAR(64) NOT NULL DEFAULT '' COMMENT 'directory file key or vfolder no',
+    url VARCHAR(1000) NOT NULL COMMENT 'url that receives the POST request',
+    secret VARCHAR(64) NOT NULL COMMENT 'secret used to sign the payload',
+    events VARCHAR(255) NOT NULL DEFAULT '' COMMENT 'subscribed events, separated by comma',
+    enabled TINYINT NOT NULL DEFAULT 1 COMMENT 'whether the webhook is enabled, 0-false, 1-true',
+    create_time TIMESTAMP DEFAULT CURRENT_TIMESTAMP COMMENT 'created at',
+    create_by VARCHAR(255) NOT NULL DEFAULT '' COMMENT 'created by',
+    update_time TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP COMMENT 'updated at',
+    update_by VARCHAR(255) NOT NULL DEFAULT '' COMMENT 'updated by',
+    is_del TINYINT NOT NULL DEFAULT 0 COMMENT '0-normal, 1-deleted',
+    UNIQUE KEY webhook_no_uk (webhook_no),
+    KEY user_no_idx (user_no),
+    KEY scope_idx (scope_type, scope_key)
+) ENGINE=INNODB DEFAULT CHARSET=utf8mb4 COMMENT='Webhook';
+
+CREATE TABLE IF NOT EXISTS webhook_delivery (
+    id BIGINT UNSIGNED PRIMARY KEY AUTO_INCREMENT COMMENT 'primary key',
+    delivery_no VARCHAR(32) NOT NULL COMMENT 'delivery no',
+    webhook_no VARCHAR(32) NOT NULL COMMENT 'webhook no',
+    event VARCHAR(32) NOT NULL COMMENT 'event',
+    payload TEXT COMMENT 'payload in json',
+    status VARCHAR(16) NOT NULL DEFAULT 'PENDING' COMMENT 'status: PENDING, RETRY, SUCCESS, FAILED',
+    attempts INT NOT NULL DEFAULT 0 COMMENT 'number of attempts made',
+    http_status INT NOT NULL DEFAULT 0 COMMENT 'http status of the last attempt',
+    err_msg VARCHAR(1000) NOT NULL DEFAULT '' COMMENT 'error message of the last attempt',
+    next_retry_time DATETIME DEFAULT NULL COMMENT 'when the delivery should be retried',
+    create_time TIMESTAMP DEFAULT CURRENT_TIMESTAMP COMMENT 'created at',
+    update_time TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP COMMENT 'updated at',
+    UNIQUE KEY delivery_no_uk (delivery_no),
+    KEY webhook_no_idx (webhook_no),
+    KEY status_retry_idx (status, next_retry_time)
+) ENGINE=INNODB DEFAULT CHARSET=utf8mb4 COMMENT='Webhook Delivery Log';
+
+CREATE TABLE IF NOT EXISTS gc_run (
+    id INT UNSIGNED PRIMARY KEY AUTO_INCREMENT COMMENT 'primary key',
+    run_no VARCHAR(32) NOT NULL COMMENT 'run no',
+    dry_run TINYINT NOT NULL DEFAULT 0 COMMENT 'whether it is a dry-run, 0-false, 1-true',
+    status VARCHAR(16) NOT NULL DEFAULT 'RUNNING' COMMENT 'status: RUNNING, FINISHED, FAILED',
+    scanned INT NOT NULL DEFAULT 0 COMMENT 'number of logically deleted files scanned',
+    deleted INT NOT NULL DEFAULT 0 COMMENT 'number of files marked physically deleted',
+    unconfirmed INT NOT NULL DEFAULT 0 COMMENT 'number of files whose mini-fstore files are not yet deleted',
+    err_msg VARCHAR(1000) NOT NULL DEFAULT '' COMMENT 'error message',
+    start_time DATETIME NOT NULL COMMENT 'when the run started',
+    end_time DATETIME DEFAULT NULL COMMENT 'when the run ended',
+    create_time TIMESTAMP DEFAULT CURRENT_TIMESTAMP COMMENT 'created at',
+    update_time TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP COMMENT 'updated at',
+    UNIQUE KEY run_no_uk (run_no)
+) ENGINE=INNODB DEFAULT CHARSET=utf8mb4 COMMENT='Physical Deletion GC Run';
+
+CREATE TABLE IF NOT EXISTS reconcile_run (
+    id INT UNSIGNED PRIMARY KEY AUTO_INCREMENT COMMENT 'primary key',
+    run_no VARCHAR(32) NOT NULL COMMENT 'run no',
+    status VARCHAR(16) NOT NULL DEFAULT 'RUNNING' COMMENT 'status: RUNNING, FINISHED, FAILED',
+    scanned INT NOT NULL DEFAULT 0 COMMENT 'number of files scanned',
+    dangling INT NOT NULL DEFAULT 0 COMMENT 'number of dangling references found',
+    thumbnails_cleared INT NOT NULL DEFAULT 0 COMMENT 'number of broken thumbnails cleared',
+    err_msg VARCHAR(1000) NOT NULL DEFAULT '' COMMENT 'error message',
+    start_time DATETIME NOT NULL COMMENT 'when the run started',
+    end_time DATETIME DEFAULT NULL COMMENT 'when the run ended',
+    create_time TIMESTAMP DEFAULT CURRENT_TIMESTAMP COMMENT 'created at',
+    update_time TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP COMMENT 'updated at',
+    UNIQUE KEY run_no_uk (run_no)
+) ENGINE=INNODB DEFAULT CHARSET=utf8mb4 COMMENT='mini-fstore Reconciliation Run';
+
+CREATE TABLE IF NOT EXISTS reconcile_item (
+    id INT UNSIGNED PRIMARY KEY AUTO_INCREMENT COMMENT 'primary key',
+    run_no VARCHAR(32) NOT NULL COMMENT 'run no',
+    file_key VARCHAR(64) NOT NULL COMMENT 'file key',
+    name VARCHAR(255) NOT NULL DEFAULT '' COMMENT 'file name',
+    uploader_no VARCHAR(32) NOT NULL DEFAULT '' COMMENT 'uploader user no',
+    ref_type VARCHAR(16) NOT NULL COMMENT 'dangling reference type: FILE, THUMBNAIL',
+    fstore_file_id VARCHAR(32) NOT NULL DEFAULT '' COMMENT 'mini-fstore file id referenced',
+    problem VARCHAR(16) NOT NULL COMMENT 'problem found: MISSING, DELETED',
+    create_time TIMESTAMP DEFAULT CURRENT_TIMESTAMP COMMENT 'created at',
+    KEY run_no_idx (run_no)
+) ENGINE=INNODB DEFAULT CHARSET=utf8mb4 COMMENT='mini-fstore Reconciliation Dangling Reference';
+
+CREATE TABLE IF NOT EXISTS dir_size_delta (
+    id BIGINT UNSIGNED PRIMARY KEY AUTO_INCREMENT COMMENT 'primary key',
+    dir_key VARCHAR(64) NOT NULL COMMENT 'file key of the dir',
+    size_delta BIGINT NOT NULL DEFAULT 0 COMMENT 'signed change of recursive size in bytes',
+    file_delta INT NOT NULL DEFAULT 0 COMMENT 'signed change of recursive number of files',
+    dir_delta INT NOT NULL DEFAULT 0 COMMENT 'signed change of recursive number of sub-directories',
+    create_time TIMESTAMP DEFAULT CURRENT_TIMESTAMP COMMENT 'created at',
+    KEY dir_key_idx (dir_key)
+) ENGINE=INNODB DEFAULT CHARSET=utf8mb4 COMMENT='Pending Dir Size Delta';
+
+CREATE TABLE IF NOT EXISTS job (
+    id INT UNSIGNED PRIMARY KEY AUTO_INCREMENT COMMENT 'primary key',
+    job_no VARCHAR(32) NOT NULL COMMENT 'job no',
+    job_type VARCHAR(32) NOT NULL COMMENT 'job type',
+    user_no VARCHAR(32) NOT NULL COMMENT 'user no of the owner',
+    username VARCHAR(50) NOT NULL DEFAULT '' COMMENT 'username of the owner',
+    status VARCHAR(12) NOT NULL COMMENT 'status: PENDING, RUNNING, SUCCESS, FAILED, CANCELLED',
+    ref_type VARCHAR(32) NOT NULL DEFAULT '' COMMENT 'type of the resource that the job operates on',
+    ref_key VARCHAR(64) NOT NULL DEFAULT '' COMMENT 'key of the resource that the job operates on',
+    param MEDIUMTEXT COMMENT 'job param in json',
+    checkpoint VARCHAR(1000) NOT NULL DEFAULT '' COMMENT 'last saved checkpoint in json',
+    total INT NOT NULL DEFAULT 0 COMMENT 'total number of items, 0 if unknown',
+    processed INT NOT NULL DEFAULT 0 COMMENT 'number of items processed',
+    failed INT NOT NULL DEFAULT 0 COMMENT 'number of items failed',
+    result_msg VARCHAR(255) NOT NULL DEFAULT '' COMMENT 'result message',
+    err_msg VARCHAR(1000) NOT NULL DEFAULT '' COMMENT 'error message',
+    cancel_req TINYINT NOT NULL DEFAULT 0 COMMENT 'whether the job is requested to cancel',
+    start_time DATETIME NULL DEFAULT NULL COMMENT 'start time',
+    end_time DATETIME NULL DEFAULT NULL COMMENT 'end time',
+    create_time TIMESTAMP DEFAULT CURRENT_TIMESTAMP COMMENT 'created at',
+    update_time TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP COMMENT 'updated at',
+    UNIQUE KEY job_no_uk (job_no),
+    KEY user_no_idx (user_no),
+    KEY status_update_time_idx (status, update_time)
+) ENGINE=INNODB DEFAULT CHARSET=utf8mb4 COMMENT='Background Job';
+
+CREATE TABLE IF NOT EXISTS idempotency_record (
+    id INT UNSIGNED PRIMARY KEY AUTO_INCREMENT COMMENT 'primary key',
+    user_no VARCHAR(32) NOT NULL COMMENT 'user no',
+    idem_key VARCHAR(128) NOT NULL COMMENT 'value of Idempotency-Key header',
+    endpoint VARCHAR(128) NOT NULL COMMENT 'request path',
+    req_hash CHAR(64) NOT NULL COMMENT 'sha256 of request path and payload',
+    response MEDIUMTEXT COMMENT 'response of the first request in json',
+    expire_time DATETIME NOT NULL COMMENT 'expire time',
+    create_time TIMESTAMP DEFAULT CURRENT_TIMESTAMP COMMENT 'created at',
+    UNIQUE KEY user_key_uk (user_no, idem_key),
+    KEY expire_time_idx (expire_time)
+) ENGINE=INNODB DEFAULT CHARSET=utf8mb4 COMMENT='Idempotency Record';
+
+CREATE TABLE IF NOT EXISTS s3_access_key (
+    id INT UNSIGNED PRIMARY KEY AUTO_INCREMENT COMMENT 'primary key',
+    access_key VARCHAR(32) NOT NULL COMMENT 'access key id',
+    secret_key VARCHAR(64) NOT NULL COMMENT 'secret key used to sign the requests',
+    name VARCHAR(255) NOT NULL DEFAULT '' COMMENT 'name of the access key',
+    user_no VARCHAR(32) NOT NULL COMMENT 'owner user_no',
+    username VARCHAR(255) NOT NULL DEFAULT '' COMMENT 'owner username',
+    create_time TIMESTAMP DEFAULT CURRENT_TIMESTAMP COMMENT 'created at',
+    create_by VARCHAR(255) NOT NULL DEFAULT '' COMMENT 'created by',
+    update_time TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP COMMENT 'updated at',
+    update_by VARCHAR(255) NOT NULL DEFAULT '' COMMENT 'updated by',
+    is_del TINYINT NOT NULL DEFAULT 0 COMMENT '0-normal, 1-deleted',
+    UNIQUE KEY access_key_uk (access_key),
+    KEY user_no_idx (user_no)
+) ENGINE=INNODB DEFAULT CHARSET=utf8mb4 COMMENT='S3 Access Key';
+
+CREATE TABLE IF NOT EXISTS file_thumbnail_variant (
+    id INT UNSIGNED PRIMARY KEY AUTO_INCREMENT COMMENT 'primary key',
+    file_key VARCHAR(64) NOT NULL COMMENT 'file key',
+    variant VARCHAR(16) NOT NULL COMMENT 'variant name: small, medium, large',
+    fstore_file_id VARCHAR(32) NOT NULL COMMENT 'mini-fstore file id of the variant',
+    width INT NOT NULL DEFAULT 0 COMMENT 'width in pixels',
+    height INT NOT NULL DEFAULT 0 COMMENT 'height in pixels',
+    create_time TIMESTAMP DEFAULT CURRENT_TIMESTAMP COMMENT 'created at',
+    update_time TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP COMMENT 'updated at',
+    UNIQUE KEY file_key_variant_uk (file_key, variant)
+) ENGINE=INNODB DEFAULT CHARSET=utf8mb4 COMMENT='Thumbnail Variants';
+
+CREATE TABLE IF NOT EXISTS image_metadata (
+    id INT UNSIGNED PRIMARY KEY AUTO_INCREMENT COMMENT 'primary key',
+    file_key VARCHAR(64) NOT NULL COMMENT 'file key',
+    capture_time DATETIME DEFAULT NULL COMMENT 'when the photo is taken',
+    make VARCHAR(64) NOT NULL DEFAULT '' COMMENT 'camera make',
+    model VARCHAR(64) NOT NULL DEFAULT '' COMMENT 'camera model',
+    orientation INT NOT NULL DEFAULT 0 COMMENT 'EXIF orientation (1-8), 0 if unknown',
+    width INT NOT NULL DEFAULT 0 COMMENT 'width in pixels',
+    height INT NOT NULL DEFAULT 0 COMMENT 'height in pixels',
+    latitude DOUBLE DEFAULT NULL COMMENT 'GPS latitude in degrees',
+    longitude DOUBLE DEFAULT NULL COMMENT 'GPS longitude in degrees',
+    create_time TIMESTAMP DEFAULT CURRENT_TIMESTAMP COMMENT 'created at',
+    update_time TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP COMMENT 'updated at',
+    UNIQUE KEY file_key_uk (file_key),
+    KEY capture_time_idx (capture_time)
+) ENGINE=INNODB DEFAULT CHARSET=utf8mb4 COMMENT='Image Metadata extracted from EXIF/XMP';
+
+CREATE TABLE IF NOT EXISTS gallery_auto_pref (
+    id INT UNSIGNED PRIMARY KEY AUTO_INCREMENT COMMENT 'primary key',
+    user_no VARCHAR(32) NOT NULL COMMENT 'user_no',
+    dir_file_key VARCHAR(64) NOT NULL DEFAULT '' COMMENT 'directory file key, empty for the default of the user',
+    enabled TINYINT NOT NULL DEFAULT 1 COMMENT 'whether galleries are created automatically, 0-false, 1-true',
+    create_time TIMESTAMP DEFAULT CURRENT_TIMESTAMP COMMENT 'created at',
+    update_time TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP COMMENT 'updated at',
+    UNIQUE KEY user_no_dir_uk (user_no, dir_file_key)
+) ENGINE=INNODB DEFAULT CHARSET=utf8mb4 COMMENT='Preference of automatic gallery creation';
@@ -0,0 +1,45 @@
+CREATE TABLE IF NOT EXISTS notification (
+    id INT UNSIGNED PRIMARY KEY AUTO_INCREMENT COMMENT 'primary key',
+    notifi_no VARCHAR(32) NOT NULL COMMENT 'notification no',
+    user_no VARCHAR(32) NOT NULL COMMENT 'receiver user_no',
+    type VARCHAR(32) NOT NULL COMMENT 'notification type',
+    title VARCHAR(255) NOT NULL DEFAULT '' COMMENT 'title',
+    message VARCHAR(1000) NOT NULL DEFAULT '' COMMENT 'message',
+    ref_type VARCHAR(32) NOT NULL DEFAULT '' COMMENT 'type of the referenced resource: FILE, VFOLDER',
+    ref_key VARCHAR(64) NOT NULL DEFAULT '' COMMENT 'key of the referenced resource',
+    merged INT NOT NULL DEFAULT 0 COMMENT 'number of notifications merged into this one',
+    is_read TINYINT NOT NULL DEFAULT 0 COMMENT 'whether the notification is read, 0-unread, 1-read',
+    read_time DATETIME DEFAULT NULL COMMENT 'when the notification is read',
+    create_time TIMESTAMP DEFAULT CURRENT_TIMESTAMP COMMENT 'created at',
+    create_by VARCHAR(255) NOT NULL DEFAULT '' COMMENT 'created by',
+    update_time TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP COMMENT 'updated at',
+    update_by VARCHAR(255) NOT NULL DEFAULT '' COMMENT 'updated by',
+    is_del TINYINT NOT NULL DEFAULT 0 COMMENT '0-normal, 1-deleted',
+    UNIQUE KEY notifi_no_uk (notifi_no),
+    KEY user_no_read_idx (user_no, is_read),
+    KEY user_no_type_ref_idx (user_no, type, ref_key)
+) ENGINE=INNODB DEFAULT CHARSET=utf8mb4 COMMENT='Notification';
+
+CREATE TABLE IF NOT EXISTS notification_pref (
+    id INT UNSIGNED PRIMARY KEY AUTO_INCREMENT COMMENT 'primary key',
+    user_no VARCHAR(32) NOT NULL COMMENT 'user_no',
+    type VARCHAR(32) NOT NULL COMMENT 'notification type',
+    muted TINYINT NOT NULL DEFAULT 0 COMMENT 'whether the notification type is muted, 0-false, 1-true',
+    create_time TIMESTAMP DEFAULT CURRENT_TIMESTAMP COMMENT 'created at',
+    update_time TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP COMMENT 'updated at',
+    UNIQUE KEY user_no_type_uk (user_no, type)
+) ENGINE=INNODB DEFAULT CHARSET=utf8mb4 COMMENT='Notification Preference';
+
+CREATE TABLE IF NOT EXISTS notification_sub (
+    id INT UNSIGNED PRIMARY KEY AUTO_INCREMENT COMMENT 'primary key',
+    user_no VARCHAR(32) NOT NULL COMMENT 'subscriber user_no',
+    target_type VARCHAR(32) NOT NULL COMMENT 'target type: DIR, VFOLDER',
+    target_key VARCHAR(64) NOT NULL COMMENT 'directory file key or vfolder no',
+    create_time TIMESTAMP DEFAULT CURRENT_TIMESTAMP COMMENT 'created at',
+    create_by VARCHAR(255) NOT NULL DEFAULT '' COMMENT 'created by',
+    update_time TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP COMMENT 'updated at',
+    update_by VARCHAR(255) NOT NULL DEFAULT '' COMMENT 'updated by',
+    is_del TINYINT NOT NULL DEFAULT 0 COMMENT '0-normal, 1-deleted',
+    UNIQUE KEY user_no_target_uk (user_no, target_type, target_key),
+    KEY target_idx (target_type, target_key)
+) ENGINE=INNODB DEFAULT CHARSET=utf8mb4 COMMENT='Notification Subscription';
@@ -0,0 +1,14 @@
+CREATE TABLE IF NOT EXISTS activity_log (
+    id BIGINT UNSIGNED PRIMARY KEY AUTO_INCREMENT COMMENT 'primary key',
+    ref_type VARCHAR(32) NOT NULL COMMENT 'type of the resource being operated: FILE, VFOLDER, GALLERY, VERSIONED_FILE',
+    ref_key VARCHAR(64) NOT NULL COMMENT 'key of the resource being operated',
+    file_key VARCHAR(64) NOT NULL DEFAULT '' COMMENT 'file involved in the operation',
+    action VARCHAR(32) NOT NULL COMMENT 'action',
+    detail VARCHAR(1000) NOT NULL DEFAULT '' COMMENT 'detail',
+    user_no VARCHAR(32) NOT NULL DEFAULT '' COMMENT 'operator user_no',
+    username VARCHAR(255) NOT NULL DEFAULT '' COMMENT 'operator username',
+    create_time DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP COMMENT 'when the operation happened',
+    KEY ref_idx (ref_type, ref_key),
+    KEY file_key_idx (file_key),
+    KEY user_no_idx (user_no)
+) ENGINE=INNODB DEFAULT CHARSET=utf8mb4 COMMENT='Append-only activity log';
@@ -0,0 +1,37 @@
+CREATE TABLE IF NOT EXISTS webhook (
+    id INT UNSIGNED PRIMARY KEY AUTO_INCREMENT COMMENT 'primary key',
+    webhook_no VARCHAR(32) NOT NULL COMMENT 'webhook no',
+    user_no VARCHAR(32) NOT NULL COMMENT 'owner user_no',
+    scope_type VARCHAR(32) NOT NULL COMMENT 'scope type: USER, DIR, VFOLDER',
+    scope_key VARCHAR(64) NOT NULL DEFAULT '' COMMENT 'directory file key or vfolder no',
+    url VARCHAR(1000) NOT NULL COMMENT 'url that receives the POST request',
+    secret VARCHAR(64) NOT NULL COMMENT 'secret used to sign the payload',
+    events VARCHAR(255) NOT NULL DEFAULT '' COMMENT 'subscribed events, separated by comma',
+    enabled TINYINT NOT NULL DEFAULT 1 COMMENT 'whether the webhook is enabled, 0-false, 1-true',
+    create_time TIMESTAMP DEFAULT CURRENT_TIMESTAMP COMMENT 'created at',
+    create_by VARCHAR(255) NOT NULL DEFAULT '' COMMENT 'created by',
+    update_time TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP COMMENT 'updated at',
+    update_by VARCHAR(255) NOT NULL DEFAULT '' COMMENT 'updated by',
+    is_del TINYINT NOT NULL DEFAULT 0 COMMENT '0-normal, 1-deleted',
+    UNIQUE KEY webhook_no_uk (webhook_no),
+    KEY user_no_idx (user_no),
+    KEY scope_idx (scope_type, scope_key)
+) ENGINE=INNODB DEFAULT CHARSET=utf8mb4 COMMENT='Webhook';
+
+CREATE TABLE IF NOT EXISTS webhook_delivery (
+    id BIGINT UNSIGNED PRIMARY KEY AUTO_INCREMENT COMMENT 'primary key',
+    delivery_no VARCHAR(32) NOT NULL COMMENT 'delivery no',
+    webhook_no VARCHAR(32) NOT NULL COMMENT 'webhook no',
+    event VARCHAR(32) NOT NULL COMMENT 'event',
+    payload TEXT COMMENT 'payload in json',
+    status VARCHAR(16) NOT NULL DEFAULT 'PENDING' COMMENT 'status: PENDING, RETRY, SUCCESS, FAILED',
+    attempts INT NOT NULL DEFAULT 0 COMMENT 'number of attempts made',
+    http_status INT NOT NULL DEFAULT 0 COMMENT 'http status of the last attempt',
+    err_msg VARCHAR(1000) NOT NULL DEFAULT '' COMMENT 'error message of the last attempt',
+    next_retry_time DATETIME DEFAULT NULL COMMENT 'when the delivery should be retried',
+    create_time TIMESTAMP DEFAULT CURRENT_TIMESTAMP COMMENT 'created at',
+    update_time TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP COMMENT 'updated at',
+    UNIQUE KEY delivery_no_uk (delivery_no),
+    KEY webhook_no_idx (webhook_no),
+    KEY status_retry_idx (status, next_retry_time)
+) ENGINE=INNODB DEFAULT CHARSET=utf8mb4 COMMENT='Webhook Delivery Log';
@@ -0,0 +1,15 @@
+CREATE TABLE IF NOT EXISTS gc_run (
+    id INT UNSIGNED PRIMARY KEY AUTO_INCREMENT COMMENT 'primary key',
+    run_no VARCHAR(32) NOT NULL COMMENT 'run no',
+    dry_run TINYINT NOT NULL DEFAULT 0 COMMENT 'whether it is a dry-run, 0-false, 1-true',
+    status VARCHAR(16) NOT NULL DEFAULT 'RUNNING' COMMENT 'status: RUNNING, FINISHED, FAILED',
+    scanned INT NOT NULL DEFAULT 0 COMMENT 'number of logically deleted files scanned',
+    deleted INT NOT NULL DEFAULT 0 COMMENT 'number of files marked physically deleted',
+    unconfirmed INT NOT NULL DEFAULT 0 COMMENT 'number of files whose mini-fstore files are not yet deleted',
+    err_msg VARCHAR(1000) NOT NULL DEFAULT '' COMMENT 'error message',
+    start_time DATETIME NOT NULL COMMENT 'when the run started',
+    end_time DATETIME DEFAULT NULL COMMENT 'when the run ended',
+    create_time TIMESTAMP DEFAULT CURRENT_TIMESTAMP COMMENT 'created at',
+    update_time TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP COMMENT 'updated at',
+    UNIQUE KEY run_no_uk (run_no)
+) ENGINE=INNODB DEFAULT CHARSET=utf8mb4 COMMENT='Physical Deletion GC Run';
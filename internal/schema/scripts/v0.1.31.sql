@@ -0,0 +1,27 @@
+CREATE TABLE IF NOT EXISTS reconcile_run (
+    id INT UNSIGNED PRIMARY KEY AUTO_INCREMENT COMMENT 'primary key',
+    run_no VARCHAR(32) NOT NULL COMMENT 'run no',
+    status VARCHAR(16) NOT NULL DEFAULT 'RUNNING' COMMENT 'status: RUNNING, FINISHED, FAILED',
+    scanned INT NOT NULL DEFAULT 0 COMMENT 'number of files scanned',
+    dangling INT NOT NULL DEFAULT 0 COMMENT 'number of dangling references found',
+    thumbnails_cleared INT NOT NULL DEFAULT 0 COMMENT 'number of broken thumbnails cleared',
+    err_msg VARCHAR(1000) NOT NULL DEFAULT '' COMMENT 'error message',
+    start_time DATETIME NOT NULL COMMENT 'when the run started',
+    end_time DATETIME DEFAULT NULL COMMENT 'when the run ended',
+    create_time TIMESTAMP DEFAULT CURRENT_TIMESTAMP COMMENT 'created at',
+    update_time TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP COMMENT 'updated at',
+    UNIQUE KEY run_no_uk (run_no)
+) ENGINE=INNODB DEFAULT CHARSET=utf8mb4 COMMENT='mini-fstore Reconciliation Run';
+
+CREATE TABLE IF NOT EXISTS reconcile_item (
+    id INT UNSIGNED PRIMARY KEY AUTO_INCREMENT COMMENT 'primary key',
+    run_no VARCHAR(32) NOT NULL COMMENT 'run no',
+    file_key VARCHAR(64) NOT NULL COMMENT 'file key',
+    name VARCHAR(255) NOT NULL DEFAULT '' COMMENT 'file name',
+    uploader_no VARCHAR(32) NOT NULL DEFAULT '' COMMENT 'uploader user no',
+    ref_type VARCHAR(16) NOT NULL COMMENT 'dangling reference type: FILE, THUMBNAIL',
+    fstore_file_id VARCHAR(32) NOT NULL DEFAULT '' COMMENT 'mini-fstore file id referenced',
+    problem VARCHAR(16) NOT NULL COMMENT 'problem found: MISSING, DELETED',
+    create_time TIMESTAMP DEFAULT CURRENT_TIMESTAMP COMMENT 'created at',
+    KEY run_no_idx (run_no)
+) ENGINE=INNODB DEFAULT CHARSET=utf8mb4 COMMENT='mini-fstore Reconciliation Dangling Reference';
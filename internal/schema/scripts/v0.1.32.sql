@@ -0,0 +1,13 @@
+ALTER TABLE file_info
+    ADD COLUMN file_count INT NOT NULL DEFAULT 0 COMMENT 'recursive number of files (for dir)',
+    ADD COLUMN dir_count INT NOT NULL DEFAULT 0 COMMENT 'recursive number of sub-directories (for dir)';
+
+CREATE TABLE IF NOT EXISTS dir_size_delta (
+    id BIGINT UNSIGNED PRIMARY KEY AUTO_INCREMENT COMMENT 'primary key',
+    dir_key VARCHAR(64) NOT NULL COMMENT 'file key of the dir',
+    size_delta BIGINT NOT NULL DEFAULT 0 COMMENT 'signed change of recursive size in bytes',
+    file_delta INT NOT NULL DEFAULT 0 COMMENT 'signed change of recursive number of files',
+    dir_delta INT NOT NULL DEFAULT 0 COMMENT 'signed change of recursive number of sub-directories',
+    create_time TIMESTAMP DEFAULT CURRENT_TIMESTAMP COMMENT 'created at',
+    KEY dir_key_idx (dir_key)
+) ENGINE=INNODB DEFAULT CHARSET=utf8mb4 COMMENT='Pending Dir Size Delta';
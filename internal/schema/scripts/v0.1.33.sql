@@ -0,0 +1,25 @@
+CREATE TABLE IF NOT EXISTS job (
+    id INT UNSIGNED PRIMARY KEY AUTO_INCREMENT COMMENT 'primary key',
+    job_no VARCHAR(32) NOT NULL COMMENT 'job no',
+    job_type VARCHAR(32) NOT NULL COMMENT 'job type',
+    user_no VARCHAR(32) NOT NULL COMMENT 'user no of the owner',
+    username VARCHAR(50) NOT NULL DEFAULT '' COMMENT 'username of the owner',
+    status VARCHAR(12) NOT NULL COMMENT 'status: PENDING, RUNNING, SUCCESS, FAILED, CANCELLED',
+    ref_type VARCHAR(32) NOT NULL DEFAULT '' COMMENT 'type of the resource that the job operates on',
+    ref_key VARCHAR(64) NOT NULL DEFAULT '' COMMENT 'key of the resource that the job operates on',
+    param MEDIUMTEXT COMMENT 'job param in json',
+    checkpoint VARCHAR(1000) NOT NULL DEFAULT '' COMMENT 'last saved checkpoint in json',
+    total INT NOT NULL DEFAULT 0 COMMENT 'total number of items, 0 if unknown',
+    processed INT NOT NULL DEFAULT 0 COMMENT 'number of items processed',
+    failed INT NOT NULL DEFAULT 0 COMMENT 'number of items failed',
+    result_msg VARCHAR(255) NOT NULL DEFAULT '' COMMENT 'result message',
+    err_msg VARCHAR(1000) NOT NULL DEFAULT '' COMMENT 'error message',
+    cancel_req TINYINT NOT NULL DEFAULT 0 COMMENT 'whether the job is requested to cancel',
+    start_time DATETIME NULL DEFAULT NULL COMMENT 'start time',
+    end_time DATETIME NULL DEFAULT NULL COMMENT 'end time',
+    create_time TIMESTAMP DEFAULT CURRENT_TIMESTAMP COMMENT 'created at',
+    update_time TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP COMMENT 'updated at',
+    UNIQUE KEY job_no_uk (job_no),
+    KEY user_no_idx (user_no),
+    KEY status_update_time_idx (status, update_time)
+) ENGINE=INNODB DEFAULT CHARSET=utf8mb4 COMMENT='Background Job';
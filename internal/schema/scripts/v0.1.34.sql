@@ -0,0 +1,12 @@
+CREATE TABLE IF NOT EXISTS idempotency_record (
+    id INT UNSIGNED PRIMARY KEY AUTO_INCREMENT COMMENT 'primary key',
+    user_no VARCHAR(32) NOT NULL COMMENT 'user no',
+    idem_key VARCHAR(128) NOT NULL COMMENT 'value of Idempotency-Key header',
+    endpoint VARCHAR(128) NOT NULL COMMENT 'request path',
+    req_hash CHAR(64) NOT NULL COMMENT 'sha256 of request path and payload',
+    response MEDIUMTEXT COMMENT 'response of the first request in json',
+    expire_time DATETIME NOT NULL COMMENT 'expire time',
+    create_time TIMESTAMP DEFAULT CURRENT_TIMESTAMP COMMENT 'created at',
+    UNIQUE KEY user_key_uk (user_no, idem_key),
+    KEY expire_time_idx (expire_time)
+) ENGINE=INNODB DEFAULT CHARSET=utf8mb4 COMMENT='Idempotency Record';
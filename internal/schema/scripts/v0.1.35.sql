@@ -0,0 +1,15 @@
+CREATE TABLE IF NOT EXISTS s3_access_key (
+    id INT UNSIGNED PRIMARY KEY AUTO_INCREMENT COMMENT 'primary key',
+    access_key VARCHAR(32) NOT NULL COMMENT 'access key id',
+    secret_key VARCHAR(64) NOT NULL COMMENT 'secret key used to sign the requests',
+    name VARCHAR(255) NOT NULL DEFAULT '' COMMENT 'name of the access key',
+    user_no VARCHAR(32) NOT NULL COMMENT 'owner user_no',
+    username VARCHAR(255) NOT NULL DEFAULT '' COMMENT 'owner username',
+    create_time TIMESTAMP DEFAULT CURRENT_TIMESTAMP COMMENT 'created at',
+    create_by VARCHAR(255) NOT NULL DEFAULT '' COMMENT 'created by',
+    update_time TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP COMMENT 'updated at',
+    update_by VARCHAR(255) NOT NULL DEFAULT '' COMMENT 'updated by',
+    is_del TINYINT NOT NULL DEFAULT 0 COMMENT '0-normal, 1-deleted',
+    UNIQUE KEY access_key_uk (access_key),
+    KEY user_no_idx (user_no)
+) ENGINE=INNODB DEFAULT CHARSET=utf8mb4 COMMENT='S3 Access Key';
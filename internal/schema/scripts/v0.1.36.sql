@@ -0,0 +1,2 @@
+ALTER TABLE file_info
+    ADD COLUMN mime_type VARCHAR(128) NOT NULL DEFAULT '' COMMENT 'media type detected from the content, empty if not yet detected';
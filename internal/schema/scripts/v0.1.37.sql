@@ -0,0 +1,6 @@
+ALTER TABLE file_info
+    ADD COLUMN thumbnail_status VARCHAR(16) NOT NULL DEFAULT '' COMMENT 'thumbnail status: PENDING, DONE, FAILED, UNSUPPORTED, empty if thumbnail generation is never triggered',
+    ADD COLUMN thumbnail_attempts INT NOT NULL DEFAULT 0 COMMENT 'number of thumbnail generation attempts',
+    ADD COLUMN thumbnail_err VARCHAR(1000) NOT NULL DEFAULT '' COMMENT 'last thumbnail generation error',
+    ADD COLUMN thumbnail_retry_time TIMESTAMP NULL DEFAULT NULL COMMENT 'when thumbnail generation is retried (or considered timed out if pending), null if no retry is needed',
+    ADD KEY thumbnail_status_retry_idx (thumbnail_status, thumbnail_retry_time);
@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS file_thumbnail_variant (
+    id INT UNSIGNED PRIMARY KEY AUTO_INCREMENT COMMENT 'primary key',
+    file_key VARCHAR(64) NOT NULL COMMENT 'file key',
+    variant VARCHAR(16) NOT NULL COMMENT 'variant name: small, medium, large',
+    fstore_file_id VARCHAR(32) NOT NULL COMMENT 'mini-fstore file id of the variant',
+    width INT NOT NULL DEFAULT 0 COMMENT 'width in pixels',
+    height INT NOT NULL DEFAULT 0 COMMENT 'height in pixels',
+    create_time TIMESTAMP DEFAULT CURRENT_TIMESTAMP COMMENT 'created at',
+    update_time TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP COMMENT 'updated at',
+    UNIQUE KEY file_key_variant_uk (file_key, variant)
+) ENGINE=INNODB DEFAULT CHARSET=utf8mb4 COMMENT='Thumbnail Variants';
@@ -0,0 +1,2 @@
+ALTER TABLE file_info
+    ADD COLUMN excerpt VARCHAR(1000) NOT NULL DEFAULT '' COMMENT 'short text excerpt of pdf or text-like file';
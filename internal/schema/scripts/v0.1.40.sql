@@ -0,0 +1,22 @@
+CREATE TABLE IF NOT EXISTS image_metadata (
+    id INT UNSIGNED PRIMARY KEY AUTO_INCREMENT COMMENT 'primary key',
+    file_key VARCHAR(64) NOT NULL COMMENT 'file key',
+    capture_time DATETIME DEFAULT NULL COMMENT 'when the photo is taken',
+    make VARCHAR(64) NOT NULL DEFAULT '' COMMENT 'camera make',
+    model VARCHAR(64) NOT NULL DEFAULT '' COMMENT 'camera model',
+    orientation INT NOT NULL DEFAULT 0 COMMENT 'EXIF orientation (1-8), 0 if unknown',
+    width INT NOT NULL DEFAULT 0 COMMENT 'width in pixels',
+    height INT NOT NULL DEFAULT 0 COMMENT 'height in pixels',
+    latitude DOUBLE DEFAULT NULL COMMENT 'GPS latitude in degrees',
+    longitude DOUBLE DEFAULT NULL COMMENT 'GPS longitude in degrees',
+    create_time TIMESTAMP DEFAULT CURRENT_TIMESTAMP COMMENT 'created at',
+    update_time TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP COMMENT 'updated at',
+    UNIQUE KEY file_key_uk (file_key),
+    KEY capture_time_idx (capture_time)
+) ENGINE=INNODB DEFAULT CHARSET=utf8mb4 COMMENT='Image Metadata extracted from EXIF/XMP';
+
+ALTER TABLE gallery
+    ADD KEY user_no_idx (user_no);
+
+ALTER TABLE gallery_user_access
+    ADD KEY user_no_idx (user_no);
@@ -0,0 +1,11 @@
+ALTER TABLE gallery_image
+    ADD COLUMN position INT DEFAULT NULL COMMENT 'position chosen by the owner, null if the image is ordered by capture time',
+    ADD KEY gallery_position_idx (gallery_no, position);
+
+ALTER TABLE gallery
+    ADD COLUMN cover_image_no VARCHAR(32) NOT NULL DEFAULT '' COMMENT 'image_no of the cover image, the latest added image is used if empty';
+
+ALTER TABLE gallery_image
+    ADD COLUMN dir_synced TINYINT NOT NULL DEFAULT 0 COMMENT 'whether the image is added by directory gallery sync, 0-false, 1-true';
+
+UPDATE gallery_image gi JOIN gallery g ON g.gallery_no = gi.gallery_no SET gi.dir_synced = 1 WHERE g.dir_file_key != '';
@@ -0,0 +1,14 @@
+ALTER TABLE gallery
+    ADD COLUMN auto_created TINYINT NOT NULL DEFAULT 0 COMMENT 'whether the gallery is created automatically for the directory, 0-false, 1-true';
+
+UPDATE gallery SET auto_created = 1 WHERE dir_file_key != '';
+
+CREATE TABLE IF NOT EXISTS gallery_auto_pref (
+    id INT UNSIGNED PRIMARY KEY AUTO_INCREMENT COMMENT 'primary key',
+    user_no VARCHAR(32) NOT NULL COMMENT 'user_no',
+    dir_file_key VARCHAR(64) NOT NULL DEFAULT '' COMMENT 'directory file key, empty for the default of the user',
+    enabled TINYINT NOT NULL DEFAULT 1 COMMENT 'whether galleries are created automatically, 0-false, 1-true',
+    create_time TIMESTAMP DEFAULT CURRENT_TIMESTAMP COMMENT 'created at',
+    update_time TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP COMMENT 'updated at',
+    UNIQUE KEY user_no_dir_uk (user_no, dir_file_key)
+) ENGINE=INNODB DEFAULT CHARSET=utf8mb4 COMMENT='Preference of automatic gallery creation';
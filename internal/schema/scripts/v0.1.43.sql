@@ -0,0 +1,7 @@
+ALTER TABLE gallery_user_access
+    ADD COLUMN role VARCHAR(16) NOT NULL DEFAULT 'VIEWER' COMMENT 'role of the user: VIEWER, CONTRIBUTOR';
+
+ALTER TABLE gallery_image
+    ADD COLUMN added_by_no VARCHAR(64) NOT NULL DEFAULT '' COMMENT 'user_no of the user who added the image';
+
+UPDATE gallery_image gi JOIN gallery g ON g.gallery_no = gi.gallery_no SET gi.added_by_no = g.user_no WHERE gi.added_by_no = '';
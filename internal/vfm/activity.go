@@ -0,0 +1,162 @@
+package vfm
+
+import (
+	"fmt"
+
+	"github.com/curtisnewbie/miso/middleware/mysql"
+	"github.com/curtisnewbie/miso/middleware/user-vault/common"
+	"github.com/curtisnewbie/miso/miso"
+	"github.com/curtisnewbie/miso/util"
+	"gorm.io/gorm"
+)
+
+const (
+	ActRefFile    = "FILE"
+	ActRefVFolder = "VFOLDER"
+	ActRefGallery = "GALLERY"
+	ActRefVerFile = "VERSIONED_FILE"
+
+	ActionCreate       = "CREATE"
+	ActionMove         = "MOVE"
+	ActionUpdate       = "UPDATE"
+	ActionDelete       = "DELETE"
+	ActionTruncate     = "TRUNCATE"
+	ActionShare        = "SHARE"
+	ActionUnshare      = "UNSHARE"
+	ActionAddFile      = "ADD_FILE"
+	ActionRemoveFile   = "REMOVE_FILE"
+	ActionGrantAccess  = "GRANT_ACCESS"
+	ActionRevokeAccess = "REVOKE_ACCESS"
+	ActionAddImage     = "ADD_IMAGE"
+	ActionRemoveImage  = "REMOVE_IMAGE"
+)
+
+type Activity struct {
+	RefType string // type of the resource being operated: FILE, VFOLDER, GALLERY, VERSIONED_FILE
+	RefKey  string // key of the resource being operated
+	FileKey string // file involved in the operation, may be empty
+	Action  string
+	Detail  string
+}
+
+// Append an activity_log record.
+//
+// The record should be written using the same tx as the operation, so that it's rollbacked together.
+func RecordActivity(rail miso.Rail, tx *gorm.DB, act Activity, user common.User) error {
+	err := tx.Exec(`INSERT INTO activity_log (ref_type, ref_key, file_key, action, detail, user_no, username, create_time)
+		VALUES (?,?,?,?,?,?,?,?)`,
+		act.RefType, act.RefKey, act.FileKey, act.Action, util.MaxLenStr(act.Detail, 1000), user.UserNo, user.Username,
+		util.Now()).Error
+	if err != nil {
+		return fmt.Errorf("failed to save activity_log, %+v, %v", act, err)
+	}
+	rail.Debugf("Recorded activity %+v by %v", act, user.Username)
+	return nil
+}
+
+type ListedActivity struct {
+	Id         int64      `json:"id"`
+	RefType    string     `json:"refType" desc:"type of the resource being operated: FILE, VFOLDER, GALLERY, VERSIONED_FILE"`
+	RefKey     string     `json:"refKey" desc:"key of the resource being operated"`
+	FileKey    string     `json:"fileKey" desc:"file involved in the operation"`
+	Action     string     `json:"action"`
+	Detail     string     `json:"detail"`
+	UserNo     string     `json:"userNo" desc:"operator's user_no"`
+	Username   string     `json:"username" desc:"operator's username"`
+	CreateTime util.ETime `json:"createTime"`
+}
+
+type ListFileActivityReq struct {
+	Paging  miso.Paging `json:"paging"`
+	FileKey string      `json:"fileKey" valid:"notEmpty"`
+}
+
+// List activities of a file, user must have access to the file.
+//
+// Both files and directories are supported, deleted ones included.
+func ListFileActivities(rail miso.Rail, db *gorm.DB, req ListFileActivityReq, user common.User) (miso.PageRes[ListedActivity], error) {
+	if err := checkFileHistoryAccess(rail, db, req.FileKey, user.UserNo); err != nil {
+		return miso.PageRes[ListedActivity]{}, err
+	}
+	return listActivities(rail, db, req.Paging, func(tx *gorm.DB) *gorm.DB {
+		return tx.Where("file_key = ?", req.FileKey)
+	})
+}
+
+type ListUserActivityReq struct {
+	Paging  miso.Paging `json:"paging"`
+	RefType *string     `json:"refType" desc:"type of the resource being operated: FILE, VFOLDER, GALLERY, VERSIONED_FILE"`
+}
+
+// List activities operated by the user.
+func ListUserActivities(rail miso.Rail, db *gorm.DB, req ListUserActivityReq, user common.User) (miso.PageRes[ListedActivity], error) {
+	return listActivities(rail, db, req.Paging, func(tx *gorm.DB) *gorm.DB {
+		tx = tx.Where("user_no = ?", user.UserNo)
+		if req.RefType != nil && *req.RefType != "" {
+			tx = tx.Where("ref_type = ?", *req.RefType)
+		}
+		return tx
+	})
+}
+
+type ListVFolderActivityReq struct {
+	Paging   miso.Paging `json:"paging"`
+	FolderNo string      `json:"folderNo" valid:"notEmpty"`
+}
+
+// List activities inside the vfolder, including the ones operated on the files in the vfolder, only the owner can do so.
+func ListVFolderActivities(rail miso.Rail, db *gorm.DB, req ListVFolderActivityReq, user common.User) (miso.PageRes[ListedActivity], error) {
+	vfo, err := findVFolder(rail, db, req.FolderNo, user.UserNo)
+	if err != nil {
+		return miso.PageRes[ListedActivity]{}, miso.NewErrf("VFolder not found").WithInternalMsg("%v", err)
+	}
+	if !vfo.IsOwner() {
+		return miso.PageRes[ListedActivity]{}, miso.NewErrf("Operation not permitted")
+	}
+	return listActivities(rail, db, req.Paging, func(tx *gorm.DB) *gorm.DB {
+		return tx.Where("(ref_type = ? AND ref_key = ?) OR file_key IN (SELECT uuid FROM file_vfolder WHERE folder_no = ? AND is_del = 0)",
+			ActRefVFolder, req.FolderNo, req.FolderNo)
+	})
+}
+
+func listActivities(rail miso.Rail, db *gorm.DB, paging miso.Paging, where func(tx *gorm.DB) *gorm.DB) (miso.PageRes[ListedActivity], error) {
+	return mysql.NewPageQuery[ListedActivity]().
+		WithPage(paging).
+		WithBaseQuery(func(tx *gorm.DB) *gorm.DB {
+			return where(tx.Table("activity_log"))
+		}).
+		WithSelectQuery(func(tx *gorm.DB) *gorm.DB {
+			return tx.Select("id, ref_type, ref_key, file_key, action, detail, user_no, username, create_time").
+				Order("id DESC")
+		}).
+		Exec(rail, db)
+}
+
+// Check whether the user can view history of the file, i.e., the user is the owner or has access to a vfolder that
+// contains the file. Unlike validateFileAccess, deleted files and directories are accepted.
+func checkFileHistoryAccess(rail miso.Rail, tx *gorm.DB, fileKey string, userNo string) error {
+	var f FileInfo
+	t := tx.Raw(`SELECT id, uploader_no FROM file_info WHERE uuid = ? LIMIT 1`, fileKey).Scan(&f)
+	if t.Error != nil {
+		return fmt.Errorf("failed to query file_info, uuid: %v, %v", fileKey, t.Error)
+	}
+	if t.RowsAffected < 1 {
+		return miso.NewErrf("File not found")
+	}
+	if f.UploaderNo == userNo {
+		return nil
+	}
+
+	var uvid int
+	err := tx.Raw(`SELECT uv.id FROM file_vfolder fv
+		JOIN user_vfolder uv ON uv.folder_no = fv.folder_no AND uv.user_no = ? AND uv.is_del = 0
+		WHERE fv.uuid = ? AND fv.is_del = 0 LIMIT 1`, userNo, fileKey).
+		Scan(&uvid).Error
+	if err != nil {
+		return fmt.Errorf("failed to query user folder relation for file, uuid: %v, %v", fileKey, err)
+	}
+	if uvid < 1 {
+		return miso.NewErrf("You are not permitted to access this file")
+	}
+	return nil
+}
@@ -0,0 +1,495 @@
+package vfm
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	fstore "github.com/curtisnewbie/mini-fstore/api"
+	"github.com/curtisnewbie/miso/middleware/redis"
+	"github.com/curtisnewbie/miso/middleware/user-vault/common"
+	"github.com/curtisnewbie/miso/miso"
+	"github.com/curtisnewbie/miso/util"
+	"gorm.io/gorm"
+)
+
+const (
+	// all items are applied in one transaction, if any of them fails, nothing is changed
+	BatchModeAllOrNothing = "ALL_OR_NOTHING"
+
+	// each item is applied in its own transaction, failed items don't affect the others
+	BatchModeBestEffort = "BEST_EFFORT"
+
+	// error code of the items that are not applied because other item failed (all-or-nothing mode)
+	BatchErrCodeRollback = "ROLLBACK"
+
+	maxBatchItems = 200
+)
+
+type BatchItemResult struct {
+	FileKey    string `json:"fileKey"`
+	Success    bool   `json:"success"`
+	NewFileKey string `json:"newFileKey" desc:"file key of the copy, only for copy operation"`
+	ErrCode    string `json:"errCode"`
+	ErrMsg     string `json:"errMsg"`
+}
+
+type BatchRes struct {
+	Success bool              `json:"success" desc:"whether all items are applied"`
+	Results []BatchItemResult `json:"results" desc:"result of each item, in the same order as the requested items"`
+}
+
+// Error of the item that failed first, nil if all items are applied.
+func (r BatchRes) FirstErr() error {
+	for _, it := range r.Results {
+		if !it.Success && it.ErrCode != BatchErrCodeRollback {
+			return miso.NewErrf(it.ErrMsg).WithCode(it.ErrCode)
+		}
+	}
+	return nil
+}
+
+// batch item, the functions are called while the locks are held.
+type batchItem struct {
+	fileKey  string
+	lockKeys []string
+
+	// called before the transaction, e.g., copying files in mini-fstore, optional
+	prepare func(rail miso.Rail) error
+
+	// called in the transaction
+	exec func(rail miso.Rail, tx *gorm.DB) error
+
+	// called after the transaction is committed, optional
+	onCommitted func(rail miso.Rail)
+
+	// called after the transaction is rollbacked or when exec is not called at all (but prepare is), optional
+	onRollbacked func(rail miso.Rail)
+
+	// file key of the copy
+	newFileKey string
+}
+
+func checkBatchReq(mode string, cnt int) error {
+	if mode != BatchModeAllOrNothing && mode != BatchModeBestEffort {
+		return miso.NewErrf("Illegal batch mode, should be either %v or %v", BatchModeAllOrNothing, BatchModeBestEffort)
+	}
+	if cnt < 1 {
+		return miso.NewErrf("Nothing to process")
+	}
+	if cnt > maxBatchItems {
+		return miso.NewErrf("At most %v items are allowed in one batch", maxBatchItems)
+	}
+	return nil
+}
+
+func execBatch(rail miso.Rail, db *gorm.DB, mode string, items []*batchItem) BatchRes {
+	if mode == BatchModeAllOrNothing {
+		return execBatchAllOrNothing(rail, db, items)
+	}
+	return execBatchBestEffort(rail, db, items)
+}
+
+func execBatchAllOrNothing(rail miso.Rail, db *gorm.DB, items []*batchItem) BatchRes {
+	res := BatchRes{Results: make([]BatchItemResult, len(items))}
+	for i, it := range items {
+		res.Results[i] = BatchItemResult{FileKey: it.fileKey}
+	}
+
+	keys := []string{}
+	for _, it := range items {
+		keys = append(keys, it.lockKeys...)
+	}
+	unlock, err := lockFilesInOrder(rail, keys)
+	if err != nil {
+		for i := range res.Results {
+			res.Results[i] = batchItemFailed(rail, res.Results[i], err)
+		}
+		return res
+	}
+	defer unlock()
+
+	prepared := 0
+	failed := -1
+	for i, it := range items {
+		if it.prepare != nil {
+			if err = it.prepare(rail); err != nil {
+				failed = i
+				break
+			}
+		}
+		prepared += 1
+	}
+
+	if failed < 0 {
+		err = db.Transaction(func(tx *gorm.DB) error {
+			for i, it := range items {
+				if err := it.exec(rail, tx); err != nil {
+					failed = i
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil && failed < 0 {
+			failed = len(items) - 1 // commit failed, blame the last one
+		}
+	}
+
+	if failed >= 0 {
+		for i := 0; i < prepared; i++ {
+			if items[i].onRollbacked != nil {
+				items[i].onRollbacked(rail)
+			}
+		}
+		for i := range res.Results {
+			if i == failed {
+				res.Results[i] = batchItemFailed(rail, res.Results[i], err)
+			} else {
+				res.Results[i].ErrCode = BatchErrCodeRollback
+				res.Results[i].ErrMsg = "Not applied because other item failed"
+			}
+		}
+		return res
+	}
+
+	res.Success = true
+	for i, it := range items {
+		if it.onCommitted != nil {
+			it.onCommitted(rail)
+		}
+		res.Results[i].Success = true
+		res.Results[i].NewFileKey = it.newFileKey
+	}
+	return res
+}
+
+func execBatchBestEffort(rail miso.Rail, db *gorm.DB, items []*batchItem) BatchRes {
+	res := BatchRes{Success: true, Results: make([]BatchItemResult, len(items))}
+	for i, it := range items {
+		r := BatchItemResult{FileKey: it.fileKey}
+		if err := execBatchItem(rail, db, it); err != nil {
+			r = batchItemFailed(rail, r, err)
+			res.Success = false
+		} else {
+			r.Success = true
+			r.NewFileKey = it.newFileKey
+		}
+		res.Results[i] = r
+	}
+	return res
+}
+
+func execBatchItem(rail miso.Rail, db *gorm.DB, it *batchItem) error {
+	unlock, err := lockFilesInOrder(rail, it.lockKeys)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	if it.prepare != nil {
+		if err := it.prepare(rail); err != nil {
+			return err
+		}
+	}
+	if err := db.Transaction(func(tx *gorm.DB) error { return it.exec(rail, tx) }); err != nil {
+		if it.onRollbacked != nil {
+			it.onRollbacked(rail)
+		}
+		return err
+	}
+	if it.onCommitted != nil {
+		it.onCommitted(rail)
+	}
+	return nil
+}
+
+func batchItemFailed(rail miso.Rail, r BatchItemResult, err error) BatchItemResult {
+	r.Success = false
+	var me *miso.MisoErr
+	if errors.As(err, &me) {
+		r.ErrCode = me.Code
+		if r.ErrCode == "" {
+			r.ErrCode = miso.ErrCodeGeneric
+		}
+		r.ErrMsg = me.Msg
+		return r
+	}
+	rail.Errorf("Batch item failed, fileKey: %v, %v", r.FileKey, err)
+	r.ErrCode = miso.ErrCodeGeneric
+	r.ErrMsg = ErrUnknown.Msg
+	return r
+}
+
+// Lock the files in a deterministic order, so that concurrent batches don't wait for each other in a cycle.
+func lockFilesInOrder(rail miso.Rail, fileKeys []string) (func(), error) {
+	set := util.NewSet[string]()
+	for _, k := range fileKeys {
+		if k != "" {
+			set.Add(k)
+		}
+	}
+	keys := set.CopyKeys()
+	sort.Strings(keys)
+
+	locks := make([]*redis.RLock, 0, len(keys))
+	unlock := func() {
+		for i := len(locks) - 1; i >= 0; i-- {
+			locks[i].Unlock()
+		}
+	}
+	for _, k := range keys {
+		l := fileLock(rail, k)
+		if err := l.Lock(); err != nil {
+			unlock()
+			return nil, miso.NewErrf("File is being processed, please try again later").
+				WithInternalMsg("failed to lock file, fileKey: %v, %v", k, err)
+		}
+		locks = append(locks, l)
+	}
+	return unlock, nil
+}
+
+func findBatchFile(rail miso.Rail, tx *gorm.DB, fileKey string) (FileInfo, error) {
+	f, err := findFile(rail, tx, fileKey)
+	if err != nil {
+		return FileInfo{}, fmt.Errorf("failed to find file, uuid: %v, %v", fileKey, err)
+	}
+	if f == nil || f.IsLogicDeleted == LDelY {
+		return FileInfo{}, miso.NewErrf("File not found")
+	}
+	return *f, nil
+}
+
+type BatchMoveFileReq struct {
+	Mode  string           `json:"mode" desc:"ALL_OR_NOTHING or BEST_EFFORT"`
+	Items []MoveIntoDirReq `json:"items"`
+}
+
+// Move files into directories in batch.
+func BatchMoveFiles(rail miso.Rail, db *gorm.DB, req BatchMoveFileReq, user common.User) (BatchRes, error) {
+	if err := checkBatchReq(req.Mode, len(req.Items)); err != nil {
+		return BatchRes{}, err
+	}
+	items := make([]*batchItem, 0, len(req.Items))
+	for _, r := range req.Items {
+		r := r
+		items = append(items, &batchItem{
+			fileKey:  r.Uuid,
+			lockKeys: []string{r.Uuid, r.ParentFileUuid},
+			exec: func(rail miso.Rail, tx *gorm.DB) error {
+				if r.Uuid == r.ParentFileUuid {
+					return nil
+				}
+				f, err := findBatchFile(rail, tx, r.Uuid)
+				if err != nil {
+					return err
+				}
+				if f.UploaderNo != user.UserNo {
+					return miso.NewErrf("Not permitted")
+				}
+				if f.ParentFile == r.ParentFileUuid {
+					return nil
+				}
+				return _moveFileToDir(rail, tx, f, r.ParentFileUuid, user)
+			},
+		})
+	}
+	return execBatch(rail, db, req.Mode, items), nil
+}
+
+type BatchDeleteFilesReq struct {
+	Mode     string   `json:"mode" desc:"ALL_OR_NOTHING or BEST_EFFORT"`
+	FileKeys []string `json:"fileKeys" desc:"files to delete, directories must be empty when it's their turn"`
+}
+
+// Delete files in batch.
+//
+// Files in mini-fstore are deleted after the transaction is committed.
+func BatchDeleteFiles(rail miso.Rail, db *gorm.DB, req BatchDeleteFilesReq, user common.User) (BatchRes, error) {
+	if err := checkBatchReq(req.Mode, len(req.FileKeys)); err != nil {
+		return BatchRes{}, err
+	}
+	items := make([]*batchItem, 0, len(req.FileKeys))
+	for _, fk := range req.FileKeys {
+		fk := fk
+		var deleted *FileInfo
+		items = append(items, &batchItem{
+			fileKey:  fk,
+			lockKeys: []string{fk},
+			exec: func(rail miso.Rail, tx *gorm.DB) error {
+				f, err := findFile(rail, tx, fk)
+				if err != nil {
+					return fmt.Errorf("failed to find file, uuid: %v, %v", fk, err)
+				}
+				if f == nil {
+					return miso.NewErrf("File not found")
+				}
+				deletable, err := checkFileDeletable(rail, tx, *f, user, nil)
+				if err != nil || !deletable {
+					return err
+				}
+				if err := _markFileDeleted(rail, tx, *f, user); err != nil {
+					return err
+				}
+				deleted = f
+				return nil
+			},
+			onCommitted: func(rail miso.Rail) {
+				if deleted == nil {
+					return
+				}
+				// the file is already marked deleted, the mini-fstore files left are reported by reconciliation
+				if err := deleteFstoreFiles(rail, db, *deleted); err != nil {
+					rail.Errorf("Failed to delete mini-fstore files of deleted file, uuid: %v, %v", deleted.Uuid, err)
+				}
+			},
+		})
+	}
+	return execBatch(rail, db, req.Mode, items), nil
+}
+
+type BatchCopyFilesReq struct {
+	Mode          string   `json:"mode" desc:"ALL_OR_NOTHING or BEST_EFFORT"`
+	FileKeys      []string `json:"fileKeys" desc:"files to copy, directories are not supported"`
+	ParentFileKey string   `json:"parentFileKey" desc:"directory that the copies are saved to, empty for top-level"`
+}
+
+// Copy files into the directory in batch.
+//
+// Files are copied in mini-fstore before the transaction, the copies are deleted if the transaction is rollbacked.
+func BatchCopyFiles(rail miso.Rail, db *gorm.DB, req BatchCopyFilesReq, user common.User) (BatchRes, error) {
+	if err := checkBatchReq(req.Mode, len(req.FileKeys)); err != nil {
+		return BatchRes{}, err
+	}
+	items := make([]*batchItem, 0, len(req.FileKeys))
+	for _, fk := range req.FileKeys {
+		fk := fk
+		var src FileInfo
+		var copied fstore.FstoreFile
+		it := &batchItem{
+			fileKey:  fk,
+			lockKeys: []string{fk, req.ParentFileKey},
+		}
+		it.prepare = func(rail miso.Rail) error {
+			f, err := findBatchFile(rail, db, fk)
+			if err != nil {
+				return err
+			}
+			if f.UploaderNo != user.UserNo {
+				return miso.NewErrf("Not permitted")
+			}
+			if f.FileType != FileTypeFile {
+				return miso.NewErrf("Copying directory is not supported")
+			}
+			src = f
+			copied, err = copyFstoreFile(rail, f.FstoreFileId, f.Name)
+			return err
+		}
+		it.exec = func(rail miso.Rail, tx *gorm.DB) error {
+			f := FileInfo{
+				Name:         src.Name,
+				Uuid:         util.GenIdP("ZZZ"),
+				FstoreFileId: copied.FileId,
+				SizeInBytes:  copied.Size,
+				FileType:     FileTypeFile,
+			}
+			if err := _saveFile(rail, tx, f, user); err != nil {
+				return err
+			}
+			if req.ParentFileKey != "" {
+				if err := _moveFileToDir(rail, tx, f, req.ParentFileKey, user); err != nil {
+					return err
+				}
+			}
+			it.newFileKey = f.Uuid
+			return nil
+		}
+		it.onRollbacked = func(rail miso.Rail) {
+			if copied.FileId == "" {
+				return
+			}
+			if err := fstore.DeleteFile(rail, copied.FileId); err != nil && !errors.Is(err, fstore.ErrFileDeleted) {
+				rail.Errorf("Failed to delete copied mini-fstore file, fileId: %v, %v", copied.FileId, err)
+			}
+		}
+		items = append(items, it)
+	}
+	return execBatch(rail, db, req.Mode, items), nil
+}
+
+// Copy mini-fstore file by streaming it back to mini-fstore.
+func copyFstoreFile(rail miso.Rail, fileId string, name string) (fstore.FstoreFile, error) {
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(fstore.DownloadFileDirect(rail, fileId, pw))
+	}()
+	uploadFileId, err := fstore.UploadFile(rail, name, pr)
+	pr.CloseWithError(io.ErrClosedPipe) // in case the upload failed halfway
+	if err != nil {
+		return fstore.FstoreFile{}, fmt.Errorf("failed to copy mini-fstore file, fileId: %v, %w", fileId, err)
+	}
+
+	ff, err := fstore.FetchFileInfo(rail, fstore.FetchFileInfoReq{UploadFileId: uploadFileId})
+	if err != nil {
+		return fstore.FstoreFile{}, fmt.Errorf("failed to fetch copied mini-fstore file, uploadFileId: %v, %w", uploadFileId, err)
+	}
+	rail.Infof("Copied mini-fstore file %v to %v", fileId, ff.FileId)
+	return ff, nil
+}
+
+type BatchRenameItem struct {
+	FileKey string `json:"fileKey"`
+	Name    string `json:"name"`
+}
+
+type BatchRenameFilesReq struct {
+	Mode  string            `json:"mode" desc:"ALL_OR_NOTHING or BEST_EFFORT"`
+	Items []BatchRenameItem `json:"items"`
+}
+
+// Rename files in batch.
+func BatchRenameFiles(rail miso.Rail, db *gorm.DB, req BatchRenameFilesReq, user common.User) (BatchRes, error) {
+	if err := checkBatchReq(req.Mode, len(req.Items)); err != nil {
+		return BatchRes{}, err
+	}
+	items := make([]*batchItem, 0, len(req.Items))
+	for _, r := range req.Items {
+		r := r
+		items = append(items, &batchItem{
+			fileKey:  r.FileKey,
+			lockKeys: []string{r.FileKey},
+			exec: func(rail miso.Rail, tx *gorm.DB) error {
+				name := strings.TrimSpace(r.Name)
+				if name == "" {
+					return miso.NewErrf("Name can't be empty")
+				}
+				f, err := findBatchFile(rail, tx, r.FileKey)
+				if err != nil {
+					return err
+				}
+				if f.UploaderNo != user.UserNo {
+					return miso.NewErrf("Not permitted")
+				}
+				if f.Name == name {
+					return nil
+				}
+				err = tx.Exec("UPDATE file_info SET name = ?, update_by = ? WHERE id = ? AND is_logic_deleted = 0 AND is_del = 0",
+					name, user.Username, f.Id).Error
+				if err != nil {
+					return fmt.Errorf("failed to update file name, uuid: %v, %v", f.Uuid, err)
+				}
+				return RecordActivity(rail, tx, Activity{
+					RefType: ActRefFile,
+					RefKey:  f.Uuid,
+					FileKey: f.Uuid,
+					Action:  ActionUpdate,
+					Detail:  fmt.Sprintf("Updated name from '%v' to '%v'", f.Name, name),
+				}, user)
+			},
+		})
+	}
+	return execBatch(rail, db, req.Mode, items), nil
+}
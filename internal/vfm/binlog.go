@@ -66,5 +66,20 @@ func SubscribeBinlogChanges(rail miso.Rail) error {
 		Listener:      OnFileMoved,
 	})
 
+	binlog.SubscribeBinlogEventsOnBootstrapV2(binlog.SubscribeBinlogOption{
+		Pipeline: client.Pipeline{
+			Schema:     miso.GetPropStr(mysql.PropMySQLSchema),
+			Table:      "file_info",
+			EventTypes: []client.EventType{client.EventTypeUpdate},
+			Stream:     "event.bus.vfm.file.renamed",
+			Condition: client.Condition{
+				ColumnChanged: []string{"name"},
+			},
+		},
+		Concurrency:   2,
+		ContinueOnErr: true,
+		Listener:      OnFileRenamed,
+	})
+
 	return nil
 }
@@ -130,13 +130,26 @@ func ProcessUploadedBookmarkFile(rail miso.Rail, path string, user common.User)
 		return ErrUnknown.WithInternalMsg("open temp file failed, path: %v", path)
 	}
 
-	go func() {
-		rail := rail.NextSpan()
-		err := SaveBookmarks(rail, mysql.GetMySQL(), bookmarkFile, user)
-		if err != nil {
-			rail.Errorf("failed to save bookmark, user: %s, %v", user.Username, err)
-		}
-	}()
+	_, err = SubmitJob(rail, mysql.GetMySQL(), SubmitJobReq{
+		JobType: JobTypeImportBookmarks,
+		Param:   bookmarkFile,
+	}, user)
+	return err
+}
+
+func runImportBookmarksJob(rail miso.Rail, db *gorm.DB, jc *JobCtx) error {
+	var bookmarkFile NetscapeBookmarkFile
+	if err := jc.Param(&bookmarkFile); err != nil {
+		return err
+	}
+	jc.Total = len(bookmarkFile.Bookmarks)
+
+	// bookmarks are inserted with IGNORE, it's safe to import them again when resumed
+	if err := SaveBookmarks(rail, db, bookmarkFile, jc.User); err != nil {
+		return fmt.Errorf("failed to save bookmark, user: %s, %v", jc.User.Username, err)
+	}
+	jc.Processed = jc.Total
+	jc.ResultMsg = fmt.Sprintf("%d bookmarks are imported", len(bookmarkFile.Bookmarks))
 	return nil
 }
 
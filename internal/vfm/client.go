@@ -1,6 +1,9 @@
 package vfm
 
 import (
+	"fmt"
+	"strings"
+	"sync"
 	"time"
 
 	fstore "github.com/curtisnewbie/mini-fstore/api"
@@ -11,6 +14,12 @@ import (
 
 var (
 	userIdInfoCache = redis.NewRCache[vault.UserInfo]("vfm:user:info:userno", redis.RCacheConfig{Exp: 5 * time.Minute, NoSync: true})
+
+	// whether the user can manage files, key is the user_no
+	manageFilesAccessCache = redis.NewRCache[bool]("vfm:user:manage-files", redis.RCacheConfig{Exp: time.Minute, NoSync: true})
+
+	manageFilesPathOnce sync.Once
+	manageFilesPath     miso.HttpRoute // one of the routes that are bound to ManageFilesResource
 )
 
 func CachedFindUser(rail miso.Rail, userNo string) (vault.UserInfo, error) {
@@ -22,6 +31,61 @@ func CachedFindUser(rail miso.Rail, userNo string) (vault.UserInfo, error) {
 	})
 }
 
+// Check whether the user is enabled and is allowed to manage files (ManageFilesResource).
+//
+// Requests that don't go through the gateway (e.g., WebDAV, S3) must be checked explicitly, the result is cached briefly.
+func CheckManageFilesAccess(rail miso.Rail, userNo string) (bool, error) {
+	return manageFilesAccessCache.Get(rail, userNo, func() (bool, error) {
+		u, err := vault.FindUser(rail, vault.FindUserReq{UserNo: &userNo})
+		if err != nil {
+			return false, err
+		}
+		if u.IsDisabled != 0 {
+			rail.Infof("User %v is disabled", userNo)
+			return false, nil
+		}
+		return testRoleResourceAccess(rail, u.RoleNo)
+	})
+}
+
+// Test whether the role has access to ManageFilesResource using one of the routes bound to it.
+func testRoleResourceAccess(rail miso.Rail, roleNo string) (bool, error) {
+	manageFilesPathOnce.Do(func() {
+		for _, r := range miso.GetHttpRoutes() {
+			if r.Resource == ManageFilesResource {
+				manageFilesPath = r
+				break
+			}
+		}
+	})
+	if manageFilesPath.Url == "" {
+		return false, fmt.Errorf("no route is bound to resource %v", ManageFilesResource)
+	}
+
+	url := manageFilesPath.Url
+	if !strings.HasPrefix(url, "/") {
+		url = "/" + url
+	}
+	var r miso.GnResp[struct {
+		Valid bool `json:"valid"`
+	}]
+	err := miso.NewDynTClient(rail, "/remote/path/resource/access-test", vault.ServiceName).
+		PostJson(map[string]string{
+			"roleNo": roleNo,
+			"url":    "/" + miso.GetPropStr(miso.PropAppName) + url,
+			"method": manageFilesPath.Method,
+		}).
+		Json(&r)
+	if err != nil {
+		return false, fmt.Errorf("failed to test resource access (user-vault), %v", err)
+	}
+	res, err := r.Res()
+	if err != nil {
+		return false, err
+	}
+	return res.Valid, nil
+}
+
 func GetFstoreTmpToken(rail miso.Rail, fileId string, filename string) (string, error) {
 	return fstore.GenTempFileKey(rail, fileId, filename)
 }
@@ -1,10 +1,8 @@
 package vfm
 
 import (
-	"fmt"
 	"time"
 
-	fstore "github.com/curtisnewbie/mini-fstore/api"
 	"github.com/curtisnewbie/miso/miso"
 	"gorm.io/gorm"
 )
@@ -14,10 +12,13 @@ func CompensateThumbnail(rail miso.Rail, tx *gorm.DB) error {
 	defer miso.TimeOp(rail, time.Now(), "CompensateThumbnail")
 
 	type FileProcInf struct {
-		Id           int
-		Name         string
-		Uuid         string
-		FstoreFileId string
+		Id              int
+		Name            string
+		Uuid            string
+		FstoreFileId    string
+		Thumbnail       string
+		MimeType        string
+		ThumbnailStatus string
 	}
 
 	limit := 500
@@ -26,14 +27,14 @@ func CompensateThumbnail(rail miso.Rail, tx *gorm.DB) error {
 	for {
 		var files []FileProcInf
 		t := tx.
-			Raw(`SELECT id, name, uuid, fstore_file_id
+			Raw(`SELECT id, name, uuid, fstore_file_id, thumbnail, mime_type, thumbnail_status
 			FROM file_info
 			WHERE id > ?
 			AND file_type = 'file'
 			AND is_logic_deleted = 0
-			AND thumbnail = ''
+			AND (mime_type = '' OR (thumbnail = '' AND thumbnail_status NOT IN (?, ?)))
 			ORDER BY id ASC
-			LIMIT ?`, minId, limit).
+			LIMIT ?`, minId, ThumbnailPending, ThumbnailUnsupported, limit).
 			Scan(&files)
 		if t.Error != nil {
 			return t.Error
@@ -43,26 +44,24 @@ func CompensateThumbnail(rail miso.Rail, tx *gorm.DB) error {
 		}
 
 		for _, f := range files {
-			if isImage(f.Name) {
-				event := fstore.ImgThumbnailTriggerEvent{Identifier: f.Uuid, FileId: f.FstoreFileId, ReplyTo: CompressImgNotifyEventBus}
-				if e := fstore.GenImgThumbnailPipeline.Send(rail, event); e != nil {
-					rail.Errorf("Failed to send CompressImageEvent, minId: %v, uuid: %v, %v", minId, f.Uuid, e)
-					return e
+			if f.MimeType == "" {
+				m, e := detectAndSaveMimeType(rail, tx, f.Uuid, f.FstoreFileId, f.Name)
+				if e != nil {
+					rail.Errorf("Failed to detect media type, uuid: %v, %v", f.Uuid, e)
+					continue
 				}
-				continue
+				f.MimeType = m
 			}
-
-			if isVideo(f.Name) {
-				evt := fstore.VidThumbnailTriggerEvent{
-					Identifier: f.Uuid,
-					FileId:     f.FstoreFileId,
-					ReplyTo:    GenVideoThumbnailNotifyEventBus,
-				}
-				if e := fstore.GenVidThumbnailPipeline.Send(rail, evt); e != nil {
-					return fmt.Errorf("failed to send %#v, uuid: %v, %v", evt, f.Uuid, e)
-				}
+			if f.Thumbnail != "" || f.ThumbnailStatus == ThumbnailPending {
 				continue
 			}
+			if e := resetThumbnailAttempts(tx, f.Uuid); e != nil {
+				return e
+			}
+			if e := triggerThumbnailGeneration(rail, tx, f.Uuid, f.FstoreFileId, f.Name, f.MimeType); e != nil {
+				rail.Errorf("Failed to trigger thumbnail generation, minId: %v, uuid: %v, %v", minId, f.Uuid, e)
+				return e
+			}
 		}
 
 		minId = files[len(files)-1].Id
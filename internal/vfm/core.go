@@ -14,6 +14,7 @@ import (
 	"github.com/curtisnewbie/miso/miso"
 	"github.com/curtisnewbie/miso/util"
 	vault "github.com/curtisnewbie/user-vault/api"
+	vfmapi "github.com/curtisnewbie/vfm/api"
 	"gorm.io/gorm"
 )
 
@@ -63,19 +64,25 @@ type ListedDir struct {
 }
 
 type ListedFile struct {
-	Id             int        `json:"id"`
-	Uuid           string     `json:"uuid"`
-	Name           string     `json:"name"`
-	UploadTime     util.ETime `json:"uploadTime"`
-	UploaderName   string     `json:"uploaderName"`
-	SizeInBytes    int64      `json:"sizeInBytes"`
-	FileType       string     `json:"fileType"`
-	UpdateTime     util.ETime `json:"updateTime"`
-	ParentFileName string     `json:"parentFileName"`
-	SensitiveMode  string     `json:"sensitiveMode"`
-	ThumbnailToken string     `json:"thumbnailToken"`
-	Thumbnail      string     `json:"-"`
-	ParentFile     string     `json:"-"`
+	Id              int                `json:"id"`
+	Uuid            string             `json:"uuid"`
+	Name            string             `json:"name"`
+	UploadTime      util.ETime         `json:"uploadTime"`
+	UploaderName    string             `json:"uploaderName"`
+	SizeInBytes     int64              `json:"sizeInBytes"`
+	FileCount       int                `json:"fileCount" desc:"recursive number of files (for dir)"`
+	DirCount        int                `json:"dirCount" desc:"recursive number of sub-directories (for dir)"`
+	FileType        string             `json:"fileType"`
+	UpdateTime      util.ETime         `json:"updateTime"`
+	ParentFileName  string             `json:"parentFileName"`
+	SensitiveMode   string             `json:"sensitiveMode"`
+	ThumbnailToken  string             `json:"thumbnailToken"`
+	MimeType        string             `json:"mimeType" desc:"media type detected from the content"`
+	ThumbnailStatus string             `json:"thumbnailStatus" desc:"thumbnail status: PENDING, DONE, FAILED, UNSUPPORTED"`
+	Excerpt         string             `json:"excerpt" desc:"short text excerpt of pdf or text-like file"`
+	Variants        []ThumbnailVariant `json:"variants" desc:"thumbnail variants in different sizes, ordered by width"`
+	Thumbnail       string             `json:"-"`
+	ParentFile      string             `json:"-"`
 }
 
 type GrantAccessReq struct {
@@ -128,28 +135,35 @@ func (f *FileDownloadInfo) IsFile() bool {
 }
 
 type FileInfo struct {
-	Id               int
-	Name             string
-	Uuid             string
-	FstoreFileId     string
-	Thumbnail        string // thumbnail is also a fstore's file_id
-	IsLogicDeleted   int
-	IsPhysicDeleted  int
-	SizeInBytes      int64
-	UploaderNo       string // uploader's user_no
-	UploaderName     string
-	UploadTime       util.ETime
-	LogicDeleteTime  util.ETime
-	PhysicDeleteTime util.ETime
-	UserGroup        int
-	FileType         string
-	ParentFile       string
-	CreateTime       util.ETime
-	CreateBy         string
-	UpdateTime       util.ETime
-	UpdateBy         string
-	IsDel            int
-	Hidden           bool
+	Id                int
+	Name              string
+	Uuid              string
+	FstoreFileId      string
+	Thumbnail         string // thumbnail is also a fstore's file_id
+	MimeType          string // media type detected from the content, empty if not yet detected
+	ThumbnailStatus   string // PENDING, DONE, FAILED, UNSUPPORTED, empty if thumbnail generation is never triggered
+	ThumbnailAttempts int
+	ThumbnailErr      string
+	Excerpt           string // short text excerpt of pdf or text-like file
+	IsLogicDeleted    int
+	IsPhysicDeleted   int
+	SizeInBytes       int64
+	FileCount         int    // recursive number of files (for dir)
+	DirCount          int    // recursive number of sub-directories (for dir)
+	UploaderNo        string // uploader's user_no
+	UploaderName      string
+	UploadTime        util.ETime
+	LogicDeleteTime   util.ETime
+	PhysicDeleteTime  util.ETime
+	UserGroup         int
+	FileType          string
+	ParentFile        string
+	CreateTime        util.ETime
+	CreateBy          string
+	UpdateTime        util.ETime
+	UpdateBy          string
+	IsDel             int
+	Hidden            bool
 }
 
 func (f FileInfo) IsZero() bool {
@@ -198,8 +212,8 @@ func listFilesInVFolder(rail miso.Rail, tx *gorm.DB, page miso.Paging, folderNo
 	return mysql.NewPageQuery[ListedFile]().
 		WithPage(page).
 		WithSelectQuery(func(tx *gorm.DB) *gorm.DB {
-			return tx.Select(`fi.id, fi.name, fi.parent_file, fi.uuid, fi.size_in_bytes,
-			fi.uploader_name, fi.upload_time, fi.file_type, fi.update_time, fi.thumbnail`).
+			return tx.Select(`fi.id, fi.name, fi.parent_file, fi.uuid, fi.size_in_bytes, fi.file_count, fi.dir_count,
+			fi.uploader_name, fi.upload_time, fi.file_type, fi.update_time, fi.thumbnail, fi.mime_type, fi.thumbnail_status, fi.excerpt`).
 				Order("fi.id DESC")
 		}).
 		WithBaseQuery(func(tx *gorm.DB) *gorm.DB {
@@ -273,6 +287,7 @@ func ListFiles(rail miso.Rail, tx *gorm.DB, req ListFileReq, user common.User) (
 		}
 	}
 
+	fileKeys := make([]string, 0, len(res.Payload))
 	for i, f := range res.Payload {
 		if f.Thumbnail != "" {
 			tkn, err := GetFstoreTmpToken(rail, f.Thumbnail, "")
@@ -281,8 +296,16 @@ func ListFiles(rail miso.Rail, tx *gorm.DB, req ListFileReq, user common.User) (
 			} else {
 				res.Payload[i].ThumbnailToken = tkn
 			}
+			fileKeys = append(fileKeys, f.Uuid)
 		}
+	}
 
+	variants, e := findThumbnailVariantsWithTokens(rail, tx, fileKeys)
+	if e != nil {
+		return res, e
+	}
+	for i, f := range res.Payload {
+		res.Payload[i].Variants = variants[f.Uuid]
 	}
 
 	return res, e
@@ -297,8 +320,8 @@ func listFilesSelective(rail miso.Rail, tx *gorm.DB, req ListFileReq, user commo
 	return mysql.NewPageQuery[ListedFile]().
 		WithPage(req.Page).
 		WithSelectQuery(func(tx *gorm.DB) *gorm.DB {
-			return tx.Select(`fi.id, fi.name, fi.parent_file, fi.uuid, fi.size_in_bytes,
-			fi.uploader_name, fi.upload_time, fi.file_type, fi.update_time, fi.sensitive_mode, fi.thumbnail`)
+			return tx.Select(`fi.id, fi.name, fi.parent_file, fi.uuid, fi.size_in_bytes, fi.file_count, fi.dir_count,
+			fi.uploader_name, fi.upload_time, fi.file_type, fi.update_time, fi.sensitive_mode, fi.thumbnail, fi.mime_type, fi.thumbnail_status, fi.excerpt`)
 		}).
 		WithBaseQuery(func(tx *gorm.DB) *gorm.DB {
 			tx = tx.Table("file_info fi").
@@ -367,6 +390,39 @@ func findFile(rail miso.Rail, tx *gorm.DB, fileKey string) (*FileInfo, error) {
 	return &f, t.Error
 }
 
+// Find user's file in the directory by name, the latest one is returned if there are multiple files with the same name.
+//
+// parentKey is empty for the top-level files.
+func findChildFile(rail miso.Rail, tx *gorm.DB, parentKey string, name string, userNo string) (*FileInfo, error) {
+	var f FileInfo
+	t := tx.Raw(`SELECT * FROM file_info
+		WHERE parent_file = ? AND name = ? AND uploader_no = ? AND hidden = 0 AND is_logic_deleted = 0 AND is_del = 0
+		ORDER BY id DESC LIMIT 1`, parentKey, name, userNo).
+		Scan(&f)
+	if t.Error != nil {
+		return nil, fmt.Errorf("failed to find file, parentKey: %v, name: %v, %v", parentKey, name, t.Error)
+	}
+	if t.RowsAffected < 1 {
+		return nil, nil
+	}
+	return &f, nil
+}
+
+// List user's files in the directory, the latest ones come first.
+//
+// parentKey is empty for the top-level files.
+func listChildFiles(rail miso.Rail, tx *gorm.DB, parentKey string, userNo string) ([]FileInfo, error) {
+	var files []FileInfo
+	err := tx.Raw(`SELECT * FROM file_info
+		WHERE parent_file = ? AND uploader_no = ? AND hidden = 0 AND is_logic_deleted = 0 AND is_del = 0
+		ORDER BY id DESC`, parentKey, userNo).
+		Scan(&files).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to list files, parentKey: %v, %v", parentKey, err)
+	}
+	return files, nil
+}
+
 func findFileById(rail miso.Rail, tx *gorm.DB, id int) (FileInfo, error) {
 	var f FileInfo
 
@@ -466,59 +522,68 @@ func MoveFileToDir(rail miso.Rail, db *gorm.DB, req MoveIntoDirReq, user common.
 		return nil
 	}
 
-	return db.Transaction(func(tx *gorm.DB) error {
-
-		// lock directory if necessary, if parentFileUuid is empty, the file is moved out of a directory
-		if req.ParentFileUuid != "" {
-			pflock := fileLock(rail, req.ParentFileUuid)
-			if err := pflock.Lock(); err != nil {
-				return err
-			}
-			defer pflock.Unlock()
-
-			pf, e := findFile(rail, tx, req.ParentFileUuid)
-			if e != nil {
-				return fmt.Errorf("failed to find parentFile, %v", e)
-			}
-			if pf == nil {
-				return fmt.Errorf("perentFile not found, parentFileKey: %v", req.ParentFileUuid)
-			}
-			rail.Debugf("parentFile: %+v", pf)
+	// lock directory if necessary, if parentFileUuid is empty, the file is moved out of a directory
+	if req.ParentFileUuid != "" {
+		pflock := fileLock(rail, req.ParentFileUuid)
+		if err := pflock.Lock(); err != nil {
+			return err
+		}
+		defer pflock.Unlock()
+	}
 
-			if pf.UploaderNo != user.UserNo {
-				return miso.NewErrf("You are not the owner of this directory")
-			}
+	return db.Transaction(func(tx *gorm.DB) error {
+		return _moveFileToDir(rail, tx, *fi, req.ParentFileUuid, user)
+	})
+}
 
-			if pf.FileType != FileTypeDir {
-				return miso.NewErrf("Target file is not a directory")
-			}
+// Move file into the directory, caller should lock both the file and the directory.
+func _moveFileToDir(rail miso.Rail, tx *gorm.DB, fi FileInfo, parentFileKey string, user common.User) error {
+	if parentFileKey != "" {
+		pf, e := findFile(rail, tx, parentFileKey)
+		if e != nil {
+			return fmt.Errorf("failed to find parentFile, %v", e)
+		}
+		if pf == nil {
+			return fmt.Errorf("perentFile not found, parentFileKey: %v", parentFileKey)
+		}
+		rail.Debugf("parentFile: %+v", pf)
 
-			if pf.IsLogicDeleted != LDelN {
-				return miso.NewErrf("Target file deleted")
-			}
+		if pf.UploaderNo != user.UserNo {
+			return miso.NewErrf("You are not the owner of this directory")
+		}
 
-			newSize := pf.SizeInBytes + fi.SizeInBytes
-			err := tx.Exec("UPDATE file_info SET size_in_bytes = ?, update_by = ?, update_time = ? WHERE uuid = ?",
-				newSize, user.Username, time.Now(), req.ParentFileUuid).Error
-			if err != nil {
-				return fmt.Errorf("failed to updated dir's size, dir: %v, %v", req.ParentFileUuid, err)
-			}
-			rail.Infof("updated dir %v size to %v", req.ParentFileUuid, newSize)
+		if pf.FileType != FileTypeDir {
+			return miso.NewErrf("Target file is not a directory")
+		}
 
+		if pf.IsLogicDeleted != LDelN {
+			return miso.NewErrf("Target file deleted")
 		}
+	}
 
-		if !util.IsBlankStr(fi.ParentFile) {
+	// update the dirs' size asynchronously
+	delta := fileDirSizeDelta(fi)
+	if err := AddDirSizeDelta(rail, tx, parentFileKey, delta); err != nil {
+		return err
+	}
+	if err := AddDirSizeDelta(rail, tx, fi.ParentFile, delta.Negate()); err != nil {
+		return err
+	}
 
-			// calculate the dir size asynchronously
-			if err := CalcDirSizePipeline.Send(rail, CalcDirSizeEvt{FileKey: fi.ParentFile}); err != nil {
-				rail.Errorf("failed to send CalcDirSizeEvt, fileKey: %v, %v", fi.ParentFile, err)
-			}
-		}
+	err := tx.Exec("UPDATE file_info SET parent_file = ?, update_by = ?, update_time = ? WHERE uuid = ?",
+		parentFileKey, user.Username, time.Now(), fi.Uuid).
+		Error
+	if err != nil {
+		return err
+	}
 
-		return tx.Exec("UPDATE file_info SET parent_file = ?, update_by = ?, update_time = ? WHERE uuid = ?",
-			req.ParentFileUuid, user.Username, time.Now(), req.Uuid).
-			Error
-	})
+	return RecordActivity(rail, tx, Activity{
+		RefType: ActRefFile,
+		RefKey:  fi.Uuid,
+		FileKey: fi.Uuid,
+		Action:  ActionMove,
+		Detail:  fmt.Sprintf("Moved '%v' from '%v' to '%v'", fi.Name, fi.ParentFile, parentFileKey),
+	}, user)
 }
 
 func _saveFile(rail miso.Rail, tx *gorm.DB, f FileInfo, user common.User) error {
@@ -532,15 +597,22 @@ func _saveFile(rail miso.Rail, tx *gorm.DB, f FileInfo, user common.User) error
 	f.UploadTime = now
 	f.CreateTime = now
 	f.UploaderNo = user.UserNo
-	f.Hidden = f.Hidden
 
 	err := tx.Table("file_info").
 		Omit("id", "update_time", "update_by").
 		Create(&f).Error
-	if err == nil {
-		rail.Infof("Saved file %+v", f)
+	if err != nil {
+		return err
 	}
-	return err
+	rail.Infof("Saved file %+v", f)
+
+	return RecordActivity(rail, tx, Activity{
+		RefType: ActRefFile,
+		RefKey:  f.Uuid,
+		FileKey: f.Uuid,
+		Action:  ActionCreate,
+		Detail:  fmt.Sprintf("Created %v '%v'", strings.ToLower(f.FileType), f.Name),
+	}, user)
 }
 
 func fileLock(rail miso.Rail, fileKey string) *redis.RLock {
@@ -596,7 +668,12 @@ func CreateVFolder(rail miso.Rail, tx *gorm.DB, r CreateVFolderReq, user common.
 			if e := tx.Omit("id", "update_by", "update_time").Table("user_vfolder").Create(&uv).Error; e != nil {
 				return fmt.Errorf("failed to save UserVFolder, %v", e)
 			}
-			return nil
+			return RecordActivity(rail, tx, Activity{
+				RefType: ActRefVFolder,
+				RefKey:  folderNo,
+				Action:  ActionCreate,
+				Detail:  fmt.Sprintf("Created vfolder '%s'", r.Name),
+			}, user)
 		})
 		if e != nil {
 			return "", e
@@ -649,8 +726,12 @@ func ShareVFolder(rail miso.Rail, tx *gorm.DB, sharedTo vault.UserInfo, folderNo
 	if user.UserNo == sharedTo.UserNo {
 		return nil
 	}
-	return _lockFolderExec(rail, folderNo, func() error {
-		vfo, e := findVFolder(rail, tx, folderNo, user.UserNo)
+
+	var vfo VFolderWithOwnership
+	shared := false
+	err := _lockFolderExec(rail, folderNo, func() error {
+		var e error
+		vfo, e = findVFolder(rail, tx, folderNo, user.UserNo)
 		if e != nil {
 			return e
 		}
@@ -674,21 +755,54 @@ func ShareVFolder(rail miso.Rail, tx *gorm.DB, sharedTo vault.UserInfo, folderNo
 			return nil
 		}
 
-		uv := UserVFolder{
-			FolderNo:   folderNo,
-			UserNo:     sharedTo.UserNo,
-			Username:   sharedTo.Username,
-			Ownership:  VfolderGranted,
-			GrantedBy:  user.Username,
-			CreateTime: util.Now(),
-			CreateBy:   user.Username,
-		}
-		if e := tx.Omit("id", "update_by", "update_time").Table("user_vfolder").Create(&uv).Error; e != nil {
-			return fmt.Errorf("failed to save UserVFolder, %v", e)
+		e = tx.Transaction(func(tx *gorm.DB) error {
+			uv := UserVFolder{
+				FolderNo:   folderNo,
+				UserNo:     sharedTo.UserNo,
+				Username:   sharedTo.Username,
+				Ownership:  VfolderGranted,
+				GrantedBy:  user.Username,
+				CreateTime: util.Now(),
+				CreateBy:   user.Username,
+			}
+			if e := tx.Omit("id", "update_by", "update_time").Table("user_vfolder").Create(&uv).Error; e != nil {
+				return fmt.Errorf("failed to save UserVFolder, %v", e)
+			}
+			return RecordActivity(rail, tx, Activity{
+				RefType: ActRefVFolder,
+				RefKey:  folderNo,
+				Action:  ActionShare,
+				Detail:  fmt.Sprintf("Shared vfolder '%s' to %s", vfo.Name, sharedTo.Username),
+			}, user)
+		})
+		if e != nil {
+			return e
 		}
 		rail.Infof("VFolder %s shared to %s by %s", folderNo, sharedTo.Username, user.Username)
+		shared = true
 		return nil
 	})
+	if err != nil || !shared {
+		return err
+	}
+
+	SendNotification(rail, CreateNotifiEvent{
+		UserNos: []string{sharedTo.UserNo},
+		Type:    NotifiTypeVFolderShared,
+		Title:   fmt.Sprintf("VFolder '%s' is shared with you", vfo.Name),
+		Message: fmt.Sprintf("%s shared vfolder '%s' with you", user.Username, vfo.Name),
+		RefType: NotifiRefTypeVFolder,
+		RefKey:  folderNo,
+	})
+	publishVFolderShared(rail, vfmapi.VFolderSharedEvent{
+		FolderNo:         folderNo,
+		FolderName:       vfo.Name,
+		SharedToUserNo:   sharedTo.UserNo,
+		SharedToUsername: sharedTo.Username,
+		OperatorUserNo:   user.UserNo,
+		OperatorUsername: user.Username,
+	})
+	return nil
 }
 
 type RemoveGrantedFolderAccessReq struct {
@@ -708,10 +822,19 @@ func RemoveVFolderAccess(rail miso.Rail, tx *gorm.DB, req RemoveGrantedFolderAcc
 		if !vfo.IsOwner() {
 			return miso.NewErrf("Operation not permitted")
 		}
-		return tx.
+		err := tx.
 			Exec("UPDATE user_vfolder SET is_del = 1, update_by = ? WHERE folder_no = ? AND user_no = ? AND ownership = 'GRANTED'",
 				user.Username, req.FolderNo, req.UserNo).
 			Error
+		if err != nil {
+			return err
+		}
+		return RecordActivity(rail, tx, Activity{
+			RefType: ActRefVFolder,
+			RefKey:  req.FolderNo,
+			Action:  ActionUnshare,
+			Detail:  fmt.Sprintf("Removed user %s's access to vfolder '%s'", req.UserNo, vfo.Name),
+		}, user)
 	})
 }
 
@@ -736,19 +859,37 @@ func NewVFolderLock(rail miso.Rail, folderNo string) *redis.RLock {
 }
 
 func HandleAddFileToVFolderEvent(rail miso.Rail, tx *gorm.DB, evt AddFileToVfolderEvent) error {
+	// files added before an error occurred are saved as well, they are still notified
+	vfo, added, err := addFileToVFolderLocked(rail, tx, evt)
+
+	// notify after the lock is released and the records are saved
+	if len(added) > 0 {
+		notifyVFolderFilesAdded(rail, tx, vfo, evt.UserNo, evt.Username, len(added))
+		publishVFolderFileAdded(rail, vfmapi.VFolderFileAddedEvent{
+			FolderNo:         vfo.FolderNo,
+			FolderName:       vfo.Name,
+			FileKeys:         added,
+			OperatorUserNo:   evt.UserNo,
+			OperatorUsername: evt.Username,
+		})
+	}
+	return err
+}
+
+func addFileToVFolderLocked(rail miso.Rail, tx *gorm.DB, evt AddFileToVfolderEvent) (VFolderWithOwnership, []string, error) {
 	lock := NewVFolderLock(rail, evt.FolderNo)
 	if err := lock.Lock(); err != nil {
-		return err
+		return VFolderWithOwnership{}, nil, err
 	}
 	defer lock.Unlock()
 
 	var vfo VFolderWithOwnership
 	var e error
 	if vfo, e = findVFolder(rail, tx, evt.FolderNo, evt.UserNo); e != nil {
-		return fmt.Errorf("failed to findVFolder, folderNo: %v, userNo: %v, %v", evt.FolderNo, evt.UserNo, e)
+		return vfo, nil, fmt.Errorf("failed to findVFolder, folderNo: %v, userNo: %v, %v", evt.FolderNo, evt.UserNo, e)
 	}
 	if !vfo.IsOwner() {
-		return miso.NewErrf("Operation not permitted")
+		return vfo, nil, miso.NewErrf("Operation not permitted")
 	}
 
 	distinct := util.NewSet[string]()
@@ -758,11 +899,12 @@ func HandleAddFileToVFolderEvent(rail miso.Rail, tx *gorm.DB, evt AddFileToVfold
 
 	filtered := util.Distinct(evt.FileKeys)
 	if len(filtered) < 1 {
-		return nil
+		return vfo, nil, nil
 	}
 
 	now := util.Now()
 	username := evt.Username
+	added := []string{}
 	doAddFileToVfolder := func(rail miso.Rail, folderNo string, fk string) error {
 		var id int
 		var err error
@@ -784,7 +926,14 @@ func HandleAddFileToVFolderEvent(rail miso.Rail, tx *gorm.DB, evt AddFileToVfold
 			return fmt.Errorf("failed to save file_vfolder record, %v", err)
 		}
 		rail.Infof("added file.uuid: %v to vfolder: %v by %v", fk, folderNo, username)
-		return nil
+		added = append(added, fk)
+		return RecordActivity(rail, tx, Activity{
+			RefType: ActRefVFolder,
+			RefKey:  folderNo,
+			FileKey: fk,
+			Action:  ActionAddFile,
+			Detail:  fmt.Sprintf("Added file to vfolder '%v'", vfo.Name),
+		}, common.User{UserNo: evt.UserNo, Username: username})
 	}
 
 	// add files to vfolder
@@ -794,7 +943,7 @@ func HandleAddFileToVFolderEvent(rail miso.Rail, tx *gorm.DB, evt AddFileToVfold
 
 		f, e := findFile(rail, tx, fk)
 		if e != nil {
-			return e
+			return vfo, added, e
 		}
 
 		if f == nil || f.UploaderNo != evt.UserNo {
@@ -805,7 +954,7 @@ func HandleAddFileToVFolderEvent(rail miso.Rail, tx *gorm.DB, evt AddFileToVfold
 			continue
 		}
 		if e = doAddFileToVfolder(rail, evt.FolderNo, fk); e != nil {
-			return fmt.Errorf("failed to doAddFileToVfolder, file.uuid: %v, %v", fk, e)
+			return vfo, added, fmt.Errorf("failed to doAddFileToVfolder, file.uuid: %v, %v", fk, e)
 		}
 	}
 
@@ -816,12 +965,12 @@ func HandleAddFileToVFolderEvent(rail miso.Rail, tx *gorm.DB, evt AddFileToVfold
 		var page int = 1
 
 		for {
-			if filesInDir, err = ListFilesInDir(rail, tx, ListFilesInDirReq{
+			if filesInDir, err = ListFilesInDir(rail, tx, vfmapi.ListFilesInDirReq{
 				FileKey: dir.Uuid,
 				Limit:   500,
 				Page:    page,
 			}); err != nil {
-				return fmt.Errorf("failed to list files in dir, dir.uuid: %v, %v", dir.Uuid, err)
+				return vfo, added, fmt.Errorf("failed to list files in dir, dir.uuid: %v, %v", dir.Uuid, err)
 			}
 
 			if len(filesInDir) < 1 {
@@ -833,13 +982,44 @@ func HandleAddFileToVFolderEvent(rail miso.Rail, tx *gorm.DB, evt AddFileToVfold
 					continue
 				}
 				if err = doAddFileToVfolder(rail, evt.FolderNo, fk); err != nil {
-					return fmt.Errorf("failed to doAddFileToVfolder, file.uuid: %v, %v", fk, e)
+					return vfo, added, fmt.Errorf("failed to doAddFileToVfolder, file.uuid: %v, %v", fk, err)
 				}
 			}
 			page += 1
 		}
 	}
-	return nil
+
+	return vfo, added, nil
+}
+
+func notifyVFolderFilesAdded(rail miso.Rail, tx *gorm.DB, vfo VFolderWithOwnership, userNo string, username string, added int) {
+	SendNotification(rail, CreateNotifiEvent{
+		UserNos: []string{userNo},
+		Type:    NotifiTypeJobFinished,
+		Title:   "Files added to vfolder",
+		Message: fmt.Sprintf("%d files are added to vfolder '%v'", added, vfo.Name),
+		RefType: NotifiRefTypeVFolder,
+		RefKey:  vfo.FolderNo,
+	})
+
+	members, err := findVFolderMembers(tx, vfo.FolderNo)
+	if err != nil {
+		rail.Errorf("failed to find vfolder members, %v", err)
+		return
+	}
+	subscribers, err := findSubscribers(tx, SubTargetVFolder, vfo.FolderNo)
+	if err != nil {
+		rail.Errorf("failed to find vfolder subscribers, %v", err)
+		return
+	}
+	SendNotification(rail, CreateNotifiEvent{
+		UserNos: excludeUserNo(append(members, subscribers...), userNo),
+		Type:    NotifiTypeVFolderFileAdded,
+		Title:   fmt.Sprintf("Files added to vfolder '%v'", vfo.Name),
+		Message: fmt.Sprintf("%v added %d files to vfolder '%v'", username, added, vfo.Name),
+		RefType: NotifiRefTypeVFolder,
+		RefKey:  vfo.FolderNo,
+	})
 }
 
 func AddFileToVFolder(rail miso.Rail, tx *gorm.DB, req AddFileToVfolderReq, user common.User) error {
@@ -880,9 +1060,11 @@ func RemoveFileFromVFolder(rail miso.Rail, tx *gorm.DB, req RemoveFileFromVfolde
 		return nil
 	}
 
-	return _lockFolderExec(rail, req.FolderNo, func() error {
-
-		vfo, e := findVFolder(rail, tx, req.FolderNo, user.UserNo)
+	var vfo VFolderWithOwnership
+	removed := 0
+	err := _lockFolderExec(rail, req.FolderNo, func() error {
+		var e error
+		vfo, e = findVFolder(rail, tx, req.FolderNo, user.UserNo)
 		if e != nil {
 			return e
 		}
@@ -895,30 +1077,61 @@ func RemoveFileFromVFolder(rail miso.Rail, tx *gorm.DB, req RemoveFileFromVfolde
 			return nil
 		}
 
-		for _, fk := range filtered {
-			f, e := findFile(rail, tx, fk)
-			if e != nil {
-				return e
-			}
-			if f == nil {
-				continue // file not found
-			}
+		return tx.Transaction(func(tx *gorm.DB) error {
+			for _, fk := range filtered {
+				f, e := findFile(rail, tx, fk)
+				if e != nil {
+					return e
+				}
+				if f == nil {
+					continue // file not found
+				}
 
-			if f.UploaderNo != user.UserNo {
-				continue // not the uploader of the file
-			}
-			if f.FileType != FileTypeFile {
-				continue // not a file type, may be a dir
-			}
+				if f.UploaderNo != user.UserNo {
+					continue // not the uploader of the file
+				}
+				if f.FileType != FileTypeFile {
+					continue // not a file type, may be a dir
+				}
 
-			e = tx.Exec("DELETE FROM file_vfolder WHERE folder_no = ? AND uuid = ?", req.FolderNo, fk).Error
-			if e != nil {
-				return fmt.Errorf("failed to delete file_vfolder record, %v", e)
+				e = tx.Exec("DELETE FROM file_vfolder WHERE folder_no = ? AND uuid = ?", req.FolderNo, fk).Error
+				if e != nil {
+					return fmt.Errorf("failed to delete file_vfolder record, %v", e)
+				}
+				removed += 1
+
+				if e := RecordActivity(rail, tx, Activity{
+					RefType: ActRefVFolder,
+					RefKey:  req.FolderNo,
+					FileKey: fk,
+					Action:  ActionRemoveFile,
+					Detail:  fmt.Sprintf("Removed '%v' from vfolder '%v'", f.Name, vfo.Name),
+				}, user); e != nil {
+					return e
+				}
 			}
-		}
+			return nil
+		})
+	})
+	if err != nil || removed < 1 {
+		return err
+	}
 
+	// the files are removed already, failing to notify subscribers shouldn't fail the request
+	subscribers, err := findSubscribers(tx, SubTargetVFolder, req.FolderNo)
+	if err != nil {
+		rail.Errorf("failed to find vfolder subscribers, folderNo: %v, %v", req.FolderNo, err)
 		return nil
+	}
+	SendNotification(rail, CreateNotifiEvent{
+		UserNos: excludeUserNo(subscribers, user.UserNo),
+		Type:    NotifiTypeVFolderFileRemoved,
+		Title:   fmt.Sprintf("Files removed from vfolder '%v'", vfo.Name),
+		Message: fmt.Sprintf("%v removed %d files from vfolder '%v'", user.Username, removed, vfo.Name),
+		RefType: NotifiRefTypeVFolder,
+		RefKey:  req.FolderNo,
 	})
+	return nil
 }
 
 type ListVFolderReq struct {
@@ -1044,10 +1257,21 @@ func UpdateFile(rail miso.Rail, tx *gorm.DB, r UpdateFileReq, user common.User)
 		r.SensitiveMode = "N"
 	}
 
-	return tx.
+	err := tx.
 		Exec("UPDATE file_info SET name = ?, sensitive_mode = ?, update_by = ? WHERE id = ? AND is_logic_deleted = 0 AND is_del = 0",
 			r.Name, r.SensitiveMode, user.Username, r.Id).
 		Error
+	if err != nil {
+		return err
+	}
+
+	return RecordActivity(rail, tx, Activity{
+		RefType: ActRefFile,
+		RefKey:  f.Uuid,
+		FileKey: f.Uuid,
+		Action:  ActionUpdate,
+		Detail:  fmt.Sprintf("Updated name from '%v' to '%v', sensitive mode: %v", f.Name, r.Name, r.SensitiveMode),
+	}, user)
 }
 
 type CreateFileReq struct {
@@ -1149,33 +1373,53 @@ func DeleteFile(rail miso.Rail, tx *gorm.DB, req DeleteFileReq, user common.User
 		return miso.NewErrf("File not found")
 	}
 
+	deletable, err := checkFileDeletable(rail, tx, *f, user, condition)
+	if err != nil || !deletable {
+		return err
+	}
+
+	if err := deleteFstoreFiles(rail, tx, *f); err != nil {
+		return err
+	}
+
+	return tx.Transaction(func(tx *gorm.DB) error {
+		return _markFileDeleted(rail, tx, *f, user)
+	})
+}
+
+// Check whether the file should be deleted, false is returned if the file is deleted already or skipped by the condition.
+func checkFileDeletable(rail miso.Rail, tx *gorm.DB, f FileInfo, user common.User, condition func(FileInfo) bool) (bool, error) {
 	if f.UploaderNo != user.UserNo {
-		return miso.NewErrf("Not permitted")
+		return false, miso.NewErrf("Not permitted")
 	}
 
 	if f.IsLogicDeleted == LDelY {
-		return nil // deleted already
+		return false, nil // deleted already
 	}
 
-	if condition != nil && !condition(*f) {
-		return nil // skip
+	if condition != nil && !condition(f) {
+		return false, nil // skip
 	}
 
 	if f.FileType == FileTypeDir { // if it's dir make sure it's empty
 		var anyId int
 		e := tx.Select("id").
 			Table("file_info").
-			Where("parent_file = ? AND is_logic_deleted = 0 AND is_del = 0", req.Uuid).
+			Where("parent_file = ? AND is_logic_deleted = 0 AND is_del = 0", f.Uuid).
 			Limit(1).
 			Scan(&anyId).Error
 		if e != nil {
-			return fmt.Errorf("failed to count files in dir, uuid: %v, %v", req.Uuid, e)
+			return false, fmt.Errorf("failed to count files in dir, uuid: %v, %v", f.Uuid, e)
 		}
 		if anyId > 0 {
-			return miso.NewErrf("Directory is not empty, unable to delete it")
+			return false, miso.NewErrf("Directory is not empty, unable to delete it")
 		}
 	}
+	return true, nil
+}
 
+// Delete the file, the thumbnail and the thumbnail variants in mini-fstore.
+func deleteFstoreFiles(rail miso.Rail, tx *gorm.DB, f FileInfo) error {
 	if f.FstoreFileId != "" {
 		if err := fstore.DeleteFile(rail, f.FstoreFileId); err != nil && !errors.Is(err, fstore.ErrFileDeleted) {
 			return fmt.Errorf("failed to delete fstore file, fileId: %v, %v", f.FstoreFileId, err)
@@ -1187,22 +1431,32 @@ func DeleteFile(rail miso.Rail, tx *gorm.DB, req DeleteFileReq, user common.User
 			return fmt.Errorf("failed to delete fstore file (thumbnail), fileId: %v, %v", f.Thumbnail, err)
 		}
 	}
+	return deleteThumbnailVariantFiles(rail, tx, f.Uuid)
+}
 
-	err := tx.Exec("UPDATE file_info SET is_logic_deleted = 1, logic_delete_time = NOW() WHERE id = ? AND is_logic_deleted = 0", f.Id).
-		Error
-	if err == nil {
-		rail.Infof("Deleted file %v", f.Uuid)
+// Mark the file logically deleted, caller should lock the file.
+func _markFileDeleted(rail miso.Rail, tx *gorm.DB, f FileInfo, user common.User) error {
+	t := tx.Exec("UPDATE file_info SET is_logic_deleted = 1, logic_delete_time = NOW() WHERE id = ? AND is_logic_deleted = 0", f.Id)
+	if t.Error != nil {
+		return t.Error
+	}
+	if t.RowsAffected < 1 {
+		return nil
+	}
+	rail.Infof("Deleted file %v", f.Uuid)
 
-		// calculate the dir size asynchronously
-		if f.ParentFile != "" {
-			if err := CalcDirSizePipeline.Send(rail, CalcDirSizeEvt{
-				FileKey: f.ParentFile,
-			}); err != nil {
-				rail.Errorf("failed to send CalcDirSizeEvt, fileKey: %v, %v", f.ParentFile, err)
-			}
-		}
+	// update the dir size asynchronously
+	if err := AddDirSizeDelta(rail, tx, f.ParentFile, fileDirSizeDelta(f).Negate()); err != nil {
+		return err
 	}
-	return err
+
+	return RecordActivity(rail, tx, Activity{
+		RefType: ActRefFile,
+		RefKey:  f.Uuid,
+		FileKey: f.Uuid,
+		Action:  ActionDelete,
+		Detail:  fmt.Sprintf("Deleted '%v'", f.Name),
+	}, user)
 }
 
 func validateFileAccess(rail miso.Rail, tx *gorm.DB, fileKey string, userNo string) (FileDownloadInfo, error) {
@@ -1272,13 +1526,7 @@ func GenTempToken(rail miso.Rail, tx *gorm.DB, r GenerateTempTokenReq, user comm
 	return GetFstoreTmpToken(rail, f.FstoreFileId, f.Name)
 }
 
-type ListFilesInDirReq struct {
-	FileKey string `form:"fileKey"`
-	Limit   int    `form:"limit"`
-	Page    int    `form:"page"`
-}
-
-func ListFilesInDir(rail miso.Rail, tx *gorm.DB, req ListFilesInDirReq) ([]string, error) {
+func ListFilesInDir(rail miso.Rail, tx *gorm.DB, req vfmapi.ListFilesInDirReq) ([]string, error) {
 	if req.Limit < 0 || req.Limit > 100 {
 		req.Limit = 100
 	}
@@ -1298,26 +1546,8 @@ func ListFilesInDir(rail miso.Rail, tx *gorm.DB, req ListFilesInDirReq) ([]strin
 	return fileKeys, e
 }
 
-type FetchFileInfoReq struct {
-	FileKey string `form:"fileKey"`
-}
-
-type FileInfoResp struct {
-	Name         string `json:"name"`
-	Uuid         string `json:"uuid"`
-	SizeInBytes  int64  `json:"sizeInBytes"`
-	UploaderNo   string `json:"uploaderNo"`
-	UploaderName string `json:"uploaderName"`
-	IsDeleted    bool   `json:"isDeleted"`
-	FileType     string `json:"fileType"`
-	ParentFile   string `json:"parentFile"`
-	LocalPath    string `json:"localPath"`
-	FstoreFileId string `json:"fstoreFileId"`
-	Thumbnail    string `json:"thumbnail"`
-}
-
-func FetchFileInfoInternal(rail miso.Rail, tx *gorm.DB, req FetchFileInfoReq) (FileInfoResp, error) {
-	var fir FileInfoResp
+func FetchFileInfoInternal(rail miso.Rail, tx *gorm.DB, req vfmapi.FetchFileInfoReq) (vfmapi.FileInfoResp, error) {
+	var fir vfmapi.FileInfoResp
 	f, e := findFile(rail, tx, req.FileKey)
 	if e != nil {
 		return fir, e
@@ -1337,15 +1567,11 @@ func FetchFileInfoInternal(rail miso.Rail, tx *gorm.DB, req FetchFileInfoReq) (F
 	fir.LocalPath = "" // files are managed by the mini-fstore, this field will no longer contain any value in it
 	fir.FstoreFileId = f.FstoreFileId
 	fir.Thumbnail = f.Thumbnail
+	fir.MimeType = f.MimeType
 	return fir, nil
 }
 
-type ValidateFileOwnerReq struct {
-	FileKey string `form:"fileKey"`
-	UserNo  string `form:"userNo"`
-}
-
-func ValidateFileOwner(rail miso.Rail, tx *gorm.DB, q ValidateFileOwnerReq) (bool, error) {
+func ValidateFileOwner(rail miso.Rail, tx *gorm.DB, q vfmapi.ValidateFileOwnerReq) (bool, error) {
 	var id int
 	e := tx.Select("id").
 		Table("file_info").
@@ -1389,7 +1615,12 @@ func RemoveVFolder(rail miso.Rail, tx *gorm.DB, user common.User, req RemoveVFol
 		if err != nil {
 			return fmt.Errorf("failed to update file_vfolder, folderNo: %v, %v", req.FolderNo, err)
 		}
-		return nil
+		return RecordActivity(rail, tx, Activity{
+			RefType: ActRefVFolder,
+			RefKey:  req.FolderNo,
+			Action:  ActionDelete,
+			Detail:  fmt.Sprintf("Deleted vfolder '%v'", vfo.Name),
+		}, user)
 	}); err != nil {
 		return err
 	}
@@ -1398,75 +1629,6 @@ func RemoveVFolder(rail miso.Rail, tx *gorm.DB, user common.User, req RemoveVFol
 	return nil
 }
 
-func ImMemBatchCalcDirSize(rail miso.Rail, db *gorm.DB) error {
-	defer miso.TimeOp(rail, time.Now(), "BatchCalcDirSize")
-
-	type TempFile struct {
-		Uuid       string
-		ParentFile string
-	}
-
-	var files []TempFile
-	err := db.Raw(`
-		SELECT uuid, parent_file FROM file_info
-		WHERE parent_file != '' AND file_type = 'DIR' AND is_logic_deleted = 0
-	`).Scan(&files).Error
-	if err != nil {
-		return fmt.Errorf("failed to list dir files, %v", err)
-	}
-
-	parDirSet := util.NewSet[string]()
-	for _, f := range files {
-		parDirSet.Add(f.ParentFile)
-	}
-
-	for _, f := range files {
-		if parDirSet.Has(f.Uuid) { // the dir itself is a parent dir
-			continue
-		}
-		if err := CalcDirSizePipeline.Send(rail, CalcDirSizeEvt{FileKey: f.Uuid}); err != nil {
-			return err
-		}
-		rail.Infof("Triggered CalcDirSizeEvt for %v", f.Uuid)
-	}
-	return nil
-}
-
-func CalcDirSize(rail miso.Rail, fk string, db *gorm.DB) error {
-	lock := fileLock(rail, fk)
-	if err := lock.Lock(); err != nil {
-		return fmt.Errorf("failed to lock, fileKey: %v, %w", fk, err)
-	}
-	defer lock.Unlock()
-
-	var size int64
-	err := db.Raw("SELECT IFNULL(SUM(size_in_bytes),0) FROM file_info WHERE parent_file = ? AND is_del = 0 AND is_logic_deleted = 0", fk).
-		Scan(&size).
-		Error
-	if err != nil {
-		return fmt.Errorf("failed to calculate dir size, fileKey: %v, %v", fk, err)
-	}
-
-	if err := db.Exec(`UPDATE file_info SET size_in_bytes = ? WHERE uuid = ?`, size, fk).Error; err != nil {
-		return fmt.Errorf("failed to update dir's size, fileKey: %v, %v", fk, err)
-	}
-
-	// if current dir also has a parent dir, calculate the parent dir's size as well
-	var parDir string
-	if err := db.Raw(`SELECT parent_file FROM file_info WHERE uuid = ?`, fk).Scan(&parDir).Error; err != nil {
-		rail.Errorf("failed to find parent dir of file: %v, %v", fk, err)
-		return nil
-	}
-
-	if parDir != "" {
-		if err := CalcDirSizePipeline.Send(rail, CalcDirSizeEvt{FileKey: parDir}); err != nil {
-			rail.Errorf("failed to publish CalcDirSizeEvt for %v, %v", parDir, err)
-			return nil
-		}
-	}
-	return nil
-}
-
 type UnpackZipReq struct {
 	FileKey       string // file key of the zip file
 	ParentFileKey string // file key of current directory (not where the zip entries will be saved)
@@ -1477,8 +1639,18 @@ type UnpackZipExtra struct {
 	ParentFileKey string // file key of the target directory
 	UserNo        string
 	Username      string
+	JobNo         string
+}
+
+type UnpackZipJobParam struct {
+	FileKey       string // file key of the zip file
+	ParentFileKey string // file key of the target directory
 }
 
+const (
+	unpackZipJobTimeout = time.Hour
+)
+
 func UnpackZip(rail miso.Rail, db *gorm.DB, user common.User, req UnpackZipReq) error {
 	flock := fileLock(rail, req.FileKey)
 	if err := flock.Lock(); err != nil {
@@ -1510,11 +1682,23 @@ func UnpackZip(rail miso.Rail, db *gorm.DB, user common.User, req UnpackZipReq)
 		return fmt.Errorf("failed to make directory before unpacking zip, %w", err)
 	}
 
+	// the job is completed when mini-fstore replies
+	jobNo, err := saveJob(rail, db, SubmitJobReq{
+		JobType: JobTypeUnpackZip,
+		RefType: NotifiRefTypeFile,
+		RefKey:  dir,
+		Param:   UnpackZipJobParam{FileKey: req.FileKey, ParentFileKey: dir},
+	}, JobStatusRunning, user)
+	if err != nil {
+		return err
+	}
+
 	extra, err := encoding.WriteJson(UnpackZipExtra{
 		FileKey:       req.FileKey,
 		ParentFileKey: dir,
 		UserNo:        user.UserNo,
 		Username:      user.Username,
+		JobNo:         jobNo,
 	})
 	if err != nil {
 		return fmt.Errorf("failed to write json as extra, %w", err)
@@ -1526,11 +1710,23 @@ func UnpackZip(rail miso.Rail, db *gorm.DB, user common.User, req UnpackZipReq)
 		Extra:           string(extra),
 	})
 	if err != nil {
-		return fmt.Errorf("failed to TriggerFileUnZip, %w", err)
+		err = fmt.Errorf("failed to TriggerFileUnZip, %w", err)
+		if e := CompleteWaitingJob(rail, db, jobNo, 0, "", err); e != nil {
+			rail.Errorf("Failed to complete job %v, %v", jobNo, e)
+		}
+		return err
 	}
 	return nil
 }
 
+// Unpacking zip is done by mini-fstore, the job only waits for the reply, see HandleZipUnpackResult.
+func runUnpackZipJob(rail miso.Rail, db *gorm.DB, jc *JobCtx) error {
+	if time.Since(jc.createTime.ToTime()) > unpackZipJobTimeout {
+		return fmt.Errorf("timeout waiting for mini-fstore to unpack the zip file")
+	}
+	return errJobWaiting
+}
+
 func HandleZipUnpackResult(rail miso.Rail, db *gorm.DB, evt fstore.UnzipFileReplyEvent) error {
 	var extra UnpackZipExtra
 	if err := encoding.ParseJson([]byte(evt.Extra), &extra); err != nil {
@@ -1541,7 +1737,7 @@ func HandleZipUnpackResult(rail miso.Rail, db *gorm.DB, evt fstore.UnzipFileRepl
 		return nil
 	}
 
-	return db.Transaction(func(tx *gorm.DB) error {
+	err := db.Transaction(func(tx *gorm.DB) error {
 		for _, ze := range evt.ZipEntries {
 			_, err := SaveFileRecord(rail, tx, SaveFileReq{
 				Filename:   ze.Name,
@@ -1558,6 +1754,24 @@ func HandleZipUnpackResult(rail miso.Rail, db *gorm.DB, evt fstore.UnzipFileRepl
 		}
 		return nil
 	})
+	if err != nil {
+		return err
+	}
+
+	msg := fmt.Sprintf("%d entries are unpacked from zip file", len(evt.ZipEntries))
+	if extra.JobNo != "" {
+		return CompleteWaitingJob(rail, db, extra.JobNo, len(evt.ZipEntries), msg, nil)
+	}
+
+	SendNotification(rail, CreateNotifiEvent{
+		UserNos: []string{extra.UserNo},
+		Type:    NotifiTypeJobFinished,
+		Title:   "Zip file unpacked",
+		Message: msg,
+		RefType: NotifiRefTypeFile,
+		RefKey:  extra.ParentFileKey,
+	})
+	return nil
 }
 
 func TruncateDir(rail miso.Rail, db *gorm.DB, req DeleteFileReq, user common.User, async bool) error {
@@ -1584,72 +1798,152 @@ func TruncateDir(rail miso.Rail, db *gorm.DB, req DeleteFileReq, user common.Use
 		return miso.NewErrf("Not a directory")
 	}
 
+	if async {
+		_, err := SubmitJob(rail, db, SubmitJobReq{
+			JobType: JobTypeTruncateDir,
+			RefType: NotifiRefTypeFile,
+			RefKey:  dir.Uuid,
+			Param:   TruncateDirJobParam{DirKey: dir.Uuid},
+		}, user)
+		return err
+	}
+
+	if err := truncateDir(rail, db, *dir, user, 0, nil); err != nil {
+		return err
+	}
+	return recordTruncateDir(rail, db, *dir, user)
+}
+
+// Record activity of the truncated directory, it's recorded only once for the top-level directory after it's truncated.
+func recordTruncateDir(rail miso.Rail, db *gorm.DB, dir FileInfo, user common.User) error {
+	return RecordActivity(rail, db, Activity{
+		RefType: ActRefFile,
+		RefKey:  dir.Uuid,
+		FileKey: dir.Uuid,
+		Action:  ActionTruncate,
+		Detail:  fmt.Sprintf("Truncated directory '%v'", dir.Name),
+	}, user)
+}
+
+type TruncateDirJobParam struct {
+	DirKey string
+}
+
+type truncateDirCheckpoint struct {
+	MinId int
+}
+
+func runTruncateDirJob(rail miso.Rail, db *gorm.DB, jc *JobCtx) error {
+	var p TruncateDirJobParam
+	if err := jc.Param(&p); err != nil {
+		return err
+	}
+	var cp truncateDirCheckpoint
+	if _, err := jc.LoadCheckpoint(&cp); err != nil {
+		return err
+	}
+
+	dir, err := findFile(rail, db, p.DirKey)
+	if err != nil {
+		return fmt.Errorf("unable to find file, uuid: %v, %v", p.DirKey, err)
+	}
+	if dir == nil {
+		return miso.NewErrf("File not found")
+	}
+	jc.ResultMsg = fmt.Sprintf("Directory '%v' is truncated", dir.Name)
+	if dir.IsLogicDeleted == LDelY {
+		return nil // truncated already
+	}
+	if jc.Total < 1 {
+		jc.Total = dir.FileCount + dir.DirCount
+	}
+
+	err = truncateDir(rail, db, *dir, jc.User, cp.MinId, func(minId int, processed int) error {
+		jc.Processed += processed
+		return jc.Checkpoint(truncateDirCheckpoint{MinId: minId})
+	})
+	if err != nil {
+		return err
+	}
+	return recordTruncateDir(rail, db, *dir, jc.User)
+}
+
+// Delete files in dir recursively, and then the dir itself.
+//
+// Files are listed in batches from minId, onBatch is called after each batch with the last id processed and the
+// number of files deleted.
+func truncateDir(rail miso.Rail, db *gorm.DB, dir FileInfo, user common.User, minId int,
+	onBatch func(minId int, processed int) error) error {
+
 	type ListedFilesInDir struct {
-		Id       int
-		Uuid     string
-		FileType string
+		Id        int
+		Uuid      string
+		FileType  string
+		FileCount int
+		DirCount  int
 	}
 
-	doTruncate := func() {
-		rail := rail
-		if async {
-			rail = rail.NextSpan()
-		}
-		listFilesInDir := func(rail miso.Rail, minId int) ([]ListedFilesInDir, error) {
-			var l []ListedFilesInDir
-			err := db.Table("file_info").
-				Select("id, uuid, file_type").
-				Where("parent_file = ?", dir.Uuid).
-				Where("id > ?", minId).
-				Order("id asc").
-				Limit(50).
-				Scan(&l).Error
-
-			rail.Debugf("listFilesInDir, minId: %v, dir.uuid: %v, count: %d", minId, dir.Uuid, len(l))
-			return l, err
-		}
+	listFilesInDir := func(rail miso.Rail, minId int) ([]ListedFilesInDir, error) {
+		var l []ListedFilesInDir
+		err := db.Table("file_info").
+			Select("id, uuid, file_type, file_count, dir_count").
+			Where("parent_file = ?", dir.Uuid).
+			Where("id > ?", minId).
+			Order("id asc").
+			Limit(50).
+			Scan(&l).Error
 
-		stillInDir := func(fi FileInfo) bool { return fi.ParentFile == dir.Uuid }
+		rail.Debugf("listFilesInDir, minId: %v, dir.uuid: %v, count: %d", minId, dir.Uuid, len(l))
+		return l, err
+	}
 
-		minId := 0
-		for {
-			l, err := listFilesInDir(rail, minId)
-			if err != nil {
-				rail.Errorf("failed to listFilesInDir, minId: %v, dir.uuid: %v, %v", minId, dir.Uuid, err)
-				return
+	stillInDir := func(fi FileInfo) bool { return fi.ParentFile == dir.Uuid }
+
+	for {
+		l, err := listFilesInDir(rail, minId)
+		if err != nil {
+			rail.Errorf("failed to listFilesInDir, minId: %v, dir.uuid: %v, %v", minId, dir.Uuid, err)
+			return err
+		}
+		if len(l) < 1 {
+			if err := DeleteFile(rail, db, DeleteFileReq{Uuid: dir.Uuid}, user, nil); err != nil {
+				rail.Errorf("failed to delete current directory: %v, %v", dir.Uuid, err)
+				return err
 			}
-			if len(l) < 1 {
-				if err := DeleteFile(rail, db, DeleteFileReq{Uuid: dir.Uuid}, user, nil); err != nil {
-					rail.Errorf("failed to delete current directory: %v, %v", dir.Uuid, err)
-				} else {
-					rail.Infof("Truncated dir %v", req.Uuid)
+			rail.Infof("Truncated dir %v", dir.Uuid)
+			return nil
+		}
+		minId = l[len(l)-1].Id
+
+		processed := 0
+		for _, lf := range l {
+			if lf.FileType == FileTypeFile {
+				if err := DeleteFile(rail, db, DeleteFileReq{Uuid: lf.Uuid}, user, stillInDir); err != nil {
+					rail.Errorf("failed to DeleteFile in dir, dir.uuid: %v, deleting file.uuid: %v, %v", dir.Uuid, lf.Uuid, err)
+					return err
 				}
-				return
-			}
-			minId = l[len(l)-1].Id
-
-			for _, lf := range l {
-				if lf.FileType == FileTypeFile {
-					if err := DeleteFile(rail, db, DeleteFileReq{Uuid: lf.Uuid}, user, stillInDir); err != nil {
-						rail.Errorf("failed to DeleteFile in dir, dir.uuid: %v, deleting file.uuid: %v, %v", dir.Uuid, lf.Uuid, err)
-						return
-					}
-					rail.Infof("Deleted file %v in dir %v", lf.Uuid, dir.Uuid)
-				} else {
-					if err := TruncateDir(rail, db, DeleteFileReq{Uuid: lf.Uuid}, user, false); err != nil {
-						rail.Errorf("failed to TruncateDir in dir, in dir.uuid: %v, truncating dir.uuid: %v, %v", dir.Uuid, lf.Uuid, err)
-						return
-					}
+				rail.Infof("Deleted file %v in dir %v", lf.Uuid, dir.Uuid)
+				processed += 1
+			} else {
+				sub, err := findFile(rail, db, lf.Uuid)
+				if err != nil {
+					return fmt.Errorf("unable to find file, uuid: %v, %v", lf.Uuid, err)
+				}
+				if sub == nil || sub.IsLogicDeleted == LDelY {
+					continue
 				}
+				if err := truncateDir(rail, db, *sub, user, 0, nil); err != nil {
+					rail.Errorf("failed to TruncateDir in dir, in dir.uuid: %v, truncating dir.uuid: %v, %v", dir.Uuid, lf.Uuid, err)
+					return err
+				}
+				processed += lf.FileCount + lf.DirCount + 1
 			}
 		}
-	}
 
-	if async {
-		vfmPool.Go(doTruncate)
-	} else {
-		doTruncate()
+		if onBatch != nil {
+			if err := onBatch(minId, processed); err != nil {
+				return err
+			}
+		}
 	}
-
-	return nil
 }
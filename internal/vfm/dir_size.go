@@ -0,0 +1,345 @@
+package vfm
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/curtisnewbie/miso/middleware/mysql"
+	"github.com/curtisnewbie/miso/middleware/redis"
+	"github.com/curtisnewbie/miso/miso"
+	"github.com/curtisnewbie/miso/util"
+	"gorm.io/gorm"
+)
+
+const (
+	PropDirSizeFlushInterval = "vfm.dir-size.flush-interval"
+	PropDirSizeFlushBatch    = "vfm.dir-size.flush-batch-size"
+
+	// max number of mismatched dirs returned in verification report
+	maxDirSizeReportItems = 1000
+
+	// max number of rounds in each flush, deltas left are processed in next flush
+	maxDirSizeFlushRounds = 100
+)
+
+func init() {
+	miso.SetDefProp(PropDirSizeFlushInterval, 5)
+	miso.SetDefProp(PropDirSizeFlushBatch, 200)
+}
+
+// Signed changes of a directory's recursive size, file count and sub-directory count.
+type DirSizeDelta struct {
+	Size  int64
+	Files int
+	Dirs  int
+}
+
+func (d DirSizeDelta) IsZero() bool {
+	return d.Size == 0 && d.Files == 0 && d.Dirs == 0
+}
+
+func (d DirSizeDelta) Negate() DirSizeDelta {
+	return DirSizeDelta{Size: -d.Size, Files: -d.Files, Dirs: -d.Dirs}
+}
+
+// Delta contributed by the file (or directory) to the directory that contains it.
+func fileDirSizeDelta(f FileInfo) DirSizeDelta {
+	if f.FileType == FileTypeDir {
+		return DirSizeDelta{Size: f.SizeInBytes, Files: f.FileCount, Dirs: f.DirCount + 1}
+	}
+	return DirSizeDelta{Size: f.SizeInBytes, Files: 1}
+}
+
+// Record delta of the directory.
+//
+// The delta should be written using the same tx as the operation, it's merged with other deltas of the same directory,
+// and then applied up the ancestor chain asynchronously by FlushDirSizeDeltas.
+func AddDirSizeDelta(rail miso.Rail, tx *gorm.DB, dirKey string, delta DirSizeDelta) error {
+	if dirKey == "" || delta.IsZero() {
+		return nil
+	}
+	err := tx.Exec(`INSERT INTO dir_size_delta (dir_key, size_delta, file_delta, dir_delta) VALUES (?,?,?,?)`,
+		dirKey, delta.Size, delta.Files, delta.Dirs).Error
+	if err != nil {
+		return fmt.Errorf("failed to save dir_size_delta, dirKey: %v, %+v, %v", dirKey, delta, err)
+	}
+	rail.Debugf("Added dir size delta %+v to %v", delta, dirKey)
+	return nil
+}
+
+type pendingDirSizeDelta struct {
+	Id    int64
+	Size  int64
+	Files int
+	Dirs  int
+}
+
+func newDirSizeFlushLock(rail miso.Rail) *redis.RLock {
+	return redis.NewRLock(rail, "vfm:dir-size:flush")
+}
+
+// Scheduled task that flushes dir size deltas.
+func FlushDirSizeDeltaTask(rail miso.Rail) error {
+	return FlushDirSizeDeltas(rail, mysql.GetMySQL())
+}
+
+// Merge pending deltas of each directory, apply them to the directory and pass them to its parent directory.
+//
+// Deltas of the same directory recorded since last flush are merged into one update, so bulk operations like truncating
+// a directory or unpacking a zip only update each ancestor a few times.
+func FlushDirSizeDeltas(rail miso.Rail, db *gorm.DB) error {
+	lock := newDirSizeFlushLock(rail)
+	if err := lock.Lock(); err != nil {
+		return fmt.Errorf("failed to lock for dir size flush, %v", err)
+	}
+	defer lock.Unlock()
+
+	limit := miso.GetPropInt(PropDirSizeFlushBatch)
+	for i := 0; i < maxDirSizeFlushRounds; i++ {
+		var dirKeys []string
+		err := db.Raw(`SELECT dir_key
+			FROM dir_size_delta
+			GROUP BY dir_key
+			ORDER BY MIN(id) ASC
+			LIMIT ?`, limit).
+			Scan(&dirKeys).Error
+		if err != nil {
+			return fmt.Errorf("failed to list dir_size_delta, %v", err)
+		}
+		if len(dirKeys) < 1 {
+			return nil
+		}
+		for _, dk := range dirKeys {
+			if err := applyDirSizeDelta(rail, db, dk); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Apply deltas of the directory.
+//
+// Deltas are locked and deleted by id within the same transaction, deltas committed in the meantime are left to next
+// flush.
+func applyDirSizeDelta(rail miso.Rail, db *gorm.DB, dirKey string) error {
+	lock := fileLock(rail, dirKey)
+	if err := lock.Lock(); err != nil {
+		return fmt.Errorf("failed to lock, fileKey: %v, %w", dirKey, err)
+	}
+	defer lock.Unlock()
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		var pending []pendingDirSizeDelta
+		if err := tx.Raw(`SELECT id, size_delta size, file_delta files, dir_delta dirs FROM dir_size_delta
+			WHERE dir_key = ? FOR UPDATE`, dirKey).
+			Scan(&pending).Error; err != nil {
+			return fmt.Errorf("failed to list dir_size_delta, dirKey: %v, %v", dirKey, err)
+		}
+		if len(pending) < 1 {
+			return nil
+		}
+
+		var delta DirSizeDelta
+		ids := make([]int64, 0, len(pending))
+		for _, p := range pending {
+			delta.Size += p.Size
+			delta.Files += p.Files
+			delta.Dirs += p.Dirs
+			ids = append(ids, p.Id)
+		}
+
+		if !delta.IsZero() {
+			err := tx.Exec(`UPDATE file_info SET size_in_bytes = size_in_bytes + ?, file_count = file_count + ?, dir_count = dir_count + ?
+				WHERE uuid = ? AND file_type = ?`, delta.Size, delta.Files, delta.Dirs, dirKey, FileTypeDir).Error
+			if err != nil {
+				return fmt.Errorf("failed to update dir's size, fileKey: %v, %v", dirKey, err)
+			}
+
+			// deleted dir is detached from its parent, the parent has already subtracted everything it had
+			var dir struct {
+				ParentFile     string
+				IsLogicDeleted int
+			}
+			if err := tx.Raw(`SELECT parent_file, is_logic_deleted FROM file_info WHERE uuid = ?`, dirKey).
+				Scan(&dir).Error; err != nil {
+				return fmt.Errorf("failed to find parent dir of file: %v, %v", dirKey, err)
+			}
+			if dir.IsLogicDeleted == LDelN {
+				if err := AddDirSizeDelta(rail, tx, dir.ParentFile, delta); err != nil {
+					return err
+				}
+			}
+		}
+
+		if err := tx.Exec(`DELETE FROM dir_size_delta WHERE id IN ?`, ids).Error; err != nil {
+			return fmt.Errorf("failed to delete dir_size_delta, dirKey: %v, %v", dirKey, err)
+		}
+		rail.Debugf("Applied dir size delta %+v to %v", delta, dirKey)
+		return nil
+	})
+}
+
+type DirSizeReport struct {
+	Fixed         bool                `json:"fixed" desc:"whether the mismatched dirs are fixed"`
+	Scanned       int                 `json:"scanned" desc:"number of dirs scanned"`
+	Mismatched    int                 `json:"mismatched" desc:"number of dirs whose stored values differ from the recomputed ones"`
+	PendingDeltas int                 `json:"pendingDeltas" desc:"number of deltas not yet flushed when the verification started, they may cause mismatches"`
+	Items         []MismatchedDirSize `json:"items" desc:"mismatched dirs (at most 1000)"`
+}
+
+type MismatchedDirSize struct {
+	FileKey        string `json:"fileKey"`
+	Name           string `json:"name"`
+	SizeInBytes    int64  `json:"sizeInBytes"`
+	FileCount      int    `json:"fileCount"`
+	DirCount       int    `json:"dirCount"`
+	ExpSizeInBytes int64  `json:"expSizeInBytes" desc:"recomputed size"`
+	ExpFileCount   int    `json:"expFileCount" desc:"recomputed file count"`
+	ExpDirCount    int    `json:"expDirCount" desc:"recomputed sub-directory count"`
+}
+
+// Recompute size, file count and sub-directory count of all directories, and fix the mismatched ones.
+func ImMemBatchCalcDirSize(rail miso.Rail, db *gorm.DB) error {
+	_, err := VerifyDirSize(rail, db, true)
+	return err
+}
+
+// Compare the stored size, file count and sub-directory count of all directories with a full recompute in memory.
+//
+// Deltas and files are read in one transaction, so the recomputed values and the pending deltas come from the same
+// snapshot. With fix, the mismatched ones are updated, and the pending deltas in the snapshot are discarded, deltas
+// recorded after the snapshot are not reflected in the recomputed values, they are left to the next flush.
+func VerifyDirSize(rail miso.Rail, db *gorm.DB, fix bool) (DirSizeReport, error) {
+	defer miso.TimeOp(rail, time.Now(), "VerifyDirSize")
+
+	report := DirSizeReport{Fixed: fix, Items: []MismatchedDirSize{}}
+
+	// flushing is paused, so that the stored values are not changed during the verification
+	lock := newDirSizeFlushLock(rail)
+	if err := lock.Lock(); err != nil {
+		return report, miso.NewErrf("Dir size is being updated, please try again later").WithInternalMsg("%v", err)
+	}
+	defer lock.Unlock()
+
+	type dirInf struct {
+		Uuid        string
+		Name        string
+		ParentFile  string
+		SizeInBytes int64
+		FileCount   int
+		DirCount    int
+	}
+	// size and number of files directly inside each dir
+	type direct struct {
+		ParentFile string
+		Size       int64
+		Files      int
+	}
+	var pendingIds []int64
+	var dirs []dirInf
+	var directs []direct
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Raw(`SELECT id FROM dir_size_delta`).Scan(&pendingIds).Error; err != nil {
+			return fmt.Errorf("failed to list dir_size_delta, %v", err)
+		}
+		if err := tx.Raw(`SELECT uuid, name, parent_file, size_in_bytes, file_count, dir_count FROM file_info
+			WHERE file_type = ? AND is_logic_deleted = 0 AND is_del = 0`, FileTypeDir).
+			Scan(&dirs).Error; err != nil {
+			return fmt.Errorf("failed to list dirs, %v", err)
+		}
+		if err := tx.Raw(`SELECT parent_file, IFNULL(SUM(size_in_bytes),0) size, COUNT(*) files FROM file_info
+			WHERE parent_file != '' AND file_type = ? AND is_logic_deleted = 0 AND is_del = 0
+			GROUP BY parent_file`, FileTypeFile).
+			Scan(&directs).Error; err != nil {
+			return fmt.Errorf("failed to sum files in dirs, %v", err)
+		}
+		return nil
+	}, &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true})
+	if err != nil {
+		return report, err
+	}
+	report.PendingDeltas = len(pendingIds)
+
+	directOf := make(map[string]direct, len(directs))
+	for _, d := range directs {
+		directOf[d.ParentFile] = d
+	}
+	children := make(map[string][]string, len(dirs))
+	for _, d := range dirs {
+		if d.ParentFile != "" {
+			children[d.ParentFile] = append(children[d.ParentFile], d.Uuid)
+		}
+	}
+
+	computed := make(map[string]DirSizeDelta, len(dirs))
+	var compute func(dirKey string, visiting util.Set[string]) DirSizeDelta
+	compute = func(dirKey string, visiting util.Set[string]) DirSizeDelta {
+		if v, ok := computed[dirKey]; ok {
+			return v
+		}
+		if !visiting.Add(dirKey) {
+			rail.Errorf("Found cyclic dir, fileKey: %v", dirKey)
+			return DirSizeDelta{}
+		}
+		d := directOf[dirKey]
+		v := DirSizeDelta{Size: d.Size, Files: d.Files}
+		for _, c := range children[dirKey] {
+			cv := compute(c, visiting)
+			v.Size += cv.Size
+			v.Files += cv.Files
+			v.Dirs += cv.Dirs + 1
+		}
+		computed[dirKey] = v
+		return v
+	}
+
+	for _, d := range dirs {
+		report.Scanned += 1
+		exp := compute(d.Uuid, util.NewSet[string]())
+		if exp.Size == d.SizeInBytes && exp.Files == d.FileCount && exp.Dirs == d.DirCount {
+			continue
+		}
+
+		report.Mismatched += 1
+		if len(report.Items) < maxDirSizeReportItems {
+			report.Items = append(report.Items, MismatchedDirSize{
+				FileKey:        d.Uuid,
+				Name:           d.Name,
+				SizeInBytes:    d.SizeInBytes,
+				FileCount:      d.FileCount,
+				DirCount:       d.DirCount,
+				ExpSizeInBytes: exp.Size,
+				ExpFileCount:   exp.Files,
+				ExpDirCount:    exp.Dirs,
+			})
+		}
+
+		if fix {
+			if err := db.Exec(`UPDATE file_info SET size_in_bytes = ?, file_count = ?, dir_count = ? WHERE uuid = ?`,
+				exp.Size, exp.Files, exp.Dirs, d.Uuid).Error; err != nil {
+				return report, fmt.Errorf("failed to update dir's size, fileKey: %v, %v", d.Uuid, err)
+			}
+			rail.Infof("Fixed dir %v, size: %v -> %v, files: %v -> %v, dirs: %v -> %v", d.Uuid, d.SizeInBytes, exp.Size,
+				d.FileCount, exp.Files, d.DirCount, exp.Dirs)
+		}
+	}
+
+	// deltas in the snapshot are already reflected in the recomputed values
+	if fix {
+		for i := 0; i < len(pendingIds); i += 500 {
+			ids := pendingIds[i:]
+			if len(ids) > 500 {
+				ids = ids[:500]
+			}
+			if err := db.Exec(`DELETE FROM dir_size_delta WHERE id IN ?`, ids).Error; err != nil {
+				return report, fmt.Errorf("failed to delete dir_size_delta, %v", err)
+			}
+		}
+	}
+
+	rail.Infof("Verified dir size, fixed: %v, scanned: %v, mismatched: %v, pendingDeltas: %v", fix, report.Scanned,
+		report.Mismatched, report.PendingDeltas)
+	return report, nil
+}
@@ -0,0 +1,91 @@
+package vfm
+
+import (
+	"time"
+
+	"github.com/curtisnewbie/miso/miso"
+	vfmapi "github.com/curtisnewbie/vfm/api"
+	"gorm.io/gorm"
+)
+
+// Handle file lifecycle event, trigger webhooks and publish domain event.
+func HandleFileLifecycleEvent(rail miso.Rail, db *gorm.DB, evt FileLifecycleEvent) {
+	TriggerFileWebhooks(rail, db, evt)
+	PublishFileDomainEvent(rail, db, evt)
+}
+
+// Publish file domain event, failures are only logged.
+func PublishFileDomainEvent(rail miso.Rail, db *gorm.DB, evt FileLifecycleEvent) {
+	f, err := findFile(rail, db, evt.FileKey)
+	if err != nil {
+		rail.Errorf("failed to find file, %v, %v", evt.FileKey, err)
+		return
+	}
+	if f == nil {
+		return
+	}
+
+	snapshot := toFileSnapshot(*f)
+	now := time.Now().UnixMilli()
+	switch evt.Event {
+	case FileEvtCreated:
+		err = vfmapi.FileCreatedPipeline.Send(rail, vfmapi.FileCreatedEvent{File: snapshot, Time: now})
+	case FileEvtMoved:
+		err = vfmapi.FileMovedPipeline.Send(rail, vfmapi.FileMovedEvent{File: snapshot, PrevParentFile: evt.PrevParentFile, Time: now})
+	case FileEvtRenamed:
+		err = vfmapi.FileRenamedPipeline.Send(rail, vfmapi.FileRenamedEvent{File: snapshot, PrevName: evt.PrevName, Time: now})
+	case FileEvtDeleted:
+		err = vfmapi.FileDeletedPipeline.Send(rail, vfmapi.FileDeletedEvent{File: snapshot, Time: now})
+	}
+	if err != nil {
+		rail.Errorf("failed to publish file domain event, %+v, %v", evt, err)
+	}
+}
+
+func toFileSnapshot(f FileInfo) vfmapi.FileSnapshot {
+	return vfmapi.FileSnapshot{
+		FileKey:      f.Uuid,
+		Name:         f.Name,
+		FileType:     f.FileType,
+		SizeInBytes:  f.SizeInBytes,
+		ParentFile:   f.ParentFile,
+		UploaderNo:   f.UploaderNo,
+		UploaderName: f.UploaderName,
+		FstoreFileId: f.FstoreFileId,
+	}
+}
+
+func publishVFolderShared(rail miso.Rail, evt vfmapi.VFolderSharedEvent) {
+	evt.Time = time.Now().UnixMilli()
+	if err := vfmapi.VFolderSharedPipeline.Send(rail, evt); err != nil {
+		rail.Errorf("failed to publish VFolderSharedEvent, %+v, %v", evt, err)
+	}
+}
+
+func publishVFolderFileAdded(rail miso.Rail, evt vfmapi.VFolderFileAddedEvent) {
+	evt.Time = time.Now().UnixMilli()
+	if err := vfmapi.VFolderFileAddedPipeline.Send(rail, evt); err != nil {
+		rail.Errorf("failed to publish VFolderFileAddedEvent, %+v, %v", evt, err)
+	}
+}
+
+func publishGalleryImageAdded(rail miso.Rail, evt vfmapi.GalleryImageAddedEvent) {
+	evt.Time = time.Now().UnixMilli()
+	if err := vfmapi.GalleryImageAddedPipeline.Send(rail, evt); err != nil {
+		rail.Errorf("failed to publish GalleryImageAddedEvent, %+v, %v", evt, err)
+	}
+}
+
+func publishGalleryImageRemoved(rail miso.Rail, evt vfmapi.GalleryImageRemovedEvent) {
+	evt.Time = time.Now().UnixMilli()
+	if err := vfmapi.GalleryImageRemovedPipeline.Send(rail, evt); err != nil {
+		rail.Errorf("failed to publish GalleryImageRemovedEvent, %+v, %v", evt, err)
+	}
+}
+
+func publishVersionedFileUpdated(rail miso.Rail, evt vfmapi.VersionedFileUpdatedEvent) {
+	evt.Time = time.Now().UnixMilli()
+	if err := vfmapi.VersionedFileUpdatedPipeline.Send(rail, evt); err != nil {
+		rail.Errorf("failed to publish VersionedFileUpdatedEvent, %+v, %v", evt, err)
+	}
+}
@@ -2,18 +2,15 @@ package vfm
 
 import (
 	"fmt"
-	"time"
 
 	ep "github.com/curtisnewbie/event-pump/client"
 	fstore "github.com/curtisnewbie/mini-fstore/api"
 	"github.com/curtisnewbie/miso/middleware/mysql"
 	"github.com/curtisnewbie/miso/middleware/rabbit"
 	"github.com/curtisnewbie/miso/miso"
-	"gorm.io/gorm"
 )
 
 const (
-	CalcDirSizeEventBus             = "event.bus.vfm.dir.size.calc"
 	AddFileToVFolderEventBus        = "event.bus.vfm.file.vfolder.add"
 	CompressImgNotifyEventBus       = "vfm.image.compressed.event"
 	GenVideoThumbnailNotifyEventBus = "vfm.video.thumbnail.generate"
@@ -26,7 +23,6 @@ var (
 	CompressImgNotifyPipeline       = rabbit.NewEventPipeline[fstore.ImageCompressReplyEvent](CompressImgNotifyEventBus)
 
 	AddFileToVFolderPipeline = rabbit.NewEventPipeline[AddFileToVfolderEvent](AddFileToVFolderEventBus)
-	CalcDirSizePipeline      = rabbit.NewEventPipeline[CalcDirSizeEvt](CalcDirSizeEventBus)
 )
 
 func PrepareEventBus(rail miso.Rail) error {
@@ -34,7 +30,11 @@ func PrepareEventBus(rail miso.Rail) error {
 	GenVideoThumbnailNotifyPipeline.Listen(2, OnVidoeThumbnailGenerated)
 	CompressImgNotifyPipeline.Listen(2, OnImageCompressed)
 	AddFileToVFolderPipeline.Listen(2, OnAddFileToVfolderEvent)
-	CalcDirSizePipeline.Listen(1, OnCalcDirSizeEvt)
+	CreateNotifiPipeline.Listen(2, OnCreateNotifiEvent)
+	WebhookDeliveryPipeline.Listen(2, OnWebhookDeliveryEvent)
+	GenThumbnailVariantPipeline.Listen(thumbnailVariantConcurrency(), OnGenThumbnailVariantEvent)
+	GenPdfPreviewPipeline.Listen(1, OnGenPdfPreviewEvent)
+	ExtractImageMetaPipeline.Listen(2, OnExtractImageMetaEvent)
 
 	rabbit.NewEventPipeline[CreateGalleryImgEvent]("event.bus.fantahsea.dir.gallery.image.add").
 		Listen(2, OnCreateGalleryImgEvent) // deprecated
@@ -49,8 +49,9 @@ type NotifyFileDeletedEvent struct {
 }
 
 // event-pump send binlog event when a file_info record is saved.
-// vfm guesses if the file is an image by file name,
-// if so, vfm sends events to hammer to compress the image as a thumbnail
+// vfm detects the media type using the magic bytes of the file,
+// if it's an image, a video or a pdf, vfm sends events to generate the thumbnail,
+// if it's a text file, an excerpt is generated, if it's an image, the EXIF/XMP metadata is extracted
 func OnFileSaved(rail miso.Rail, evt ep.StreamEvent) error {
 	if evt.Type != ep.EventTypeInsert {
 		return nil
@@ -80,37 +81,42 @@ func OnFileSaved(rail miso.Rail, evt ep.StreamEvent) error {
 		return nil // file already deleted
 	}
 
+	HandleFileLifecycleEvent(rail, mysql.GetMySQL(), FileLifecycleEvent{Event: FileEvtCreated, FileKey: uuid})
+
 	if f.FileType != FileTypeFile {
 		rail.Infof("file is dir, %v", uuid)
 		return nil // a directory
 	}
 
-	if f.Thumbnail != "" {
-		rail.Infof("file has thumbnail aleady, %v", uuid)
-		return nil // already has a thumbnail
+	// the lifecycle event is already handled, the media type is guessed by name if the detection fails,
+	// it's detected again by the compensation later
+	if f.MimeType == "" {
+		m, err := detectAndSaveMimeType(rail, mysql.GetMySQL(), f.Uuid, f.FstoreFileId, f.Name)
+		if err != nil {
+			rail.Errorf("Failed to detect media type, guessing by name, uuid: %v, %v", f.Uuid, err)
+		} else {
+			f.MimeType = m
+		}
 	}
 
-	if isImage(f.Name) {
-		evt := fstore.ImgThumbnailTriggerEvent{Identifier: f.Uuid, FileId: f.FstoreFileId, ReplyTo: CompressImgNotifyEventBus}
-		if err := fstore.GenImgThumbnailPipeline.Send(rail, evt); err != nil {
-			return fmt.Errorf("failed to send %#v, uuid: %v, %v", evt, f.Uuid, err)
+	if f.IsImage() {
+		if err := triggerImageMetaExtraction(rail, f.Uuid); err != nil {
+			rail.Errorf("Failed to trigger image metadata extraction, uuid: %v, %v", f.Uuid, err)
 		}
-		return nil
 	}
 
-	if isVideo(f.Name) {
-		evt := fstore.VidThumbnailTriggerEvent{
-			Identifier: f.Uuid,
-			FileId:     f.FstoreFileId,
-			ReplyTo:    GenVideoThumbnailNotifyEventBus,
+	if isTextMime(f.MimeType) && f.SizeInBytes > 0 {
+		if err := generateTextExcerpt(rail, mysql.GetMySQL(), f.Uuid, f.FstoreFileId, f.Name); err != nil {
+			rail.Errorf("Failed to generate excerpt, uuid: %v, %v", f.Uuid, err)
 		}
-		if err := fstore.GenVidThumbnailPipeline.Send(rail, evt); err != nil {
-			return fmt.Errorf("failed to send %#v, uuid: %v, %v", evt, f.Uuid, err)
-		}
-		return nil
 	}
 
-	return nil
+	if f.Thumbnail != "" {
+		rail.Infof("file has thumbnail aleady, %v", uuid)
+		return nil // already has a thumbnail
+	}
+
+	return triggerThumbnailGeneration(rail, mysql.GetMySQL(), f.Uuid, f.FstoreFileId, f.Name, f.MimeType)
 }
 
 // hammer sends event message when the thumbnail image is compressed and saved on mini-fstore
@@ -124,28 +130,6 @@ func OnVidoeThumbnailGenerated(rail miso.Rail, evt fstore.GenVideoThumbnailReply
 	return OnThumbnailGenerated(rail, mysql.GetMySQL(), evt.Identifier, evt.FileId)
 }
 
-func OnThumbnailGenerated(rail miso.Rail, tx *gorm.DB, identifier string, fileId string) error {
-	fileKey := identifier
-	lock := fileLock(rail, fileKey)
-	if err := lock.Lock(); err != nil {
-		return err
-	}
-	defer lock.Unlock()
-
-	f, e := findFile(rail, tx, fileKey)
-	if e != nil {
-		rail.Errorf("Unable to find file, uuid: %v, %v", fileKey, e)
-		return nil
-	}
-	if f == nil {
-		rail.Errorf("File not found, uuid: %v", fileKey)
-		return nil
-	}
-
-	return tx.Exec("UPDATE file_info SET thumbnail = ? WHERE uuid = ?", fileId, fileKey).
-		Error
-}
-
 // event-pump send binlog event when a file_info's thumbnail is updated.
 // vfm receives the event and check if the file has a thumbnail,
 // if so, sends events to fantahsea to create a gallery image,
@@ -184,7 +168,7 @@ func OnThumbnailUpdated(rail miso.Rail, evt ep.StreamEvent) error {
 	if f.Thumbnail == "" || f.ParentFile == "" {
 		return nil
 	}
-	if !isImage(f.Name) {
+	if !f.IsImage() {
 		return nil
 	}
 
@@ -238,6 +222,17 @@ func OnFileDeleted(rail miso.Rail, evt ep.StreamEvent) error {
 
 	rail.Infof("File logically deleted, %v", uuid)
 
+	if parentFile, ok := evt.ColumnAfter("parent_file"); ok && parentFile != "" {
+		name, _ := evt.ColumnAfter("name")
+		notifyDirChanged(rail, mysql.GetMySQL(), parentFile, fmt.Sprintf("'%v' is deleted", name))
+	}
+
+	HandleFileLifecycleEvent(rail, mysql.GetMySQL(), FileLifecycleEvent{Event: FileEvtDeleted, FileKey: uuid})
+
+	if err := OnDirGalleryDirDeleted(rail, mysql.GetMySQL(), uuid); err != nil {
+		return err
+	}
+
 	if e := OnNotifyFileDeletedEvent(rail, NotifyFileDeletedEvent{FileKey: uuid}); e != nil {
 		return fmt.Errorf("failed to send NotifyFileDeletedEvent, uuid: %v, %v", uuid, e)
 	}
@@ -255,15 +250,6 @@ func OnAddFileToVfolderEvent(rail miso.Rail, evt AddFileToVfolderEvent) error {
 	return HandleAddFileToVFolderEvent(rail, mysql.GetMySQL(), evt)
 }
 
-type CalcDirSizeEvt struct {
-	FileKey string
-}
-
-func OnCalcDirSizeEvt(rail miso.Rail, evt CalcDirSizeEvt) error {
-	defer miso.TimeOp(rail, time.Now(), fmt.Sprintf("Process CalcDirSizeEvt: %+v", evt))
-	return CalcDirSize(rail, evt.FileKey, mysql.GetMySQL())
-}
-
 func OnUnzipFileReplyEvent(rail miso.Rail, evt fstore.UnzipFileReplyEvent) error {
 	rail.Infof("received UnzipFileReplyEvent: %+v", evt)
 	return HandleZipUnpackResult(rail, mysql.GetMySQL(), evt)
@@ -285,8 +271,17 @@ func OnFileMoved(rail miso.Rail, evt ep.StreamEvent) error {
 	rail.Infof("Filed %v is moved from %v to %v", fileKey, v.Before, v.After)
 
 	db := mysql.GetMySQL()
+	if name, ok := evt.ColumnAfter("name"); ok {
+		notifyDirChanged(rail, db, v.Before, fmt.Sprintf("'%v' is moved out", name))
+		notifyDirChanged(rail, db, v.After, fmt.Sprintf("'%v' is added", name))
+	}
+
+	HandleFileLifecycleEvent(rail, db, FileLifecycleEvent{Event: FileEvtMoved, FileKey: fileKey, PrevParentFile: v.Before})
+
 	if v.Before != "" {
-		// TODO: remove from gallery
+		if err := RemoveDirGalleryImage(rail, db, v.Before, fileKey); err != nil {
+			return err
+		}
 	}
 	if v.After != "" {
 		// lock before we do anything about it
@@ -301,7 +296,7 @@ func OnFileMoved(rail miso.Rail, evt ep.StreamEvent) error {
 			return err
 		}
 		if f == nil || f.FileType != FileTypeFile ||
-			f.Thumbnail == "" || !isImage(f.Name) {
+			f.Thumbnail == "" || !f.IsImage() {
 			return nil
 		}
 
@@ -331,3 +326,24 @@ func OnFileMoved(rail miso.Rail, evt ep.StreamEvent) error {
 	}
 	return nil
 }
+
+// event-pump send binlog event when a file_info is renamed.
+func OnFileRenamed(rail miso.Rail, evt ep.StreamEvent) error {
+	fileKey, ok := evt.ColumnAfter("uuid")
+	if !ok {
+		rail.Errorf("Event doesn't contain uuid column, %+v", evt)
+		return nil
+	}
+
+	v, ok := evt.Columns["name"]
+	if !ok {
+		rail.Errorf("Event doesn't contain name column, %+v", evt)
+		return nil
+	}
+	rail.Infof("File %v is renamed from '%v' to '%v'", fileKey, v.Before, v.After)
+
+	HandleFileLifecycleEvent(rail, mysql.GetMySQL(), FileLifecycleEvent{Event: FileEvtRenamed, FileKey: fileKey, PrevName: v.Before})
+
+	// only directories have galleries
+	return RenameDirGallery(rail, mysql.GetMySQL(), fileKey, v.After)
+}
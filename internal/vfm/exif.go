@@ -0,0 +1,352 @@
+package vfm
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"regexp"
+	"strings"
+	"time"
+)
+
+const (
+	exifTagImageWidth   = 0x0100
+	exifTagImageLength  = 0x0101
+	exifTagMake         = 0x010f
+	exifTagModel        = 0x0110
+	exifTagOrientation  = 0x0112
+	exifTagDateTime     = 0x0132
+	exifTagExifIFD      = 0x8769
+	exifTagGpsIFD       = 0x8825
+	exifTagDateOriginal = 0x9003
+	exifTagOffsetOrig   = 0x9011
+	exifTagPixelX       = 0xa002
+	exifTagPixelY       = 0xa003
+
+	gpsTagLatRef = 0x0001
+	gpsTagLat    = 0x0002
+	gpsTagLonRef = 0x0003
+	gpsTagLon    = 0x0004
+
+	exifTypeByte      = 1
+	exifTypeAscii     = 2
+	exifTypeShort     = 3
+	exifTypeLong      = 4
+	exifTypeRational  = 5
+	exifTypeUndefined = 7
+	exifTypeSLong     = 9
+	exifTypeSRational = 10
+
+	exifDateLayout = "2006:01:02 15:04:05"
+
+	// max number of entries read in each IFD, in case the data is corrupted.
+	exifMaxIfdEntries = 512
+)
+
+var (
+	_exifTypeSize = map[uint16]int{
+		exifTypeByte:      1,
+		exifTypeAscii:     1,
+		exifTypeShort:     2,
+		exifTypeLong:      4,
+		exifTypeRational:  8,
+		exifTypeUndefined: 1,
+		exifTypeSLong:     4,
+		exifTypeSRational: 8,
+	}
+
+	// capture time in XMP packet, either as attribute or as element, in order of preference.
+	_xmpDateRegexps = []*regexp.Regexp{
+		regexp.MustCompile(`exif:DateTimeOriginal(?:="|>)([^"<]+)`),
+		regexp.MustCompile(`photoshop:DateCreated(?:="|>)([^"<]+)`),
+		regexp.MustCompile(`xmp:CreateDate(?:="|>)([^"<]+)`),
+	}
+
+	_xmpDateLayouts = []string{
+		time.RFC3339Nano,
+		"2006-01-02T15:04:05",
+		"2006-01-02T15:04Z07:00",
+		"2006-01-02T15:04",
+		"2006-01-02",
+	}
+)
+
+// Metadata extracted from the image.
+type imageMeta struct {
+	CaptureTime *time.Time
+	Make        string
+	Model       string
+	Orientation int
+	Width       int
+	Height      int
+	Latitude    *float64
+	Longitude   *float64
+}
+
+type exifEntry struct {
+	typ   uint16
+	count uint32
+	value []byte
+}
+
+type exifReader struct {
+	tiff []byte
+	bo   binary.ByteOrder
+}
+
+// Extract metadata from the beginning of the image file.
+//
+// EXIF is supported for JPEG, PNG, WebP and TIFF-based formats (including most raw formats), capture time in XMP
+// packet is used when EXIF is not available. Fields that are not found are left empty.
+func extractImageMeta(head []byte) imageMeta {
+	var m imageMeta
+	if tiff := findExifTiff(head); tiff != nil {
+		parseExif(tiff, &m)
+	}
+	if m.CaptureTime == nil {
+		m.CaptureTime = parseXmpCaptureTime(head)
+	}
+	if cfg, _, err := image.DecodeConfig(bytes.NewReader(head)); err == nil && cfg.Width > 0 {
+		m.Width, m.Height = cfg.Width, cfg.Height
+	}
+	return m
+}
+
+// Locate the TIFF structure that contains the EXIF data.
+func findExifTiff(b []byte) []byte {
+	switch {
+	case bytes.HasPrefix(b, []byte("\xff\xd8")):
+		// JPEG segments, EXIF is in APP1
+		i := 2
+		for i+4 <= len(b) && b[i] == 0xff {
+			marker := b[i+1]
+			if marker == 0xd8 || marker == 0x01 || (marker >= 0xd0 && marker <= 0xd7) {
+				i += 2
+				continue
+			}
+			if marker == 0xda || marker == 0xd9 {
+				return nil // start of scan, no more metadata
+			}
+			n := int(binary.BigEndian.Uint16(b[i+2:]))
+			end := i + 2 + n
+			if n < 2 || end > len(b) {
+				return nil
+			}
+			seg := b[i+4 : end]
+			if marker == 0xe1 && bytes.HasPrefix(seg, []byte("Exif\x00\x00")) {
+				return seg[6:]
+			}
+			i = end
+		}
+	case bytes.HasPrefix(b, []byte("\x89PNG\r\n\x1a\n")):
+		i := 8
+		for i+8 <= len(b) {
+			n := int(binary.BigEndian.Uint32(b[i:]))
+			typ := string(b[i+4 : i+8])
+			end := i + 8 + n
+			if n < 0 || end > len(b) {
+				return nil
+			}
+			if typ == "eXIf" {
+				return b[i+8 : end]
+			}
+			if typ == "IDAT" {
+				return nil
+			}
+			i = end + 4 // crc
+		}
+	case len(b) >= 12 && string(b[:4]) == "RIFF" && string(b[8:12]) == "WEBP":
+		i := 12
+		for i+8 <= len(b) {
+			typ := string(b[i : i+4])
+			n := int(binary.LittleEndian.Uint32(b[i+4:]))
+			end := i + 8 + n
+			if n < 0 || end > len(b) {
+				return nil
+			}
+			if typ == "EXIF" {
+				return bytes.TrimPrefix(b[i+8:end], []byte("Exif\x00\x00"))
+			}
+			i = end + n%2 // padded to even size
+		}
+	case bytes.HasPrefix(b, []byte("II")), bytes.HasPrefix(b, []byte("MM")):
+		// TIFF and TIFF-based raw formats, some of them have their own magic number (e.g., ORF, RW2)
+		return b
+	}
+	return nil
+}
+
+func parseExif(tiff []byte, m *imageMeta) {
+	if len(tiff) < 8 {
+		return
+	}
+	r := exifReader{tiff: tiff}
+	switch string(tiff[:2]) {
+	case "II":
+		r.bo = binary.LittleEndian
+	case "MM":
+		r.bo = binary.BigEndian
+	default:
+		return
+	}
+
+	ifd0 := r.readIfd(r.bo.Uint32(tiff[4:]))
+	m.Make = r.str(ifd0, exifTagMake)
+	m.Model = r.str(ifd0, exifTagModel)
+	m.Orientation = r.int(ifd0, exifTagOrientation)
+	m.Width = r.int(ifd0, exifTagImageWidth)
+	m.Height = r.int(ifd0, exifTagImageLength)
+
+	var exif map[uint16]exifEntry
+	if off := r.int(ifd0, exifTagExifIFD); off > 0 {
+		exif = r.readIfd(uint32(off))
+	}
+	if w, h := r.int(exif, exifTagPixelX), r.int(exif, exifTagPixelY); w > 0 && h > 0 {
+		m.Width, m.Height = w, h
+	}
+
+	captured := r.str(exif, exifTagDateOriginal)
+	if captured == "" {
+		captured = r.str(ifd0, exifTagDateTime)
+	}
+	m.CaptureTime = parseExifTime(captured, r.str(exif, exifTagOffsetOrig))
+
+	if off := r.int(ifd0, exifTagGpsIFD); off > 0 {
+		gps := r.readIfd(uint32(off))
+		lat, latOk := r.gpsCoord(gps, gpsTagLat, gpsTagLatRef, "S")
+		lon, lonOk := r.gpsCoord(gps, gpsTagLon, gpsTagLonRef, "W")
+		if latOk && lonOk {
+			m.Latitude, m.Longitude = &lat, &lon
+		}
+	}
+}
+
+func (r exifReader) readIfd(off uint32) map[uint16]exifEntry {
+	entries := map[uint16]exifEntry{}
+	if int64(off)+2 > int64(len(r.tiff)) {
+		return entries
+	}
+	n := int(r.bo.Uint16(r.tiff[off:]))
+	if n > exifMaxIfdEntries {
+		return entries
+	}
+	for i := 0; i < n; i++ {
+		p := int(off) + 2 + i*12
+		if p+12 > len(r.tiff) {
+			break
+		}
+		tag := r.bo.Uint16(r.tiff[p:])
+		typ := r.bo.Uint16(r.tiff[p+2:])
+		count := r.bo.Uint32(r.tiff[p+4:])
+		size, ok := _exifTypeSize[typ]
+		if !ok || count > uint32(len(r.tiff)) {
+			continue
+		}
+		total := size * int(count)
+		var value []byte
+		if total <= 4 {
+			value = r.tiff[p+8 : p+8+total]
+		} else {
+			vo := int64(r.bo.Uint32(r.tiff[p+8:]))
+			if vo+int64(total) > int64(len(r.tiff)) {
+				continue
+			}
+			value = r.tiff[vo : vo+int64(total)]
+		}
+		entries[tag] = exifEntry{typ: typ, count: count, value: value}
+	}
+	return entries
+}
+
+func (r exifReader) str(ifd map[uint16]exifEntry, tag uint16) string {
+	e, ok := ifd[tag]
+	if !ok || e.typ != exifTypeAscii {
+		return ""
+	}
+	if i := bytes.IndexByte(e.value, 0); i >= 0 {
+		e.value = e.value[:i]
+	}
+	return strings.TrimSpace(strings.ToValidUTF8(string(e.value), ""))
+}
+
+func (r exifReader) int(ifd map[uint16]exifEntry, tag uint16) int {
+	e, ok := ifd[tag]
+	if !ok || e.count < 1 {
+		return 0
+	}
+	switch e.typ {
+	case exifTypeShort:
+		return int(r.bo.Uint16(e.value))
+	case exifTypeLong:
+		return int(r.bo.Uint32(e.value))
+	}
+	return 0
+}
+
+func (r exifReader) rational(e exifEntry, i int) (float64, bool) {
+	if e.typ != exifTypeRational || uint32(i) >= e.count {
+		return 0, false
+	}
+	num := r.bo.Uint32(e.value[i*8:])
+	den := r.bo.Uint32(e.value[i*8+4:])
+	if den == 0 {
+		return 0, false
+	}
+	return float64(num) / float64(den), true
+}
+
+// GPS coordinate in degrees, stored as degrees, minutes and seconds.
+func (r exifReader) gpsCoord(gps map[uint16]exifEntry, tag uint16, refTag uint16, negRef string) (float64, bool) {
+	e, ok := gps[tag]
+	if !ok || e.count < 3 {
+		return 0, false
+	}
+	var v float64
+	for i, div := range []float64{1, 60, 3600} {
+		x, ok := r.rational(e, i)
+		if !ok {
+			return 0, false
+		}
+		v += x / div
+	}
+	if strings.EqualFold(r.str(gps, refTag), negRef) {
+		v = -v
+	}
+	return v, true
+}
+
+// Parse EXIF date time, the offset (e.g., +08:00) is optional, local time zone is assumed without it.
+func parseExifTime(s string, offset string) *time.Time {
+	if s == "" || strings.HasPrefix(s, "0000") {
+		return nil
+	}
+	if offset != "" {
+		if t, err := time.Parse(exifDateLayout+"-07:00", s+offset); err == nil {
+			return &t
+		}
+	}
+	t, err := time.ParseInLocation(exifDateLayout, s, time.Local)
+	if err != nil {
+		return nil
+	}
+	return &t
+}
+
+func parseXmpCaptureTime(b []byte) *time.Time {
+	if !bytes.Contains(b, []byte("<x:xmpmeta")) {
+		return nil
+	}
+	for _, re := range _xmpDateRegexps {
+		sm := re.FindSubmatch(b)
+		if sm == nil {
+			continue
+		}
+		v := strings.TrimSpace(string(sm[1]))
+		for _, layout := range _xmpDateLayouts {
+			if t, err := time.ParseInLocation(layout, v, time.Local); err == nil {
+				return &t
+			}
+		}
+	}
+	return nil
+}
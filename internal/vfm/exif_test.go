@@ -0,0 +1,160 @@
+package vfm
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/jpeg"
+	"math"
+	"testing"
+	"time"
+)
+
+type testIfdEntry struct {
+	tag   uint16
+	typ   uint16
+	count uint32
+	value []byte
+}
+
+// build a little-endian TIFF with IFD0, Exif IFD and GPS IFD.
+func buildTestTiff(ifd0, exif, gps []testIfdEntry) []byte {
+	bo := binary.LittleEndian
+	var buf bytes.Buffer
+	buf.WriteString("II*\x00")
+	binary.Write(&buf, bo, uint32(8))
+
+	ifdSize := func(n int) int { return 2 + n*12 + 4 }
+	exifOff := 8 + ifdSize(len(ifd0)+2)
+	gpsOff := exifOff + ifdSize(len(exif))
+	dataOff := gpsOff + ifdSize(len(gps))
+
+	ifd0 = append(ifd0,
+		testIfdEntry{exifTagExifIFD, exifTypeLong, 1, u32(uint32(exifOff))},
+		testIfdEntry{exifTagGpsIFD, exifTypeLong, 1, u32(uint32(gpsOff))})
+
+	var data bytes.Buffer
+	writeIfd := func(entries []testIfdEntry) {
+		binary.Write(&buf, bo, uint16(len(entries)))
+		for _, e := range entries {
+			binary.Write(&buf, bo, e.tag)
+			binary.Write(&buf, bo, e.typ)
+			binary.Write(&buf, bo, e.count)
+			if len(e.value) <= 4 {
+				v := make([]byte, 4)
+				copy(v, e.value)
+				buf.Write(v)
+			} else {
+				binary.Write(&buf, bo, uint32(dataOff+data.Len()))
+				data.Write(e.value)
+			}
+		}
+		binary.Write(&buf, bo, uint32(0))
+	}
+	writeIfd(ifd0)
+	writeIfd(exif)
+	writeIfd(gps)
+	buf.Write(data.Bytes())
+	return buf.Bytes()
+}
+
+func u16(v uint16) []byte {
+	b := make([]byte, 2)
+	binary.LittleEndian.PutUint16(b, v)
+	return b
+}
+
+func u32(v uint32) []byte {
+	b := make([]byte, 4)
+	binary.LittleEndian.PutUint32(b, v)
+	return b
+}
+
+func rationals(v ...uint32) []byte {
+	var b []byte
+	for _, x := range v {
+		b = append(b, u32(x)...)
+	}
+	return b
+}
+
+func ascii(s string) (uint32, []byte) {
+	return uint32(len(s) + 1), append([]byte(s), 0)
+}
+
+func TestExtractImageMeta(t *testing.T) {
+	makeCnt, makeVal := ascii("Canon")
+	modelCnt, modelVal := ascii("EOS R5")
+	dtCnt, dtVal := ascii("2023:05:06 07:08:09")
+	offCnt, offVal := ascii("+08:00")
+	tiff := buildTestTiff(
+		[]testIfdEntry{
+			{exifTagMake, exifTypeAscii, makeCnt, makeVal},
+			{exifTagModel, exifTypeAscii, modelCnt, modelVal},
+			{exifTagOrientation, exifTypeShort, 1, u16(6)},
+		},
+		[]testIfdEntry{
+			{exifTagDateOriginal, exifTypeAscii, dtCnt, dtVal},
+			{exifTagOffsetOrig, exifTypeAscii, offCnt, offVal},
+			{exifTagPixelX, exifTypeLong, 1, u32(4000)},
+			{exifTagPixelY, exifTypeShort, 1, u16(3000)},
+		},
+		[]testIfdEntry{
+			{gpsTagLatRef, exifTypeAscii, 2, []byte("S\x00")},
+			{gpsTagLat, exifTypeRational, 3, rationals(33, 1, 30, 1, 0, 1)},
+			{gpsTagLonRef, exifTypeAscii, 2, []byte("E\x00")},
+			{gpsTagLon, exifTypeRational, 3, rationals(151, 1, 12, 1, 36, 1)},
+		},
+	)
+
+	// a real jpeg, with the EXIF APP1 segment inserted after SOI
+	var img bytes.Buffer
+	if err := jpeg.Encode(&img, image.NewRGBA(image.Rect(0, 0, 16, 8)), nil); err != nil {
+		t.Fatal(err)
+	}
+	app1 := append([]byte("Exif\x00\x00"), tiff...)
+	var jpg bytes.Buffer
+	jpg.Write(img.Bytes()[:2])
+	jpg.Write([]byte{0xff, 0xe1})
+	binary.Write(&jpg, binary.BigEndian, uint16(len(app1)+2))
+	jpg.Write(app1)
+	jpg.Write(img.Bytes()[2:])
+
+	m := extractImageMeta(jpg.Bytes())
+	if m.Make != "Canon" || m.Model != "EOS R5" || m.Orientation != 6 {
+		t.Errorf("unexpected camera info: %+v", m)
+	}
+	want := time.Date(2023, 5, 6, 7, 8, 9, 0, time.FixedZone("", 8*3600))
+	if m.CaptureTime == nil || !m.CaptureTime.Equal(want) {
+		t.Errorf("want capture time %v, got %v", want, m.CaptureTime)
+	}
+	// decoded dimensions take precedence over EXIF
+	if m.Width != 16 || m.Height != 8 {
+		t.Errorf("want 16x8, got %vx%v", m.Width, m.Height)
+	}
+	if m.Latitude == nil || math.Abs(*m.Latitude+33.5) > 1e-9 {
+		t.Errorf("unexpected latitude: %v", m.Latitude)
+	}
+	if m.Longitude == nil || math.Abs(*m.Longitude-151.21) > 1e-9 {
+		t.Errorf("unexpected longitude: %v", m.Longitude)
+	}
+
+	// raw tiff, dimensions from EXIF
+	m = extractImageMeta(tiff)
+	if m.Width != 4000 || m.Height != 3000 || m.CaptureTime == nil {
+		t.Errorf("unexpected tiff metadata: %+v", m)
+	}
+}
+
+func TestParseXmpCaptureTime(t *testing.T) {
+	xmp := []byte(`<x:xmpmeta xmlns:x="adobe:ns:meta/"><rdf:Description xmp:CreateDate="2020-01-02T03:04:05"
+		photoshop:DateCreated="2019-12-31T10:00:00+02:00"/></x:xmpmeta>`)
+	got := parseXmpCaptureTime(xmp)
+	want := time.Date(2019, 12, 31, 10, 0, 0, 0, time.FixedZone("", 2*3600))
+	if got == nil || !got.Equal(want) {
+		t.Errorf("want %v, got %v", want, got)
+	}
+	if parseXmpCaptureTime([]byte("no xmp")) != nil {
+		t.Error("want nil")
+	}
+}
@@ -2,6 +2,7 @@ package vfm
 
 import (
 	"fmt"
+	"time"
 
 	"github.com/curtisnewbie/miso/middleware/mysql"
 	"github.com/curtisnewbie/miso/middleware/redis"
@@ -15,16 +16,18 @@ import (
 
 // Gallery
 type Gallery struct {
-	Id         int64
-	GalleryNo  string
-	UserNo     string
-	Name       string
-	DirFileKey string
-	CreateTime util.ETime
-	CreateBy   string
-	UpdateTime util.ETime
-	UpdateBy   string
-	IsDel      bool
+	Id           int64
+	GalleryNo    string
+	UserNo       string
+	Name         string
+	DirFileKey   string
+	CoverImageNo string
+	AutoCreated  bool
+	CreateTime   util.ETime
+	CreateBy     string
+	UpdateTime   util.ETime
+	UpdateBy     string
+	IsDel        bool
 }
 
 func (Gallery) TableName() string {
@@ -64,17 +67,21 @@ type VGalleryBrief struct {
 }
 
 type VGallery struct {
-	ID            int64      `json:"id"`
-	GalleryNo     string     `json:"galleryNo"`
-	UserNo        string     `json:"userNo"`
-	Name          string     `json:"name"`
-	CreateTime    util.ETime `json:"-"`
-	UpdateTime    util.ETime `json:"-"`
-	CreateBy      string     `json:"createBy"`
-	UpdateBy      string     `json:"updateBy"`
-	IsOwner       bool       `json:"isOwner"`
-	CreateTimeStr string     `json:"createTime"`
-	UpdateTimeStr string     `json:"updateTime"`
+	ID                  int64      `json:"id"`
+	GalleryNo           string     `json:"galleryNo"`
+	UserNo              string     `json:"userNo"`
+	Name                string     `json:"name"`
+	CreateTime          util.ETime `json:"-"`
+	UpdateTime          util.ETime `json:"-"`
+	CreateBy            string     `json:"createBy"`
+	UpdateBy            string     `json:"updateBy"`
+	IsOwner             bool       `json:"isOwner"`
+	CreateTimeStr       string     `json:"createTime"`
+	UpdateTimeStr       string     `json:"updateTime"`
+	CoverImageNo        string     `json:"coverImageNo" desc:"image_no of the cover image chosen by the owner, empty if not chosen"`
+	CoverThumbnailToken string     `json:"coverThumbnailToken" desc:"thumbnail token of the cover image, the latest added image is used if the cover is not chosen"`
+	AutoCreated         bool       `json:"autoCreated" desc:"whether the gallery is created automatically for a directory"`
+	Role                string     `json:"role" desc:"role of the user: OWNER, CONTRIBUTOR, VIEWER"`
 }
 
 // List owned gallery briefs
@@ -95,7 +102,7 @@ func ListOwnedGalleryBriefs(rail miso.Rail, user common.User, tx *gorm.DB) ([]VG
 
 /* List Galleries */
 func ListGalleries(rail miso.Rail, cmd ListGalleriesCmd, user common.User, db *gorm.DB) (miso.PageRes[VGallery], error) {
-	return mysql.NewPageQuery[VGallery]().
+	res, err := mysql.NewPageQuery[VGallery]().
 		WithPage(cmd.Paging).
 		WithBaseQuery(func(tx *gorm.DB) *gorm.DB {
 			return tx.Table("gallery g").
@@ -103,18 +110,130 @@ func ListGalleries(rail miso.Rail, cmd ListGalleriesCmd, user common.User, db *g
 				Where("g.user_no = ? OR EXISTS (select * from gallery_user_access ga where ga.user_no = ? AND ga.is_del = 0 AND ga.gallery_no = g.gallery_no)", user.UserNo, user.UserNo)
 		}).
 		WithSelectQuery(func(tx *gorm.DB) *gorm.DB {
-			tx = tx.Select("g.*").Order("g.update_time DESC")
+			tx = tx.Select(`g.*, COALESCE((SELECT ga.role FROM gallery_user_access ga
+				WHERE ga.user_no = ? AND ga.is_del = 0 AND ga.gallery_no = g.gallery_no LIMIT 1), '') role`, user.UserNo).
+				Order("g.update_time DESC")
 			return tx
 		}).
 		ForEach(func(g VGallery) VGallery {
 			if g.UserNo == user.UserNo {
 				g.IsOwner = true
+				g.Role = GalleryRoleOwner
 			}
 			g.CreateTimeStr = g.CreateTime.FormatClassic()
 			g.UpdateTimeStr = g.UpdateTime.FormatClassic()
 			return g
 		}).
 		Exec(rail, db)
+	if err != nil {
+		return res, err
+	}
+
+	galleryNos := make([]string, 0, len(res.Payload))
+	for _, g := range res.Payload {
+		galleryNos = append(galleryNos, g.GalleryNo)
+	}
+	covers, err := findGalleryCoverTokens(rail, db, galleryNos)
+	if err != nil {
+		return res, err
+	}
+	for i, g := range res.Payload {
+		res.Payload[i].CoverThumbnailToken = covers[g.GalleryNo]
+	}
+	return res, nil
+}
+
+// Find thumbnail tokens of the gallery covers, the latest added image is used if the cover is not chosen.
+//
+// Key is the gallery_no, galleries without images are not included.
+func findGalleryCoverTokens(rail miso.Rail, tx *gorm.DB, galleryNos []string) (map[string]string, error) {
+	res := map[string]string{}
+	if len(galleryNos) < 1 {
+		return res, nil
+	}
+
+	type galleryCover struct {
+		GalleryNo    string
+		FstoreFileId string
+		Thumbnail    string
+	}
+	var covers []galleryCover
+	err := tx.Raw(`SELECT g.gallery_no, fi.fstore_file_id, fi.thumbnail FROM gallery g
+		JOIN gallery_image gi ON gi.id = COALESCE(
+			(SELECT c.id FROM gallery_image c WHERE c.gallery_no = g.gallery_no AND c.image_no = g.cover_image_no AND g.cover_image_no != ''),
+			(SELECT MAX(l.id) FROM gallery_image l WHERE l.gallery_no = g.gallery_no))
+		JOIN file_info fi ON fi.uuid = gi.file_key
+		WHERE g.gallery_no IN ?`, galleryNos).
+		Scan(&covers).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to query gallery covers, %v", err)
+	}
+	if len(covers) < 1 {
+		return res, nil
+	}
+
+	awaitFutures := util.NewAwaitFutures[FstoreTmpToken](vfmPool)
+	for i, c := range covers {
+		if c.Thumbnail == "" {
+			covers[i].Thumbnail = c.FstoreFileId
+		}
+		GenFstoreTknBatch(rail, awaitFutures, covers[i].Thumbnail, "")
+	}
+	idTknMap := map[string]string{}
+	for _, fut := range awaitFutures.Await() {
+		t, err := fut.Get()
+		if err != nil {
+			rail.Errorf("Failed to get mini-fstore temp token for gallery cover: %v, %v", t.FileId, err)
+			continue
+		}
+		idTknMap[t.FileId] = t.TempKey
+	}
+	for _, c := range covers {
+		res[c.GalleryNo] = idTknMap[c.Thumbnail]
+	}
+	return res, nil
+}
+
+type SetGalleryCoverReq struct {
+	GalleryNo string `json:"galleryNo" validation:"notEmpty"`
+	ImageNo   string `json:"imageNo" desc:"image_no of the cover image, empty to use the latest added image"`
+}
+
+// Choose the cover image of the gallery.
+func SetGalleryCover(rail miso.Rail, tx *gorm.DB, req SetGalleryCoverReq, user common.User) error {
+	gallery, err := FindGallery(rail, tx, req.GalleryNo)
+	if err != nil {
+		return err
+	}
+	if gallery.UserNo != user.UserNo {
+		return miso.NewErrf("You are not allowed to update this gallery")
+	}
+
+	detail := "Reset cover image"
+	if req.ImageNo != "" {
+		var img GalleryImage
+		t := tx.Raw(`SELECT name FROM gallery_image WHERE gallery_no = ? AND image_no = ?`, req.GalleryNo, req.ImageNo).Scan(&img)
+		if t.Error != nil {
+			return fmt.Errorf("failed to query gallery_image, %v", t.Error)
+		}
+		if t.RowsAffected < 1 {
+			return miso.NewErrf("Image is not found in gallery")
+		}
+		detail = fmt.Sprintf("Set image '%s' as cover", img.Name)
+	}
+
+	return tx.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Exec(`UPDATE gallery SET cover_image_no = ?, update_by = ? WHERE gallery_no = ?`,
+			req.ImageNo, user.Username, req.GalleryNo).Error; err != nil {
+			return fmt.Errorf("failed to update gallery.cover_image_no, %v", err)
+		}
+		return RecordActivity(rail, tx, Activity{
+			RefType: ActRefGallery,
+			RefKey:  req.GalleryNo,
+			Action:  ActionUpdate,
+			Detail:  detail,
+		}, user)
+	})
 }
 
 func GalleryNoOfDir(dirFileKey string, tx *gorm.DB) (string, error) {
@@ -158,16 +277,25 @@ func CreateGalleryForDir(rail miso.Rail, cmd CreateGalleryForDirCmd, tx *gorm.DB
 
 				err := tx.Transaction(func(tx *gorm.DB) error {
 					gallery := &Gallery{
-						GalleryNo:  galleryNo,
-						Name:       cmd.DirName,
-						DirFileKey: cmd.DirFileKey,
-						UserNo:     cmd.UserNo,
-						CreateBy:   cmd.Username,
-						UpdateBy:   cmd.Username,
-						IsDel:      false,
+						GalleryNo:   galleryNo,
+						Name:        cmd.DirName,
+						DirFileKey:  cmd.DirFileKey,
+						AutoCreated: true,
+						UserNo:      cmd.UserNo,
+						CreateBy:    cmd.Username,
+						UpdateBy:    cmd.Username,
+						IsDel:       false,
 					}
-					result := tx.Omit("CreateTime", "UpdateTime").Create(gallery)
-					return result.Error
+					if err := tx.Omit("CreateTime", "UpdateTime").Create(gallery).Error; err != nil {
+						return err
+					}
+					return RecordActivity(rail, tx, Activity{
+						RefType: ActRefGallery,
+						RefKey:  galleryNo,
+						FileKey: cmd.DirFileKey,
+						Action:  ActionCreate,
+						Detail:  fmt.Sprintf("Created gallery '%s' for directory", cmd.DirName),
+					}, common.User{UserNo: cmd.UserNo, Username: cmd.Username})
 				})
 				if err != nil {
 					return galleryNo, err
@@ -199,8 +327,15 @@ func CreateGallery(rail miso.Rail, cmd CreateGalleryCmd, user common.User, tx *g
 			UpdateBy:  user.Username,
 			IsDel:     false,
 		}
-		result := tx.Omit("CreateTime", "UpdateTime").Create(gallery)
-		return gallery, result.Error
+		if err := tx.Omit("CreateTime", "UpdateTime").Create(gallery).Error; err != nil {
+			return gallery, err
+		}
+		return gallery, RecordActivity(rail, tx, Activity{
+			RefType: ActRefGallery,
+			RefKey:  galleryNo,
+			Action:  ActionCreate,
+			Detail:  fmt.Sprintf("Created gallery '%s'", cmd.Name),
+		}, user)
 	})
 
 	if er != nil {
@@ -235,7 +370,12 @@ func UpdateGallery(rail miso.Rail, cmd UpdateGalleryCmd, user common.User, tx *g
 		return miso.NewErrf("Failed to update gallery, please try again later")
 	}
 
-	return nil
+	return RecordActivity(rail, tx, Activity{
+		RefType: ActRefGallery,
+		RefKey:  galleryNo,
+		Action:  ActionUpdate,
+		Detail:  fmt.Sprintf("Renamed gallery from '%s' to '%s'", gallery.Name, cmd.Name),
+	}, user)
 }
 
 /* Find Gallery's creator by gallery_no */
@@ -287,7 +427,12 @@ func DeleteGallery(rail miso.Rail, tx *gorm.DB, cmd DeleteGalleryCmd, user commo
 		return t.Error
 	}
 
-	return nil
+	return RecordActivity(rail, tx, Activity{
+		RefType: ActRefGallery,
+		RefKey:  galleryNo,
+		Action:  ActionDelete,
+		Detail:  "Deleted gallery",
+	}, user)
 }
 
 // Check if the gallery exists
@@ -315,6 +460,22 @@ func OnCreateGalleryImgEvent(rail miso.Rail, evt CreateGalleryImgEvent) error {
 		return nil
 	}
 
+	// galleries that already exist are kept in sync even if automatic creation is disabled
+	enabled, err := IsAutoGalleryEnabled(tx, evt.UserNo, evt.DirFileKey)
+	if err != nil {
+		return err
+	}
+	if !enabled {
+		galleryNo, err := GalleryNoOfDir(evt.DirFileKey, tx)
+		if err != nil {
+			return err
+		}
+		if galleryNo == "" {
+			rail.Infof("Automatic gallery creation is disabled for dir %v, skipped", evt.DirFileKey)
+			return nil
+		}
+	}
+
 	// create gallery for the directory if necessary
 	galleryNo, err := CreateGalleryForDir(rail, CreateGalleryForDirCmd{
 		Username:   evt.Username,
@@ -333,6 +494,7 @@ func OnCreateGalleryImgEvent(rail miso.Rail, evt CreateGalleryImgEvent) error {
 			GalleryNo: galleryNo,
 			Name:      evt.ImageName,
 			FileKey:   evt.ImageFileKey,
+			DirSynced: true,
 		},
 		evt.UserNo,
 		evt.Username, tx)
@@ -342,3 +504,135 @@ func OnNotifyFileDeletedEvent(rail miso.Rail, evt NotifyFileDeletedEvent) error
 	rail.Infof("Received NotifyFileDeletedEvent: %+v", evt)
 	return DeleteGalleryImage(rail, mysql.GetMySQL(), evt.FileKey)
 }
+
+// Remove image from the gallery of the directory, e.g., when the image is moved out of the directory.
+//
+// Only images added by directory gallery sync are removed, those added manually are kept.
+func RemoveDirGalleryImage(rail miso.Rail, tx *gorm.DB, dirFileKey string, fileKey string) error {
+	galleryNo, err := GalleryNoOfDir(dirFileKey, tx)
+	if err != nil {
+		return fmt.Errorf("failed to find gallery of dir, dirFileKey: %v, %v", dirFileKey, err)
+	}
+	if galleryNo == "" {
+		return nil
+	}
+
+	lock := NewGalleryFileLock(rail, galleryNo, fileKey)
+	if err := lock.Lock(); err != nil {
+		return fmt.Errorf("failed to obtain gallery image lock, gallery:%v, fileKey: %v", galleryNo, fileKey)
+	}
+	defer lock.Unlock()
+
+	t := tx.Exec(`DELETE FROM gallery_image WHERE gallery_no = ? AND file_key = ? AND dir_synced = 1`, galleryNo, fileKey)
+	if t.Error != nil {
+		return fmt.Errorf("failed to delete gallery_image, galleryNo: %v, fileKey: %v, %v", galleryNo, fileKey, t.Error)
+	}
+	if t.RowsAffected > 0 {
+		rail.Infof("Removed image %v from gallery %v of dir %v", fileKey, galleryNo, dirFileKey)
+	}
+	return nil
+}
+
+// Rename the gallery of the directory to follow the directory name.
+func RenameDirGallery(rail miso.Rail, tx *gorm.DB, dirFileKey string, name string) error {
+	t := tx.Exec(`UPDATE gallery SET name = ? WHERE dir_file_key = ? AND is_del = 0 AND name != ?`, name, dirFileKey, name)
+	if t.Error != nil {
+		return fmt.Errorf("failed to rename gallery of dir, dirFileKey: %v, %v", dirFileKey, t.Error)
+	}
+	if t.RowsAffected > 0 {
+		rail.Infof("Renamed gallery of dir %v to '%v'", dirFileKey, name)
+	}
+	return nil
+}
+
+// Handle the deletion of the directory.
+//
+// The gallery is soft-deleted if it's empty, otherwise it's detached from the directory, since the images left are
+// added manually (the directory must be empty before it's deleted).
+func OnDirGalleryDirDeleted(rail miso.Rail, tx *gorm.DB, dirFileKey string) error {
+	galleryNo, err := GalleryNoOfDir(dirFileKey, tx)
+	if err != nil {
+		return fmt.Errorf("failed to find gallery of dir, dirFileKey: %v, %v", dirFileKey, err)
+	}
+	if galleryNo == "" {
+		return nil
+	}
+
+	var anyId int
+	if err := tx.Raw(`SELECT id FROM gallery_image WHERE gallery_no = ? LIMIT 1`, galleryNo).Scan(&anyId).Error; err != nil {
+		return fmt.Errorf("failed to query gallery_image, galleryNo: %v, %v", galleryNo, err)
+	}
+	if anyId > 0 {
+		rail.Infof("Dir %v is deleted, detaching gallery %v", dirFileKey, galleryNo)
+		return tx.Exec(`UPDATE gallery SET dir_file_key = '' WHERE gallery_no = ?`, galleryNo).Error
+	}
+	rail.Infof("Dir %v is deleted, deleting empty gallery %v", dirFileKey, galleryNo)
+	return tx.Exec(`UPDATE gallery SET is_del = 1 WHERE gallery_no = ? AND is_del = 0`, galleryNo).Error
+}
+
+// Bring directory galleries in sync with their directories.
+//
+// Images added by the sync that are no longer in the directory are removed, galleries are renamed to follow the directory names,
+// and galleries of deleted directories are deleted or detached.
+func CompensateDirGalleries(rail miso.Rail, db *gorm.DB) error {
+	rail.Info("CompensateDirGalleries start")
+	defer miso.TimeOp(rail, time.Now(), "CompensateDirGalleries")
+
+	type dirGallery struct {
+		Id             int
+		GalleryNo      string
+		Name           string
+		DirFileKey     string
+		DirName        string
+		IsLogicDeleted int
+	}
+
+	minId := 0
+	for {
+		var galleries []dirGallery
+		err := db.Raw(`SELECT g.id, g.gallery_no, g.name, g.dir_file_key, fi.name dir_name, fi.is_logic_deleted
+			FROM gallery g
+			LEFT JOIN file_info fi ON fi.uuid = g.dir_file_key
+			WHERE g.id > ? AND g.dir_file_key != '' AND g.is_del = 0
+			ORDER BY g.id ASC
+			LIMIT 200`, minId).
+			Scan(&galleries).Error
+		if err != nil {
+			return fmt.Errorf("failed to list dir galleries, minId: %v, %v", minId, err)
+		}
+		if len(galleries) < 1 {
+			return nil
+		}
+
+		for _, g := range galleries {
+			var movedOut []string
+			err := db.Raw(`SELECT gi.file_key FROM gallery_image gi
+				LEFT JOIN file_info fi ON fi.uuid = gi.file_key
+				WHERE gi.gallery_no = ? AND gi.dir_synced = 1 AND (fi.id IS NULL OR fi.parent_file != ?)`, g.GalleryNo, g.DirFileKey).
+				Scan(&movedOut).Error
+			if err != nil {
+				return fmt.Errorf("failed to list images moved out of dir, galleryNo: %v, %v", g.GalleryNo, err)
+			}
+			for _, fk := range movedOut {
+				if err := RemoveDirGalleryImage(rail, db, g.DirFileKey, fk); err != nil {
+					return err
+				}
+			}
+
+			if g.DirName == "" || g.IsLogicDeleted == LDelY {
+				if err := OnDirGalleryDirDeleted(rail, db, g.DirFileKey); err != nil {
+					return err
+				}
+				continue
+			}
+			if g.DirName != g.Name {
+				if err := RenameDirGallery(rail, db, g.DirFileKey, g.DirName); err != nil {
+					return err
+				}
+			}
+		}
+
+		minId = galleries[len(galleries)-1].Id
+		rail.Infof("CompensateDirGalleries, minId: %v", minId)
+	}
+}
@@ -0,0 +1,162 @@
+package vfm
+
+import (
+	"fmt"
+
+	"github.com/curtisnewbie/miso/middleware/user-vault/common"
+	"github.com/curtisnewbie/miso/miso"
+	"gorm.io/gorm"
+)
+
+type AutoGalleryPrefs struct {
+	DefaultEnabled bool             `json:"defaultEnabled" desc:"whether galleries are created automatically for directories without their own setting"`
+	Dirs           []AutoGalleryDir `json:"dirs" desc:"directories with their own setting"`
+}
+
+type AutoGalleryDir struct {
+	DirFileKey string `json:"dirFileKey"`
+	DirName    string `json:"dirName"`
+	Enabled    bool   `json:"enabled"`
+}
+
+type UpdateAutoGalleryPrefReq struct {
+	DirFileKey string `json:"dirFileKey" desc:"directory file key, empty to update the default of the user"`
+	Enabled    *bool  `json:"enabled" desc:"whether galleries are created automatically, null to remove the setting of the directory"`
+}
+
+type CleanAutoGalleriesReq struct {
+	DryRun bool `json:"dryRun" desc:"only list the galleries that would be deleted"`
+}
+
+// Check whether gallery is created automatically for the directory.
+//
+// Setting of the directory takes precedence over the default of the user, it's enabled if neither is configured.
+func IsAutoGalleryEnabled(tx *gorm.DB, userNo string, dirFileKey string) (bool, error) {
+	defEnabled, dirEnabled, err := findAutoGalleryPref(tx, userNo, dirFileKey)
+	if err != nil {
+		return false, err
+	}
+	if dirEnabled != nil {
+		return *dirEnabled, nil
+	}
+	return defEnabled, nil
+}
+
+func findAutoGalleryPref(tx *gorm.DB, userNo string, dirFileKey string) (bool, *bool, error) {
+	var prefs []AutoGalleryDir
+	err := tx.Raw(`SELECT dir_file_key, enabled FROM gallery_auto_pref WHERE user_no = ? AND dir_file_key IN ?`,
+		userNo, []string{"", dirFileKey}).
+		Scan(&prefs).Error
+	if err != nil {
+		return false, nil, fmt.Errorf("failed to query gallery_auto_pref, userNo: %v, dirFileKey: %v, %v", userNo, dirFileKey, err)
+	}
+
+	defEnabled := true
+	var dirEnabled *bool
+	for _, p := range prefs {
+		if p.DirFileKey == "" {
+			defEnabled = p.Enabled
+		} else {
+			enabled := p.Enabled
+			dirEnabled = &enabled
+		}
+	}
+	return defEnabled, dirEnabled, nil
+}
+
+// List user's preferences of automatic gallery creation.
+func ListAutoGalleryPrefs(rail miso.Rail, tx *gorm.DB, user common.User) (AutoGalleryPrefs, error) {
+	defEnabled, _, err := findAutoGalleryPref(tx, user.UserNo, "")
+	if err != nil {
+		return AutoGalleryPrefs{}, err
+	}
+
+	dirs := []AutoGalleryDir{}
+	err = tx.Raw(`SELECT p.dir_file_key, fi.name dir_name, p.enabled
+		FROM gallery_auto_pref p
+		JOIN file_info fi ON fi.uuid = p.dir_file_key AND fi.is_logic_deleted = 0
+		WHERE p.user_no = ? AND p.dir_file_key != ''
+		ORDER BY p.id DESC`, user.UserNo).
+		Scan(&dirs).Error
+	if err != nil {
+		return AutoGalleryPrefs{}, fmt.Errorf("failed to list gallery_auto_pref, userNo: %v, %v", user.UserNo, err)
+	}
+	return AutoGalleryPrefs{DefaultEnabled: defEnabled, Dirs: dirs}, nil
+}
+
+// Update user's default or the setting of the directory.
+func UpdateAutoGalleryPref(rail miso.Rail, tx *gorm.DB, req UpdateAutoGalleryPrefReq, user common.User) error {
+	if req.DirFileKey == "" {
+		if req.Enabled == nil {
+			return miso.NewErrf("Enabled is required")
+		}
+	} else {
+		f, err := findFile(rail, tx, req.DirFileKey)
+		if err != nil {
+			return err
+		}
+		if f == nil || f.IsLogicDeleted == LDelY {
+			return miso.NewErrf("File not found")
+		}
+		if f.FileType != FileTypeDir {
+			return miso.NewErrf("Not a directory")
+		}
+		if f.UploaderNo != user.UserNo {
+			return miso.NewErrf("Not permitted")
+		}
+	}
+
+	if req.Enabled == nil {
+		return tx.Exec(`DELETE FROM gallery_auto_pref WHERE user_no = ? AND dir_file_key = ?`, user.UserNo, req.DirFileKey).Error
+	}
+	return tx.Exec(`INSERT INTO gallery_auto_pref (user_no, dir_file_key, enabled) VALUES (?,?,?)
+		ON DUPLICATE KEY UPDATE enabled = ?`, user.UserNo, req.DirFileKey, *req.Enabled, *req.Enabled).Error
+}
+
+// Delete user's galleries that are created automatically for directories where automatic creation is disabled.
+//
+// Galleries detached from their directories are not deleted. The files are not deleted.
+func CleanAutoGalleries(rail miso.Rail, tx *gorm.DB, req CleanAutoGalleriesReq, user common.User) ([]VGalleryBrief, error) {
+	defEnabled, _, err := findAutoGalleryPref(tx, user.UserNo, "")
+	if err != nil {
+		return nil, err
+	}
+
+	galleries := []VGalleryBrief{}
+	err = tx.Raw(`SELECT g.gallery_no, g.name
+		FROM gallery g
+		LEFT JOIN gallery_auto_pref p ON p.user_no = g.user_no AND p.dir_file_key = g.dir_file_key
+		WHERE g.user_no = ? AND g.is_del = 0 AND g.auto_created = 1 AND g.dir_file_key != ''
+		AND COALESCE(p.enabled, ?) = 0
+		ORDER BY g.id ASC`, user.UserNo, defEnabled).
+		Scan(&galleries).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to list unwanted auto-created galleries, userNo: %v, %v", user.UserNo, err)
+	}
+	if req.DryRun {
+		return galleries, nil
+	}
+
+	for _, g := range galleries {
+		err := tx.Transaction(func(tx *gorm.DB) error {
+			t := tx.Exec(`UPDATE gallery SET is_del = 1, update_by = ? WHERE gallery_no = ? AND is_del = 0`, user.Username, g.GalleryNo)
+			if t.Error != nil {
+				return t.Error
+			}
+			if t.RowsAffected < 1 {
+				return nil
+			}
+			return RecordActivity(rail, tx, Activity{
+				RefType: ActRefGallery,
+				RefKey:  g.GalleryNo,
+				Action:  ActionDelete,
+				Detail:  fmt.Sprintf("Deleted auto-created gallery '%s'", g.Name),
+			}, user)
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to delete gallery, galleryNo: %v, %v", g.GalleryNo, err)
+		}
+		rail.Infof("Deleted auto-created gallery %v ('%v') of user %v", g.GalleryNo, g.Name, user.UserNo)
+	}
+	return galleries, nil
+}
@@ -8,6 +8,7 @@ import (
 	"github.com/curtisnewbie/miso/middleware/user-vault/common"
 	"github.com/curtisnewbie/miso/miso"
 	"github.com/curtisnewbie/miso/util"
+	vfmapi "github.com/curtisnewbie/vfm/api"
 	"gorm.io/gorm"
 )
 
@@ -41,6 +42,7 @@ type GalleryImage struct {
 	ImageNo    string
 	Name       string
 	FileKey    string
+	AddedByNo  string
 	Status     ImgStatus
 	CreateTime time.Time
 	CreateBy   string
@@ -78,16 +80,25 @@ type ListGalleryImagesResp struct {
 }
 
 type ImageInfo struct {
-	ThumbnailToken  string `json:"thumbnailToken"`
-	FileTempToken   string `json:"fileTempToken"`
-	ImageFileId     string `json:"-"`
-	ThumbnailFileId string `json:"-"`
+	ImageNo         string             `json:"imageNo"`
+	ThumbnailToken  string             `json:"thumbnailToken"`
+	FileTempToken   string             `json:"fileTempToken"`
+	Variants        []ThumbnailVariant `json:"variants" desc:"thumbnail variants in different sizes, ordered by width"`
+	Metadata        *ImageMetadata     `json:"metadata" desc:"metadata extracted from EXIF/XMP, null if not yet extracted"`
+	AddedBy         string             `json:"addedBy" desc:"username of the user who added the image"`
+	AddedByNo       string             `json:"addedByNo" desc:"user_no of the user who added the image"`
+	FileKey         string             `json:"-"`
+	ImageFileId     string             `json:"-"`
+	ThumbnailFileId string             `json:"-"`
 }
 
 type CreateGalleryImageCmd struct {
 	GalleryNo string `json:"galleryNo"`
 	Name      string `json:"name"`
 	FileKey   string `json:"fileKey"`
+
+	// added by directory gallery sync, these are removed when the file is moved out of the directory
+	DirSynced bool `json:"-"`
 }
 
 func DeleteGalleryImage(rail miso.Rail, tx *gorm.DB, fileKey string) error {
@@ -95,13 +106,25 @@ func DeleteGalleryImage(rail miso.Rail, tx *gorm.DB, fileKey string) error {
 }
 
 // Create a gallery image record
+//
+// Both the owner and the contributors can add images, contributors can only add images that they own.
 func CreateGalleryImage(rail miso.Rail, cmd CreateGalleryImageCmd, userNo string, username string, tx *gorm.DB) error {
-	creator, err := FindGalleryCreator(rail, cmd.GalleryNo, tx)
+	role, err := FindGalleryRole(rail, tx, userNo, cmd.GalleryNo)
 	if err != nil {
 		return err
 	}
 
-	if *creator != userNo {
+	switch role {
+	case GalleryRoleOwner:
+	case GalleryRoleContributor:
+		f, err := findFile(rail, tx, cmd.FileKey)
+		if err != nil {
+			return err
+		}
+		if f == nil || f.UploaderNo != userNo {
+			return miso.NewErrf("Only file's owner can add it to this gallery ('%s')", cmd.Name)
+		}
+	default:
 		return miso.NewErrf("You are not allowed to upload image to this gallery")
 	}
 
@@ -120,13 +143,35 @@ func CreateGalleryImage(rail miso.Rail, cmd CreateGalleryImageCmd, userNo string
 	}
 
 	imageNo := util.GenNoL("IMG", 25)
-	return tx.Transaction(func(tx *gorm.DB) error {
-		if err := tx.Exec(`insert into gallery_image (gallery_no, image_no, name, file_key, create_by) values (?, ?, ?, ?, ?)`,
-			cmd.GalleryNo, imageNo, cmd.Name, cmd.FileKey, username).Error; err != nil {
+	err = tx.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Exec(`insert into gallery_image (gallery_no, image_no, name, file_key, added_by_no, dir_synced, create_by) values (?, ?, ?, ?, ?, ?, ?)`,
+			cmd.GalleryNo, imageNo, cmd.Name, cmd.FileKey, userNo, cmd.DirSynced, username).Error; err != nil {
+			return err
+		}
+		if err := tx.Exec(`update gallery set update_time = ? where gallery_no = ?`, util.Now(), cmd.GalleryNo).Error; err != nil {
 			return err
 		}
-		return tx.Exec(`update gallery set update_time = ? where gallery_no = ?`, util.Now(), cmd.GalleryNo).Error
+		return RecordActivity(rail, tx, Activity{
+			RefType: ActRefGallery,
+			RefKey:  cmd.GalleryNo,
+			FileKey: cmd.FileKey,
+			Action:  ActionAddImage,
+			Detail:  fmt.Sprintf("Added image '%s' to gallery", cmd.Name),
+		}, common.User{UserNo: userNo, Username: username})
 	})
+	if err != nil {
+		return err
+	}
+
+	publishGalleryImageAdded(rail, vfmapi.GalleryImageAddedEvent{
+		GalleryNo:        cmd.GalleryNo,
+		ImageNo:          imageNo,
+		ImageName:        cmd.Name,
+		FileKey:          cmd.FileKey,
+		OperatorUserNo:   userNo,
+		OperatorUsername: username,
+	})
+	return nil
 }
 
 type FstoreTmpToken struct {
@@ -172,8 +217,14 @@ func ListGalleryImages(rail miso.Rail, tx *gorm.DB, cmd ListGalleryImagesCmd, us
 		return nil, miso.NewErrf("You are not allowed to access this gallery")
 	}
 
+	// images reordered by the owner come first, the rest are sorted by capture time, those without capture time are put
+	// at the end in insertion order
 	var galleryImages []GalleryImage
-	t := tx.Raw(`select image_no, file_key from gallery_image where gallery_no = ? order by id desc limit ?, ?`,
+	t := tx.Raw(`select gi.image_no, gi.file_key, gi.added_by_no, gi.create_by from gallery_image gi
+		left join image_metadata im on gi.file_key = im.file_key
+		where gi.gallery_no = ?
+		order by gi.position is null, gi.position asc, im.capture_time is null, im.capture_time asc, gi.id asc
+		limit ?, ?`,
 		cmd.GalleryNo, cmd.Paging.GetOffset(), cmd.Paging.GetLimit()).Scan(&galleryImages)
 	if t.Error != nil {
 		return nil, fmt.Errorf("select gallery_image failed, %v", t.Error)
@@ -213,7 +264,21 @@ func ListGalleryImages(rail miso.Rail, tx *gorm.DB, cmd ListGalleryImagesCmd, us
 			} else {
 				GenFstoreTknBatch(rail, awaitFutures, thumbnailFileId, fi.Name)
 			}
-			images = append(images, ImageInfo{ImageFileId: fi.FstoreFileId, ThumbnailFileId: thumbnailFileId})
+			images = append(images, ImageInfo{ImageNo: img.ImageNo, FileKey: fi.Uuid, ImageFileId: fi.FstoreFileId, ThumbnailFileId: thumbnailFileId,
+				AddedBy: img.CreateBy, AddedByNo: img.AddedByNo})
+		}
+
+		fileKeys := make([]string, 0, len(images))
+		for _, im := range images {
+			fileKeys = append(fileKeys, im.FileKey)
+		}
+		variants, err := findThumbnailVariantsWithTokens(rail, tx, fileKeys)
+		if err != nil {
+			return nil, err
+		}
+		metas, err := findImageMetadata(tx, fileKeys)
+		if err != nil {
+			return nil, err
 		}
 
 		genTknFutures := awaitFutures.Await()
@@ -234,6 +299,10 @@ func ListGalleryImages(rail miso.Rail, tx *gorm.DB, cmd ListGalleryImagesCmd, us
 		for i, im := range images {
 			im.ThumbnailToken = idTknMap[im.ThumbnailFileId]
 			im.FileTempToken = idTknMap[im.ImageFileId]
+			im.Variants = variants[im.FileKey]
+			if m, ok := metas[im.FileKey]; ok {
+				im.Metadata = &m
+			}
 			images[i] = im
 		}
 	}
@@ -251,9 +320,24 @@ func BatchTransferAsync(rail miso.Rail, cmd TransferGalleryImageReq, user common
 		return nil, nil
 	}
 
+	// only the owner and the contributors can add images
+	galleryNos := util.NewSet[string]()
+	for _, img := range cmd.Images {
+		if !galleryNos.Add(img.GalleryNo) {
+			continue
+		}
+		role, err := FindGalleryRole(rail, tx, user.UserNo, img.GalleryNo)
+		if err != nil {
+			return nil, err
+		}
+		if role != GalleryRoleOwner && role != GalleryRoleContributor {
+			return nil, miso.NewErrf("You are not allowed to upload image to this gallery")
+		}
+	}
+
 	// validate the keys first
 	for _, img := range cmd.Images {
-		if isValid, e := ValidateFileOwner(rail, tx, ValidateFileOwnerReq{
+		if isValid, e := ValidateFileOwner(rail, tx, vfmapi.ValidateFileOwnerReq{
 			FileKey: img.FileKey,
 			UserNo:  user.UserNo,
 		}); e != nil || !isValid {
@@ -265,40 +349,77 @@ func BatchTransferAsync(rail miso.Rail, cmd TransferGalleryImageReq, user common
 	}
 
 	// start transferring
-	go func(rail miso.Rail, images []CreateGalleryImageCmd) {
-		for _, cmd := range images {
-			fi, er := findFile(rail, tx, cmd.FileKey)
-			if er != nil || fi == nil {
-				rail.Errorf("Failed to fetch file info while transferring selected images, fi's fileKey: %s, error: %v", cmd.FileKey, er)
-				continue
-			}
+	_, err := SubmitJob(rail, tx, SubmitJobReq{
+		JobType: JobTypeTransferGalleryImages,
+		Param:   TransferGalleryImagesJobParam{Images: cmd.Images},
+	}, user)
+	return nil, err
+}
 
-			if fi.FileType == FileTypeFile { // a file
-				if fi.FstoreFileId == "" {
-					continue // doesn't have fstore fileId, cannot be transferred
-				}
+type TransferGalleryImagesJobParam struct {
+	Images []CreateGalleryImageCmd
+}
 
-				if GuessIsImage(rail, *fi) {
-					nc := CreateGalleryImageCmd{GalleryNo: cmd.GalleryNo, Name: fi.Name, FileKey: fi.Uuid}
-					if err := CreateGalleryImage(rail, nc, user.UserNo, user.Username, tx); err != nil {
-						rail.Errorf("Failed to create gallery image, fi's fileKey: %s, error: %v", cmd.FileKey, err)
-						continue
-					}
-				}
-			} else { // a directory
-				treq := TransferGalleryImageInDirReq{
-					GalleryNo: cmd.GalleryNo,
-					FileKey:   cmd.FileKey,
-				}
-				if err := TransferImagesInDir(rail, treq, user, tx); err != nil {
-					rail.Errorf("Failed to transfer images in directory, fi's fileKey: %s, error: %v", cmd.FileKey, err)
-					continue
-				}
+type transferGalleryImagesCheckpoint struct {
+	Next int // index of the next image
+}
+
+func runTransferGalleryImagesJob(rail miso.Rail, tx *gorm.DB, jc *JobCtx) error {
+	var p TransferGalleryImagesJobParam
+	if err := jc.Param(&p); err != nil {
+		return err
+	}
+	var cp transferGalleryImagesCheckpoint
+	if _, err := jc.LoadCheckpoint(&cp); err != nil {
+		return err
+	}
+	jc.Total = len(p.Images)
+	user := jc.User
+
+	for i := cp.Next; i < len(p.Images); i++ {
+		cmd := p.Images[i]
+		if err := transferGalleryImage(rail, tx, cmd, user); err != nil {
+			rail.Errorf("Failed to transfer gallery image, fileKey: %s, error: %v", cmd.FileKey, err)
+			jc.Failed += 1
+		}
+		jc.Processed += 1
+		if err := jc.Checkpoint(transferGalleryImagesCheckpoint{Next: i + 1}); err != nil {
+			return err
+		}
+	}
+	jc.ResultMsg = fmt.Sprintf("%d files are transferred to gallery, %d failed", jc.Processed-jc.Failed, jc.Failed)
+	return nil
+}
+
+func transferGalleryImage(rail miso.Rail, tx *gorm.DB, cmd CreateGalleryImageCmd, user common.User) error {
+	fi, er := findFile(rail, tx, cmd.FileKey)
+	if er != nil || fi == nil {
+		return fmt.Errorf("failed to fetch file info while transferring selected images, fi's fileKey: %s, error: %v", cmd.FileKey, er)
+	}
+
+	if fi.FileType == FileTypeFile { // a file
+		if fi.FstoreFileId == "" {
+			return nil // doesn't have fstore fileId, cannot be transferred
+		}
+
+		if GuessIsImage(rail, *fi) {
+			nc := CreateGalleryImageCmd{GalleryNo: cmd.GalleryNo, Name: fi.Name, FileKey: fi.Uuid}
+			if err := CreateGalleryImage(rail, nc, user.UserNo, user.Username, tx); err != nil {
+				return fmt.Errorf("failed to create gallery image, fi's fileKey: %s, error: %v", cmd.FileKey, err)
 			}
 		}
-	}(rail, cmd.Images)
+		return nil
+	}
 
-	return nil, nil
+	// a directory
+	treq := TransferGalleryImageInDirReq{
+		GalleryNo: cmd.GalleryNo,
+		FileKey:   cmd.FileKey,
+	}
+	if err := TransferImagesInDir(rail, treq, user, tx); err != nil {
+		return fmt.Errorf("failed to transfer images in directory, fi's fileKey: %s, error: %v", cmd.FileKey, err)
+	}
+	return nil
 }
 
 // Transfer images in dir
@@ -330,7 +451,7 @@ func TransferImagesInDir(rail miso.Rail, cmd TransferGalleryImageInDirReq, user
 	page := 1
 	for {
 		// dirFileKey, 100, page
-		res, err := ListFilesInDir(rail, tx, ListFilesInDirReq{
+		res, err := ListFilesInDir(rail, tx, vfmapi.ListFilesInDirReq{
 			FileKey: dirFileKey,
 			Limit:   100,
 			Page:    page,
@@ -379,7 +500,7 @@ func GuessIsImage(rail miso.Rail, f FileInfo) bool {
 		rail.Infof("File doesn't have thumbnail, fileKey: %v", f.Uuid)
 		return false
 	}
-	return isImage(f.Name)
+	return f.IsImage()
 }
 
 // check whether the gallery image is created already
@@ -404,3 +525,129 @@ func isImgCreatedAlready(rail miso.Rail, tx *gorm.DB, galleryNo string, fileKey
 func NewGalleryFileLock(rail miso.Rail, galleryNo string, fileKey string) *redis.RLock {
 	return redis.NewRLockf(rail, "gallery:image:%v:%v", galleryNo, fileKey)
 }
+
+type RemoveGalleryImagesReq struct {
+	GalleryNo string   `json:"galleryNo" validation:"notEmpty"`
+	ImageNos  []string `json:"imageNos" validation:"notEmpty" desc:"image_no of the images to be removed"`
+}
+
+// Remove images from the gallery, the files are not deleted.
+//
+// The owner can remove any image, contributors can only remove images added by themselves.
+func RemoveGalleryImages(rail miso.Rail, db *gorm.DB, req RemoveGalleryImagesReq, user common.User) error {
+	role, err := FindGalleryRole(rail, db, user.UserNo, req.GalleryNo)
+	if err != nil {
+		return err
+	}
+	if role != GalleryRoleOwner && role != GalleryRoleContributor {
+		return miso.NewErrf("You are not allowed to remove images from this gallery")
+	}
+
+	var images []GalleryImage
+	if err := db.Raw(`SELECT image_no, name, file_key, added_by_no FROM gallery_image WHERE gallery_no = ? AND image_no IN ?`,
+		req.GalleryNo, req.ImageNos).Scan(&images).Error; err != nil {
+		return fmt.Errorf("failed to query gallery_image, %v", err)
+	}
+	if len(images) < 1 {
+		return nil
+	}
+	if role == GalleryRoleContributor {
+		for _, img := range images {
+			if img.AddedByNo != user.UserNo {
+				return miso.NewErrf("You are not allowed to remove image '%s', it's not added by you", img.Name)
+			}
+		}
+	}
+
+	evt := vfmapi.GalleryImageRemovedEvent{
+		GalleryNo:        req.GalleryNo,
+		OperatorUserNo:   user.UserNo,
+		OperatorUsername: user.Username,
+	}
+	err = db.Transaction(func(tx *gorm.DB) error {
+		for _, img := range images {
+			if err := tx.Exec(`DELETE FROM gallery_image WHERE gallery_no = ? AND image_no = ?`, req.GalleryNo, img.ImageNo).Error; err != nil {
+				return err
+			}
+			if err := RecordActivity(rail, tx, Activity{
+				RefType: ActRefGallery,
+				RefKey:  req.GalleryNo,
+				FileKey: img.FileKey,
+				Action:  ActionRemoveImage,
+				Detail:  fmt.Sprintf("Removed image '%s' from gallery", img.Name),
+			}, user); err != nil {
+				return err
+			}
+			evt.ImageNos = append(evt.ImageNos, img.ImageNo)
+			evt.FileKeys = append(evt.FileKeys, img.FileKey)
+		}
+		if err := tx.Exec(`UPDATE gallery SET cover_image_no = '' WHERE gallery_no = ? AND cover_image_no IN ?`,
+			req.GalleryNo, evt.ImageNos).Error; err != nil {
+			return err
+		}
+		return tx.Exec(`UPDATE gallery SET update_time = ? WHERE gallery_no = ?`, util.Now(), req.GalleryNo).Error
+	})
+	if err != nil {
+		return fmt.Errorf("failed to remove gallery images, galleryNo: %v, %v", req.GalleryNo, err)
+	}
+
+	publishGalleryImageRemoved(rail, evt)
+	return nil
+}
+
+const (
+	// max number of images that can be reordered in one request.
+	maxReorderGalleryImages = 2000
+)
+
+type ReorderGalleryImagesReq struct {
+	GalleryNo string   `json:"galleryNo" validation:"notEmpty"`
+	ImageNos  []string `json:"imageNos" desc:"image_no in the desired order, images not listed are placed after them by capture time, empty to reset to capture time order"`
+}
+
+// Reorder images in gallery, the listed images are given explicit positions, positions of the others are reset.
+func ReorderGalleryImages(rail miso.Rail, db *gorm.DB, req ReorderGalleryImagesReq, user common.User) error {
+	if len(req.ImageNos) > maxReorderGalleryImages {
+		return miso.NewErrf("At most %v images can be reordered at a time", maxReorderGalleryImages)
+	}
+	gallery, err := FindGallery(rail, db, req.GalleryNo)
+	if err != nil {
+		return err
+	}
+	if gallery.UserNo != user.UserNo {
+		return miso.NewErrf("You are not allowed to reorder images in this gallery")
+	}
+
+	seen := util.NewSet[string]()
+	for _, no := range req.ImageNos {
+		if !seen.Add(no) {
+			return miso.NewErrf("Image '%s' is listed more than once", no)
+		}
+	}
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Exec(`UPDATE gallery_image SET position = NULL WHERE gallery_no = ? AND position IS NOT NULL`, req.GalleryNo).Error; err != nil {
+			return fmt.Errorf("failed to reset gallery_image.position, %v", err)
+		}
+		for i, no := range req.ImageNos {
+			t := tx.Exec(`UPDATE gallery_image SET position = ? WHERE gallery_no = ? AND image_no = ?`, i+1, req.GalleryNo, no)
+			if t.Error != nil {
+				return fmt.Errorf("failed to update gallery_image.position, %v", t.Error)
+			}
+			if t.RowsAffected < 1 {
+				return miso.NewErrf("Image '%s' is not found in gallery", no)
+			}
+		}
+
+		detail := "Reordered images"
+		if len(req.ImageNos) < 1 {
+			detail = "Reset image order to capture time"
+		}
+		return RecordActivity(rail, tx, Activity{
+			RefType: ActRefGallery,
+			RefKey:  req.GalleryNo,
+			Action:  ActionUpdate,
+			Detail:  detail,
+		}, user)
+	})
+}
@@ -12,11 +12,18 @@ import (
 	"gorm.io/gorm"
 )
 
+const (
+	GalleryRoleOwner       = "OWNER"       // not stored in gallery_user_access, only used in responses
+	GalleryRoleContributor = "CONTRIBUTOR" // can view the gallery, add images owned by the user and remove them
+	GalleryRoleViewer      = "VIEWER"      // can only view the gallery
+)
+
 // User's access to a Gallery
 type GalleryUserAccess struct {
 	ID         int64
 	GalleryNo  string
 	UserNo     string
+	Role       string
 	CreateTime time.Time
 	CreateBy   string
 	UpdateTime time.Time
@@ -24,14 +31,6 @@ type GalleryUserAccess struct {
 	IsDel      bool
 }
 
-type UpdateGUAIsDelCmd struct {
-	GalleryNo string
-	UserNo    string
-	IsDelFrom bool
-	IsDelTo   bool
-	UpdateBy  string
-}
-
 func (GalleryUserAccess) TableName() string {
 	return "gallery_user_access"
 }
@@ -61,8 +60,28 @@ func HasAccessToGallery(rail miso.Rail, tx *gorm.DB, userNo string, galleryNo st
 	return true, nil
 }
 
-// Assign user access to the gallery
-func CreateGalleryAccess(rail miso.Rail, tx *gorm.DB, userNo string, galleryNo string, operator string) error {
+// Find user's role in the gallery, empty string is returned if the user has no access to it.
+func FindGalleryRole(rail miso.Rail, tx *gorm.DB, userNo string, galleryNo string) (string, error) {
+	gallery, e := FindGallery(rail, tx, galleryNo)
+	if e != nil {
+		return "", e
+	}
+	if gallery.UserNo == userNo {
+		return GalleryRoleOwner, nil
+	}
+
+	userAccess, err := findGalleryAccess(rail, tx, userNo, galleryNo)
+	if err != nil {
+		return "", err
+	}
+	if userAccess == nil || userAccess.IsDel {
+		return "", nil
+	}
+	return userAccess.Role, nil
+}
+
+// Assign user access to the gallery, role of the existing access is updated
+func CreateGalleryAccess(rail miso.Rail, tx *gorm.DB, userNo string, galleryNo string, role string, operator string) error {
 
 	// check if the user has access to the gallery
 	userAccess, err := findGalleryAccess(rail, tx, userNo, galleryNo)
@@ -71,23 +90,13 @@ func CreateGalleryAccess(rail miso.Rail, tx *gorm.DB, userNo string, galleryNo s
 	}
 
 	if userAccess != nil && !userAccess.IsDel {
-		return nil
-	}
-
-	var e error
-	if userAccess == nil {
-		e = createUserAccess(rail, tx, userNo, galleryNo, operator)
-	} else {
-		e = updateUserAccessIsDelFlag(rail, tx, &UpdateGUAIsDelCmd{
-			UserNo:    userNo,
-			GalleryNo: galleryNo,
-			IsDelFrom: false,
-			IsDelTo:   true,
-			UpdateBy:  operator,
-		})
+		if userAccess.Role == role {
+			return nil
+		}
+		return tx.Exec(`UPDATE gallery_user_access SET role = ?, update_by = ? WHERE id = ?`, role, operator, userAccess.ID).Error
 	}
 
-	return e
+	return createUserAccess(rail, tx, userNo, galleryNo, role, operator)
 }
 
 /* find GalleryUserAccess, is_del flag is ignored */
@@ -110,22 +119,10 @@ func findGalleryAccess(rail miso.Rail, tx *gorm.DB, userNo string, galleryNo str
 	return userAccess, nil
 }
 
-// Insert a new gallery_user_access record
-func createUserAccess(rail miso.Rail, tx *gorm.DB, userNo string, galleryNo string, createdBy string) error {
-	tx = tx.Exec(`INSERT INTO gallery_user_access (gallery_no, user_no, create_by) VALUES (?, ?, ?)`, galleryNo, userNo, createdBy)
-	if e := tx.Error; e != nil {
-		return e
-	}
-
-	return nil
-}
-
-// Update is_del of the record
-func updateUserAccessIsDelFlag(rail miso.Rail, tx *gorm.DB, cmd *UpdateGUAIsDelCmd) error {
-	tx = tx.Exec(`
-	UPDATE gallery_user_access SET is_del = ?, update_by = ?
-	WHERE gallery_no = ? AND user_no = ? AND is_del = ?`, cmd.IsDelTo, cmd.UpdateBy, cmd.GalleryNo, cmd.UserNo, cmd.IsDelFrom)
-
+// Insert a new gallery_user_access record, the removed one is restored if any
+func createUserAccess(rail miso.Rail, tx *gorm.DB, userNo string, galleryNo string, role string, createdBy string) error {
+	tx = tx.Exec(`INSERT INTO gallery_user_access (gallery_no, user_no, role, create_by) VALUES (?, ?, ?, ?)
+		ON DUPLICATE KEY UPDATE is_del = 0, role = ?, update_by = ?`, galleryNo, userNo, role, createdBy, role, createdBy)
 	if e := tx.Error; e != nil {
 		return e
 	}
@@ -148,12 +145,14 @@ type ListedGalleryAccessRes struct {
 	GalleryNo  string
 	UserNo     string
 	Username   string
+	Role       string
 	CreateTime util.ETime
 }
 
 type PermitGalleryAccessCmd struct {
 	GalleryNo string `validation:"notEmpty"`
 	Username  string `validation:"notEmpty"`
+	Role      string `desc:"role of the user: VIEWER (default), CONTRIBUTOR"`
 }
 
 func ListedGrantedGalleryAccess(rail miso.Rail, tx *gorm.DB, req ListGrantedGalleryAccessCmd, user common.User) (miso.PageRes[ListedGalleryAccessRes], error) {
@@ -168,7 +167,7 @@ func ListedGrantedGalleryAccess(rail miso.Rail, tx *gorm.DB, req ListGrantedGall
 	return mysql.NewPageQuery[ListedGalleryAccessRes]().
 		WithPage(req.Paging).
 		WithSelectQuery(func(tx *gorm.DB) *gorm.DB {
-			return tx.Select("id", "gallery_no", "user_no", "create_time").
+			return tx.Select("id", "gallery_no", "user_no", "role", "create_time").
 				Order("id DESC")
 		}).
 		WithBaseQuery(func(tx *gorm.DB) *gorm.DB {
@@ -205,11 +204,24 @@ func RemoveGalleryAccess(rail miso.Rail, tx *gorm.DB, cmd RemoveGalleryAccessCmd
 		return fmt.Errorf("failed to update gallery_user_access, galleryNo: %v, userNo: %v, %v", cmd.GalleryNo, cmd.UserNo, e)
 	}
 	rail.Infof("Gallery %v user access to %v is removed by %v", cmd.GalleryNo, cmd.UserNo, user.Username)
-	return nil
+	return RecordActivity(rail, tx, Activity{
+		RefType: ActRefGallery,
+		RefKey:  cmd.GalleryNo,
+		Action:  ActionRevokeAccess,
+		Detail:  fmt.Sprintf("Removed user %s's access to gallery '%s'", cmd.UserNo, gallery.Name),
+	}, user)
 }
 
 // Grant user's access to the gallery, only the owner can do so
 func GrantGalleryAccessToUser(rail miso.Rail, tx *gorm.DB, cmd PermitGalleryAccessCmd, user common.User) error {
+	switch cmd.Role {
+	case "":
+		cmd.Role = GalleryRoleViewer
+	case GalleryRoleViewer, GalleryRoleContributor:
+	default:
+		return miso.NewErrf("Illegal role, should be either VIEWER or CONTRIBUTOR")
+	}
+
 	gallery, e := FindGallery(rail, tx, cmd.GalleryNo)
 	if e != nil {
 		return e
@@ -230,5 +242,17 @@ func GrantGalleryAccessToUser(rail miso.Rail, tx *gorm.DB, cmd PermitGalleryAcce
 		return miso.NewErrf("You are not allowed to grant access to this gallery")
 	}
 
-	return CreateGalleryAccess(rail, tx, toUser.UserNo, cmd.GalleryNo, user.Username)
+	if toUser.UserNo == gallery.UserNo {
+		return miso.NewErrf("User is the owner of the gallery")
+	}
+
+	if err := CreateGalleryAccess(rail, tx, toUser.UserNo, cmd.GalleryNo, cmd.Role, user.Username); err != nil {
+		return err
+	}
+	return RecordActivity(rail, tx, Activity{
+		RefType: ActRefGallery,
+		RefKey:  cmd.GalleryNo,
+		Action:  ActionGrantAccess,
+		Detail:  fmt.Sprintf("Granted %s %s access to gallery '%s'", toUser.Username, cmd.Role, gallery.Name),
+	}, user)
 }
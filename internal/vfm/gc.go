@@ -0,0 +1,316 @@
+package vfm
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	fstore "github.com/curtisnewbie/mini-fstore/api"
+	"github.com/curtisnewbie/miso/middleware/mysql"
+	"github.com/curtisnewbie/miso/middleware/redis"
+	"github.com/curtisnewbie/miso/miso"
+	"github.com/curtisnewbie/miso/util"
+	"gorm.io/gorm"
+)
+
+const (
+	PropGcRetentionDays = "vfm.gc.physic-delete.retention-days"
+	PropGcBatchSize     = "vfm.gc.physic-delete.batch-size"
+
+	// status of gc_run and reconcile_run
+	RunStatusRunning  = "RUNNING"
+	RunStatusFinished = "FINISHED"
+	RunStatusFailed   = "FAILED"
+
+	// max number of files checked and returned in dry-run report
+	maxGcReportItems = 1000
+)
+
+func init() {
+	miso.SetDefProp(PropGcRetentionDays, 30)
+	miso.SetDefProp(PropGcBatchSize, 200)
+}
+
+type PhysicDeleteGcReport struct {
+	RunNo       string               `json:"runNo"`
+	DryRun      bool                 `json:"dryRun"`
+	Scanned     int                  `json:"scanned" desc:"number of logically deleted files scanned, for dry-run, it's the number of files that are expired"`
+	Deleted     int                  `json:"deleted" desc:"number of files marked physically deleted (or would be for dry-run, only files in items are counted)"`
+	Unconfirmed int                  `json:"unconfirmed" desc:"number of files whose mini-fstore files are not yet deleted (only files in items are counted for dry-run)"`
+	Items       []PhysicDeleteGcItem `json:"items" desc:"files checked, only returned for dry-run (at most 1000)"`
+}
+
+type PhysicDeleteGcItem struct {
+	FileKey         string     `json:"fileKey"`
+	Name            string     `json:"name"`
+	FileType        string     `json:"fileType"`
+	LogicDeleteTime util.ETime `json:"logicDeleteTime"`
+	Confirmed       bool       `json:"confirmed" desc:"whether mini-fstore files are confirmed deleted"`
+}
+
+type gcFileInf struct {
+	Id              int
+	Uuid            string
+	Name            string
+	FileType        string
+	FstoreFileId    string
+	Thumbnail       string
+	LogicDeleteTime util.ETime
+}
+
+// Scheduled physical deletion GC.
+func PhysicDeleteGcTask(rail miso.Rail) error {
+	_, err := RunPhysicDeleteGc(rail, mysql.GetMySQL(), false)
+	return err
+}
+
+// Trigger physical deletion GC asynchronously, returns the runNo.
+func TriggerPhysicDeleteGc(rail miso.Rail, db *gorm.DB) (string, error) {
+	lock := newPhysicDeleteGcLock(rail)
+	if err := lock.Lock(); err != nil {
+		return "", miso.NewErrf("GC is running, please try again later").WithInternalMsg("%v", err)
+	}
+	runNo, err := startGcRun(db, false)
+	if err != nil {
+		lock.Unlock()
+		return "", err
+	}
+	vfmPool.Go(func() {
+		defer lock.Unlock()
+		rail := rail.NextSpan()
+		if _, err := doPhysicDeleteGc(rail, db, runNo, false); err != nil {
+			rail.Errorf("Physic delete GC failed, runNo: %v, %v", runNo, err)
+		}
+	})
+	return runNo, nil
+}
+
+// Run physical deletion GC.
+//
+// Files that are logically deleted longer than the retention period are scanned, if their mini-fstore files are confirmed
+// deleted, they are marked physically deleted and their file_vfolder, gallery_image, versioned_file_log,
+// file_thumbnail_variant and image_metadata references are removed.
+//
+// With dryRun, nothing is changed, the report contains the files that would be processed. Only the first 1000 files are
+// checked with mini-fstore, the rest are only counted.
+func RunPhysicDeleteGc(rail miso.Rail, db *gorm.DB, dryRun bool) (PhysicDeleteGcReport, error) {
+	lock := newPhysicDeleteGcLock(rail)
+	if err := lock.Lock(); err != nil {
+		return PhysicDeleteGcReport{}, miso.NewErrf("GC is running, please try again later").WithInternalMsg("%v", err)
+	}
+	defer lock.Unlock()
+
+	runNo, err := startGcRun(db, dryRun)
+	if err != nil {
+		return PhysicDeleteGcReport{}, err
+	}
+	return doPhysicDeleteGc(rail, db, runNo, dryRun)
+}
+
+func newPhysicDeleteGcLock(rail miso.Rail) *redis.RLock {
+	return redis.NewRLock(rail, "vfm:gc:physic-delete")
+}
+
+func startGcRun(db *gorm.DB, dryRun bool) (string, error) {
+	runNo := util.GenIdP("gc_")
+	err := db.Exec(`INSERT INTO gc_run (run_no, dry_run, status, start_time) VALUES (?,?,?,?)`,
+		runNo, dryRun, RunStatusRunning, util.Now()).Error
+	if err != nil {
+		return "", fmt.Errorf("failed to save gc_run, %v", err)
+	}
+	return runNo, nil
+}
+
+func doPhysicDeleteGc(rail miso.Rail, db *gorm.DB, runNo string, dryRun bool) (PhysicDeleteGcReport, error) {
+	rail.Infof("Physic delete GC start, runNo: %v, dryRun: %v", runNo, dryRun)
+	defer miso.TimeOp(rail, time.Now(), "PhysicDeleteGc")
+
+	report, err := scanPhysicDeleteGc(rail, db, dryRun)
+	report.RunNo = runNo
+	report.DryRun = dryRun
+
+	status := RunStatusFinished
+	errMsg := ""
+	if err != nil {
+		status = RunStatusFailed
+		errMsg = util.MaxLenStr(err.Error(), 1000)
+	}
+	if e := db.Exec(`UPDATE gc_run SET status = ?, scanned = ?, deleted = ?, unconfirmed = ?, err_msg = ?, end_time = ?
+		WHERE run_no = ?`, status, report.Scanned, report.Deleted, report.Unconfirmed, errMsg, util.Now(), runNo).Error; e != nil {
+		rail.Errorf("failed to update gc_run, runNo: %v, %v", runNo, e)
+	}
+	rail.Infof("Physic delete GC end, report: %+v", report)
+	return report, err
+}
+
+func scanPhysicDeleteGc(rail miso.Rail, db *gorm.DB, dryRun bool) (PhysicDeleteGcReport, error) {
+	report := PhysicDeleteGcReport{Items: []PhysicDeleteGcItem{}}
+	deadline := time.Now().AddDate(0, 0, -miso.GetPropInt(PropGcRetentionDays))
+	limit := miso.GetPropInt(PropGcBatchSize)
+	minId := 0
+
+	for {
+		var files []gcFileInf
+		err := db.Raw(`SELECT id, uuid, name, file_type, fstore_file_id, thumbnail, logic_delete_time
+			FROM file_info
+			WHERE id > ?
+			AND is_logic_deleted = 1
+			AND is_physic_deleted = 0
+			AND logic_delete_time < ?
+			ORDER BY id ASC
+			LIMIT ?`, minId, deadline, limit).
+			Scan(&files).Error
+		if err != nil {
+			return report, fmt.Errorf("failed to list logically deleted files, minId: %v, %v", minId, err)
+		}
+		if len(files) < 1 {
+			return report, nil // the end
+		}
+
+		for _, f := range files {
+			if dryRun && len(report.Items) >= maxGcReportItems {
+				total, err := countPhysicDeleteGc(db, deadline)
+				if err != nil {
+					return report, err
+				}
+				report.Scanned = total
+				return report, nil
+			}
+			report.Scanned += 1
+
+			confirmed, err := confirmFstoreFileDeleted(rail, f.FstoreFileId)
+			if err != nil {
+				return report, err
+			}
+			if confirmed {
+				if confirmed, err = confirmFstoreFileDeleted(rail, f.Thumbnail); err != nil {
+					return report, err
+				}
+			}
+
+			if dryRun && len(report.Items) < maxGcReportItems {
+				report.Items = append(report.Items, PhysicDeleteGcItem{
+					FileKey:         f.Uuid,
+					Name:            f.Name,
+					FileType:        f.FileType,
+					LogicDeleteTime: f.LogicDeleteTime,
+					Confirmed:       confirmed,
+				})
+			}
+
+			if !confirmed {
+				report.Unconfirmed += 1
+				rail.Infof("mini-fstore file is not yet deleted, fileKey: %v, fileId: %v", f.Uuid, f.FstoreFileId)
+				continue
+			}
+
+			if dryRun {
+				report.Deleted += 1
+				continue
+			}
+
+			marked, err := markPhysicDeleted(rail, db, f)
+			if err != nil {
+				return report, err
+			}
+			if marked {
+				report.Deleted += 1
+			}
+		}
+
+		minId = files[len(files)-1].Id
+		rail.Infof("Physic delete GC, minId: %v, scanned: %v, deleted: %v", minId, report.Scanned, report.Deleted)
+	}
+}
+
+// Count files that are logically deleted before the deadline and not yet physically deleted.
+func countPhysicDeleteGc(db *gorm.DB, deadline time.Time) (int, error) {
+	var total int
+	err := db.Raw(`SELECT COUNT(*) FROM file_info
+		WHERE is_logic_deleted = 1 AND is_physic_deleted = 0 AND logic_delete_time < ?`, deadline).
+		Scan(&total).Error
+	if err != nil {
+		return 0, fmt.Errorf("failed to count logically deleted files, %v", err)
+	}
+	return total, nil
+}
+
+// Check whether the mini-fstore file is deleted, empty fileId is treated as deleted.
+func confirmFstoreFileDeleted(rail miso.Rail, fileId string) (bool, error) {
+	if fileId == "" {
+		return true, nil
+	}
+	ff, err := fstore.FetchFileInfo(rail, fstore.FetchFileInfoReq{FileId: fileId})
+	if err != nil {
+		if errors.Is(err, fstore.ErrFileNotFound) || errors.Is(err, fstore.ErrFileDeleted) {
+			return true, nil
+		}
+		return false, fmt.Errorf("failed to fetch mini-fstore file info, fileId: %v, %v", fileId, err)
+	}
+	return ff.Status != fstore.FileStatusNormal, nil
+}
+
+func markPhysicDeleted(rail miso.Rail, db *gorm.DB, f gcFileInf) (bool, error) {
+	marked := false
+	err := db.Transaction(func(tx *gorm.DB) error {
+		t := tx.Exec(`UPDATE file_info SET is_physic_deleted = ?, physic_delete_time = ?
+			WHERE id = ? AND is_logic_deleted = ? AND is_physic_deleted = ?`, PDelY, util.Now(), f.Id, LDelY, PDelN)
+		if t.Error != nil {
+			return fmt.Errorf("failed to update file_info, uuid: %v, %v", f.Uuid, t.Error)
+		}
+		if t.RowsAffected < 1 {
+			return nil
+		}
+		if err := tx.Exec(`DELETE FROM file_vfolder WHERE uuid = ?`, f.Uuid).Error; err != nil {
+			return fmt.Errorf("failed to delete file_vfolder, uuid: %v, %v", f.Uuid, err)
+		}
+		if err := DeleteGalleryImage(rail, tx, f.Uuid); err != nil {
+			return fmt.Errorf("failed to delete gallery_image, uuid: %v, %v", f.Uuid, err)
+		}
+		if err := tx.Exec(`DELETE FROM versioned_file_log WHERE file_key = ?`, f.Uuid).Error; err != nil {
+			return fmt.Errorf("failed to delete versioned_file_log, uuid: %v, %v", f.Uuid, err)
+		}
+		if err := tx.Exec(`DELETE FROM file_thumbnail_variant WHERE file_key = ?`, f.Uuid).Error; err != nil {
+			return fmt.Errorf("failed to delete file_thumbnail_variant, uuid: %v, %v", f.Uuid, err)
+		}
+		if err := tx.Exec(`DELETE FROM image_metadata WHERE file_key = ?`, f.Uuid).Error; err != nil {
+			return fmt.Errorf("failed to delete image_metadata, uuid: %v, %v", f.Uuid, err)
+		}
+		marked = true
+		return nil
+	})
+	if err == nil && marked {
+		rail.Infof("File %v marked physically deleted", f.Uuid)
+	}
+	return marked, err
+}
+
+type ListGcRunReq struct {
+	Paging miso.Paging `json:"paging"`
+}
+
+type GcRun struct {
+	RunNo       string      `json:"runNo"`
+	DryRun      bool        `json:"dryRun"`
+	Status      string      `json:"status" desc:"RUNNING, FINISHED, FAILED"`
+	Scanned     int         `json:"scanned"`
+	Deleted     int         `json:"deleted"`
+	Unconfirmed int         `json:"unconfirmed"`
+	ErrMsg      string      `json:"errMsg"`
+	StartTime   util.ETime  `json:"startTime"`
+	EndTime     *util.ETime `json:"endTime"`
+}
+
+// List physical deletion GC reports.
+func ListGcRuns(rail miso.Rail, db *gorm.DB, req ListGcRunReq) (miso.PageRes[GcRun], error) {
+	return mysql.NewPageQuery[GcRun]().
+		WithPage(req.Paging).
+		WithBaseQuery(func(tx *gorm.DB) *gorm.DB {
+			return tx.Table("gc_run")
+		}).
+		WithSelectQuery(func(tx *gorm.DB) *gorm.DB {
+			return tx.Select("run_no, dry_run, status, scanned, deleted, unconfirmed, err_msg, start_time, end_time").
+				Order("id DESC")
+		}).
+		Exec(rail, db)
+}
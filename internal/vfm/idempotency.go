@@ -0,0 +1,145 @@
+package vfm
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	"github.com/curtisnewbie/miso/encoding"
+	"github.com/curtisnewbie/miso/middleware/mysql"
+	"github.com/curtisnewbie/miso/middleware/redis"
+	"github.com/curtisnewbie/miso/middleware/user-vault/common"
+	"github.com/curtisnewbie/miso/miso"
+	"github.com/curtisnewbie/miso/util"
+	"gorm.io/gorm"
+)
+
+const (
+	PropIdempotencyWindowHours = "vfm.idempotency.window-hours"
+
+	HeaderIdempotencyKey = "Idempotency-Key"
+
+	maxIdempotencyKeyLen = 128
+
+	// number of expired records deleted in each batch
+	idempotencyPurgeBatchSize = 500
+)
+
+func init() {
+	miso.SetDefProp(PropIdempotencyWindowHours, 24)
+}
+
+type idempotencyRecord struct {
+	Endpoint   string
+	ReqHash    string
+	Response   string
+	ExpireTime util.ETime
+}
+
+// Run f at most once for the same Idempotency-Key header within the configured window.
+//
+// The response of the first successful call is saved and replayed on retries, a reused key that comes with a different
+// endpoint or payload is rejected. Failed calls are not saved, they can be retried with the same key.
+//
+// f is simply called if the request doesn't have the Idempotency-Key header.
+func RunIdempotent[T any](inb *miso.Inbound, db *gorm.DB, req any, user common.User, f func() (T, error)) (T, error) {
+	_, r := inb.Unwrap()
+	key := r.Header.Get(HeaderIdempotencyKey)
+	if key == "" {
+		return f()
+	}
+
+	var res T
+	if len(key) > maxIdempotencyKeyLen {
+		return res, miso.NewErrf("Idempotency-Key is too long, at most %d characters", maxIdempotencyKeyLen)
+	}
+
+	rail := inb.Rail()
+	endpoint := r.URL.Path
+	reqHash, err := hashIdempotentReq(endpoint, req)
+	if err != nil {
+		return res, err
+	}
+
+	lock := redis.NewRLock(rail, "vfm:idempotency:"+user.UserNo+":"+key)
+	if err := lock.Lock(); err != nil {
+		return res, miso.NewErrf("Request with the same Idempotency-Key is being processed, please try again later").
+			WithInternalMsg("%v", err)
+	}
+	defer lock.Unlock()
+
+	var rec idempotencyRecord
+	t := db.Raw(`SELECT endpoint, req_hash, response, expire_time FROM idempotency_record
+		WHERE user_no = ? AND idem_key = ? AND expire_time > ?`, user.UserNo, key, util.Now()).Scan(&rec)
+	if t.Error != nil {
+		return res, fmt.Errorf("failed to find idempotency_record, key: %v, %v", key, t.Error)
+	}
+	if t.RowsAffected > 0 {
+		if rec.Endpoint != endpoint || rec.ReqHash != reqHash {
+			return res, miso.NewErrf("Idempotency-Key is already used by a different request").
+				WithInternalMsg("user: %v, key: %v, endpoint: %v, prev endpoint: %v", user.Username, key, endpoint, rec.Endpoint)
+		}
+		if err := encoding.ParseJson([]byte(rec.Response), &res); err != nil {
+			return res, fmt.Errorf("failed to parse saved response, key: %v, %v", key, err)
+		}
+		rail.Infof("Replayed response for Idempotency-Key %v, user: %v, endpoint: %v", key, user.Username, endpoint)
+		return res, nil
+	}
+
+	res, err = f()
+	if err != nil {
+		return res, err
+	}
+
+	resp, err := encoding.WriteJson(res)
+	if err != nil {
+		rail.Errorf("Failed to write response as json, key: %v, %v", key, err)
+		return res, nil
+	}
+	expireTime := time.Now().Add(time.Duration(miso.GetPropInt(PropIdempotencyWindowHours)) * time.Hour)
+	err = db.Exec(`INSERT INTO idempotency_record (user_no, idem_key, endpoint, req_hash, response, expire_time)
+		VALUES (?,?,?,?,?,?)
+		ON DUPLICATE KEY UPDATE endpoint = VALUES(endpoint), req_hash = VALUES(req_hash), response = VALUES(response),
+		expire_time = VALUES(expire_time)`, user.UserNo, key, endpoint, reqHash, string(resp), expireTime).Error
+	if err != nil {
+		// the request is already processed, the response is still returned
+		rail.Errorf("Failed to save idempotency_record, key: %v, %v", key, err)
+	}
+	return res, nil
+}
+
+func hashIdempotentReq(endpoint string, req any) (string, error) {
+	b, err := encoding.WriteJson(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to write request as json, %v", err)
+	}
+	h := sha256.New()
+	h.Write([]byte(endpoint))
+	h.Write(b)
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// Scheduled task that deletes expired idempotency records.
+func PurgeIdempotencyRecordTask(rail miso.Rail) error {
+	return PurgeIdempotencyRecords(rail, mysql.GetMySQL())
+}
+
+// Delete expired idempotency records.
+func PurgeIdempotencyRecords(rail miso.Rail, db *gorm.DB) error {
+	total := 0
+	for {
+		t := db.Exec(`DELETE FROM idempotency_record WHERE expire_time < ? LIMIT ?`, util.Now(), idempotencyPurgeBatchSize)
+		if t.Error != nil {
+			return fmt.Errorf("failed to delete expired idempotency_record, %v", t.Error)
+		}
+		total += int(t.RowsAffected)
+		if t.RowsAffected < idempotencyPurgeBatchSize {
+			break
+		}
+	}
+	if total > 0 {
+		rail.Infof("Deleted %d expired idempotency records", total)
+	}
+	return nil
+}
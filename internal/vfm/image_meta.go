@@ -0,0 +1,142 @@
+package vfm
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/curtisnewbie/miso/middleware/mysql"
+	"github.com/curtisnewbie/miso/middleware/rabbit"
+	"github.com/curtisnewbie/miso/miso"
+	"github.com/curtisnewbie/miso/util"
+	"gorm.io/gorm"
+)
+
+const (
+	// number of bytes read from the beginning of the image to extract the metadata,
+	// EXIF is at most 64kb in JPEG, XMP packet normally follows it.
+	imageMetaReadLen = 256 * 1024
+)
+
+var (
+	ExtractImageMetaPipeline = rabbit.NewEventPipeline[ExtractImageMetaEvent]("event.bus.vfm.image.metadata.extract").
+		LogPayload()
+)
+
+type ExtractImageMetaEvent struct {
+	FileKey string
+}
+
+// Image metadata extracted from EXIF/XMP.
+type ImageMetadata struct {
+	CaptureTime *util.ETime `json:"captureTime" desc:"when the photo is taken, null if unknown"`
+	Make        string      `json:"make" desc:"camera make"`
+	Model       string      `json:"model" desc:"camera model"`
+	Orientation int         `json:"orientation" desc:"EXIF orientation (1-8), 0 if unknown"`
+	Width       int         `json:"width" desc:"width in pixels, 0 if unknown"`
+	Height      int         `json:"height" desc:"height in pixels, 0 if unknown"`
+	Latitude    *float64    `json:"latitude" desc:"GPS latitude in degrees, null if unknown"`
+	Longitude   *float64    `json:"longitude" desc:"GPS longitude in degrees, null if unknown"`
+	FileKey     string      `json:"-"`
+}
+
+func triggerImageMetaExtraction(rail miso.Rail, fileKey string) error {
+	evt := ExtractImageMetaEvent{FileKey: fileKey}
+	if err := ExtractImageMetaPipeline.Send(rail, evt); err != nil {
+		return fmt.Errorf("failed to send %#v, %v", evt, err)
+	}
+	return nil
+}
+
+func OnExtractImageMetaEvent(rail miso.Rail, evt ExtractImageMetaEvent) error {
+	return ExtractImageMetadata(rail, mysql.GetMySQL(), evt.FileKey)
+}
+
+// Extract metadata of the image and save it in image_metadata.
+//
+// The record is created even if nothing is found, so that the image is not processed again by compensation.
+func ExtractImageMetadata(rail miso.Rail, db *gorm.DB, fileKey string) error {
+	f, err := findFile(rail, db, fileKey)
+	if err != nil {
+		return fmt.Errorf("failed to find file, uuid: %v, %v", fileKey, err)
+	}
+	if f == nil || f.IsLogicDeleted == LDelY || f.FileType != FileTypeFile || !f.IsImage() {
+		return nil
+	}
+
+	head, err := readFstoreFileHead(rail, f.FstoreFileId, f.Name, imageMetaReadLen)
+	if err != nil {
+		return err
+	}
+	m := extractImageMeta(head)
+	rail.Infof("Extracted image metadata, uuid: %v, %+v", fileKey, m)
+
+	var captureTime *time.Time
+	if m.CaptureTime != nil {
+		t := m.CaptureTime.In(time.Local)
+		captureTime = &t
+	}
+	err = db.Exec(`INSERT INTO image_metadata (file_key, capture_time, make, model, orientation, width, height, latitude, longitude)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON DUPLICATE KEY UPDATE capture_time = VALUES(capture_time), make = VALUES(make), model = VALUES(model),
+		orientation = VALUES(orientation), width = VALUES(width), height = VALUES(height),
+		latitude = VALUES(latitude), longitude = VALUES(longitude)`,
+		fileKey, captureTime, util.MaxLenStr(m.Make, 64), util.MaxLenStr(m.Model, 64), m.Orientation, m.Width, m.Height,
+		m.Latitude, m.Longitude).Error
+	if err != nil {
+		return fmt.Errorf("failed to save image_metadata, uuid: %v, %v", fileKey, err)
+	}
+	return nil
+}
+
+// Find metadata of the images, key is the file key.
+func findImageMetadata(tx *gorm.DB, fileKeys []string) (map[string]ImageMetadata, error) {
+	res := map[string]ImageMetadata{}
+	if len(fileKeys) < 1 {
+		return res, nil
+	}
+	var metas []ImageMetadata
+	err := tx.Raw(`SELECT file_key, capture_time, make, model, orientation, width, height, latitude, longitude
+		FROM image_metadata WHERE file_key IN ?`, fileKeys).
+		Scan(&metas).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to query image_metadata, %v", err)
+	}
+	for _, m := range metas {
+		res[m.FileKey] = m
+	}
+	return res, nil
+}
+
+// Compensate metadata extraction for images that don't have image_metadata.
+func CompensateImageMetadata(rail miso.Rail, db *gorm.DB) error {
+	rail.Info("CompensateImageMetadata start")
+	defer miso.TimeOp(rail, time.Now(), "CompensateImageMetadata")
+
+	minId := 0
+	for {
+		var files []FileInfo
+		err := db.Raw(`SELECT fi.id, fi.uuid
+			FROM file_info fi
+			WHERE fi.id > ?
+			AND fi.file_type = 'file'
+			AND fi.is_logic_deleted = 0
+			AND fi.mime_type LIKE 'image/%'
+			AND NOT EXISTS (SELECT 1 FROM image_metadata im WHERE im.file_key = fi.uuid)
+			ORDER BY fi.id ASC
+			LIMIT 500`, minId).
+			Scan(&files).Error
+		if err != nil {
+			return fmt.Errorf("failed to list images without metadata, minId: %v, %v", minId, err)
+		}
+		if len(files) < 1 {
+			return nil
+		}
+		for _, f := range files {
+			if err := triggerImageMetaExtraction(rail, f.Uuid); err != nil {
+				return err
+			}
+		}
+		minId = files[len(files)-1].Id
+		rail.Infof("CompensateImageMetadata, minId: %v", minId)
+	}
+}
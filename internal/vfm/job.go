@@ -0,0 +1,434 @@
+package vfm
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/curtisnewbie/miso/encoding"
+	"github.com/curtisnewbie/miso/middleware/mysql"
+	"github.com/curtisnewbie/miso/middleware/redis"
+	"github.com/curtisnewbie/miso/middleware/user-vault/common"
+	"github.com/curtisnewbie/miso/miso"
+	"github.com/curtisnewbie/miso/util"
+	"gorm.io/gorm"
+)
+
+const (
+	PropJobResumeAfter = "vfm.job.resume-after"
+
+	JobStatusPending   = "PENDING"
+	JobStatusRunning   = "RUNNING"
+	JobStatusSuccess   = "SUCCESS"
+	JobStatusFailed    = "FAILED"
+	JobStatusCancelled = "CANCELLED"
+
+	JobTypeTruncateDir           = "TRUNCATE_DIR"
+	JobTypeTransferGalleryImages = "TRANSFER_GALLERY_IMAGES"
+	JobTypeImportBookmarks       = "IMPORT_BOOKMARKS"
+	JobTypeUnpackZip             = "UNPACK_ZIP"
+
+	// max number of interrupted jobs resumed in each run
+	maxResumedJobs = 100
+)
+
+var (
+	// Returned by Checkpoint when the job is requested to cancel.
+	ErrJobCancelled = errors.New("job is cancelled")
+
+	// Returned by job that waits for external callback, the job stays RUNNING.
+	errJobWaiting = errors.New("job is waiting for external callback")
+
+	jobHandlers map[string]jobHandler
+)
+
+type jobHandler struct {
+	name        string // displayed in notifications
+	cancellable bool
+	run         func(rail miso.Rail, db *gorm.DB, jc *JobCtx) error
+}
+
+func init() {
+	miso.SetDefProp(PropJobResumeAfter, 60)
+
+	jobHandlers = map[string]jobHandler{
+		JobTypeTruncateDir:           {name: "Truncate directory", cancellable: true, run: runTruncateDirJob},
+		JobTypeTransferGalleryImages: {name: "Transfer images to gallery", cancellable: true, run: runTransferGalleryImagesJob},
+		JobTypeImportBookmarks:       {name: "Import bookmarks", cancellable: false, run: runImportBookmarksJob},
+		JobTypeUnpackZip:             {name: "Unpack zip file", cancellable: false, run: runUnpackZipJob},
+	}
+}
+
+type job struct {
+	JobNo      string
+	JobType    string
+	UserNo     string
+	Username   string
+	Status     string
+	RefType    string
+	RefKey     string
+	Param      string
+	Checkpoint string
+	Total      int
+	Processed  int
+	Failed     int
+	ResultMsg  string
+	CancelReq  bool
+	CreateTime util.ETime
+}
+
+// Context of the running job.
+type JobCtx struct {
+	JobNo     string
+	User      common.User
+	Total     int    // total number of items, 0 if unknown
+	Processed int    // number of items processed
+	Failed    int    // number of items failed
+	ResultMsg string // message displayed in notification when the job is finished
+
+	param      string
+	checkpoint string
+	createTime util.ETime
+	db         *gorm.DB
+}
+
+// Parse job's param.
+func (j *JobCtx) Param(v any) error {
+	if err := encoding.ParseJson([]byte(j.param), v); err != nil {
+		return fmt.Errorf("failed to parse job param, jobNo: %v, %v", j.JobNo, err)
+	}
+	return nil
+}
+
+// Load last saved checkpoint, returns false if there is none.
+func (j *JobCtx) LoadCheckpoint(v any) (bool, error) {
+	if j.checkpoint == "" {
+		return false, nil
+	}
+	if err := encoding.ParseJson([]byte(j.checkpoint), v); err != nil {
+		return false, fmt.Errorf("failed to parse job checkpoint, jobNo: %v, %v", j.JobNo, err)
+	}
+	return true, nil
+}
+
+// Save checkpoint and progress, the job is resumed from the checkpoint if it's interrupted.
+//
+// ErrJobCancelled is returned if the job is requested to cancel.
+func (j *JobCtx) Checkpoint(v any) error {
+	cp, err := encoding.WriteJson(v)
+	if err != nil {
+		return fmt.Errorf("failed to write job checkpoint, jobNo: %v, %v", j.JobNo, err)
+	}
+	j.checkpoint = string(cp)
+
+	err = j.db.Exec(`UPDATE job SET checkpoint = ?, total = ?, processed = ?, failed = ?, update_time = ? WHERE job_no = ?`,
+		j.checkpoint, j.Total, j.Processed, j.Failed, util.Now(), j.JobNo).Error
+	if err != nil {
+		return fmt.Errorf("failed to save job checkpoint, jobNo: %v, %v", j.JobNo, err)
+	}
+
+	var cancelReq bool
+	if err := j.db.Raw(`SELECT cancel_req FROM job WHERE job_no = ?`, j.JobNo).Scan(&cancelReq).Error; err != nil {
+		return fmt.Errorf("failed to find job, jobNo: %v, %v", j.JobNo, err)
+	}
+	if cancelReq {
+		return ErrJobCancelled
+	}
+	return nil
+}
+
+type SubmitJobReq struct {
+	JobType string
+	RefType string // type of the resource that the job operates on, see NotifiRefType*, optional
+	RefKey  string // key of the resource that the job operates on, optional
+	Param   any
+}
+
+// Save the job and run it asynchronously, returns the jobNo.
+func SubmitJob(rail miso.Rail, db *gorm.DB, req SubmitJobReq, user common.User) (string, error) {
+	jobNo, err := saveJob(rail, db, req, JobStatusPending, user)
+	if err != nil {
+		return "", err
+	}
+	startJob(rail, jobNo)
+	return jobNo, nil
+}
+
+func saveJob(rail miso.Rail, db *gorm.DB, req SubmitJobReq, status string, user common.User) (string, error) {
+	if _, ok := jobHandlers[req.JobType]; !ok {
+		return "", fmt.Errorf("unknown job type: %v", req.JobType)
+	}
+	param, err := encoding.WriteJson(req.Param)
+	if err != nil {
+		return "", fmt.Errorf("failed to write job param, %v", err)
+	}
+
+	jobNo := util.GenIdP("job_")
+	now := util.Now()
+	var startTime *util.ETime
+	if status == JobStatusRunning {
+		startTime = &now
+	}
+	err = db.Exec(`INSERT INTO job (job_no, job_type, user_no, username, status, ref_type, ref_key, param, start_time, update_time)
+		VALUES (?,?,?,?,?,?,?,?,?,?)`, jobNo, req.JobType, user.UserNo, user.Username, status, req.RefType, req.RefKey,
+		string(param), startTime, now).Error
+	if err != nil {
+		return "", fmt.Errorf("failed to save job, %v", err)
+	}
+	rail.Infof("Saved %v job %v for %v", req.JobType, jobNo, user.Username)
+	return jobNo, nil
+}
+
+func startJob(rail miso.Rail, jobNo string) {
+	vfmPool.Go(func() {
+		rail := rail.NextSpan()
+		if err := runJob(rail, mysql.GetMySQL(), jobNo); err != nil {
+			rail.Errorf("Failed to run job %v, %v", jobNo, err)
+		}
+	})
+}
+
+func runJob(rail miso.Rail, db *gorm.DB, jobNo string) error {
+	lock := redis.NewRLock(rail, "vfm:job:"+jobNo)
+	if err := lock.Lock(); err != nil {
+		rail.Infof("Job %v is being run by others, skipped, %v", jobNo, err)
+		return nil
+	}
+	defer lock.Unlock()
+
+	var j job
+	t := db.Raw(`SELECT job_no, job_type, user_no, username, status, ref_type, ref_key, param, checkpoint, total, processed,
+		failed, result_msg, cancel_req, create_time FROM job WHERE job_no = ?`, jobNo).Scan(&j)
+	if t.Error != nil {
+		return fmt.Errorf("failed to find job, jobNo: %v, %v", jobNo, t.Error)
+	}
+	if t.RowsAffected < 1 || (j.Status != JobStatusPending && j.Status != JobStatusRunning) {
+		return nil
+	}
+
+	jc := &JobCtx{
+		JobNo:      j.JobNo,
+		User:       common.User{UserNo: j.UserNo, Username: j.Username},
+		Total:      j.Total,
+		Processed:  j.Processed,
+		Failed:     j.Failed,
+		ResultMsg:  j.ResultMsg,
+		param:      j.Param,
+		checkpoint: j.Checkpoint,
+		createTime: j.CreateTime,
+		db:         db,
+	}
+
+	h, ok := jobHandlers[j.JobType]
+	if !ok {
+		return finishJob(rail, db, j, jc, fmt.Errorf("unknown job type: %v", j.JobType))
+	}
+	if j.CancelReq {
+		return finishJob(rail, db, j, jc, ErrJobCancelled)
+	}
+
+	t = db.Exec(`UPDATE job SET status = ?, start_time = IFNULL(start_time, ?), update_time = ? WHERE job_no = ? AND status IN (?,?)`,
+		JobStatusRunning, util.Now(), util.Now(), jobNo, JobStatusPending, JobStatusRunning)
+	if t.Error != nil {
+		return fmt.Errorf("failed to update job status, jobNo: %v, %v", jobNo, t.Error)
+	}
+	if t.RowsAffected < 1 {
+		return nil // cancelled
+	}
+
+	rail.Infof("Running %v job %v, processed: %v", j.JobType, jobNo, j.Processed)
+	err := h.run(rail, db, jc)
+	if errors.Is(err, errJobWaiting) {
+		rail.Infof("Job %v is waiting for external callback", jobNo)
+		return nil
+	}
+	return finishJob(rail, db, j, jc, err)
+}
+
+// Complete the job that waits for external callback, the job is failed if err is not nil.
+func CompleteWaitingJob(rail miso.Rail, db *gorm.DB, jobNo string, processed int, resultMsg string, err error) error {
+	var j job
+	t := db.Raw(`SELECT job_no, job_type, user_no, username, status, ref_type, ref_key FROM job WHERE job_no = ?`, jobNo).Scan(&j)
+	if t.Error != nil {
+		return fmt.Errorf("failed to find job, jobNo: %v, %v", jobNo, t.Error)
+	}
+	if t.RowsAffected < 1 || j.Status != JobStatusRunning {
+		return nil
+	}
+	jc := &JobCtx{JobNo: jobNo, Total: processed, Processed: processed, ResultMsg: resultMsg}
+	return finishJob(rail, db, j, jc, err)
+}
+
+func finishJob(rail miso.Rail, db *gorm.DB, j job, jc *JobCtx, err error) error {
+	status := JobStatusSuccess
+	errMsg := ""
+	if err != nil {
+		if errors.Is(err, ErrJobCancelled) {
+			status = JobStatusCancelled
+		} else {
+			status = JobStatusFailed
+			errMsg = util.MaxLenStr(err.Error(), 1000)
+			rail.Errorf("Job %v failed, %v", j.JobNo, err)
+		}
+	}
+
+	t := db.Exec(`UPDATE job SET status = ?, total = ?, processed = ?, failed = ?, result_msg = ?, err_msg = ?, end_time = ?, update_time = ?
+		WHERE job_no = ? AND status IN (?,?)`, status, jc.Total, jc.Processed, jc.Failed, util.MaxLenStr(jc.ResultMsg, 255),
+		errMsg, util.Now(), util.Now(), j.JobNo, JobStatusPending, JobStatusRunning)
+	if t.Error != nil {
+		return fmt.Errorf("failed to update job status, jobNo: %v, %v", j.JobNo, t.Error)
+	}
+	if t.RowsAffected < 1 {
+		return nil
+	}
+	rail.Infof("Job %v %v", j.JobNo, status)
+
+	name := j.JobType
+	if h, ok := jobHandlers[j.JobType]; ok {
+		name = h.name
+	}
+	evt := CreateNotifiEvent{UserNos: []string{j.UserNo}, RefType: j.RefType, RefKey: j.RefKey}
+	switch status {
+	case JobStatusSuccess:
+		evt.Type = NotifiTypeJobFinished
+		evt.Title = name + " finished"
+		evt.Message = jc.ResultMsg
+	case JobStatusCancelled:
+		evt.Type = NotifiTypeJobFinished
+		evt.Title = name + " cancelled"
+		evt.Message = fmt.Sprintf("%d items are processed before the job is cancelled", jc.Processed)
+	default:
+		evt.Type = NotifiTypeJobFailed
+		evt.Title = name + " failed"
+		evt.Message = "Job failed, please try again later"
+	}
+	SendNotification(rail, evt)
+	return nil
+}
+
+// Scheduled task that resumes jobs interrupted by restart.
+func ResumeJobsTask(rail miso.Rail) error {
+	return ResumeJobs(rail, mysql.GetMySQL())
+}
+
+// Resume pending or running jobs that are not updated for a while, e.g., the node running them is restarted.
+//
+// Jobs that are still running are skipped by the job's lock.
+func ResumeJobs(rail miso.Rail, db *gorm.DB) error {
+	deadline := time.Now().Add(-time.Duration(miso.GetPropInt(PropJobResumeAfter)) * time.Second)
+	var jobNos []string
+	err := db.Raw(`SELECT job_no FROM job WHERE status IN (?,?) AND update_time < ? ORDER BY id ASC LIMIT ?`,
+		JobStatusPending, JobStatusRunning, deadline, maxResumedJobs).
+		Scan(&jobNos).Error
+	if err != nil {
+		return fmt.Errorf("failed to list interrupted jobs, %v", err)
+	}
+	for _, jobNo := range jobNos {
+		rail.Infof("Resuming job %v", jobNo)
+		startJob(rail, jobNo)
+	}
+	return nil
+}
+
+type CancelJobReq struct {
+	JobNo string `json:"jobNo" valid:"notEmpty"`
+}
+
+// Cancel the job, running job stops at next checkpoint.
+func CancelJob(rail miso.Rail, db *gorm.DB, req CancelJobReq, user common.User) error {
+	var jobType string
+	t := db.Raw(`SELECT job_type FROM job WHERE job_no = ? AND user_no = ?`, req.JobNo, user.UserNo).Scan(&jobType)
+	if t.Error != nil {
+		return fmt.Errorf("failed to find job, jobNo: %v, %v", req.JobNo, t.Error)
+	}
+	if t.RowsAffected < 1 {
+		return miso.NewErrf("Job not found")
+	}
+	if h, ok := jobHandlers[jobType]; !ok || !h.cancellable {
+		return miso.NewErrf("Job can't be cancelled")
+	}
+
+	t = db.Exec(`UPDATE job SET status = ?, cancel_req = 1, end_time = ? WHERE job_no = ? AND status = ?`,
+		JobStatusCancelled, util.Now(), req.JobNo, JobStatusPending)
+	if t.Error != nil {
+		return fmt.Errorf("failed to cancel job, jobNo: %v, %v", req.JobNo, t.Error)
+	}
+	if t.RowsAffected > 0 {
+		rail.Infof("Pending job %v cancelled by %v", req.JobNo, user.Username)
+		return nil
+	}
+
+	t = db.Exec(`UPDATE job SET cancel_req = 1 WHERE job_no = ? AND status = ?`, req.JobNo, JobStatusRunning)
+	if t.Error != nil {
+		return fmt.Errorf("failed to cancel job, jobNo: %v, %v", req.JobNo, t.Error)
+	}
+	if t.RowsAffected < 1 {
+		return miso.NewErrf("Job is already finished")
+	}
+	rail.Infof("Requested to cancel job %v by %v", req.JobNo, user.Username)
+	return nil
+}
+
+type ListedJob struct {
+	JobNo      string      `json:"jobNo"`
+	JobType    string      `json:"jobType" desc:"TRUNCATE_DIR, TRANSFER_GALLERY_IMAGES, IMPORT_BOOKMARKS, UNPACK_ZIP"`
+	Status     string      `json:"status" desc:"PENDING, RUNNING, SUCCESS, FAILED, CANCELLED"`
+	RefType    string      `json:"refType" desc:"type of the resource that the job operates on"`
+	RefKey     string      `json:"refKey" desc:"key of the resource that the job operates on"`
+	Total      int         `json:"total" desc:"total number of items, 0 if unknown"`
+	Processed  int         `json:"processed" desc:"number of items processed"`
+	Failed     int         `json:"failed" desc:"number of items failed"`
+	ResultMsg  string      `json:"resultMsg"`
+	ErrMsg     string      `json:"errMsg"`
+	CancelReq  bool        `json:"cancelReq" desc:"whether the job is requested to cancel"`
+	StartTime  *util.ETime `json:"startTime"`
+	EndTime    *util.ETime `json:"endTime"`
+	CreateTime util.ETime  `json:"createTime"`
+	UpdateTime util.ETime  `json:"updateTime"`
+}
+
+const listedJobCols = `job_no, job_type, status, ref_type, ref_key, total, processed, failed, result_msg, err_msg, cancel_req,
+	start_time, end_time, create_time, update_time`
+
+type ListJobReq struct {
+	Paging  miso.Paging `json:"paging"`
+	JobType *string     `json:"jobType"`
+	Status  *string     `json:"status"`
+}
+
+// List user's jobs.
+func ListJobs(rail miso.Rail, db *gorm.DB, req ListJobReq, user common.User) (miso.PageRes[ListedJob], error) {
+	return mysql.NewPageQuery[ListedJob]().
+		WithPage(req.Paging).
+		WithBaseQuery(func(tx *gorm.DB) *gorm.DB {
+			tx = tx.Table("job").Where("user_no = ?", user.UserNo)
+			if req.JobType != nil && *req.JobType != "" {
+				tx = tx.Where("job_type = ?", *req.JobType)
+			}
+			if req.Status != nil && *req.Status != "" {
+				tx = tx.Where("status = ?", *req.Status)
+			}
+			return tx
+		}).
+		WithSelectQuery(func(tx *gorm.DB) *gorm.DB {
+			return tx.Select(listedJobCols).Order("id DESC")
+		}).
+		Exec(rail, db)
+}
+
+type GetJobReq struct {
+	JobNo string `form:"jobNo" valid:"notEmpty"`
+}
+
+// Get user's job.
+func GetJob(rail miso.Rail, db *gorm.DB, req GetJobReq, user common.User) (ListedJob, error) {
+	var j ListedJob
+	t := db.Raw(`SELECT `+listedJobCols+` FROM job WHERE job_no = ? AND user_no = ?`, req.JobNo, user.UserNo).Scan(&j)
+	if t.Error != nil {
+		return j, fmt.Errorf("failed to find job, jobNo: %v, %v", req.JobNo, t.Error)
+	}
+	if t.RowsAffected < 1 {
+		return j, miso.NewErrf("Job not found")
+	}
+	return j, nil
+}
@@ -0,0 +1,223 @@
+package vfm
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+
+	"github.com/curtisnewbie/miso/miso"
+	"github.com/curtisnewbie/miso/util"
+	"gorm.io/gorm"
+)
+
+const (
+	// number of bytes read from the beginning of the file to detect the media type.
+	mimeSniffLen = 512
+
+	MimeOctetStream = "application/octet-stream"
+)
+
+var (
+	// brands of ISO base media file format (the 'ftyp' box).
+	_ftypBrandMime = map[string]string{
+		"heic": "image/heic",
+		"heix": "image/heic",
+		"hevc": "image/heic-sequence",
+		"hevx": "image/heic-sequence",
+		"heim": "image/heic",
+		"heis": "image/heic",
+		"mif1": "image/heif",
+		"msf1": "image/heif-sequence",
+		"avif": "image/avif",
+		"avis": "image/avif",
+		"crx ": "image/x-canon-cr3",
+		"qt  ": "video/quicktime",
+		"M4A ": "audio/mp4",
+		"M4B ": "audio/mp4",
+		"3gp4": "video/3gpp",
+		"3gp5": "video/3gpp",
+		"3gp6": "video/3gpp",
+		"3g2a": "video/3gpp2",
+	}
+
+	// raw formats based on TIFF, these can only be told apart from plain TIFF by the file extension.
+	_tiffRawMime = map[string]string{
+		"dng": "image/x-adobe-dng",
+		"nef": "image/x-nikon-nef",
+		"nrw": "image/x-nikon-nrw",
+		"arw": "image/x-sony-arw",
+		"srf": "image/x-sony-srf",
+		"sr2": "image/x-sony-sr2",
+		"pef": "image/x-pentax-pef",
+		"srw": "image/x-samsung-srw",
+		"erf": "image/x-epson-erf",
+		"3fr": "image/x-hasselblad-3fr",
+	}
+
+	// images that can be decoded by mini-fstore's thumbnail generation
+	_thumbnailImageMimes  = util.NewSet[string]()
+	_thumbnailImageSuffix = util.NewSet[string]()
+)
+
+func init() {
+	_thumbnailImageMimes.AddAll([]string{"image/jpeg", "image/png", "image/gif", "image/webp"})
+	_thumbnailImageSuffix.AddAll([]string{"jpeg", "jpg", "png", "gif", "webp"})
+}
+
+// Detect media type using the magic bytes at the beginning of the file.
+//
+// The file name is only used to tell apart the raw formats that are based on TIFF.
+// Parameters such as charset are stripped, MimeOctetStream is returned if the type is unknown.
+func detectMimeType(head []byte, name string) string {
+	if len(head) < 1 {
+		return MimeOctetStream
+	}
+	if len(head) > mimeSniffLen {
+		head = head[:mimeSniffLen]
+	}
+
+	switch {
+	case len(head) >= 12 && string(head[4:8]) == "ftyp":
+		if m, ok := _ftypBrandMime[string(head[8:12])]; ok {
+			return m
+		}
+		return "video/mp4"
+	case bytes.HasPrefix(head, []byte("FUJIFILMCCD-RAW")):
+		return "image/x-fuji-raf"
+	case bytes.HasPrefix(head, []byte("IIRO")), bytes.HasPrefix(head, []byte("IIRS")), bytes.HasPrefix(head, []byte("MMOR")):
+		return "image/x-olympus-orf"
+	case bytes.HasPrefix(head, []byte("IIU\x00")):
+		return "image/x-panasonic-rw2"
+	case bytes.HasPrefix(head, []byte("II*\x00")), bytes.HasPrefix(head, []byte("MM\x00*")):
+		if len(head) >= 10 && string(head[8:10]) == "CR" {
+			return "image/x-canon-cr2"
+		}
+		if m, ok := _tiffRawMime[fileExt(name)]; ok {
+			return m
+		}
+		return "image/tiff"
+	case bytes.HasPrefix(head, []byte("\x1a\x45\xdf\xa3")):
+		if bytes.Contains(head, []byte("webm")) {
+			return "video/webm"
+		}
+		return "video/x-matroska"
+	case len(head) >= 12 && string(head[:4]) == "RIFF" && string(head[8:12]) == "AVI ":
+		return "video/x-msvideo"
+	case bytes.HasPrefix(head, []byte("OggS")):
+		if bytes.Contains(head, []byte("theora")) {
+			return "video/ogg"
+		}
+		return "audio/ogg"
+	case isSvg(head):
+		return "image/svg+xml"
+	}
+
+	m, _, err := mime.ParseMediaType(http.DetectContentType(head))
+	if err != nil || m == "" {
+		return MimeOctetStream
+	}
+	return m
+}
+
+func isSvg(head []byte) bool {
+	head = bytes.TrimSpace(head)
+	return bytes.HasPrefix(head, []byte("<")) && bytes.Contains(head, []byte("<svg"))
+}
+
+func fileExt(name string) string {
+	i := strings.LastIndex(name, ".")
+	if i < 0 {
+		return ""
+	}
+	return strings.ToLower(name[i+1:])
+}
+
+func isImageMime(m string) bool {
+	return strings.HasPrefix(m, "image/")
+}
+
+// Whether thumbnail can be generated for the image, mini-fstore only decodes jpeg, png, gif and webp.
+func isThumbnailImageMime(m string) bool {
+	return _thumbnailImageMimes.Has(m)
+}
+
+func isVideoMime(m string) bool {
+	return strings.HasPrefix(m, "video/")
+}
+
+// Whether the file is an image, files that are not yet detected are guessed by name.
+func (f FileInfo) IsImage() bool {
+	if f.MimeType == "" {
+		return isImage(f.Name)
+	}
+	return isImageMime(f.MimeType)
+}
+
+// Whether thumbnail can be generated for the image, files that are not yet detected are guessed by name.
+func (f FileInfo) IsThumbnailImage() bool {
+	if f.MimeType == "" {
+		return _thumbnailImageSuffix.Has(fileExt(f.Name))
+	}
+	return isThumbnailImageMime(f.MimeType)
+}
+
+// Whether the file is a video, files that are not yet detected are guessed by name.
+func (f FileInfo) IsVideo() bool {
+	if f.MimeType == "" {
+		return isVideo(f.Name)
+	}
+	return isVideoMime(f.MimeType)
+}
+
+// Read the first few bytes of the mini-fstore file and detect the media type.
+func detectFstoreMimeType(rail miso.Rail, fstoreFileId string, name string) (string, error) {
+	head, err := readFstoreFileHead(rail, fstoreFileId, name, mimeSniffLen)
+	if err != nil {
+		return "", err
+	}
+	return detectMimeType(head, name), nil
+}
+
+// Read the first n bytes of the mini-fstore file using range request, empty slice is returned for empty file.
+func readFstoreFileHead(rail miso.Rail, fstoreFileId string, name string, n int) ([]byte, error) {
+	tkn, err := GetFstoreTmpToken(rail, fstoreFileId, name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate mini-fstore temp token, fileId: %v, %v", fstoreFileId, err)
+	}
+	r := miso.NewDynTClient(rail, "/file/stream", "fstore").
+		AddQueryParams("key", tkn).
+		AddHeader("Range", fmt.Sprintf("bytes=0-%d", n-1)).
+		Get()
+	if r.Err != nil {
+		return nil, fmt.Errorf("failed to stream mini-fstore file, fileId: %v, %v", fstoreFileId, r.Err)
+	}
+	defer r.Resp.Body.Close()
+	if r.Resp.StatusCode == http.StatusRequestedRangeNotSatisfiable {
+		return []byte{}, nil // empty file
+	}
+	if r.Resp.StatusCode != http.StatusOK && r.Resp.StatusCode != http.StatusPartialContent {
+		return nil, fmt.Errorf("failed to stream mini-fstore file, fileId: %v, status: %v", fstoreFileId, r.Resp.StatusCode)
+	}
+
+	head, err := io.ReadAll(io.LimitReader(r.Resp.Body, int64(n)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read mini-fstore file, fileId: %v, %v", fstoreFileId, err)
+	}
+	return head, nil
+}
+
+// Detect and save the media type of the file, the detected media type is returned.
+func detectAndSaveMimeType(rail miso.Rail, tx *gorm.DB, fileKey string, fstoreFileId string, name string) (string, error) {
+	m, err := detectFstoreMimeType(rail, fstoreFileId, name)
+	if err != nil {
+		return "", err
+	}
+	if err := tx.Exec("UPDATE file_info SET mime_type = ? WHERE uuid = ?", m, fileKey).Error; err != nil {
+		return "", fmt.Errorf("failed to update file_info.mime_type, uuid: %v, %v", fileKey, err)
+	}
+	rail.Infof("Detected media type of file %v: %v", fileKey, m)
+	return m, nil
+}
@@ -0,0 +1,56 @@
+package vfm
+
+import "testing"
+
+func TestDetectMimeType(t *testing.T) {
+	for _, c := range []struct {
+		head string
+		name string
+		want string
+	}{
+		{"\xff\xd8\xff\xe0\x00\x10JFIF\x00", "IMG_0001.bin", "image/jpeg"},
+		{"\x89PNG\r\n\x1a\n\x00\x00\x00\x0dIHDR", "a.png", "image/png"},
+		{"hello world", "x.png", "text/plain"},
+		{"\x00\x00\x00\x18ftypheic\x00\x00\x00\x00", "a.heic", "image/heic"},
+		{"\x00\x00\x00\x1cftypavif\x00\x00\x00\x00", "a.avif", "image/avif"},
+		{"\x00\x00\x00\x14ftypqt  \x00\x00\x00\x00", "a.mov", "video/quicktime"},
+		{"\x00\x00\x00\x20ftypisom\x00\x00\x02\x00", "a", "video/mp4"},
+		{"\x00\x00\x00\x18ftypcrx \x00\x00\x00\x01", "a.cr3", "image/x-canon-cr3"},
+		{"\x1a\x45\xdf\xa3\x9f\x42\x86\x81\x01\x42\x82\x88matroska", "a.mkv", "video/x-matroska"},
+		{"\x1a\x45\xdf\xa3\x9f\x42\x86\x81\x01\x42\x82\x84webm", "a.mkv", "video/webm"},
+		{"RIFF\x00\x00\x00\x00AVI LIST", "a.avi", "video/x-msvideo"},
+		{"RIFF\x00\x00\x00\x00WEBPVP8 ", "a", "image/webp"},
+		{"II*\x00\x08\x00\x00\x00", "a.tif", "image/tiff"},
+		{"II*\x00\x10\x00\x00\x00CR\x02\x00", "a.cr2", "image/x-canon-cr2"},
+		{"MM\x00*\x00\x00\x00\x08", "DSC_0001.NEF", "image/x-nikon-nef"},
+		{"FUJIFILMCCD-RAW 0201", "a.raf", "image/x-fuji-raf"},
+		{"IIU\x00\x18\x00\x00\x00", "a.rw2", "image/x-panasonic-rw2"},
+		{"<?xml version=\"1.0\"?>\n<svg xmlns=\"http://www.w3.org/2000/svg\"></svg>", "a.svg", "image/svg+xml"},
+		{"%PDF-1.7", "a.pdf", "application/pdf"},
+		{"", "a.jpg", MimeOctetStream},
+	} {
+		if got := detectMimeType([]byte(c.head), c.name); got != c.want {
+			t.Errorf("%q (%v): want %v, got %v", c.head, c.name, c.want, got)
+		}
+	}
+}
+
+func TestIsThumbnailImage(t *testing.T) {
+	for _, c := range []struct {
+		f    FileInfo
+		want bool
+	}{
+		{FileInfo{Name: "a.bin", MimeType: "image/jpeg"}, true},
+		{FileInfo{Name: "a.webp", MimeType: "image/webp"}, true},
+		{FileInfo{Name: "a.heic", MimeType: "image/heic"}, false},
+		{FileInfo{Name: "a.tif", MimeType: "image/tiff"}, false},
+		{FileInfo{Name: "a.svg", MimeType: "image/svg+xml"}, false},
+		{FileInfo{Name: "a.avif", MimeType: "image/avif"}, false},
+		{FileInfo{Name: "a.PNG"}, true},
+		{FileInfo{Name: "a.avif"}, false},
+	} {
+		if got := c.f.IsThumbnailImage(); got != c.want {
+			t.Errorf("%v (%v): want %v, got %v", c.f.Name, c.f.MimeType, c.want, got)
+		}
+	}
+}
@@ -1,9 +1,10 @@
-// auto generated by misoapi v0.1.8 at 2024/09/19 23:30:26, please do not modify
+// auto generated by misoapi v0.1.9 at 2026/10/19 15:31:19, please do not modify
 package vfm
 
 import (
 	"github.com/curtisnewbie/miso/middleware/mysql"
 	"github.com/curtisnewbie/miso/miso"
+	"github.com/curtisnewbie/vfm/api"
 )
 
 func init() {
@@ -40,7 +41,8 @@ func init() {
 			return MakeDirEp(inb, req)
 		}).
 		Desc("User make directory").
-		Resource(ManageFilesResource)
+		Resource(ManageFilesResource).
+		DocHeader("Idempotency-Key", "optional key, retries with the same key replay the first response")
 
 	miso.Get("/open/api/file/dir/list",
 		func(inb *miso.Inbound) ([]ListedDir, error) {
@@ -77,12 +79,41 @@ func init() {
 		Desc("User delete file in batch").
 		Resource(ManageFilesResource)
 
+	miso.IPost("/open/api/file/batch/move",
+		func(inb *miso.Inbound, req BatchMoveFileReq) (BatchRes, error) {
+			return ApiBatchMoveFiles(inb, req)
+		}).
+		Desc("User move files into directories in batch, in ALL_OR_NOTHING mode, nothing is changed if any item fails; in BEST_EFFORT mode, each item is applied independently").
+		Resource(ManageFilesResource)
+
+	miso.IPost("/open/api/file/batch/delete",
+		func(inb *miso.Inbound, req BatchDeleteFilesReq) (BatchRes, error) {
+			return ApiBatchDeleteFiles(inb, req)
+		}).
+		Desc("User delete files in batch, in ALL_OR_NOTHING mode, nothing is changed if any item fails; in BEST_EFFORT mode, each item is applied independently").
+		Resource(ManageFilesResource)
+
+	miso.IPost("/open/api/file/batch/copy",
+		func(inb *miso.Inbound, req BatchCopyFilesReq) (BatchRes, error) {
+			return ApiBatchCopyFiles(inb, req)
+		}).
+		Desc("User copy files into directory in batch, in ALL_OR_NOTHING mode, nothing is changed if any item fails; in BEST_EFFORT mode, each item is applied independently").
+		Resource(ManageFilesResource)
+
+	miso.IPost("/open/api/file/batch/rename",
+		func(inb *miso.Inbound, req BatchRenameFilesReq) (BatchRes, error) {
+			return ApiBatchRenameFiles(inb, req)
+		}).
+		Desc("User rename files in batch, in ALL_OR_NOTHING mode, nothing is changed if any item fails; in BEST_EFFORT mode, each item is applied independently").
+		Resource(ManageFilesResource)
+
 	miso.IPost("/open/api/file/create",
-		func(inb *miso.Inbound, req CreateFileReq) (any, error) {
+		func(inb *miso.Inbound, req CreateFileReq) (string, error) {
 			return CreateFileEp(inb, req)
 		}).
-		Desc("User create file").
-		Resource(ManageFilesResource)
+		Desc("User create file, the file key is returned").
+		Resource(ManageFilesResource).
+		DocHeader("Idempotency-Key", "optional key, retries with the same key replay the first response")
 
 	miso.IPost("/open/api/file/info/update",
 		func(inb *miso.Inbound, req UpdateFileReq) (any, error) {
@@ -98,6 +129,13 @@ func init() {
 		Desc("User generate temporary token").
 		Resource(ManageFilesResource)
 
+	miso.IPost("/open/api/file/thumbnail/regenerate",
+		func(inb *miso.Inbound, req RegenerateThumbnailReq) (any, error) {
+			return ApiRegenerateThumbnail(inb, req)
+		}).
+		Desc("User regenerate thumbnail of the image or video, the failed attempts are reset").
+		Resource(ManageFilesResource)
+
 	miso.IPost("/open/api/file/unpack",
 		func(inb *miso.Inbound, req UnpackZipReq) (any, error) {
 			return UnpackZipEp(inb, req)
@@ -129,7 +167,8 @@ func init() {
 			return CreateVFolderEp(inb, req)
 		}).
 		Desc("User create virtual folder").
-		Resource(ManageFilesResource)
+		Resource(ManageFilesResource).
+		DocHeader("Idempotency-Key", "optional key, retries with the same key replay the first response")
 
 	miso.IPost("/open/api/vfolder/file/add",
 		func(inb *miso.Inbound, req AddFileToVfolderReq) (any, error) {
@@ -212,7 +251,7 @@ func init() {
 		func(inb *miso.Inbound, req PermitGalleryAccessCmd) (any, error) {
 			return GranteGalleryAccessEp(inb, req)
 		}).
-		Desc("Grant access to the galleries").
+		Desc("Grant access to the galleries, either as VIEWER or CONTRIBUTOR, role of the existing access is updated").
 		Resource(ManageFilesResource)
 
 	miso.IPost("/open/api/gallery/access/remove",
@@ -236,11 +275,67 @@ func init() {
 		Desc("List images of gallery").
 		Resource(ManageFilesResource)
 
+	miso.IPost("/open/api/gallery/image/remove",
+		func(inb *miso.Inbound, req RemoveGalleryImagesReq) (any, error) {
+			return RemoveGalleryImagesEp(inb, req)
+		}).
+		Desc("Remove images from gallery, the owner can remove any image, contributors can only remove images added by themselves, the files are not deleted").
+		Resource(ManageFilesResource)
+
+	miso.IPost("/open/api/gallery/image/reorder",
+		func(inb *miso.Inbound, req ReorderGalleryImagesReq) (any, error) {
+			return ReorderGalleryImagesEp(inb, req)
+		}).
+		Desc("Reorder images in gallery, the listed images are placed first in the given order, the others are ordered by capture time").
+		Resource(ManageFilesResource)
+
+	miso.IPost("/open/api/gallery/cover",
+		func(inb *miso.Inbound, req SetGalleryCoverReq) (any, error) {
+			return SetGalleryCoverEp(inb, req)
+		}).
+		Desc("Choose the cover image of gallery").
+		Resource(ManageFilesResource)
+
+	miso.Get("/open/api/gallery/auto/pref/list",
+		func(inb *miso.Inbound) (AutoGalleryPrefs, error) {
+			return ListAutoGalleryPrefsEp(inb)
+		}).
+		Desc("List preferences of automatic gallery creation for directories").
+		Resource(ManageFilesResource)
+
+	miso.IPost("/open/api/gallery/auto/pref/update",
+		func(inb *miso.Inbound, req UpdateAutoGalleryPrefReq) (any, error) {
+			return UpdateAutoGalleryPrefEp(inb, req)
+		}).
+		Desc("Turn automatic gallery creation on or off for a directory, or update the default of the user").
+		Resource(ManageFilesResource)
+
+	miso.IPost("/open/api/gallery/auto/clean",
+		func(inb *miso.Inbound, req CleanAutoGalleriesReq) ([]VGalleryBrief, error) {
+			return CleanAutoGalleriesEp(inb, req)
+		}).
+		Desc("Delete galleries created automatically for directories where automatic gallery creation is disabled, the files are not deleted").
+		Resource(ManageFilesResource)
+
+	miso.IPost("/open/api/timeline",
+		func(inb *miso.Inbound, req ListTimelineReq) (ListTimelineResp, error) {
+			return ListTimelineEp(inb, req)
+		}).
+		Desc("List user's images and videos (including those in accessible galleries) by capture time, grouped by day or month").
+		Resource(ManageFilesResource)
+
+	miso.IPost("/open/api/timeline/on-this-day",
+		func(inb *miso.Inbound, req ListOnThisDayReq) (ListOnThisDayResp, error) {
+			return ListOnThisDayEp(inb, req)
+		}).
+		Desc("List user's images and videos taken on the same day in previous years").
+		Resource(ManageFilesResource)
+
 	miso.IPost("/open/api/gallery/image/transfer",
 		func(inb *miso.Inbound, req TransferGalleryImageReq) (any, error) {
 			return TransferGalleryImageEp(inb, req)
 		}).
-		Desc("Host selected images on gallery").
+		Desc("Host selected images on gallery, both the owner and the contributors can add images that they own").
 		Resource(ManageFilesResource)
 
 	miso.IPost("/open/api/versioned-file/list",
@@ -276,7 +371,8 @@ func init() {
 			return ApiUpdateVersionedFile(inb, req)
 		}).
 		Desc("Update versioned file").
-		Resource(ManageFilesResource)
+		Resource(ManageFilesResource).
+		DocHeader("Idempotency-Key", "optional key, retries with the same key replay the first response")
 
 	miso.IPost("/open/api/versioned-file/delete",
 		func(inb *miso.Inbound, req ApiDelVerFileReq) (any, error) {
@@ -285,17 +381,273 @@ func init() {
 		Desc("Delete versioned file").
 		Resource(ManageFilesResource)
 
+	miso.IPost("/open/api/notification/list",
+		func(inb *miso.Inbound, req ListNotifiReq) (miso.PageRes[ListedNotifi], error) {
+			return ApiListNotifications(inb, req)
+		}).
+		Desc("List notifications").
+		Resource(ManageFilesResource)
+
+	miso.Get("/open/api/notification/count-unread",
+		func(inb *miso.Inbound) (int, error) {
+			return ApiCountUnreadNotifications(inb)
+		}).
+		Desc("Count unread notifications").
+		Resource(ManageFilesResource)
+
+	miso.IPost("/open/api/notification/read",
+		func(inb *miso.Inbound, req MarkNotifiReadReq) (any, error) {
+			return ApiMarkNotificationRead(inb, req)
+		}).
+		Desc("Mark notification as read").
+		Resource(ManageFilesResource)
+
+	miso.Post("/open/api/notification/read-all",
+		func(inb *miso.Inbound) (any, error) {
+			return ApiMarkAllNotificationsRead(inb)
+		}).
+		Desc("Mark all notifications as read").
+		Resource(ManageFilesResource)
+
+	miso.Get("/open/api/notification/pref/list",
+		func(inb *miso.Inbound) ([]NotifiPref, error) {
+			return ApiListNotifiPrefs(inb)
+		}).
+		Desc("List notification preferences").
+		Resource(ManageFilesResource)
+
+	miso.IPost("/open/api/notification/pref/update",
+		func(inb *miso.Inbound, req UpdateNotifiPrefReq) (any, error) {
+			return ApiUpdateNotifiPref(inb, req)
+		}).
+		Desc("Mute or unmute notification type").
+		Resource(ManageFilesResource)
+
+	miso.IPost("/open/api/notification/subscribe",
+		func(inb *miso.Inbound, req SubscribeReq) (any, error) {
+			return ApiSubscribe(inb, req)
+		}).
+		Desc("Subscribe to changes of a directory or vfolder").
+		Resource(ManageFilesResource)
+
+	miso.IPost("/open/api/notification/unsubscribe",
+		func(inb *miso.Inbound, req SubscribeReq) (any, error) {
+			return ApiUnsubscribe(inb, req)
+		}).
+		Desc("Unsubscribe from changes of a directory or vfolder").
+		Resource(ManageFilesResource)
+
+	miso.IPost("/open/api/notification/subscription/list",
+		func(inb *miso.Inbound, req ListSubscriptionReq) (miso.PageRes[ListedSubscription], error) {
+			return ApiListSubscriptions(inb, req)
+		}).
+		Desc("List subscriptions").
+		Resource(ManageFilesResource)
+
+	miso.IPost("/open/api/activity/file",
+		func(inb *miso.Inbound, req ListFileActivityReq) (miso.PageRes[ListedActivity], error) {
+			return ApiListFileActivities(inb, req)
+		}).
+		Desc("List activities of a file").
+		Resource(ManageFilesResource)
+
+	miso.IPost("/open/api/activity/user",
+		func(inb *miso.Inbound, req ListUserActivityReq) (miso.PageRes[ListedActivity], error) {
+			return ApiListUserActivities(inb, req)
+		}).
+		Desc("List activities operated by current user").
+		Resource(ManageFilesResource)
+
+	miso.IPost("/open/api/activity/vfolder",
+		func(inb *miso.Inbound, req ListVFolderActivityReq) (miso.PageRes[ListedActivity], error) {
+			return ApiListVFolderActivities(inb, req)
+		}).
+		Desc("List activities inside a vfolder, only the owner of the vfolder can do so").
+		Resource(ManageFilesResource)
+
+	miso.IPost("/open/api/webhook/create",
+		func(inb *miso.Inbound, req CreateWebhookReq) (CreateWebhookRes, error) {
+			return ApiCreateWebhook(inb, req)
+		}).
+		Desc("Register webhook on a directory, a vfolder or user's whole space").
+		Resource(ManageFilesResource)
+
+	miso.IPost("/open/api/webhook/update",
+		func(inb *miso.Inbound, req UpdateWebhookReq) (any, error) {
+			return ApiUpdateWebhook(inb, req)
+		}).
+		Desc("Update webhook").
+		Resource(ManageFilesResource)
+
+	miso.IPost("/open/api/webhook/delete",
+		func(inb *miso.Inbound, req DeleteWebhookReq) (any, error) {
+			return ApiDeleteWebhook(inb, req)
+		}).
+		Desc("Delete webhook").
+		Resource(ManageFilesResource)
+
+	miso.IPost("/open/api/webhook/list",
+		func(inb *miso.Inbound, req ListWebhookReq) (miso.PageRes[ListedWebhook], error) {
+			return ApiListWebhooks(inb, req)
+		}).
+		Desc("List webhooks").
+		Resource(ManageFilesResource)
+
+	miso.IPost("/open/api/webhook/delivery/list",
+		func(inb *miso.Inbound, req ListWebhookDeliveryReq) (miso.PageRes[ListedWebhookDelivery], error) {
+			return ApiListWebhookDeliveries(inb, req)
+		}).
+		Desc("List webhook deliveries").
+		Resource(ManageFilesResource)
+
+	miso.IPost("/open/api/s3/access-key/create",
+		func(inb *miso.Inbound, req CreateS3AccessKeyReq) (CreateS3AccessKeyRes, error) {
+			return ApiCreateS3AccessKey(inb, req)
+		}).
+		Desc("Create access key for the S3-compatible gateway, the secret key is returned only once").
+		Resource(ManageFilesResource)
+
+	miso.IPost("/open/api/s3/access-key/list",
+		func(inb *miso.Inbound, req ListS3AccessKeyReq) (miso.PageRes[ListedS3AccessKey], error) {
+			return ApiListS3AccessKeys(inb, req)
+		}).
+		Desc("List access keys for the S3-compatible gateway").
+		Resource(ManageFilesResource)
+
+	miso.IPost("/open/api/s3/access-key/delete",
+		func(inb *miso.Inbound, req DeleteS3AccessKeyReq) (any, error) {
+			return ApiDeleteS3AccessKey(inb, req)
+		}).
+		Desc("Delete access key for the S3-compatible gateway").
+		Resource(ManageFilesResource)
+
+	miso.IPost("/open/api/job/list",
+		func(inb *miso.Inbound, req ListJobReq) (miso.PageRes[ListedJob], error) {
+			return ApiListJobs(inb, req)
+		}).
+		Desc("List user's background jobs").
+		Resource(ManageFilesResource)
+
+	miso.IGet("/open/api/job/get",
+		func(inb *miso.Inbound, req GetJobReq) (ListedJob, error) {
+			return ApiGetJob(inb, req)
+		}).
+		Desc("Get user's background job").
+		Resource(ManageFilesResource).
+		DocQueryParam("jobNo", "job no")
+
+	miso.IPost("/open/api/job/cancel",
+		func(inb *miso.Inbound, req CancelJobReq) (any, error) {
+			return ApiCancelJob(inb, req)
+		}).
+		Desc("Cancel user's background job, running job stops at next checkpoint").
+		Resource(ManageFilesResource)
+
+	miso.IGet("/remote/user/file/info",
+		func(inb *miso.Inbound, req api.FetchFileInfoReq) (api.FileInfoResp, error) {
+			return FetchFileInfoInternalEp(inb.Rail(), mysql.GetMySQL(), req)
+		}).
+		Desc("Internal endpoint, fetch file info").
+		DocQueryParam("fileKey", "file key")
+
+	miso.IGet("/remote/user/file/owner/validation",
+		func(inb *miso.Inbound, req api.ValidateFileOwnerReq) (bool, error) {
+			return ValidateFileOwnerEp(inb.Rail(), mysql.GetMySQL(), req)
+		}).
+		Desc("Internal endpoint, validate whether the file is owned by the user").
+		DocQueryParam("fileKey", "file key").
+		DocQueryParam("userNo", "user_no of the expected owner")
+
+	miso.IGet("/remote/user/file/indir/list",
+		func(inb *miso.Inbound, req api.ListFilesInDirReq) ([]string, error) {
+			return ListFilesInDirEp(inb.Rail(), mysql.GetMySQL(), req)
+		}).
+		Desc("Internal endpoint, list file keys of files in the directory, directories are excluded").
+		DocQueryParam("fileKey", "file key of the directory").
+		DocQueryParam("limit", "page size, at most 100").
+		DocQueryParam("page", "page number, starts from 1")
+
 	miso.Post("/compensate/thumbnail",
 		func(inb *miso.Inbound) (any, error) {
 			return CompensateThumbnailEp(inb.Rail(), mysql.GetMySQL())
 		}).
-		Desc("Compensate thumbnail generation")
+		Desc("Detect media types of files that are not yet detected, and compensate thumbnail generation")
+
+	miso.IPost("/compensate/thumbnail/failure/report",
+		func(inb *miso.Inbound, req ListThumbnailFailureReq) (miso.PageRes[ThumbnailFailure], error) {
+			return ListThumbnailFailuresEp(inb.Rail(), mysql.GetMySQL(), req)
+		}).
+		Desc("List files that have failed thumbnail generation permanently")
+
+	miso.Post("/compensate/thumbnail/variant",
+		func(inb *miso.Inbound) (any, error) {
+			return CompensateThumbnailVariantsEp(inb.Rail(), mysql.GetMySQL())
+		}).
+		Desc("Compensate thumbnail variants generation for images that have thumbnails but don't have any variant")
+
+	miso.Post("/compensate/preview",
+		func(inb *miso.Inbound) (any, error) {
+			return CompensatePreviewEp(inb.Rail(), mysql.GetMySQL())
+		}).
+		Desc("Compensate previews of documents, PDFs without thumbnails are rendered and excerpts are generated for text files")
+
+	miso.Post("/compensate/image/metadata",
+		func(inb *miso.Inbound) (any, error) {
+			return CompensateImageMetadataEp(inb.Rail(), mysql.GetMySQL())
+		}).
+		Desc("Compensate EXIF/XMP metadata extraction for images that don't have metadata")
+
+	miso.Post("/compensate/gallery/dir",
+		func(inb *miso.Inbound) (any, error) {
+			return CompensateDirGalleriesEp(inb.Rail(), mysql.GetMySQL())
+		}).
+		Desc("Bring directory galleries in sync with their directories, i.e., remove images moved out, follow directory names and delete or detach galleries of deleted directories")
 
 	miso.Post("/compensate/dir/calculate-size",
 		func(inb *miso.Inbound) (any, error) {
 			return ImMemBatchCalcDirSizeEp(inb.Rail(), mysql.GetMySQL())
 		}).
-		Desc("Calculate size of all directories recursively")
+		Desc("Recompute size, file count and sub-directory count of all directories, and fix the mismatched ones")
+
+	miso.Post("/compensate/dir/verify-size",
+		func(inb *miso.Inbound) (DirSizeReport, error) {
+			return VerifyDirSizeEp(inb.Rail(), mysql.GetMySQL())
+		}).
+		Desc("Compare the stored size, file count and sub-directory count of all directories with a full recompute, nothing is changed")
+
+	miso.Post("/gc/physic-delete/run",
+		func(inb *miso.Inbound) (string, error) {
+			return TriggerPhysicDeleteGcEp(inb.Rail(), mysql.GetMySQL())
+		}).
+		Desc("Trigger physical deletion GC asynchronously, returns the runNo")
+
+	miso.Post("/gc/physic-delete/dry-run",
+		func(inb *miso.Inbound) (PhysicDeleteGcReport, error) {
+			return DryRunPhysicDeleteGcEp(inb.Rail(), mysql.GetMySQL())
+		}).
+		Desc("Run physical deletion GC in dry-run mode, report the files that would be processed")
+
+	miso.IPost("/gc/physic-delete/report",
+		func(inb *miso.Inbound, req ListGcRunReq) (miso.PageRes[GcRun], error) {
+			return ListGcRunsEp(inb.Rail(), mysql.GetMySQL(), req)
+		}).
+		Desc("List physical deletion GC reports")
+
+	miso.Post("/compensate/reconcile/fstore",
+		func(inb *miso.Inbound) (string, error) {
+			return TriggerReconcileFstoreEp(inb.Rail(), mysql.GetMySQL())
+		}).
+		Desc("Trigger reconciliation between file_info and mini-fstore asynchronously, returns the runNo")
+
+	miso.IPost("/compensate/reconcile/fstore/report",
+		func(inb *miso.Inbound, req ListReconcileRunReq) (miso.PageRes[ReconcileRun], error) {
+			return ListReconcileRunsEp(inb.Rail(), mysql.GetMySQL(), req)
+		}).
+		Desc("List reconciliation reports")
+
+	miso.RawGet("/compensate/reconcile/fstore/report/download", DownloadReconcileReportEp).
+		Desc("Download dangling references found in the reconciliation run as csv").
+		DocQueryParam("runNo", "Reconciliation runNo")
 
 	miso.Put("/bookmark/file/upload",
 		func(inb *miso.Inbound) (any, error) {
@@ -0,0 +1,323 @@
+package vfm
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/curtisnewbie/miso/middleware/mysql"
+	"github.com/curtisnewbie/miso/middleware/rabbit"
+	"github.com/curtisnewbie/miso/middleware/user-vault/common"
+	"github.com/curtisnewbie/miso/miso"
+	"github.com/curtisnewbie/miso/util"
+	"gorm.io/gorm"
+)
+
+const (
+	NotifiTypeVFolderShared      = "VFOLDER_SHARED"       // vfolder shared to the user
+	NotifiTypeVFolderFileAdded   = "VFOLDER_FILE_ADDED"   // files added to a vfolder that the user has access to
+	NotifiTypeVFolderFileRemoved = "VFOLDER_FILE_REMOVED" // files removed from a subscribed vfolder
+	NotifiTypeDirChanged         = "DIR_CHANGED"          // files in subscribed directory changed
+	NotifiTypeJobFinished        = "JOB_FINISHED"         // async job finished
+	NotifiTypeJobFailed          = "JOB_FAILED"           // async job failed
+
+	NotifiRefTypeFile    = "FILE"
+	NotifiRefTypeVFolder = "VFOLDER"
+
+	SubTargetDir     = "DIR"     // subscription target - directory
+	SubTargetVFolder = "VFOLDER" // subscription target - vfolder
+)
+
+var (
+	notifiTypes = util.NewSet[string]()
+
+	// types of notification that are merged into the previous unread one with the same ref_key
+	mergedNotifiTypes = util.NewSet[string]()
+
+	CreateNotifiPipeline = rabbit.NewEventPipeline[CreateNotifiEvent]("event.bus.vfm.notification.create").
+				MaxRetry(3)
+)
+
+func init() {
+	notifiTypes.AddAll([]string{NotifiTypeVFolderShared, NotifiTypeVFolderFileAdded, NotifiTypeVFolderFileRemoved,
+		NotifiTypeDirChanged, NotifiTypeJobFinished, NotifiTypeJobFailed})
+	mergedNotifiTypes.AddAll([]string{NotifiTypeDirChanged, NotifiTypeVFolderFileAdded, NotifiTypeVFolderFileRemoved})
+}
+
+type CreateNotifiEvent struct {
+	UserNos []string
+	Type    string
+	Title   string
+	Message string
+	RefType string
+	RefKey  string
+}
+
+func OnCreateNotifiEvent(rail miso.Rail, evt CreateNotifiEvent) error {
+	return SaveNotifications(rail, mysql.GetMySQL(), evt)
+}
+
+// Send notification asynchronously, failure is only logged, notification should never break the actual business logic.
+func SendNotification(rail miso.Rail, evt CreateNotifiEvent) {
+	evt.UserNos = util.Distinct(evt.UserNos)
+	if len(evt.UserNos) < 1 {
+		return
+	}
+	if err := CreateNotifiPipeline.Send(rail, evt); err != nil {
+		rail.Errorf("failed to send CreateNotifiEvent, %+v, %v", evt, err)
+	}
+}
+
+func SaveNotifications(rail miso.Rail, db *gorm.DB, evt CreateNotifiEvent) error {
+	for _, userNo := range util.Distinct(evt.UserNos) {
+		muted, err := isNotifiMuted(db, userNo, evt.Type)
+		if err != nil {
+			return err
+		}
+		if muted {
+			rail.Debugf("Notification type %v muted by %v", evt.Type, userNo)
+			continue
+		}
+
+		if mergedNotifiTypes.Has(evt.Type) && evt.RefKey != "" {
+			t := db.Exec(`UPDATE notification SET message = ?, merged = merged + 1, update_time = ?
+				WHERE user_no = ? AND type = ? AND ref_key = ? AND is_read = 0 AND is_del = 0`,
+				evt.Message, util.Now(), userNo, evt.Type, evt.RefKey)
+			if t.Error != nil {
+				return fmt.Errorf("failed to merge notification, userNo: %v, %+v, %v", userNo, evt, t.Error)
+			}
+			if t.RowsAffected > 0 {
+				continue
+			}
+		}
+
+		err = db.Exec(`INSERT INTO notification (notifi_no, user_no, type, title, message, ref_type, ref_key)
+			VALUES (?,?,?,?,?,?,?)`,
+			util.GenIdP("notifi_"), userNo, evt.Type, util.MaxLenStr(evt.Title, 255), util.MaxLenStr(evt.Message, 1000),
+			evt.RefType, evt.RefKey).Error
+		if err != nil {
+			return fmt.Errorf("failed to save notification, userNo: %v, %+v, %v", userNo, evt, err)
+		}
+	}
+	return nil
+}
+
+func isNotifiMuted(db *gorm.DB, userNo string, typ string) (bool, error) {
+	var id int
+	err := db.Raw(`SELECT id FROM notification_pref WHERE user_no = ? AND type = ? AND muted = 1 LIMIT 1`, userNo, typ).
+		Scan(&id).Error
+	if err != nil {
+		return false, fmt.Errorf("failed to query notification_pref, userNo: %v, type: %v, %v", userNo, typ, err)
+	}
+	return id > 0, nil
+}
+
+type ListNotifiReq struct {
+	Paging     miso.Paging `json:"paging"`
+	OnlyUnread bool        `json:"onlyUnread" desc:"only list unread notifications"`
+}
+
+type ListedNotifi struct {
+	NotifiNo   string     `json:"notifiNo"`
+	Type       string     `json:"type"`
+	Title      string     `json:"title"`
+	Message    string     `json:"message"`
+	RefType    string     `json:"refType"`
+	RefKey     string     `json:"refKey"`
+	Merged     int        `json:"merged" desc:"number of notifications merged into this one"`
+	IsRead     bool       `json:"isRead"`
+	CreateTime util.ETime `json:"createTime"`
+	UpdateTime util.ETime `json:"updateTime"`
+}
+
+func ListNotifications(rail miso.Rail, db *gorm.DB, req ListNotifiReq, user common.User) (miso.PageRes[ListedNotifi], error) {
+	return mysql.NewPageQuery[ListedNotifi]().
+		WithPage(req.Paging).
+		WithBaseQuery(func(tx *gorm.DB) *gorm.DB {
+			tx = tx.Table("notification").
+				Where("user_no = ?", user.UserNo).
+				Where("is_del = 0")
+			if req.OnlyUnread {
+				tx = tx.Where("is_read = 0")
+			}
+			return tx
+		}).
+		WithSelectQuery(func(tx *gorm.DB) *gorm.DB {
+			return tx.Select("notifi_no, type, title, message, ref_type, ref_key, merged, is_read, create_time, update_time").
+				Order("update_time DESC, id DESC")
+		}).
+		Exec(rail, db)
+}
+
+func CountUnreadNotifications(rail miso.Rail, db *gorm.DB, user common.User) (int, error) {
+	var cnt int
+	err := db.Raw(`SELECT COUNT(*) FROM notification WHERE user_no = ? AND is_read = 0 AND is_del = 0`, user.UserNo).
+		Scan(&cnt).Error
+	return cnt, err
+}
+
+type MarkNotifiReadReq struct {
+	NotifiNo string `json:"notifiNo" valid:"notEmpty"`
+}
+
+func MarkNotificationRead(rail miso.Rail, db *gorm.DB, req MarkNotifiReadReq, user common.User) error {
+	return db.Exec(`UPDATE notification SET is_read = 1, read_time = ? WHERE notifi_no = ? AND user_no = ? AND is_read = 0`,
+		util.Now(), req.NotifiNo, user.UserNo).Error
+}
+
+func MarkAllNotificationsRead(rail miso.Rail, db *gorm.DB, user common.User) error {
+	return db.Exec(`UPDATE notification SET is_read = 1, read_time = ? WHERE user_no = ? AND is_read = 0`,
+		util.Now(), user.UserNo).Error
+}
+
+type NotifiPref struct {
+	Type  string `json:"type"`
+	Muted bool   `json:"muted"`
+}
+
+// List user's notification preferences, types that are not configured are unmuted.
+func ListNotifiPrefs(rail miso.Rail, db *gorm.DB, user common.User) ([]NotifiPref, error) {
+	var muted []string
+	err := db.Raw(`SELECT type FROM notification_pref WHERE user_no = ? AND muted = 1`, user.UserNo).
+		Scan(&muted).Error
+	if err != nil {
+		return nil, err
+	}
+	mutedSet := util.NewSet[string]()
+	mutedSet.AddAll(muted)
+
+	types := notifiTypes.CopyKeys()
+	sort.Strings(types)
+	prefs := make([]NotifiPref, 0, len(types))
+	for _, t := range types {
+		prefs = append(prefs, NotifiPref{Type: t, Muted: mutedSet.Has(t)})
+	}
+	return prefs, nil
+}
+
+type UpdateNotifiPrefReq struct {
+	Type  string `json:"type" valid:"notEmpty"`
+	Muted bool   `json:"muted"`
+}
+
+func UpdateNotifiPref(rail miso.Rail, db *gorm.DB, req UpdateNotifiPrefReq, user common.User) error {
+	if !notifiTypes.Has(req.Type) {
+		return miso.NewErrf("Illegal notification type")
+	}
+	return db.Exec(`INSERT INTO notification_pref (user_no, type, muted) VALUES (?,?,?)
+		ON DUPLICATE KEY UPDATE muted = ?`, user.UserNo, req.Type, req.Muted, req.Muted).Error
+}
+
+type SubscribeReq struct {
+	TargetType string `json:"targetType" valid:"notEmpty" desc:"target type: DIR, VFOLDER"`
+	TargetKey  string `json:"targetKey" valid:"notEmpty" desc:"directory file key or vfolder no"`
+}
+
+func Subscribe(rail miso.Rail, db *gorm.DB, req SubscribeReq, user common.User) error {
+	switch req.TargetType {
+	case SubTargetDir:
+		f, err := findFile(rail, db, req.TargetKey)
+		if err != nil {
+			return err
+		}
+		if f == nil || f.IsLogicDeleted == LDelY {
+			return miso.NewErrf("File not found")
+		}
+		if f.FileType != FileTypeDir {
+			return miso.NewErrf("Not a directory")
+		}
+		if f.UploaderNo != user.UserNo {
+			return miso.NewErrf("Not permitted")
+		}
+	case SubTargetVFolder:
+		if _, err := findVFolder(rail, db, req.TargetKey, user.UserNo); err != nil {
+			return miso.NewErrf("VFolder not found").WithInternalMsg("%v", err)
+		}
+	default:
+		return miso.NewErrf("Illegal target type")
+	}
+
+	return db.Exec(`INSERT INTO notification_sub (user_no, target_type, target_key, create_by) VALUES (?,?,?,?)
+		ON DUPLICATE KEY UPDATE is_del = 0, update_by = ?`,
+		user.UserNo, req.TargetType, req.TargetKey, user.Username, user.Username).Error
+}
+
+func Unsubscribe(rail miso.Rail, db *gorm.DB, req SubscribeReq, user common.User) error {
+	return db.Exec(`UPDATE notification_sub SET is_del = 1, update_by = ? WHERE user_no = ? AND target_type = ? AND target_key = ?`,
+		user.Username, user.UserNo, req.TargetType, req.TargetKey).Error
+}
+
+type ListSubscriptionReq struct {
+	Paging miso.Paging `json:"paging"`
+}
+
+type ListedSubscription struct {
+	TargetType string     `json:"targetType"`
+	TargetKey  string     `json:"targetKey"`
+	CreateTime util.ETime `json:"createTime"`
+}
+
+func ListSubscriptions(rail miso.Rail, db *gorm.DB, req ListSubscriptionReq, user common.User) (miso.PageRes[ListedSubscription], error) {
+	return mysql.NewPageQuery[ListedSubscription]().
+		WithPage(req.Paging).
+		WithBaseQuery(func(tx *gorm.DB) *gorm.DB {
+			return tx.Table("notification_sub").
+				Where("user_no = ? AND is_del = 0", user.UserNo)
+		}).
+		WithSelectQuery(func(tx *gorm.DB) *gorm.DB {
+			return tx.Select("target_type, target_key, create_time").Order("id DESC")
+		}).
+		Exec(rail, db)
+}
+
+func findSubscribers(db *gorm.DB, targetType string, targetKey string) ([]string, error) {
+	var userNos []string
+	err := db.Raw(`SELECT user_no FROM notification_sub WHERE target_type = ? AND target_key = ? AND is_del = 0`,
+		targetType, targetKey).Scan(&userNos).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to query notification_sub, %v: %v, %v", targetType, targetKey, err)
+	}
+	return userNos, nil
+}
+
+func findVFolderMembers(db *gorm.DB, folderNo string) ([]string, error) {
+	var userNos []string
+	err := db.Raw(`SELECT user_no FROM user_vfolder WHERE folder_no = ? AND is_del = 0`, folderNo).
+		Scan(&userNos).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to query user_vfolder, folderNo: %v, %v", folderNo, err)
+	}
+	return userNos, nil
+}
+
+// Notify subscribers of the directory that something in the directory has changed.
+func notifyDirChanged(rail miso.Rail, db *gorm.DB, dirFileKey string, message string) {
+	if dirFileKey == "" {
+		return
+	}
+	subscribers, err := findSubscribers(db, SubTargetDir, dirFileKey)
+	if err != nil {
+		rail.Errorf("failed to find subscribers, dir: %v, %v", dirFileKey, err)
+		return
+	}
+	if len(subscribers) < 1 {
+		return
+	}
+	dir, err := findFile(rail, db, dirFileKey)
+	if err != nil || dir == nil {
+		rail.Errorf("failed to find dir: %v, %v", dirFileKey, err)
+		return
+	}
+	SendNotification(rail, CreateNotifiEvent{
+		UserNos: subscribers,
+		Type:    NotifiTypeDirChanged,
+		Title:   fmt.Sprintf("Directory '%v' changed", dir.Name),
+		Message: message,
+		RefType: NotifiRefTypeFile,
+		RefKey:  dirFileKey,
+	})
+}
+
+// Filter out the operator from receivers.
+func excludeUserNo(userNos []string, userNo string) []string {
+	return util.CopyFilter(userNos, func(u string) bool { return u != userNo })
+}
@@ -0,0 +1,318 @@
+package vfm
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	fstore "github.com/curtisnewbie/mini-fstore/api"
+	"github.com/curtisnewbie/miso/middleware/mysql"
+	"github.com/curtisnewbie/miso/middleware/rabbit"
+	"github.com/curtisnewbie/miso/miso"
+	"github.com/curtisnewbie/miso/util"
+	"gorm.io/gorm"
+)
+
+const (
+	PropPreviewPdfCommand     = "vfm.preview.pdf.command"      // command to render the first page of pdf as jpeg
+	PropPreviewPdfTextCommand = "vfm.preview.pdf.text-command" // command to extract text from the first page of pdf
+	PropPreviewPdfMaxSize     = "vfm.preview.pdf.max-size"     // in bytes
+	PropPreviewCommandTimeout = "vfm.preview.command-timeout"  // in seconds
+	PropPreviewExcerptLen     = "vfm.preview.excerpt.max-len"  // in characters
+
+	MimePdf = "application/pdf"
+
+	// number of bytes read from the beginning of text files to generate the excerpt.
+	excerptReadLen = 4096
+
+	// size of the rendered pdf page (the longer edge).
+	pdfPreviewSize = 1024
+)
+
+var (
+	GenPdfPreviewPipeline = rabbit.NewEventPipeline[GenPdfPreviewEvent]("event.bus.vfm.preview.pdf.generate").
+				LogPayload()
+
+	_textMime = util.NewSet[string]()
+)
+
+func init() {
+	miso.SetDefProp(PropPreviewPdfCommand, "pdftoppm")
+	miso.SetDefProp(PropPreviewPdfTextCommand, "pdftotext")
+	miso.SetDefProp(PropPreviewPdfMaxSize, 100*1024*1024)
+	miso.SetDefProp(PropPreviewCommandTimeout, 60)
+	miso.SetDefProp(PropPreviewExcerptLen, 300)
+
+	_textMime.AddAll([]string{"application/json", "application/xml", "application/x-yaml", "application/x-sh"})
+}
+
+type GenPdfPreviewEvent struct {
+	FileKey string
+}
+
+// Whether the file is a PDF document.
+func (f FileInfo) IsPdf() bool {
+	if f.MimeType == "" {
+		return fileExt(f.Name) == "pdf"
+	}
+	return f.MimeType == MimePdf
+}
+
+// Whether the media type is text-like, html is excluded as the markup is not readable as an excerpt.
+func isTextMime(m string) bool {
+	if strings.HasPrefix(m, "text/") {
+		return m != "text/html"
+	}
+	return _textMime.Has(m)
+}
+
+func sendPdfPreviewEvent(rail miso.Rail, fileKey string) error {
+	evt := GenPdfPreviewEvent{FileKey: fileKey}
+	if err := GenPdfPreviewPipeline.Send(rail, evt); err != nil {
+		return fmt.Errorf("failed to send %#v, %v", evt, err)
+	}
+	return nil
+}
+
+func OnGenPdfPreviewEvent(rail miso.Rail, evt GenPdfPreviewEvent) error {
+	return GeneratePdfPreview(rail, mysql.GetMySQL(), evt.FileKey)
+}
+
+// Render the first page of the pdf as the thumbnail, and extract the text of the first page as the excerpt.
+//
+// The thumbnail status is updated the same way as the thumbnails generated by mini-fstore,
+// failed renderings are retried by RetryThumbnailGenerations.
+func GeneratePdfPreview(rail miso.Rail, db *gorm.DB, fileKey string) error {
+	defer miso.TimeOp(rail, time.Now(), "GeneratePdfPreview")
+
+	f, err := findFile(rail, db, fileKey)
+	if err != nil {
+		return fmt.Errorf("failed to find file, uuid: %v, %v", fileKey, err)
+	}
+	if f == nil || f.IsLogicDeleted == LDelY || !f.IsPdf() {
+		return nil
+	}
+	if f.SizeInBytes > int64(miso.GetPropInt(PropPreviewPdfMaxSize)) {
+		rail.Infof("PDF is too large for preview, uuid: %v, size: %v", fileKey, f.SizeInBytes)
+		return db.Exec(`UPDATE file_info SET thumbnail_status = ?, thumbnail_err = ?, thumbnail_retry_time = NULL WHERE uuid = ?`,
+			ThumbnailUnsupported, "File is too large for preview", fileKey).Error
+	}
+
+	thumbnail, excerpt, err := renderPdfPreview(rail, *f)
+	if err != nil {
+		rail.Errorf("Failed to render pdf preview, uuid: %v, %v", fileKey, err)
+		return onPdfPreviewFailed(rail, db, fileKey, err)
+	}
+
+	if excerpt != "" {
+		if err := saveExcerpt(db, fileKey, excerpt); err != nil {
+			return err
+		}
+	}
+	return OnThumbnailGenerated(rail, db, fileKey, thumbnail)
+}
+
+func onPdfPreviewFailed(rail miso.Rail, db *gorm.DB, fileKey string, cause error) error {
+	lock := fileLock(rail, fileKey)
+	if err := lock.Lock(); err != nil {
+		return err
+	}
+	defer lock.Unlock()
+
+	f, err := findFile(rail, db, fileKey)
+	if err != nil {
+		return fmt.Errorf("failed to find file, uuid: %v, %v", fileKey, err)
+	}
+	if f == nil {
+		return nil
+	}
+	return markThumbnailFailed(rail, db, fileKey, f.ThumbnailAttempts, "PDF preview failed, "+cause.Error())
+}
+
+// Download the pdf, render the first page and upload it to mini-fstore.
+//
+// The mini-fstore file_id of the rendered page is returned along with the excerpt, the excerpt is empty if the
+// text can't be extracted.
+func renderPdfPreview(rail miso.Rail, f FileInfo) (string, string, error) {
+	dir, err := os.MkdirTemp(miso.GetPropStr(PropTempPath), "preview_")
+	if err != nil {
+		return "", "", fmt.Errorf("failed to create temp dir, %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	src := filepath.Join(dir, "src.pdf")
+	if err := downloadFstoreFile(rail, f.FstoreFileId, src); err != nil {
+		return "", "", err
+	}
+
+	// pdftoppm appends the extension to the output prefix
+	out := filepath.Join(dir, "page")
+	if _, err := runPreviewCommand(miso.GetPropStr(PropPreviewPdfCommand),
+		"-f", "1", "-l", "1", "-singlefile", "-jpeg", "-scale-to", fmt.Sprint(pdfPreviewSize), src, out); err != nil {
+		return "", "", err
+	}
+	page, err := os.Open(out + ".jpg")
+	if err != nil {
+		return "", "", fmt.Errorf("failed to open rendered page, %v", err)
+	}
+	defer page.Close()
+
+	uploadFileId, err := fstore.UploadFile(rail, f.Name+"_preview.jpg", page)
+	if err != nil {
+		return "", "", err
+	}
+	ff, err := fstore.FetchFileInfo(rail, fstore.FetchFileInfoReq{UploadFileId: uploadFileId})
+	if err != nil {
+		return "", "", fmt.Errorf("failed to fetch uploaded preview, uploadFileId: %v, %w", uploadFileId, err)
+	}
+
+	// the excerpt is optional, the text may not be extractable (e.g., scanned documents)
+	var excerpt string
+	if txt, err := runPreviewCommand(miso.GetPropStr(PropPreviewPdfTextCommand),
+		"-f", "1", "-l", "1", "-enc", "UTF-8", src, "-"); err != nil {
+		rail.Warnf("Failed to extract text from pdf, uuid: %v, %v", f.Uuid, err)
+	} else {
+		excerpt = buildExcerpt(txt, miso.GetPropInt(PropPreviewExcerptLen))
+	}
+	return ff.FileId, excerpt, nil
+}
+
+func downloadFstoreFile(rail miso.Rail, fstoreFileId string, path string) error {
+	out, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("failed to create temp file, %v", err)
+	}
+	defer out.Close()
+	if err := fstore.DownloadFileDirect(rail, fstoreFileId, out); err != nil {
+		return fmt.Errorf("failed to download mini-fstore file, fileId: %v, %v", fstoreFileId, err)
+	}
+	return nil
+}
+
+// Run external command with timeout, the stdout is returned.
+func runPreviewCommand(name string, args ...string) ([]byte, error) {
+	if _, err := exec.LookPath(name); err != nil {
+		return nil, fmt.Errorf("command '%v' not found, %v", name, err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), miso.GetPropDur(PropPreviewCommandTimeout, time.Second))
+	defer cancel()
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return nil, fmt.Errorf("command '%v' timed out", name)
+		}
+		return nil, fmt.Errorf("command '%v' failed, %v, %v", name, err, util.MaxLenStr(stderr.String(), 200))
+	}
+	return stdout.Bytes(), nil
+}
+
+// Build a short plain text excerpt, whitespaces are collapsed and markdown heading/quote markers are removed.
+func buildExcerpt(b []byte, maxLen int) string {
+	b = bytes.TrimPrefix(b, []byte("\xef\xbb\xbf")) // utf-8 bom
+	// the content may be cut in the middle of a multi-byte character
+	for len(b) > 0 && !utf8.Valid(b) {
+		r, size := utf8.DecodeLastRune(b)
+		if r != utf8.RuneError {
+			break
+		}
+		b = b[:len(b)-size]
+	}
+
+	words := []string{}
+	for _, line := range strings.Split(strings.ToValidUTF8(string(b), ""), "\n") {
+		line = strings.TrimLeft(strings.TrimSpace(line), "#> ")
+		words = append(words, strings.Fields(line)...)
+	}
+	s := strings.Join(words, " ")
+	if utf8.RuneCountInString(s) > maxLen {
+		s = strings.TrimSpace(string([]rune(s)[:maxLen]))
+	}
+	return s
+}
+
+func saveExcerpt(tx *gorm.DB, fileKey string, excerpt string) error {
+	if err := tx.Exec(`UPDATE file_info SET excerpt = ? WHERE uuid = ?`, excerpt, fileKey).Error; err != nil {
+		return fmt.Errorf("failed to update file_info.excerpt, uuid: %v, %v", fileKey, err)
+	}
+	return nil
+}
+
+// Generate excerpt for text-like file using the first few bytes of the file.
+func generateTextExcerpt(rail miso.Rail, tx *gorm.DB, fileKey string, fstoreFileId string, name string) error {
+	head, err := readFstoreFileHead(rail, fstoreFileId, name, excerptReadLen)
+	if err != nil {
+		return err
+	}
+	return saveExcerpt(tx, fileKey, buildExcerpt(head, miso.GetPropInt(PropPreviewExcerptLen)))
+}
+
+// Compensate previews of existing documents.
+//
+// PDFs without thumbnails are rendered (including those that were considered unsupported before previews are
+// introduced), and excerpts are generated for text-like files that don't have one.
+//
+// PDFs that have failed are left to the thumbnail retry task, or regenerated by users once they have failed
+// permanently, so their attempts are not reset over and over again.
+func CompensatePreview(rail miso.Rail, db *gorm.DB) error {
+	rail.Info("CompensatePreview start")
+	defer miso.TimeOp(rail, time.Now(), "CompensatePreview")
+
+	// PDFs that are given up by vfm (e.g., too large for preview) are marked UNSUPPORTED with thumbnail_err,
+	// these are skipped, otherwise they are picked up again on every run
+	maxPdfSize := int64(miso.GetPropInt(PropPreviewPdfMaxSize))
+	minId := 0
+	for {
+		var files []FileInfo
+		err := db.Raw(`SELECT id, uuid, name, fstore_file_id, mime_type, thumbnail, thumbnail_status, thumbnail_err, size_in_bytes
+			FROM file_info
+			WHERE id > ?
+			AND file_type = 'file'
+			AND is_logic_deleted = 0
+			AND ((mime_type = ? AND thumbnail = '' AND thumbnail_status NOT IN ? AND size_in_bytes <= ?
+					AND NOT (thumbnail_status = ? AND thumbnail_err != ''))
+				OR (excerpt = '' AND size_in_bytes > 0 AND (mime_type LIKE 'text/%' OR mime_type IN ?)))
+			ORDER BY id ASC
+			LIMIT 500`, minId, MimePdf, []string{ThumbnailPending, ThumbnailFailed}, maxPdfSize,
+			ThumbnailUnsupported, _textMime.CopyKeys()).
+			Scan(&files).Error
+		if err != nil {
+			return fmt.Errorf("failed to list files to compensate previews, minId: %v, %v", minId, err)
+		}
+		if len(files) < 1 {
+			return nil
+		}
+
+		for _, f := range files {
+			if f.IsPdf() && f.Thumbnail == "" && f.ThumbnailStatus != ThumbnailPending && f.ThumbnailStatus != ThumbnailFailed &&
+				f.SizeInBytes <= maxPdfSize && !(f.ThumbnailStatus == ThumbnailUnsupported && f.ThumbnailErr != "") {
+				if err := resetThumbnailAttempts(db, f.Uuid); err != nil {
+					return err
+				}
+				if err := triggerThumbnailGeneration(rail, db, f.Uuid, f.FstoreFileId, f.Name, f.MimeType); err != nil {
+					return err
+				}
+				continue
+			}
+			if !isTextMime(f.MimeType) {
+				continue
+			}
+			if err := generateTextExcerpt(rail, db, f.Uuid, f.FstoreFileId, f.Name); err != nil {
+				rail.Errorf("Failed to generate excerpt, uuid: %v, %v", f.Uuid, err)
+			}
+		}
+
+		minId = files[len(files)-1].Id
+		rail.Infof("CompensatePreview, minId: %v", minId)
+	}
+}
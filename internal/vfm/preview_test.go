@@ -0,0 +1,23 @@
+package vfm
+
+import "testing"
+
+func TestBuildExcerpt(t *testing.T) {
+	for _, c := range []struct {
+		in     string
+		maxLen int
+		want   string
+	}{
+		{"# Title\n\nSome   text\r\nnext line", 100, "Title Some text next line"},
+		{"\xef\xbb\xbfhello", 100, "hello"},
+		{"> quoted\n- item", 100, "quoted - item"},
+		{"abcdef", 3, "abc"},
+		{"你好世界", 2, "你好"},
+		{"ok \xe4\xbd", 100, "ok"},
+		{"", 100, ""},
+	} {
+		if got := buildExcerpt([]byte(c.in), c.maxLen); got != c.want {
+			t.Errorf("%q: want %q, got %q", c.in, c.want, got)
+		}
+	}
+}
@@ -0,0 +1,309 @@
+package vfm
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	fstore "github.com/curtisnewbie/mini-fstore/api"
+	"github.com/curtisnewbie/miso/middleware/mysql"
+	"github.com/curtisnewbie/miso/middleware/redis"
+	"github.com/curtisnewbie/miso/miso"
+	"github.com/curtisnewbie/miso/util"
+	"gorm.io/gorm"
+)
+
+const (
+	PropReconcileBatchSize = "vfm.reconcile.fstore.batch-size"
+
+	ReconcileRefFile      = "FILE"
+	ReconcileRefThumbnail = "THUMBNAIL"
+
+	ReconcileProblemMissing = "MISSING"
+	ReconcileProblemDeleted = "DELETED"
+)
+
+func init() {
+	miso.SetDefProp(PropReconcileBatchSize, 200)
+}
+
+type reconcileFileInf struct {
+	Id           int
+	Uuid         string
+	Name         string
+	UploaderNo   string
+	FstoreFileId string
+	Thumbnail    string
+	MimeType     string
+}
+
+type ReconcileRun struct {
+	RunNo             string      `json:"runNo"`
+	Status            string      `json:"status" desc:"RUNNING, FINISHED, FAILED"`
+	Scanned           int         `json:"scanned" desc:"number of files scanned"`
+	Dangling          int         `json:"dangling" desc:"number of dangling references found"`
+	ThumbnailsCleared int         `json:"thumbnailsCleared" desc:"number of broken thumbnails cleared"`
+	ErrMsg            string      `json:"errMsg"`
+	StartTime         util.ETime  `json:"startTime"`
+	EndTime           *util.ETime `json:"endTime"`
+}
+
+type reconcileItem struct {
+	Id           int
+	FileKey      string
+	Name         string
+	UploaderNo   string
+	RefType      string
+	FstoreFileId string
+	Problem      string
+	CreateTime   util.ETime
+}
+
+// Scheduled reconciliation between file_info and mini-fstore.
+func ReconcileFstoreTask(rail miso.Rail) error {
+	lock := newReconcileFstoreLock(rail)
+	if err := lock.Lock(); err != nil {
+		return miso.NewErrf("Reconciliation is running, please try again later").WithInternalMsg("%v", err)
+	}
+	defer lock.Unlock()
+
+	db := mysql.GetMySQL()
+	runNo, err := startReconcileRun(db)
+	if err != nil {
+		return err
+	}
+	return doReconcileFstore(rail, db, runNo)
+}
+
+// Trigger reconciliation between file_info and mini-fstore asynchronously, returns the runNo.
+//
+// file_info records are scanned in batches, records that reference missing or deleted mini-fstore files are reported,
+// broken thumbnails are cleared and regenerated if possible.
+func TriggerReconcileFstore(rail miso.Rail, db *gorm.DB) (string, error) {
+	lock := newReconcileFstoreLock(rail)
+	if err := lock.Lock(); err != nil {
+		return "", miso.NewErrf("Reconciliation is running, please try again later").WithInternalMsg("%v", err)
+	}
+	runNo, err := startReconcileRun(db)
+	if err != nil {
+		lock.Unlock()
+		return "", err
+	}
+	vfmPool.Go(func() {
+		defer lock.Unlock()
+		rail := rail.NextSpan()
+		if err := doReconcileFstore(rail, db, runNo); err != nil {
+			rail.Errorf("Reconciliation failed, runNo: %v, %v", runNo, err)
+		}
+	})
+	return runNo, nil
+}
+
+func newReconcileFstoreLock(rail miso.Rail) *redis.RLock {
+	return redis.NewRLock(rail, "vfm:reconcile:fstore")
+}
+
+func startReconcileRun(db *gorm.DB) (string, error) {
+	runNo := util.GenIdP("rec_")
+	err := db.Exec(`INSERT INTO reconcile_run (run_no, status, start_time) VALUES (?,?,?)`,
+		runNo, RunStatusRunning, util.Now()).Error
+	if err != nil {
+		return "", fmt.Errorf("failed to save reconcile_run, %v", err)
+	}
+	return runNo, nil
+}
+
+func doReconcileFstore(rail miso.Rail, db *gorm.DB, runNo string) error {
+	rail.Infof("Reconciliation start, runNo: %v", runNo)
+	defer miso.TimeOp(rail, time.Now(), "ReconcileFstore")
+
+	run := ReconcileRun{RunNo: runNo}
+	err := scanReconcileFstore(rail, db, &run)
+
+	status := RunStatusFinished
+	errMsg := ""
+	if err != nil {
+		status = RunStatusFailed
+		errMsg = util.MaxLenStr(err.Error(), 1000)
+	}
+	if e := db.Exec(`UPDATE reconcile_run SET status = ?, scanned = ?, dangling = ?, thumbnails_cleared = ?, err_msg = ?, end_time = ?
+		WHERE run_no = ?`, status, run.Scanned, run.Dangling, run.ThumbnailsCleared, errMsg, util.Now(), runNo).Error; e != nil {
+		rail.Errorf("failed to update reconcile_run, runNo: %v, %v", runNo, e)
+	}
+	rail.Infof("Reconciliation end, runNo: %v, scanned: %v, dangling: %v, thumbnailsCleared: %v", runNo, run.Scanned,
+		run.Dangling, run.ThumbnailsCleared)
+	return err
+}
+
+func scanReconcileFstore(rail miso.Rail, db *gorm.DB, run *ReconcileRun) error {
+	limit := miso.GetPropInt(PropReconcileBatchSize)
+	minId := 0
+
+	for {
+		var files []reconcileFileInf
+		err := db.Raw(`SELECT id, uuid, name, uploader_no, fstore_file_id, thumbnail, mime_type
+			FROM file_info
+			WHERE id > ?
+			AND file_type = 'file'
+			AND is_logic_deleted = 0
+			ORDER BY id ASC
+			LIMIT ?`, minId, limit).
+			Scan(&files).Error
+		if err != nil {
+			return fmt.Errorf("failed to list files, minId: %v, %v", minId, err)
+		}
+		if len(files) < 1 {
+			return nil // the end
+		}
+
+		for _, f := range files {
+			run.Scanned += 1
+
+			fileProblem, err := checkFstoreFile(rail, f.FstoreFileId)
+			if err != nil {
+				return err
+			}
+			if fileProblem != "" {
+				run.Dangling += 1
+				if err := saveReconcileItem(db, run.RunNo, f, ReconcileRefFile, f.FstoreFileId, fileProblem); err != nil {
+					return err
+				}
+			}
+
+			if f.Thumbnail == "" {
+				continue
+			}
+			thumbnailProblem, err := checkFstoreFile(rail, f.Thumbnail)
+			if err != nil {
+				return err
+			}
+			if thumbnailProblem == "" {
+				continue
+			}
+			run.Dangling += 1
+			if err := saveReconcileItem(db, run.RunNo, f, ReconcileRefThumbnail, f.Thumbnail, thumbnailProblem); err != nil {
+				return err
+			}
+
+			cleared, err := clearBrokenThumbnail(db, f)
+			if err != nil {
+				return err
+			}
+			if !cleared {
+				continue
+			}
+			run.ThumbnailsCleared += 1
+
+			// the thumbnail can only be regenerated when the file itself is still there
+			if fileProblem == "" {
+				if err := triggerThumbnailGeneration(rail, db, f.Uuid, f.FstoreFileId, f.Name, f.MimeType); err != nil {
+					return err
+				}
+			}
+		}
+
+		minId = files[len(files)-1].Id
+		rail.Infof("Reconciliation, minId: %v, scanned: %v, dangling: %v", minId, run.Scanned, run.Dangling)
+	}
+}
+
+// Check whether the mini-fstore file is still available, returns the problem found or empty string if none.
+func checkFstoreFile(rail miso.Rail, fileId string) (string, error) {
+	if fileId == "" {
+		return ReconcileProblemMissing, nil
+	}
+	ff, err := fstore.FetchFileInfo(rail, fstore.FetchFileInfoReq{FileId: fileId})
+	if err != nil {
+		if errors.Is(err, fstore.ErrFileNotFound) {
+			return ReconcileProblemMissing, nil
+		}
+		if errors.Is(err, fstore.ErrFileDeleted) {
+			return ReconcileProblemDeleted, nil
+		}
+		return "", fmt.Errorf("failed to fetch mini-fstore file info, fileId: %v, %v", fileId, err)
+	}
+	if ff.Status != fstore.FileStatusNormal {
+		return ReconcileProblemDeleted, nil
+	}
+	return "", nil
+}
+
+func saveReconcileItem(db *gorm.DB, runNo string, f reconcileFileInf, refType string, fileId string, problem string) error {
+	err := db.Exec(`INSERT INTO reconcile_item (run_no, file_key, name, uploader_no, ref_type, fstore_file_id, problem, create_time)
+		VALUES (?,?,?,?,?,?,?,?)`, runNo, f.Uuid, f.Name, f.UploaderNo, refType, fileId, problem, util.Now()).Error
+	if err != nil {
+		return fmt.Errorf("failed to save reconcile_item, runNo: %v, uuid: %v, %v", runNo, f.Uuid, err)
+	}
+	return nil
+}
+
+func clearBrokenThumbnail(db *gorm.DB, f reconcileFileInf) (bool, error) {
+	t := db.Exec(`UPDATE file_info SET thumbnail = '' WHERE id = ? AND thumbnail = ?`, f.Id, f.Thumbnail)
+	if t.Error != nil {
+		return false, fmt.Errorf("failed to clear thumbnail, uuid: %v, %v", f.Uuid, t.Error)
+	}
+	return t.RowsAffected > 0, nil
+}
+
+type ListReconcileRunReq struct {
+	Paging miso.Paging `json:"paging"`
+}
+
+// List reconciliation reports.
+func ListReconcileRuns(rail miso.Rail, db *gorm.DB, req ListReconcileRunReq) (miso.PageRes[ReconcileRun], error) {
+	return mysql.NewPageQuery[ReconcileRun]().
+		WithPage(req.Paging).
+		WithBaseQuery(func(tx *gorm.DB) *gorm.DB {
+			return tx.Table("reconcile_run")
+		}).
+		WithSelectQuery(func(tx *gorm.DB) *gorm.DB {
+			return tx.Select("run_no, status, scanned, dangling, thumbnails_cleared, err_msg, start_time, end_time").
+				Order("id DESC")
+		}).
+		Exec(rail, db)
+}
+
+// Write dangling references found in the reconciliation run as csv.
+func WriteReconcileReport(rail miso.Rail, db *gorm.DB, runNo string, w io.Writer) error {
+	var exists int
+	if err := db.Raw(`SELECT 1 FROM reconcile_run WHERE run_no = ? LIMIT 1`, runNo).Scan(&exists).Error; err != nil {
+		return fmt.Errorf("failed to find reconcile_run, runNo: %v, %v", runNo, err)
+	}
+	if exists < 1 {
+		return miso.NewErrf("Report not found")
+	}
+
+	cw := csv.NewWriter(w)
+	if err := cw.Write([]string{"file_key", "name", "uploader_no", "ref_type", "fstore_file_id", "problem", "create_time"}); err != nil {
+		return err
+	}
+
+	limit := miso.GetPropInt(PropReconcileBatchSize)
+	minId := 0
+	for {
+		var items []reconcileItem
+		err := db.Raw(`SELECT id, file_key, name, uploader_no, ref_type, fstore_file_id, problem, create_time
+			FROM reconcile_item
+			WHERE run_no = ? AND id > ?
+			ORDER BY id ASC
+			LIMIT ?`, runNo, minId, limit).
+			Scan(&items).Error
+		if err != nil {
+			return fmt.Errorf("failed to list reconcile_item, runNo: %v, %v", runNo, err)
+		}
+		if len(items) < 1 {
+			break
+		}
+		for _, it := range items {
+			if err := cw.Write([]string{it.FileKey, it.Name, it.UploaderNo, it.RefType, it.FstoreFileId, it.Problem,
+				it.CreateTime.FormatClassic()}); err != nil {
+				return err
+			}
+		}
+		minId = items[len(items)-1].Id
+	}
+	cw.Flush()
+	return cw.Error()
+}
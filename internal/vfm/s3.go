@@ -0,0 +1,1088 @@
+package vfm
+
+import (
+	"bufio"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/curtisnewbie/miso/middleware/mysql"
+	"github.com/curtisnewbie/miso/middleware/redis"
+	"github.com/curtisnewbie/miso/middleware/user-vault/common"
+	"github.com/curtisnewbie/miso/miso"
+	"github.com/curtisnewbie/miso/util"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+const (
+	PropS3Enabled          = "vfm.s3.enabled"
+	PropS3SignedPathPrefix = "vfm.s3.signed-path-prefix" // path prefix stripped by the gateway, e.g., /vfm
+
+	S3Prefix = "/s3"
+
+	s3Xmlns             = "http://s3.amazonaws.com/doc/2006-03-01/"
+	s3Algorithm         = "AWS4-HMAC-SHA256"
+	s3AmzDateFormat     = "20060102T150405Z"
+	s3MaxKeys           = 1000
+	s3MaxClockSkew      = 15 * time.Minute
+	s3MaxPresignExpires = 7 * 24 * time.Hour
+	s3MaxChunkSize      = 16 * 1024 * 1024
+
+	s3UnsignedPayload          = "UNSIGNED-PAYLOAD"
+	s3StreamingPayload         = "STREAMING-AWS4-HMAC-SHA256-PAYLOAD"
+	s3StreamingPayloadTrailer  = "STREAMING-AWS4-HMAC-SHA256-PAYLOAD-TRAILER"
+	s3StreamingUnsignedTrailer = "STREAMING-UNSIGNED-PAYLOAD-TRAILER"
+	s3EmptySha256              = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+)
+
+var (
+	s3Methods = []string{http.MethodGet, http.MethodHead, http.MethodPut, http.MethodDelete, http.MethodPost}
+
+	s3ErrAccessDenied     = newS3Err(http.StatusForbidden, "AccessDenied", "Access Denied")
+	s3ErrSignature        = newS3Err(http.StatusForbidden, "SignatureDoesNotMatch", "The request signature we calculated does not match the signature you provided")
+	s3ErrNoSuchBucket     = newS3Err(http.StatusNotFound, "NoSuchBucket", "The specified bucket does not exist")
+	s3ErrNoSuchKey        = newS3Err(http.StatusNotFound, "NoSuchKey", "The specified key does not exist")
+	s3ErrNotImplemented   = newS3Err(http.StatusNotImplemented, "NotImplemented", "The operation is not supported by vfm")
+	s3ErrInvalidRange     = newS3Err(http.StatusRequestedRangeNotSatisfiable, "InvalidRange", "The requested range is not satisfiable")
+	s3ErrSha256Mismatch   = newS3Err(http.StatusBadRequest, "XAmzContentSHA256Mismatch", "The provided 'x-amz-content-sha256' header does not match what was computed")
+	s3ErrIncompleteBody   = newS3Err(http.StatusBadRequest, "IncompleteBody", "The request body is malformed or incomplete")
+	s3ErrInvalidKey       = newS3Err(http.StatusBadRequest, "InvalidArgument", "The specified key is not valid")
+	s3ErrRequestTimeSkew  = newS3Err(http.StatusForbidden, "RequestTimeTooSkewed", "The difference between the request time and the server's time is too large")
+	s3ErrExpiredRequest   = newS3Err(http.StatusForbidden, "AccessDenied", "Request has expired")
+	s3ErrKeyIsDirectory   = newS3Err(http.StatusConflict, "InvalidRequest", "A directory with the same key exists")
+	s3ErrInvalidAccessKey = newS3Err(http.StatusForbidden, "InvalidAccessKeyId", "The AWS access key Id you provided does not exist in our records")
+)
+
+func init() {
+	miso.SetDefProp(PropS3Enabled, true)
+	miso.SetDefProp(PropS3SignedPathPrefix, "")
+}
+
+type s3Error struct {
+	status  int
+	code    string
+	message string
+}
+
+func (e *s3Error) Error() string {
+	return e.code + ": " + e.message
+}
+
+func newS3Err(status int, code string, message string) *s3Error {
+	return &s3Error{status: status, code: code, message: message}
+}
+
+type s3ErrorResp struct {
+	XMLName  xml.Name `xml:"Error"`
+	Code     string   `xml:"Code"`
+	Message  string   `xml:"Message"`
+	Resource string   `xml:"Resource"`
+}
+
+// Register S3-compatible gateway routes.
+//
+// Path-style requests are served, each user's top-level directories are buckets, nested directories are key prefixes.
+func RegisterS3Routes(rail miso.Rail) error {
+	if !miso.GetPropBool(PropS3Enabled) {
+		return nil
+	}
+	miso.PreProcessGin(func(rail miso.Rail, engine *gin.Engine) {
+		handler := func(c *gin.Context) {
+			ServeS3(miso.BuildRail(c), mysql.GetMySQL(), c.Writer, c.Request)
+		}
+		for _, m := range s3Methods {
+			engine.Handle(m, S3Prefix, handler)
+			engine.Handle(m, S3Prefix+"/*path", handler)
+		}
+	})
+	rail.Infof("S3 gateway enabled, prefix: %v", S3Prefix)
+	return nil
+}
+
+// Serve S3 request, the request is authenticated using AWS Signature Version 4 with the access keys created by users.
+func ServeS3(rail miso.Rail, db *gorm.DB, w http.ResponseWriter, r *http.Request) {
+	if err := serveS3(rail, db, w, r); err != nil {
+		var se *s3Error
+		if !errors.As(err, &se) {
+			var me *miso.MisoErr
+			if errors.As(err, &me) {
+				se = newS3Err(http.StatusBadRequest, "InvalidRequest", me.Msg)
+			} else {
+				rail.Errorf("S3 %v %v failed, %v", r.Method, r.URL.Path, err)
+				se = newS3Err(http.StatusInternalServerError, "InternalError", "We encountered an internal error, please try again")
+			}
+		}
+		writeS3Err(w, r, se)
+	}
+}
+
+func writeS3Err(w http.ResponseWriter, r *http.Request, se *s3Error) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(se.status)
+	if r.Method == http.MethodHead {
+		return
+	}
+	_ = xml.NewEncoder(w).Encode(s3ErrorResp{Code: se.code, Message: se.message, Resource: r.URL.Path})
+}
+
+func writeS3Xml(w http.ResponseWriter, v any) error {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(http.StatusOK)
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	return xml.NewEncoder(w).Encode(v)
+}
+
+func serveS3(rail miso.Rail, db *gorm.DB, w http.ResponseWriter, r *http.Request) error {
+	user, payload, err := s3Authenticate(rail, db, r)
+	if err != nil {
+		return err
+	}
+
+	p := strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, S3Prefix), "/")
+	bucketName, key, _ := strings.Cut(p, "/")
+
+	s := &s3Gateway{rail: rail, db: db, user: user}
+	if bucketName == "" {
+		if r.Method == http.MethodGet {
+			return s.listBuckets(w)
+		}
+		return s3ErrNotImplemented
+	}
+
+	bucket, err := findChildFile(rail, db, "", bucketName, user.UserNo)
+	if err != nil {
+		return err
+	}
+	if bucket == nil || bucket.FileType != FileTypeDir {
+		return s3ErrNoSuchBucket
+	}
+
+	if key == "" {
+		switch r.Method {
+		case http.MethodHead:
+			w.WriteHeader(http.StatusOK)
+			return nil
+		case http.MethodGet:
+			if r.URL.Query().Get("list-type") == "2" {
+				return s.listObjectsV2(w, r, *bucket)
+			}
+		}
+		return s3ErrNotImplemented
+	}
+
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		return s.getObject(w, r, *bucket, key)
+	case http.MethodPut:
+		if r.Header.Get("x-amz-copy-source") != "" || r.URL.Query().Has("uploadId") {
+			return s3ErrNotImplemented
+		}
+		return s.putObject(w, *bucket, key, payload)
+	case http.MethodDelete:
+		return s.deleteObject(w, *bucket, key)
+	}
+	return s3ErrNotImplemented
+}
+
+// AWS Signature Version 4 in Authorization header or query parameters (presigned url).
+type s3SigV4 struct {
+	accessKey     string
+	date          string
+	region        string
+	service       string
+	signedHeaders []string
+	signature     string
+	amzDate       string
+	payloadHash   string
+	presigned     bool
+}
+
+func (s s3SigV4) scope() string {
+	return s.date + "/" + s.region + "/" + s.service + "/aws4_request"
+}
+
+func parseS3SigV4(r *http.Request) (s3SigV4, error) {
+	var s s3SigV4
+	var credential, signedHeaders string
+
+	if auth := r.Header.Get("Authorization"); auth != "" {
+		if !strings.HasPrefix(auth, s3Algorithm+" ") {
+			return s, s3ErrAccessDenied
+		}
+		for _, kv := range strings.Split(strings.TrimPrefix(auth, s3Algorithm+" "), ",") {
+			k, v, _ := strings.Cut(strings.TrimSpace(kv), "=")
+			switch k {
+			case "Credential":
+				credential = v
+			case "SignedHeaders":
+				signedHeaders = v
+			case "Signature":
+				s.signature = v
+			}
+		}
+		s.amzDate = r.Header.Get("X-Amz-Date")
+		s.payloadHash = r.Header.Get("X-Amz-Content-Sha256")
+		if s.payloadHash == "" {
+			return s, newS3Err(http.StatusBadRequest, "InvalidRequest", "Missing required header for this request: x-amz-content-sha256")
+		}
+	} else {
+		q := r.URL.Query()
+		if q.Get("X-Amz-Algorithm") != s3Algorithm {
+			return s, s3ErrAccessDenied
+		}
+		credential = q.Get("X-Amz-Credential")
+		signedHeaders = q.Get("X-Amz-SignedHeaders")
+		s.signature = q.Get("X-Amz-Signature")
+		s.amzDate = q.Get("X-Amz-Date")
+		s.payloadHash = s3UnsignedPayload
+		s.presigned = true
+	}
+
+	cred := strings.Split(credential, "/")
+	if len(cred) != 5 || cred[4] != "aws4_request" || signedHeaders == "" || s.signature == "" || s.amzDate == "" {
+		return s, newS3Err(http.StatusBadRequest, "AuthorizationHeaderMalformed", "The authorization header is malformed")
+	}
+	s.accessKey, s.date, s.region, s.service = cred[0], cred[1], cred[2], cred[3]
+	s.signedHeaders = strings.Split(signedHeaders, ";")
+	return s, nil
+}
+
+// Check the request time, presigned url expires after X-Amz-Expires seconds.
+func checkS3RequestTime(r *http.Request, s s3SigV4, now time.Time) error {
+	t, err := time.Parse(s3AmzDateFormat, s.amzDate)
+	if err != nil || !strings.HasPrefix(s.amzDate, s.date) {
+		return newS3Err(http.StatusBadRequest, "AuthorizationHeaderMalformed", "The x-amz-date is malformed")
+	}
+	if !s.presigned {
+		if d := now.Sub(t); d > s3MaxClockSkew || d < -s3MaxClockSkew {
+			return s3ErrRequestTimeSkew
+		}
+		return nil
+	}
+	expires, err := strconv.Atoi(r.URL.Query().Get("X-Amz-Expires"))
+	if err != nil || expires < 1 || time.Duration(expires)*time.Second > s3MaxPresignExpires {
+		return newS3Err(http.StatusBadRequest, "AuthorizationQueryParametersError", "X-Amz-Expires is not valid")
+	}
+	if now.Before(t.Add(-s3MaxClockSkew)) || now.After(t.Add(time.Duration(expires)*time.Second)) {
+		return s3ErrExpiredRequest
+	}
+	return nil
+}
+
+func s3CanonicalRequest(r *http.Request, s s3SigV4, pathPrefix string) string {
+	uri := s3UriEncode(pathPrefix+r.URL.Path, false)
+	if uri == "" {
+		uri = "/"
+	}
+
+	type kv struct{ k, v string }
+	var params []kv
+	for k, vs := range r.URL.Query() {
+		if s.presigned && k == "X-Amz-Signature" {
+			continue
+		}
+		for _, v := range vs {
+			params = append(params, kv{s3UriEncode(k, true), s3UriEncode(v, true)})
+		}
+	}
+	sort.Slice(params, func(i, j int) bool {
+		if params[i].k == params[j].k {
+			return params[i].v < params[j].v
+		}
+		return params[i].k < params[j].k
+	})
+	query := make([]string, 0, len(params))
+	for _, p := range params {
+		query = append(query, p.k+"="+p.v)
+	}
+
+	var headers strings.Builder
+	for _, h := range s.signedHeaders {
+		var v string
+		if h == "host" {
+			v = r.Host
+		} else {
+			vals := r.Header.Values(h)
+			for i := range vals {
+				vals[i] = strings.Join(strings.Fields(vals[i]), " ")
+			}
+			v = strings.Join(vals, ",")
+		}
+		headers.WriteString(h + ":" + v + "\n")
+	}
+
+	return r.Method + "\n" + uri + "\n" + strings.Join(query, "&") + "\n" + headers.String() + "\n" +
+		strings.Join(s.signedHeaders, ";") + "\n" + s.payloadHash
+}
+
+func s3SigningKey(secret string, s s3SigV4) []byte {
+	k := s3Hmac([]byte("AWS4"+secret), s.date)
+	k = s3Hmac(k, s.region)
+	k = s3Hmac(k, s.service)
+	return s3Hmac(k, "aws4_request")
+}
+
+func s3Signature(signingKey []byte, r *http.Request, s s3SigV4, pathPrefix string) string {
+	sts := s3Algorithm + "\n" + s.amzDate + "\n" + s.scope() + "\n" + s3Sha256Hex([]byte(s3CanonicalRequest(r, s, pathPrefix)))
+	return hex.EncodeToString(s3Hmac(signingKey, sts))
+}
+
+func s3Hmac(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+func s3Sha256Hex(b []byte) string {
+	h := sha256.Sum256(b)
+	return hex.EncodeToString(h[:])
+}
+
+// Uri encode as required by SigV4, only the unreserved characters are not encoded.
+func s3UriEncode(s string, encodeSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if (c >= 'A' && c <= 'Z') || (c >= 'a' && c <= 'z') || (c >= '0' && c <= '9') ||
+			c == '-' || c == '_' || c == '.' || c == '~' || (c == '/' && !encodeSlash) {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+// Authenticate the request, returns the user and the verified payload reader.
+func s3Authenticate(rail miso.Rail, db *gorm.DB, r *http.Request) (common.User, io.Reader, error) {
+	s, err := parseS3SigV4(r)
+	if err != nil {
+		return common.User{}, nil, err
+	}
+	if err := checkS3RequestTime(r, s, time.Now()); err != nil {
+		return common.User{}, nil, err
+	}
+
+	var ak s3AccessKey
+	t := db.Raw(`SELECT access_key, secret_key, user_no, username FROM s3_access_key WHERE access_key = ? AND is_del = 0`,
+		s.accessKey).Scan(&ak)
+	if t.Error != nil {
+		return common.User{}, nil, fmt.Errorf("failed to find s3_access_key, %v", t.Error)
+	}
+	if t.RowsAffected < 1 {
+		return common.User{}, nil, s3ErrInvalidAccessKey
+	}
+
+	signingKey := s3SigningKey(ak.SecretKey, s)
+	expected := s3Signature(signingKey, r, s, miso.GetPropStr(PropS3SignedPathPrefix))
+	if !hmac.Equal([]byte(expected), []byte(s.signature)) {
+		rail.Infof("S3 signature mismatch, accessKey: %v, method: %v, path: %v", s.accessKey, r.Method, r.URL.Path)
+		return common.User{}, nil, s3ErrSignature
+	}
+
+	// the user may be disabled or no longer permitted to manage files after the access key is created
+	permitted, err := CheckManageFilesAccess(rail, ak.UserNo)
+	if err != nil {
+		return common.User{}, nil, fmt.Errorf("failed to check user's access, userNo: %v, %v", ak.UserNo, err)
+	}
+	if !permitted {
+		rail.Infof("S3 request rejected, user %v is not permitted to manage files, accessKey: %v", ak.Username, s.accessKey)
+		return common.User{}, nil, s3ErrAccessDenied
+	}
+
+	user := common.User{UserNo: ak.UserNo, Username: ak.Username}
+	payload := &s3Payload{src: bufio.NewReader(r.Body)}
+	switch s.payloadHash {
+	case s3UnsignedPayload:
+	case s3StreamingPayload, s3StreamingPayloadTrailer:
+		payload.chunked = true
+		payload.signingKey = signingKey
+		payload.amzDate = s.amzDate
+		payload.scope = s.scope()
+		payload.prevSig = s.signature
+	case s3StreamingUnsignedTrailer:
+		payload.chunked = true
+	default:
+		if len(s.payloadHash) != 64 {
+			return user, nil, newS3Err(http.StatusBadRequest, "InvalidArgument", "x-amz-content-sha256 must be UNSIGNED-PAYLOAD, STREAMING-* or a valid sha256 value")
+		}
+		payload.expectedHash = strings.ToLower(s.payloadHash)
+		payload.hash = sha256.New()
+	}
+	return user, payload, nil
+}
+
+// Request payload, the content is verified while it's read.
+type s3Payload struct {
+	src *bufio.Reader
+	err error
+
+	// sha256 of the whole payload
+	expectedHash string
+	hash         hash.Hash
+
+	// aws-chunked encoding, signingKey is nil if the chunks are not signed
+	chunked    bool
+	signingKey []byte
+	amzDate    string
+	scope      string
+	prevSig    string
+	buf        []byte
+	eof        bool
+}
+
+func (p *s3Payload) Read(b []byte) (int, error) {
+	if p.err != nil {
+		return 0, p.err
+	}
+	if !p.chunked {
+		n, err := p.src.Read(b)
+		if p.hash != nil {
+			p.hash.Write(b[:n])
+			if err == io.EOF && hex.EncodeToString(p.hash.Sum(nil)) != p.expectedHash {
+				p.err = s3ErrSha256Mismatch
+				return n, p.err
+			}
+		}
+		return n, err
+	}
+
+	for len(p.buf) < 1 {
+		if p.eof {
+			return 0, io.EOF
+		}
+		if err := p.readChunk(); err != nil {
+			p.err = err
+			return 0, err
+		}
+	}
+	n := copy(b, p.buf)
+	p.buf = p.buf[n:]
+	return n, nil
+}
+
+// Read chunk in the format of: hex(size)[;chunk-signature=signature]\r\n data \r\n, trailers after the last chunk are skipped.
+func (p *s3Payload) readChunk() error {
+	line, err := p.src.ReadString('\n')
+	if err != nil {
+		return s3ErrIncompleteBody
+	}
+	sizeStr, ext, _ := strings.Cut(strings.TrimSpace(line), ";")
+	size, err := strconv.ParseInt(sizeStr, 16, 64)
+	if err != nil || size < 0 || size > s3MaxChunkSize {
+		return s3ErrIncompleteBody
+	}
+
+	data := make([]byte, size)
+	if _, err := io.ReadFull(p.src, data); err != nil {
+		return s3ErrIncompleteBody
+	}
+	if size > 0 {
+		if crlf, err := p.src.ReadString('\n'); err != nil || strings.TrimSpace(crlf) != "" {
+			return s3ErrIncompleteBody
+		}
+	}
+
+	if p.signingKey != nil {
+		sts := "AWS4-HMAC-SHA256-PAYLOAD\n" + p.amzDate + "\n" + p.scope + "\n" + p.prevSig + "\n" + s3EmptySha256 + "\n" +
+			s3Sha256Hex(data)
+		expected := hex.EncodeToString(s3Hmac(p.signingKey, sts))
+		if !hmac.Equal([]byte(expected), []byte(strings.TrimPrefix(ext, "chunk-signature="))) {
+			return s3ErrSignature
+		}
+		p.prevSig = expected
+	}
+
+	if size == 0 {
+		for {
+			line, err := p.src.ReadString('\n')
+			if strings.TrimSpace(line) == "" || err != nil {
+				break
+			}
+		}
+		p.eof = true
+		return nil
+	}
+	p.buf = data
+	return nil
+}
+
+type s3Gateway struct {
+	rail miso.Rail
+	db   *gorm.DB
+	user common.User
+}
+
+type s3Owner struct {
+	ID          string `xml:"ID"`
+	DisplayName string `xml:"DisplayName"`
+}
+
+type s3Bucket struct {
+	Name         string `xml:"Name"`
+	CreationDate string `xml:"CreationDate"`
+}
+
+type s3ListBucketsResult struct {
+	XMLName xml.Name   `xml:"ListAllMyBucketsResult"`
+	Xmlns   string     `xml:"xmlns,attr"`
+	Owner   s3Owner    `xml:"Owner"`
+	Buckets []s3Bucket `xml:"Buckets>Bucket"`
+}
+
+type s3Object struct {
+	Key          string `xml:"Key"`
+	LastModified string `xml:"LastModified"`
+	ETag         string `xml:"ETag"`
+	Size         int64  `xml:"Size"`
+	StorageClass string `xml:"StorageClass"`
+}
+
+type s3CommonPrefix struct {
+	Prefix string `xml:"Prefix"`
+}
+
+type s3ListObjectsV2Result struct {
+	XMLName               xml.Name         `xml:"ListBucketResult"`
+	Xmlns                 string           `xml:"xmlns,attr"`
+	Name                  string           `xml:"Name"`
+	Prefix                string           `xml:"Prefix"`
+	Delimiter             string           `xml:"Delimiter,omitempty"`
+	StartAfter            string           `xml:"StartAfter,omitempty"`
+	ContinuationToken     string           `xml:"ContinuationToken,omitempty"`
+	NextContinuationToken string           `xml:"NextContinuationToken,omitempty"`
+	EncodingType          string           `xml:"EncodingType,omitempty"`
+	KeyCount              int              `xml:"KeyCount"`
+	MaxKeys               int              `xml:"MaxKeys"`
+	IsTruncated           bool             `xml:"IsTruncated"`
+	Contents              []s3Object       `xml:"Contents"`
+	CommonPrefixes        []s3CommonPrefix `xml:"CommonPrefixes"`
+}
+
+// File under the bucket, key of directory ends with '/'.
+type s3Entry struct {
+	key  string
+	file FileInfo
+}
+
+func s3ETag(f FileInfo) string {
+	return `"` + f.Uuid + `"`
+}
+
+func s3Time(t util.ETime) string {
+	return t.ToTime().UTC().Format("2006-01-02T15:04:05.000Z")
+}
+
+func (s *s3Gateway) listBuckets(w http.ResponseWriter) error {
+	files, err := listChildFiles(s.rail, s.db, "", s.user.UserNo)
+	if err != nil {
+		return err
+	}
+	res := s3ListBucketsResult{
+		Xmlns:   s3Xmlns,
+		Owner:   s3Owner{ID: s.user.UserNo, DisplayName: s.user.Username},
+		Buckets: []s3Bucket{},
+	}
+	seen := util.NewSet[string]()
+	for _, f := range files {
+		if f.FileType == FileTypeDir && seen.Add(f.Name) {
+			res.Buckets = append(res.Buckets, s3Bucket{Name: f.Name, CreationDate: s3Time(f.CreateTime)})
+		}
+	}
+	return writeS3Xml(w, res)
+}
+
+func (s *s3Gateway) listObjectsV2(w http.ResponseWriter, r *http.Request, bucket FileInfo) error {
+	q := r.URL.Query()
+	prefix := q.Get("prefix")
+	delimiter := q.Get("delimiter")
+	startAfter := q.Get("start-after")
+	token := q.Get("continuation-token")
+	encodingType := q.Get("encoding-type")
+	maxKeys := s3MaxKeys
+	if v := q.Get("max-keys"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return newS3Err(http.StatusBadRequest, "InvalidArgument", "max-keys is not valid")
+		}
+		if n < maxKeys {
+			maxKeys = n
+		}
+	}
+
+	// keys after the marker are returned, keys under the common prefix returned in last page are skipped
+	marker := startAfter
+	skipPrefix := ""
+	if token != "" {
+		b, err := base64.RawURLEncoding.DecodeString(token)
+		if err != nil || len(b) < 2 {
+			return newS3Err(http.StatusBadRequest, "InvalidArgument", "The continuation token provided is incorrect")
+		}
+		marker = string(b[2:])
+		if b[0] == 'P' {
+			skipPrefix = marker
+		}
+	}
+
+	enc := func(v string) string {
+		if encodingType == "url" {
+			return url.QueryEscape(v)
+		}
+		return v
+	}
+	res := s3ListObjectsV2Result{
+		Xmlns:             s3Xmlns,
+		Name:              bucket.Name,
+		Prefix:            enc(prefix),
+		Delimiter:         enc(delimiter),
+		StartAfter:        enc(startAfter),
+		ContinuationToken: token,
+		EncodingType:      encodingType,
+		MaxKeys:           maxKeys,
+		Contents:          []s3Object{},
+		CommonPrefixes:    []s3CommonPrefix{},
+	}
+
+	last := ""
+	lastPrefix := ""
+	err := s.walkEntries(bucket, prefix, delimiter != "/", marker, skipPrefix, func(e s3Entry) bool {
+		if delimiter != "" {
+			if i := strings.Index(e.key[len(prefix):], delimiter); i > -1 {
+				cp := e.key[:len(prefix)+i+len(delimiter)]
+				if cp == lastPrefix {
+					return true
+				}
+				if res.KeyCount >= maxKeys {
+					res.IsTruncated = true
+					return false
+				}
+				res.CommonPrefixes = append(res.CommonPrefixes, s3CommonPrefix{Prefix: enc(cp)})
+				res.KeyCount++
+				lastPrefix = cp
+				last = "P:" + cp
+				return true
+			}
+		}
+		if res.KeyCount >= maxKeys {
+			res.IsTruncated = true
+			return false
+		}
+		res.Contents = append(res.Contents, s3Object{
+			Key:          enc(e.key),
+			LastModified: s3Time(e.file.UpdateTime),
+			ETag:         s3ETag(e.file),
+			Size:         e.file.SizeInBytes,
+			StorageClass: "STANDARD",
+		})
+		res.KeyCount++
+		last = "K:" + e.key
+		return true
+	})
+	if err != nil {
+		return err
+	}
+	if res.IsTruncated {
+		res.NextContinuationToken = base64.RawURLEncoding.EncodeToString([]byte(last))
+	}
+	return writeS3Xml(w, res)
+}
+
+// Visit files under the prefix in the order of keys, only files in the deepest directory of the prefix are visited if
+// not recursive.
+//
+// Directories are listed one at a time, keys of files in a directory always sort right after the key of the directory,
+// so directories before the marker or under the skipped prefix are not listed at all, and the walk stops as soon as
+// visit returns false.
+func (s *s3Gateway) walkEntries(bucket FileInfo, prefix string, recursive bool, marker string, skipPrefix string,
+	visit func(e s3Entry) bool) error {
+
+	baseDir := prefix[:strings.LastIndex(prefix, "/")+1]
+	dir := &bucket
+	if baseDir != "" {
+		var err error
+		dir, err = s.findDir(bucket, strings.Split(strings.TrimSuffix(baseDir, "/"), "/"))
+		if err != nil || dir == nil {
+			return err
+		}
+	}
+
+	skipped := func(key string) bool { return skipPrefix != "" && strings.HasPrefix(key, skipPrefix) }
+
+	var walk func(dir FileInfo, dirKey string) (bool, error)
+	walk = func(dir FileInfo, dirKey string) (bool, error) {
+		files, err := listChildFiles(s.rail, s.db, dir.Uuid, s.user.UserNo)
+		if err != nil {
+			return false, err
+		}
+		entries := make([]s3Entry, 0, len(files))
+		seen := util.NewSet[string]()
+		for _, f := range files {
+			if !seen.Add(f.Name) {
+				continue // shadowed by the latest one with the same name
+			}
+			key := dirKey + f.Name
+			if f.FileType == FileTypeDir {
+				key += "/"
+			}
+			if !strings.HasPrefix(key, prefix) {
+				continue
+			}
+			entries = append(entries, s3Entry{key: key, file: f})
+		}
+		sort.Slice(entries, func(i, j int) bool { return entries[i].key < entries[j].key })
+
+		for _, e := range entries {
+			if e.key > marker && !skipped(e.key) {
+				if !visit(e) {
+					return false, nil
+				}
+			}
+			if e.file.FileType != FileTypeDir || !recursive || skipped(e.key) {
+				continue
+			}
+			if e.key <= marker && !strings.HasPrefix(marker, e.key) {
+				continue // all files in the directory are before the marker
+			}
+			if ok, err := walk(e.file, e.key); err != nil || !ok {
+				return ok, err
+			}
+		}
+		return true, nil
+	}
+	_, err := walk(*dir, baseDir)
+	return err
+}
+
+// Find directory by path segments under the bucket, nil is returned if it's not found.
+func (s *s3Gateway) findDir(bucket FileInfo, segments []string) (*FileInfo, error) {
+	dir := &bucket
+	for _, seg := range segments {
+		f, err := findChildFile(s.rail, s.db, dir.Uuid, seg, s.user.UserNo)
+		if err != nil {
+			return nil, err
+		}
+		if f == nil || f.FileType != FileTypeDir {
+			return nil, nil
+		}
+		dir = f
+	}
+	return dir, nil
+}
+
+// Split key into directory segments and file name, name is empty if the key refers to a directory.
+func splitS3Key(key string) ([]string, string, error) {
+	dirKey := strings.HasSuffix(key, "/")
+	segments := strings.Split(strings.TrimSuffix(key, "/"), "/")
+	for _, seg := range segments {
+		if seg == "" || seg == "." || seg == ".." {
+			return nil, "", s3ErrInvalidKey
+		}
+	}
+	if dirKey {
+		return segments, "", nil
+	}
+	return segments[:len(segments)-1], segments[len(segments)-1], nil
+}
+
+// Find file by key, nil is returned if it's not found.
+func (s *s3Gateway) findObject(bucket FileInfo, key string) (*FileInfo, error) {
+	dirs, name, err := splitS3Key(key)
+	if err != nil {
+		return nil, err
+	}
+	dir, err := s.findDir(bucket, dirs)
+	if err != nil || dir == nil || name == "" {
+		return dir, err
+	}
+	f, err := findChildFile(s.rail, s.db, dir.Uuid, name, s.user.UserNo)
+	if err != nil || f == nil || f.FileType != FileTypeFile {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (s *s3Gateway) getObject(w http.ResponseWriter, r *http.Request, bucket FileInfo, key string) error {
+	f, err := s.findObject(bucket, key)
+	if err != nil {
+		return err
+	}
+	if f == nil {
+		return s3ErrNoSuchKey
+	}
+	if f.FileType == FileTypeDir && r.Method != http.MethodHead {
+		return s3ErrNoSuchKey // directories have no content
+	}
+
+	ct := "application/octet-stream"
+	size := f.SizeInBytes
+	if f.FileType == FileTypeDir {
+		ct = "application/x-directory"
+		size = 0
+	} else if f.MimeType != "" && f.MimeType != MimeOctetStream {
+		ct = f.MimeType
+	} else if v := mime.TypeByExtension(path.Ext(f.Name)); v != "" {
+		ct = v
+	}
+
+	start, end := int64(0), size-1
+	status := http.StatusOK
+	if rg := r.Header.Get("Range"); rg != "" && size > 0 {
+		start, end, err = parseS3Range(rg, size)
+		if err != nil {
+			return err
+		}
+		status = http.StatusPartialContent
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, size))
+	}
+
+	w.Header().Set("Content-Type", ct)
+	w.Header().Set("Content-Length", strconv.FormatInt(end-start+1, 10))
+	w.Header().Set("ETag", s3ETag(*f))
+	w.Header().Set("Last-Modified", f.UpdateTime.ToTime().UTC().Format(http.TimeFormat))
+	w.Header().Set("Accept-Ranges", "bytes")
+	if r.Method == http.MethodHead || end < start {
+		w.WriteHeader(status)
+		return nil
+	}
+
+	body, err := openFstoreStream(s.rail, f.FstoreFileId, f.Name, start)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+	w.WriteHeader(status)
+	if _, err := io.CopyN(w, body, end-start+1); err != nil {
+		s.rail.Warnf("Failed to write S3 object, key: %v, %v", key, err)
+	}
+	return nil
+}
+
+// Parse single byte range, e.g., bytes=0-9, bytes=10-, bytes=-10.
+func parseS3Range(rg string, size int64) (int64, int64, error) {
+	spec := strings.TrimPrefix(rg, "bytes=")
+	if spec == rg || strings.Contains(spec, ",") {
+		return 0, 0, s3ErrInvalidRange
+	}
+	startStr, endStr, ok := strings.Cut(strings.TrimSpace(spec), "-")
+	if !ok {
+		return 0, 0, s3ErrInvalidRange
+	}
+	if startStr == "" {
+		n, err := strconv.ParseInt(endStr, 10, 64)
+		if err != nil || n < 1 {
+			return 0, 0, s3ErrInvalidRange
+		}
+		if n > size {
+			n = size
+		}
+		return size - n, size - 1, nil
+	}
+	start, err := strconv.ParseInt(startStr, 10, 64)
+	if err != nil || start < 0 || start >= size {
+		return 0, 0, s3ErrInvalidRange
+	}
+	end := size - 1
+	if endStr != "" {
+		if end, err = strconv.ParseInt(endStr, 10, 64); err != nil || end < start {
+			return 0, 0, s3ErrInvalidRange
+		}
+		if end > size-1 {
+			end = size - 1
+		}
+	}
+	return start, end, nil
+}
+
+func (s *s3Gateway) putObject(w http.ResponseWriter, bucket FileInfo, key string, payload io.Reader) error {
+	dirs, name, err := splitS3Key(key)
+	if err != nil {
+		return err
+	}
+
+	// directory marker
+	if name == "" {
+		if _, err := io.Copy(io.Discard, payload); err != nil {
+			return err
+		}
+		dir, err := s.mkdirs(bucket, dirs)
+		if err != nil {
+			return err
+		}
+		w.Header().Set("ETag", s3ETag(dir))
+		w.WriteHeader(http.StatusOK)
+		return nil
+	}
+
+	uploadFileId, err := uploadFstoreStream(s.rail, name, payload)
+	if err != nil {
+		var se *s3Error
+		if pe := payload.(*s3Payload).err; errors.As(pe, &se) {
+			return se
+		}
+		return err
+	}
+
+	dir, err := s.mkdirs(bucket, dirs)
+	if err != nil {
+		return err
+	}
+	prev, err := findChildFile(s.rail, s.db, dir.Uuid, name, s.user.UserNo)
+	if err != nil {
+		return err
+	}
+	if prev != nil && prev.FileType == FileTypeDir {
+		return s3ErrKeyIsDirectory
+	}
+
+	fileKey, err := CreateFile(s.rail, s.db, CreateFileReq{Filename: name, FakeFstoreFileId: uploadFileId, ParentFile: dir.Uuid}, s.user)
+	if err != nil {
+		return err
+	}
+	if prev != nil {
+		if err := DeleteFile(s.rail, s.db, DeleteFileReq{Uuid: prev.Uuid}, s.user, nil); err != nil {
+			s.rail.Errorf("Failed to delete overwritten file %v, %v", prev.Uuid, err)
+		}
+	}
+	w.Header().Set("ETag", s3ETag(FileInfo{Uuid: fileKey}))
+	w.WriteHeader(http.StatusOK)
+	return nil
+}
+
+// Find or create the directories under the bucket.
+func (s *s3Gateway) mkdirs(bucket FileInfo, segments []string) (FileInfo, error) {
+	dir := bucket
+	for _, seg := range segments {
+		f, err := s.mkdir(dir, seg)
+		if err != nil {
+			return dir, err
+		}
+		dir = f
+	}
+	return dir, nil
+}
+
+func (s *s3Gateway) mkdir(parent FileInfo, name string) (FileInfo, error) {
+	lock := redis.NewRLock(s.rail, "vfm:s3:mkdir:"+parent.Uuid+":"+name)
+	if err := lock.Lock(); err != nil {
+		return FileInfo{}, err
+	}
+	defer lock.Unlock()
+
+	f, err := findChildFile(s.rail, s.db, parent.Uuid, name, s.user.UserNo)
+	if err != nil {
+		return FileInfo{}, err
+	}
+	if f != nil {
+		if f.FileType != FileTypeDir {
+			return FileInfo{}, newS3Err(http.StatusConflict, "InvalidRequest", "A file with the same key as the directory exists")
+		}
+		return *f, nil
+	}
+	fileKey, err := MakeDir(s.rail, s.db, MakeDirReq{ParentFile: parent.Uuid, Name: name}, s.user)
+	if err != nil {
+		return FileInfo{}, err
+	}
+	f, err = findFile(s.rail, s.db, fileKey)
+	if err != nil {
+		return FileInfo{}, fmt.Errorf("failed to find created dir, fileKey: %v, %v", fileKey, err)
+	}
+	if f == nil {
+		return FileInfo{}, fmt.Errorf("created dir not found, fileKey: %v", fileKey)
+	}
+	return *f, nil
+}
+
+func (s *s3Gateway) deleteObject(w http.ResponseWriter, bucket FileInfo, key string) error {
+	f, err := s.findObject(bucket, key)
+	if err != nil {
+		return err
+	}
+	if f != nil && f.Uuid != bucket.Uuid {
+		if err := DeleteFile(s.rail, s.db, DeleteFileReq{Uuid: f.Uuid}, s.user, nil); err != nil {
+			return err
+		}
+	}
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+type s3AccessKey struct {
+	AccessKey string
+	SecretKey string
+	UserNo    string
+	Username  string
+}
+
+type CreateS3AccessKeyReq struct {
+	Name string `json:"name" valid:"notEmpty" desc:"name of the access key"`
+}
+
+type CreateS3AccessKeyRes struct {
+	AccessKey string `json:"accessKey"`
+	SecretKey string `json:"secretKey" desc:"secret key used to sign the requests, it's only returned once"`
+}
+
+// Create S3 access key for user.
+func CreateS3AccessKey(rail miso.Rail, db *gorm.DB, req CreateS3AccessKeyReq, user common.User) (CreateS3AccessKeyRes, error) {
+	res := CreateS3AccessKeyRes{
+		AccessKey: "VFM" + util.RandUpperAlphaNumeric(17),
+		SecretKey: util.ERand(40),
+	}
+	err := db.Exec(`INSERT INTO s3_access_key (access_key, secret_key, name, user_no, username, create_by)
+		VALUES (?,?,?,?,?,?)`, res.AccessKey, res.SecretKey, req.Name, user.UserNo, user.Username, user.Username).Error
+	if err != nil {
+		return res, fmt.Errorf("failed to save s3_access_key, %v", err)
+	}
+	rail.Infof("S3 access key %v created by %v", res.AccessKey, user.Username)
+	return res, nil
+}
+
+type DeleteS3AccessKeyReq struct {
+	AccessKey string `json:"accessKey" valid:"notEmpty"`
+}
+
+// Delete user's S3 access key.
+func DeleteS3AccessKey(rail miso.Rail, db *gorm.DB, req DeleteS3AccessKeyReq, user common.User) error {
+	return db.Exec(`UPDATE s3_access_key SET is_del = 1, update_by = ? WHERE access_key = ? AND user_no = ?`,
+		user.Username, req.AccessKey, user.UserNo).Error
+}
+
+type ListS3AccessKeyReq struct {
+	Paging miso.Paging `json:"paging"`
+}
+
+type ListedS3AccessKey struct {
+	AccessKey  string     `json:"accessKey"`
+	Name       string     `json:"name"`
+	CreateTime util.ETime `json:"createTime"`
+}
+
+// List user's S3 access keys.
+func ListS3AccessKeys(rail miso.Rail, db *gorm.DB, req ListS3AccessKeyReq, user common.User) (miso.PageRes[ListedS3AccessKey], error) {
+	return mysql.NewPageQuery[ListedS3AccessKey]().
+		WithPage(req.Paging).
+		WithBaseQuery(func(tx *gorm.DB) *gorm.DB {
+			return tx.Table("s3_access_key").Where("user_no = ? AND is_del = 0", user.UserNo)
+		}).
+		WithSelectQuery(func(tx *gorm.DB) *gorm.DB {
+			return tx.Select("access_key, name, create_time").Order("id DESC")
+		}).
+		Exec(rail, db)
+}
@@ -0,0 +1,66 @@
+package vfm
+
+import (
+	"fmt"
+
+	"github.com/curtisnewbie/miso/middleware/mysql"
+	"github.com/curtisnewbie/miso/middleware/task"
+	"github.com/curtisnewbie/miso/miso"
+)
+
+func ScheduleTasks(rail miso.Rail) error {
+	tasks := []miso.Job{
+		{
+			Name:            "FlushDirSizeDeltaTask",
+			Cron:            fmt.Sprintf("*/%d * * * * *", miso.GetPropInt(PropDirSizeFlushInterval)),
+			CronWithSeconds: true,
+			Run:             FlushDirSizeDeltaTask,
+		},
+		{
+			Name:            "RetryWebhookDeliveryTask",
+			Cron:            "*/15 * * * * *",
+			CronWithSeconds: true,
+			Run: func(rail miso.Rail) error {
+				return RetryWebhookDeliveries(rail, mysql.GetMySQL())
+			},
+		},
+		{
+			Name:            "RetryThumbnailGenerationTask",
+			Cron:            "30 * * * * *",
+			CronWithSeconds: true,
+			Run: func(rail miso.Rail) error {
+				return RetryThumbnailGenerations(rail, mysql.GetMySQL())
+			},
+		},
+		{
+			Name:            "PhysicDeleteGcTask",
+			Cron:            "0 30 3 * * *",
+			CronWithSeconds: true,
+			Run:             PhysicDeleteGcTask,
+		},
+		{
+			Name:            "ReconcileFstoreTask",
+			Cron:            "0 0 4 * * 0",
+			CronWithSeconds: true,
+			Run:             ReconcileFstoreTask,
+		},
+		{
+			Name:            "ResumeJobsTask",
+			Cron:            "0 * * * * *",
+			CronWithSeconds: true,
+			Run:             ResumeJobsTask,
+		},
+		{
+			Name:            "PurgeIdempotencyRecordTask",
+			Cron:            "0 15 * * * *",
+			CronWithSeconds: true,
+			Run:             PurgeIdempotencyRecordTask,
+		},
+	}
+	for _, t := range tasks {
+		if err := task.ScheduleDistributedTask(t); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -22,7 +22,10 @@ func PrepareServer() {
 	miso.PreServerBootstrap(PrintVersion)
 	miso.PreServerBootstrap(PrepareEventBus)
 	miso.PreServerBootstrap(RegisterHttpRoutes)
+	miso.PreServerBootstrap(RegisterWebDavRoutes)
+	miso.PreServerBootstrap(RegisterS3Routes)
 	miso.PreServerBootstrap(MakeTempDirs)
+	miso.PreServerBootstrap(ScheduleTasks)
 }
 
 func BootstrapServer(args []string) {
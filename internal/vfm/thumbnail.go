@@ -0,0 +1,255 @@
+package vfm
+
+import (
+	"fmt"
+	"time"
+
+	fstore "github.com/curtisnewbie/mini-fstore/api"
+	"github.com/curtisnewbie/miso/middleware/mysql"
+	"github.com/curtisnewbie/miso/middleware/user-vault/common"
+	"github.com/curtisnewbie/miso/miso"
+	"github.com/curtisnewbie/miso/util"
+	"gorm.io/gorm"
+)
+
+const (
+	PropThumbnailMaxAttempts  = "vfm.thumbnail.max-attempts"
+	PropThumbnailRetryBackoff = "vfm.thumbnail.retry-backoff" // base backoff in seconds, doubled on each attempt
+	PropThumbnailTimeout      = "vfm.thumbnail.timeout"       // in seconds
+
+	ThumbnailPending     = "PENDING"     // thumbnail generation is requested, waiting for the reply
+	ThumbnailDone        = "DONE"        // thumbnail is generated
+	ThumbnailFailed      = "FAILED"      // retried later if thumbnail_retry_time is set, otherwise it has failed permanently
+	ThumbnailUnsupported = "UNSUPPORTED" // file is not an image (that can be decoded), a video or a pdf
+)
+
+func init() {
+	miso.SetDefProp(PropThumbnailMaxAttempts, 5)
+	miso.SetDefProp(PropThumbnailRetryBackoff, 300)
+	miso.SetDefProp(PropThumbnailTimeout, 1800)
+}
+
+// Trigger thumbnail generation if the file is an image, a video or a pdf, the thumbnail status is updated accordingly.
+//
+// Images that can't be decoded by mini-fstore (e.g., HEIC, TIFF, RAW, SVG) are marked UNSUPPORTED.
+// The media type is guessed by name if it's not yet detected.
+func triggerThumbnailGeneration(rail miso.Rail, tx *gorm.DB, fileKey string, fstoreFileId string, name string, mimeType string) error {
+	f := FileInfo{Name: name, MimeType: mimeType}
+	if !f.IsThumbnailImage() && !f.IsVideo() && !f.IsPdf() {
+		return tx.Exec(`UPDATE file_info SET thumbnail_status = ?, thumbnail_err = '', thumbnail_retry_time = NULL WHERE uuid = ?`,
+			ThumbnailUnsupported, fileKey).Error
+	}
+
+	// the reply is considered lost if it doesn't arrive before the deadline
+	deadline := time.Now().Add(miso.GetPropDur(PropThumbnailTimeout, time.Second))
+	err := tx.Exec(`UPDATE file_info SET thumbnail_status = ?, thumbnail_attempts = thumbnail_attempts + 1, thumbnail_retry_time = ?
+		WHERE uuid = ?`, ThumbnailPending, deadline, fileKey).Error
+	if err != nil {
+		return fmt.Errorf("failed to update thumbnail_status, uuid: %v, %v", fileKey, err)
+	}
+
+	// pdf previews are rendered by vfm
+	if f.IsPdf() {
+		return sendPdfPreviewEvent(rail, fileKey)
+	}
+
+	if f.IsThumbnailImage() {
+		evt := fstore.ImgThumbnailTriggerEvent{Identifier: fileKey, FileId: fstoreFileId, ReplyTo: CompressImgNotifyEventBus}
+		if e := fstore.GenImgThumbnailPipeline.Send(rail, evt); e != nil {
+			return fmt.Errorf("failed to send %#v, uuid: %v, %v", evt, fileKey, e)
+		}
+		return nil
+	}
+
+	evt := fstore.VidThumbnailTriggerEvent{
+		Identifier: fileKey,
+		FileId:     fstoreFileId,
+		ReplyTo:    GenVideoThumbnailNotifyEventBus,
+	}
+	if e := fstore.GenVidThumbnailPipeline.Send(rail, evt); e != nil {
+		return fmt.Errorf("failed to send %#v, uuid: %v, %v", evt, fileKey, e)
+	}
+	return nil
+}
+
+// Reset the attempts, so that the thumbnail generation is retried as if it's a new file.
+func resetThumbnailAttempts(tx *gorm.DB, fileKey string) error {
+	return tx.Exec(`UPDATE file_info SET thumbnail_attempts = 0 WHERE uuid = ?`, fileKey).Error
+}
+
+// Record the failed attempt, the generation is retried with exponential backoff until max attempts is reached.
+func markThumbnailFailed(rail miso.Rail, tx *gorm.DB, fileKey string, attempts int, errMsg string) error {
+	errMsg = util.MaxLenStr(errMsg, 1000)
+	if attempts >= miso.GetPropInt(PropThumbnailMaxAttempts) {
+		rail.Warnf("Thumbnail generation failed permanently, uuid: %v, attempts: %v, %v", fileKey, attempts, errMsg)
+		return tx.Exec(`UPDATE file_info SET thumbnail_status = ?, thumbnail_err = ?, thumbnail_retry_time = NULL WHERE uuid = ?`,
+			ThumbnailFailed, errMsg, fileKey).Error
+	}
+	nextRetry := time.Now().Add(thumbnailBackoff(attempts))
+	rail.Infof("Thumbnail generation failed, uuid: %v, attempts: %v, retry at: %v, %v", fileKey, attempts, nextRetry, errMsg)
+	return tx.Exec(`UPDATE file_info SET thumbnail_status = ?, thumbnail_err = ?, thumbnail_retry_time = ? WHERE uuid = ?`,
+		ThumbnailFailed, errMsg, nextRetry, fileKey).Error
+}
+
+// Exponential backoff based on the number of attempts made.
+func thumbnailBackoff(attempts int) time.Duration {
+	base := miso.GetPropDur(PropThumbnailRetryBackoff, time.Second)
+	if attempts < 1 {
+		attempts = 1
+	}
+	if attempts > 10 {
+		attempts = 10
+	}
+	return base * time.Duration(1<<(attempts-1))
+}
+
+// Handle thumbnail generation reply, empty fileId means that the generation has failed.
+func OnThumbnailGenerated(rail miso.Rail, tx *gorm.DB, identifier string, fileId string) error {
+	fileKey := identifier
+	lock := fileLock(rail, fileKey)
+	if err := lock.Lock(); err != nil {
+		return err
+	}
+	defer lock.Unlock()
+
+	f, e := findFile(rail, tx, fileKey)
+	if e != nil {
+		rail.Errorf("Unable to find file, uuid: %v, %v", fileKey, e)
+		return nil
+	}
+	if f == nil {
+		rail.Errorf("File not found, uuid: %v", fileKey)
+		return nil
+	}
+
+	if fileId == "" {
+		return markThumbnailFailed(rail, tx, fileKey, f.ThumbnailAttempts, "Thumbnail generation failed, the file may be corrupted or in an unsupported format")
+	}
+
+	err := tx.Exec(`UPDATE file_info SET thumbnail = ?, thumbnail_status = ?, thumbnail_err = '', thumbnail_retry_time = NULL
+		WHERE uuid = ?`, fileId, ThumbnailDone, fileKey).
+		Error
+	if err != nil {
+		return err
+	}
+
+	// the default thumbnail is generated by hammer, the variants in other sizes are generated by vfm
+	if f.IsImage() {
+		if err := triggerThumbnailVariants(rail, *f); err != nil {
+			rail.Errorf("Failed to trigger thumbnail variants generation, uuid: %v, %v", fileKey, err)
+		}
+	}
+	return nil
+}
+
+// Retry failed thumbnail generations, and those that have not received replies before the deadline.
+func RetryThumbnailGenerations(rail miso.Rail, db *gorm.DB) error {
+	type thumbnailRetryInf struct {
+		Uuid              string
+		Name              string
+		FstoreFileId      string
+		MimeType          string
+		ThumbnailStatus   string
+		ThumbnailAttempts int
+	}
+	var files []thumbnailRetryInf
+	err := db.Raw(`SELECT uuid, name, fstore_file_id, mime_type, thumbnail_status, thumbnail_attempts
+		FROM file_info
+		WHERE thumbnail_status IN (?, ?)
+		AND thumbnail_retry_time <= ?
+		AND is_logic_deleted = 0
+		ORDER BY id ASC LIMIT 100`, ThumbnailPending, ThumbnailFailed, time.Now()).
+		Scan(&files).Error
+	if err != nil {
+		return fmt.Errorf("failed to list thumbnails to retry, %v", err)
+	}
+
+	for _, f := range files {
+		err := func() error {
+			lock := fileLock(rail, f.Uuid)
+			if err := lock.Lock(); err != nil {
+				return err
+			}
+			defer lock.Unlock()
+
+			if f.ThumbnailStatus == ThumbnailPending {
+				return markThumbnailFailed(rail, db, f.Uuid, f.ThumbnailAttempts, "Timed out waiting for thumbnail generation")
+			}
+			return triggerThumbnailGeneration(rail, db, f.Uuid, f.FstoreFileId, f.Name, f.MimeType)
+		}()
+		if err != nil {
+			rail.Errorf("Failed to retry thumbnail generation, uuid: %v, %v", f.Uuid, err)
+		}
+	}
+	return nil
+}
+
+type RegenerateThumbnailReq struct {
+	FileKey string `json:"fileKey" valid:"notEmpty"`
+}
+
+// Regenerate thumbnail of user's file, the attempts are reset.
+func RegenerateThumbnail(rail miso.Rail, db *gorm.DB, req RegenerateThumbnailReq, user common.User) error {
+	lock := fileLock(rail, req.FileKey)
+	if err := lock.Lock(); err != nil {
+		return err
+	}
+	defer lock.Unlock()
+
+	f, err := findFile(rail, db, req.FileKey)
+	if err != nil {
+		return fmt.Errorf("failed to find file, uuid: %v, %v", req.FileKey, err)
+	}
+	if f == nil || f.IsLogicDeleted == LDelY || f.UploaderNo != user.UserNo {
+		return miso.NewErrf("File not found")
+	}
+	if f.FileType != FileTypeFile {
+		return miso.NewErrf("Directory doesn't have thumbnail")
+	}
+
+	if f.MimeType == "" {
+		if f.MimeType, err = detectAndSaveMimeType(rail, db, f.Uuid, f.FstoreFileId, f.Name); err != nil {
+			return err
+		}
+	}
+	if !f.IsThumbnailImage() && !f.IsVideo() && !f.IsPdf() {
+		return miso.NewErrf("Thumbnail is only supported for JPEG/PNG/GIF/WebP images, videos and PDFs")
+	}
+
+	if err := resetThumbnailAttempts(db, f.Uuid); err != nil {
+		return fmt.Errorf("failed to reset thumbnail_attempts, uuid: %v, %v", f.Uuid, err)
+	}
+	return triggerThumbnailGeneration(rail, db, f.Uuid, f.FstoreFileId, f.Name, f.MimeType)
+}
+
+type ListThumbnailFailureReq struct {
+	Paging miso.Paging `json:"paging"`
+}
+
+type ThumbnailFailure struct {
+	FileKey           string     `json:"fileKey"`
+	Name              string     `json:"name"`
+	UploaderNo        string     `json:"uploaderNo"`
+	UploaderName      string     `json:"uploaderName"`
+	MimeType          string     `json:"mimeType"`
+	ThumbnailAttempts int        `json:"thumbnailAttempts"`
+	ThumbnailErr      string     `json:"thumbnailErr"`
+	UpdateTime        util.ETime `json:"updateTime"`
+}
+
+// List files that have failed thumbnail generation permanently.
+func ListThumbnailFailures(rail miso.Rail, db *gorm.DB, req ListThumbnailFailureReq) (miso.PageRes[ThumbnailFailure], error) {
+	return mysql.NewPageQuery[ThumbnailFailure]().
+		WithPage(req.Paging).
+		WithBaseQuery(func(tx *gorm.DB) *gorm.DB {
+			return tx.Table("file_info").
+				Where("thumbnail_status = ?", ThumbnailFailed).
+				Where("thumbnail_retry_time IS NULL").
+				Where("is_logic_deleted = 0 AND is_del = 0")
+		}).
+		WithSelectQuery(func(tx *gorm.DB) *gorm.DB {
+			return tx.Select("uuid file_key, name, uploader_no, uploader_name, mime_type, thumbnail_attempts, thumbnail_err, update_time").
+				Order("id DESC")
+		}).
+		Exec(rail, db)
+}
@@ -0,0 +1,455 @@
+package vfm
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	_ "image/gif"
+	"image/jpeg"
+	"image/png"
+	"time"
+
+	fstore "github.com/curtisnewbie/mini-fstore/api"
+	"github.com/curtisnewbie/miso/middleware/mysql"
+	"github.com/curtisnewbie/miso/middleware/rabbit"
+	"github.com/curtisnewbie/miso/miso"
+	"github.com/curtisnewbie/miso/util"
+	"gorm.io/gorm"
+)
+
+const (
+	// number of variant generation events consumed concurrently by each instance, each of them may hold up to
+	// ~140MB (see maxVariantSourcePixels) while the image is resized.
+	PropThumbnailVariantConcurrency = "vfm.thumbnail.variant.concurrency"
+
+	// images with more pixels are not decoded to generate the variants, the decoded image and its RGBA copy take
+	// about 5.5 bytes per pixel, i.e., ~140MB at most.
+	maxVariantSourcePixels = 25_000_000
+)
+
+var (
+	// Named thumbnail variants, the value is the max length of the longer edge in pixels.
+	//
+	// Variants are only generated for images that are larger than the variant, the aspect ratio is kept.
+	ThumbnailVariants = []ThumbnailVariantDef{
+		{Name: "small", MaxEdge: 320},
+		{Name: "medium", MaxEdge: 960},
+		{Name: "large", MaxEdge: 2048},
+	}
+
+	// media types of images that can be decoded to generate the variants.
+	_variantSourceMime = util.NewSet[string]()
+
+	GenThumbnailVariantPipeline = rabbit.NewEventPipeline[GenThumbnailVariantEvent]("event.bus.vfm.thumbnail.variant.generate").
+					LogPayload().
+					MaxRetry(3)
+)
+
+func init() {
+	miso.SetDefProp(PropThumbnailVariantConcurrency, 1)
+	_variantSourceMime.AddAll([]string{"image/jpeg", "image/png", "image/gif"})
+}
+
+type ThumbnailVariantDef struct {
+	Name    string
+	MaxEdge int
+}
+
+type GenThumbnailVariantEvent struct {
+	FileKey string
+}
+
+// Thumbnail variant returned in listings.
+type ThumbnailVariant struct {
+	Name         string `json:"name" desc:"variant name: small, medium, large"`
+	Width        int    `json:"width"`
+	Height       int    `json:"height"`
+	Token        string `json:"token" desc:"mini-fstore temporary token for downloading the variant"`
+	FileKey      string `json:"-"`
+	FstoreFileId string `json:"-"`
+}
+
+func canGenerateVariants(f FileInfo) bool {
+	return f.FileType == FileTypeFile && _variantSourceMime.Has(f.MimeType) && f.SizeInBytes <= IMAGE_SIZE_THRESHOLD
+}
+
+// Request thumbnail variants generation asynchronously.
+func triggerThumbnailVariants(rail miso.Rail, f FileInfo) error {
+	if !canGenerateVariants(f) {
+		return nil
+	}
+	evt := GenThumbnailVariantEvent{FileKey: f.Uuid}
+	if err := GenThumbnailVariantPipeline.Send(rail, evt); err != nil {
+		return fmt.Errorf("failed to send %#v, %v", evt, err)
+	}
+	return nil
+}
+
+func OnGenThumbnailVariantEvent(rail miso.Rail, evt GenThumbnailVariantEvent) error {
+	return GenerateThumbnailVariants(rail, mysql.GetMySQL(), evt.FileKey)
+}
+
+func thumbnailVariantConcurrency() int {
+	if n := miso.GetPropInt(PropThumbnailVariantConcurrency); n > 0 {
+		return n
+	}
+	return 1
+}
+
+// Generate the thumbnail variants of the image.
+//
+// The original image is downloaded from mini-fstore and resized, the variants are uploaded back to mini-fstore.
+// EXIF orientation is applied to the variants, so that they are displayed upright without the metadata.
+// Existing variants are replaced.
+//
+// mini-fstore's thumbnail pipeline only generates thumbnails in a fixed size (ImgThumbnailTriggerEvent doesn't
+// take the size), so the variants are generated by vfm. The dimensions are checked using the head of the file
+// before the whole image is downloaded and decoded, images over maxVariantSourcePixels are skipped, and the
+// generation is only run by the consumers of GenThumbnailVariantPipeline, never in the request thread, with
+// concurrency capped by vfm.thumbnail.variant.concurrency. Memory used is therefore bounded by ~140MB per consumer.
+func GenerateThumbnailVariants(rail miso.Rail, db *gorm.DB, fileKey string) error {
+	defer miso.TimeOp(rail, time.Now(), "GenerateThumbnailVariants")
+
+	f, err := findFile(rail, db, fileKey)
+	if err != nil {
+		return fmt.Errorf("failed to find file, uuid: %v, %v", fileKey, err)
+	}
+	if f == nil || f.IsLogicDeleted == LDelY || !canGenerateVariants(*f) {
+		return nil
+	}
+
+	// check the dimensions before the whole image is downloaded, the head may not cover the header of some jpegs
+	// (e.g., with a large embedded preview), these are checked again below
+	head, err := readFstoreFileHead(rail, f.FstoreFileId, f.Name, imageMetaReadLen)
+	if err != nil {
+		return err
+	}
+	if cfg, _, err := image.DecodeConfig(bytes.NewReader(head)); err == nil && cfg.Width*cfg.Height > maxVariantSourcePixels {
+		rail.Warnf("Image is too large, giving up variants generation, uuid: %v, %vx%v", fileKey, cfg.Width, cfg.Height)
+		return nil
+	}
+
+	var buf bytes.Buffer
+	if err := fstore.DownloadFileDirect(rail, f.FstoreFileId, &buf); err != nil {
+		return fmt.Errorf("failed to download mini-fstore file, fileId: %v, %v", f.FstoreFileId, err)
+	}
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		rail.Warnf("Unable to decode image, giving up variants generation, uuid: %v, %v", fileKey, err)
+		return nil
+	}
+	if cfg.Width*cfg.Height > maxVariantSourcePixels {
+		rail.Warnf("Image is too large, giving up variants generation, uuid: %v, %vx%v", fileKey, cfg.Width, cfg.Height)
+		return nil
+	}
+	orientation := extractImageMeta(head).Orientation
+
+	img, _, err := image.Decode(&buf)
+	if err != nil {
+		rail.Warnf("Unable to decode image, giving up variants generation, uuid: %v, %v", fileKey, err)
+		return nil
+	}
+	buf = bytes.Buffer{}
+
+	src := toRGBA(img)
+	img = nil
+	generated := []ThumbnailVariant{}
+	defer func() {
+		// uploaded but not saved
+		for _, v := range generated {
+			if err := fstore.DeleteFile(rail, v.FstoreFileId); err != nil && !errors.Is(err, fstore.ErrFileDeleted) {
+				rail.Errorf("Failed to delete unused thumbnail variant, fileId: %v, %v", v.FstoreFileId, err)
+			}
+		}
+	}()
+
+	// larger variants first, smaller ones are resized from the previous one
+	for i := len(ThumbnailVariants) - 1; i >= 0; i-- {
+		def := ThumbnailVariants[i]
+		b := src.Bounds()
+		if b.Dx() <= def.MaxEdge && b.Dy() <= def.MaxEdge {
+			continue // not larger than the variant, the original (or the smaller variant) should be used instead
+		}
+		src = resizeImage(src, def.MaxEdge)
+
+		// the next variant is resized from the unrotated one
+		v, err := uploadThumbnailVariant(rail, f.Name, def.Name, orientImage(src, orientation))
+		if err != nil {
+			return err
+		}
+		generated = append(generated, v)
+	}
+
+	lock := fileLock(rail, fileKey)
+	if err := lock.Lock(); err != nil {
+		return err
+	}
+	defer lock.Unlock()
+
+	var replaced []string
+	err = db.Transaction(func(tx *gorm.DB) error {
+		var deleted bool
+		if err := tx.Raw(`SELECT is_logic_deleted FROM file_info WHERE uuid = ?`, fileKey).Scan(&deleted).Error; err != nil {
+			return err
+		}
+		if deleted {
+			return nil // deleted while the variants are generated, the uploaded variants are removed
+		}
+		if err := tx.Raw(`SELECT fstore_file_id FROM file_thumbnail_variant WHERE file_key = ?`, fileKey).Scan(&replaced).Error; err != nil {
+			return err
+		}
+		if err := tx.Exec(`DELETE FROM file_thumbnail_variant WHERE file_key = ?`, fileKey).Error; err != nil {
+			return err
+		}
+		for _, v := range generated {
+			err := tx.Exec(`INSERT INTO file_thumbnail_variant (file_key, variant, fstore_file_id, width, height) VALUES (?, ?, ?, ?, ?)`,
+				fileKey, v.Name, v.FstoreFileId, v.Width, v.Height).Error
+			if err != nil {
+				return err
+			}
+		}
+		generated = nil
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to save file_thumbnail_variant, uuid: %v, %v", fileKey, err)
+	}
+
+	for _, fileId := range replaced {
+		if err := fstore.DeleteFile(rail, fileId); err != nil && !errors.Is(err, fstore.ErrFileDeleted) {
+			rail.Errorf("Failed to delete replaced thumbnail variant, fileId: %v, %v", fileId, err)
+		}
+	}
+	return nil
+}
+
+func uploadThumbnailVariant(rail miso.Rail, name string, variant string, img *image.RGBA) (ThumbnailVariant, error) {
+	v := ThumbnailVariant{Name: variant, Width: img.Bounds().Dx(), Height: img.Bounds().Dy()}
+
+	// keep the transparency using png, the rest are encoded as jpeg
+	var buf bytes.Buffer
+	var err error
+	if img.Opaque() {
+		err = jpeg.Encode(&buf, img, &jpeg.Options{Quality: 85})
+	} else {
+		err = png.Encode(&buf, img)
+	}
+	if err != nil {
+		return v, fmt.Errorf("failed to encode thumbnail variant, %v", err)
+	}
+
+	uploadFileId, err := fstore.UploadFile(rail, name+"_"+variant, &buf)
+	if err != nil {
+		return v, err
+	}
+	ff, err := fstore.FetchFileInfo(rail, fstore.FetchFileInfoReq{UploadFileId: uploadFileId})
+	if err != nil {
+		return v, fmt.Errorf("failed to fetch uploaded thumbnail variant, uploadFileId: %v, %w", uploadFileId, err)
+	}
+	v.FstoreFileId = ff.FileId
+	return v, nil
+}
+
+func toRGBA(img image.Image) *image.RGBA {
+	if m, ok := img.(*image.RGBA); ok {
+		return m
+	}
+	b := img.Bounds()
+	m := image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(m, m.Bounds(), img, b.Min, draw.Src)
+	return m
+}
+
+// Transform the image according to the EXIF orientation (1-8), so that it's displayed upright.
+func orientImage(src *image.RGBA, orientation int) *image.RGBA {
+	if orientation < 2 || orientation > 8 {
+		return src
+	}
+	b := src.Bounds()
+	w, h := b.Dx(), b.Dy()
+	dw, dh := w, h
+	if orientation >= 5 {
+		dw, dh = h, w // rotated by 90 degrees
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			var dx, dy int
+			switch orientation {
+			case 2: // mirrored horizontally
+				dx, dy = w-1-x, y
+			case 3: // rotated 180
+				dx, dy = w-1-x, h-1-y
+			case 4: // mirrored vertically
+				dx, dy = x, h-1-y
+			case 5: // mirrored along the top-left to bottom-right diagonal
+				dx, dy = y, x
+			case 6: // rotated 90 clockwise
+				dx, dy = h-1-y, x
+			case 7: // mirrored along the top-right to bottom-left diagonal
+				dx, dy = h-1-y, w-1-x
+			case 8: // rotated 90 counter-clockwise
+				dx, dy = y, w-1-x
+			}
+			si := src.PixOffset(b.Min.X+x, b.Min.Y+y)
+			di := dst.PixOffset(dx, dy)
+			copy(dst.Pix[di:di+4], src.Pix[si:si+4])
+		}
+	}
+	return dst
+}
+
+// Downscale the image using area averaging, so that the longer edge is at most maxEdge pixels.
+func resizeImage(src *image.RGBA, maxEdge int) *image.RGBA {
+	sb := src.Bounds()
+	sw, sh := sb.Dx(), sb.Dy()
+	dw, dh := sw, sh
+	if sw >= sh && sw > maxEdge {
+		dw, dh = maxEdge, sh*maxEdge/sw
+	} else if sh > sw && sh > maxEdge {
+		dw, dh = sw*maxEdge/sh, maxEdge
+	}
+	if dw < 1 {
+		dw = 1
+	}
+	if dh < 1 {
+		dh = 1
+	}
+	if dw == sw && dh == sh {
+		return src
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	for y := 0; y < dh; y++ {
+		y0, y1 := y*sh/dh, (y+1)*sh/dh
+		if y1 <= y0 {
+			y1 = y0 + 1
+		}
+		for x := 0; x < dw; x++ {
+			x0, x1 := x*sw/dw, (x+1)*sw/dw
+			if x1 <= x0 {
+				x1 = x0 + 1
+			}
+			var r, g, b, a, n uint64
+			for sy := y0; sy < y1; sy++ {
+				i := src.PixOffset(sb.Min.X+x0, sb.Min.Y+sy)
+				for sx := x0; sx < x1; sx++ {
+					p := src.Pix[i : i+4 : i+4]
+					r += uint64(p[0])
+					g += uint64(p[1])
+					b += uint64(p[2])
+					a += uint64(p[3])
+					n++
+					i += 4
+				}
+			}
+			j := dst.PixOffset(x, y)
+			dst.Pix[j] = uint8(r / n)
+			dst.Pix[j+1] = uint8(g / n)
+			dst.Pix[j+2] = uint8(b / n)
+			dst.Pix[j+3] = uint8(a / n)
+		}
+	}
+	return dst
+}
+
+// Find thumbnail variants of the files, key is the file key.
+func findThumbnailVariants(tx *gorm.DB, fileKeys []string) (map[string][]ThumbnailVariant, error) {
+	res := map[string][]ThumbnailVariant{}
+	if len(fileKeys) < 1 {
+		return res, nil
+	}
+	var variants []ThumbnailVariant
+	err := tx.Raw(`SELECT file_key, variant name, fstore_file_id, width, height FROM file_thumbnail_variant
+		WHERE file_key IN ? ORDER BY width ASC`, fileKeys).
+		Scan(&variants).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to query file_thumbnail_variant, %v", err)
+	}
+	for _, v := range variants {
+		res[v.FileKey] = append(res[v.FileKey], v)
+	}
+	return res, nil
+}
+
+// Find thumbnail variants of the files and generate the temporary tokens, key is the file key.
+func findThumbnailVariantsWithTokens(rail miso.Rail, tx *gorm.DB, fileKeys []string) (map[string][]ThumbnailVariant, error) {
+	res, err := findThumbnailVariants(tx, fileKeys)
+	if err != nil || len(res) < 1 {
+		return res, err
+	}
+
+	awaitFutures := util.NewAwaitFutures[FstoreTmpToken](vfmPool)
+	for _, variants := range res {
+		for _, v := range variants {
+			GenFstoreTknBatch(rail, awaitFutures, v.FstoreFileId, "")
+		}
+	}
+	idTknMap := map[string]string{}
+	for _, fut := range awaitFutures.Await() {
+		t, err := fut.Get()
+		if err != nil {
+			rail.Errorf("Failed to get mini-fstore temp token for thumbnail variant: %v, %v", t.FileId, err)
+			continue
+		}
+		idTknMap[t.FileId] = t.TempKey
+	}
+	for k, variants := range res {
+		for i, v := range variants {
+			variants[i].Token = idTknMap[v.FstoreFileId]
+		}
+		res[k] = variants
+	}
+	return res, nil
+}
+
+// Delete the thumbnail variants in mini-fstore.
+func deleteThumbnailVariantFiles(rail miso.Rail, tx *gorm.DB, fileKey string) error {
+	var fileIds []string
+	if err := tx.Raw(`SELECT fstore_file_id FROM file_thumbnail_variant WHERE file_key = ?`, fileKey).Scan(&fileIds).Error; err != nil {
+		return fmt.Errorf("failed to query file_thumbnail_variant, uuid: %v, %v", fileKey, err)
+	}
+	for _, fileId := range fileIds {
+		if err := fstore.DeleteFile(rail, fileId); err != nil && !errors.Is(err, fstore.ErrFileDeleted) {
+			return fmt.Errorf("failed to delete fstore file (thumbnail variant), fileId: %v, %v", fileId, err)
+		}
+	}
+	return nil
+}
+
+// Compensate thumbnail variants generation for images that have thumbnails but don't have any variant.
+func CompensateThumbnailVariants(rail miso.Rail, db *gorm.DB) error {
+	rail.Info("CompensateThumbnailVariants start")
+	defer miso.TimeOp(rail, time.Now(), "CompensateThumbnailVariants")
+
+	minId := 0
+	for {
+		var files []FileInfo
+		err := db.Raw(`SELECT fi.id, fi.uuid, fi.file_type, fi.mime_type, fi.size_in_bytes
+			FROM file_info fi
+			WHERE fi.id > ?
+			AND fi.file_type = 'file'
+			AND fi.is_logic_deleted = 0
+			AND fi.thumbnail_status = ?
+			AND fi.mime_type IN ?
+			AND NOT EXISTS (SELECT 1 FROM file_thumbnail_variant v WHERE v.file_key = fi.uuid)
+			ORDER BY fi.id ASC
+			LIMIT 500`, minId, ThumbnailDone, _variantSourceMime.CopyKeys()).
+			Scan(&files).Error
+		if err != nil {
+			return fmt.Errorf("failed to list files without thumbnail variants, minId: %v, %v", minId, err)
+		}
+		if len(files) < 1 {
+			return nil
+		}
+		for _, f := range files {
+			if err := triggerThumbnailVariants(rail, f); err != nil {
+				return err
+			}
+		}
+		minId = files[len(files)-1].Id
+		rail.Infof("CompensateThumbnailVariants, minId: %v", minId)
+	}
+}
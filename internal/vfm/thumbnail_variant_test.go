@@ -0,0 +1,71 @@
+package vfm
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+func TestResizeImage(t *testing.T) {
+	src := image.NewRGBA(image.Rect(0, 0, 400, 100))
+	for y := 0; y < 100; y++ {
+		for x := 0; x < 400; x++ {
+			c := color.RGBA{A: 255}
+			if x%2 == 0 {
+				c.R = 200
+			}
+			src.SetRGBA(x, y, c)
+		}
+	}
+
+	for _, c := range []struct {
+		src     *image.RGBA
+		maxEdge int
+		w, h    int
+	}{
+		{src, 200, 200, 50},
+		{src, 1000, 400, 100},
+		{image.NewRGBA(image.Rect(0, 0, 100, 400)), 40, 10, 40},
+		{image.NewRGBA(image.Rect(0, 0, 1000, 1)), 10, 10, 1},
+	} {
+		dst := resizeImage(c.src, c.maxEdge)
+		if dst.Bounds().Dx() != c.w || dst.Bounds().Dy() != c.h {
+			t.Errorf("%v (max %v): want %vx%v, got %v", c.src.Bounds(), c.maxEdge, c.w, c.h, dst.Bounds())
+		}
+	}
+
+	// area averaged
+	if p := resizeImage(src, 200).RGBAAt(10, 10); p.R != 100 || p.A != 255 {
+		t.Errorf("want averaged pixel, got %v", p)
+	}
+}
+
+func TestOrientImage(t *testing.T) {
+	// 3x2, the top-left pixel is marked
+	src := image.NewRGBA(image.Rect(0, 0, 3, 2))
+	src.SetRGBA(0, 0, color.RGBA{R: 255, A: 255})
+
+	for _, c := range []struct {
+		orientation int
+		w, h        int
+		x, y        int // where the marked pixel is expected
+	}{
+		{1, 3, 2, 0, 0},
+		{2, 3, 2, 2, 0},
+		{3, 3, 2, 2, 1},
+		{4, 3, 2, 0, 1},
+		{5, 2, 3, 0, 0},
+		{6, 2, 3, 1, 0},
+		{7, 2, 3, 1, 2},
+		{8, 2, 3, 0, 2},
+	} {
+		dst := orientImage(src, c.orientation)
+		if dst.Bounds().Dx() != c.w || dst.Bounds().Dy() != c.h {
+			t.Errorf("orientation %v: want %vx%v, got %v", c.orientation, c.w, c.h, dst.Bounds())
+			continue
+		}
+		if dst.RGBAAt(c.x, c.y).R != 255 {
+			t.Errorf("orientation %v: want marked pixel at (%v,%v)", c.orientation, c.x, c.y)
+		}
+	}
+}
@@ -0,0 +1,246 @@
+package vfm
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/curtisnewbie/miso/middleware/user-vault/common"
+	"github.com/curtisnewbie/miso/miso"
+	"github.com/curtisnewbie/miso/util"
+	"gorm.io/gorm"
+)
+
+const (
+	TimelineGroupDay   = "DAY"
+	TimelineGroupMonth = "MONTH"
+
+	timelineDefLimit = 100
+	timelineMaxLimit = 500
+)
+
+type ListTimelineReq struct {
+	Group  string      `json:"group" desc:"group by: DAY (default), MONTH"`
+	Before *util.ETime `json:"before" desc:"only list photos taken before this time, used to jump to a date, ignored if cursor is provided"`
+	Cursor string      `json:"cursor" desc:"cursor returned by previous page"`
+	Limit  int         `json:"limit" desc:"max number of photos returned, 100 by default, at most 500"`
+}
+
+type ListTimelineResp struct {
+	Groups     []TimelineGroup `json:"groups" desc:"groups in reverse chronological order, a group may continue on the next page"`
+	NextCursor string          `json:"nextCursor" desc:"cursor for the next page, empty if there is no more"`
+}
+
+type TimelineGroup struct {
+	Key   string         `json:"key" desc:"date of the group, yyyy-MM-dd for DAY, yyyy-MM for MONTH"`
+	Items []TimelineItem `json:"items"`
+}
+
+type TimelineItem struct {
+	FileKey        string     `json:"fileKey"`
+	Name           string     `json:"name"`
+	MimeType       string     `json:"mimeType"`
+	TakenTime      util.ETime `json:"takenTime" desc:"capture time, or upload time if capture time is unknown"`
+	Width          int        `json:"width" desc:"width in pixels, 0 if unknown"`
+	Height         int        `json:"height" desc:"height in pixels, 0 if unknown"`
+	ThumbnailToken string     `json:"thumbnailToken"`
+	FileTempToken  string     `json:"fileTempToken"`
+
+	Id           int    `json:"-"`
+	FstoreFileId string `json:"-"`
+	Thumbnail    string `json:"-"`
+}
+
+type ListOnThisDayReq struct {
+	Date  *util.ETime `json:"date" desc:"the day, today by default"`
+	Limit int         `json:"limit" desc:"max number of photos returned, 100 by default, at most 500"`
+}
+
+type ListOnThisDayResp struct {
+	Groups []TimelineGroup `json:"groups" desc:"photos taken on the same day in previous years, grouped by year (yyyy)"`
+}
+
+// List images and videos in timeline.
+//
+// Images and videos owned by the user, as well as those in the galleries that the user has access to, are included.
+// The photos are ordered by capture time (upload time if unknown) in reverse chronological order.
+func ListTimeline(rail miso.Rail, tx *gorm.DB, req ListTimelineReq, user common.User) (ListTimelineResp, error) {
+	var layout string
+	switch req.Group {
+	case "", TimelineGroupDay:
+		layout = "2006-01-02"
+	case TimelineGroupMonth:
+		layout = "2006-01"
+	default:
+		return ListTimelineResp{}, miso.NewErrf("Illegal group, should be either DAY or MONTH")
+	}
+	limit := timelineLimit(req.Limit)
+
+	var filter func(q *gorm.DB) *gorm.DB
+	if req.Cursor != "" {
+		before, id, err := parseTimelineCursor(req.Cursor)
+		if err != nil {
+			return ListTimelineResp{}, miso.NewErrf("Invalid cursor")
+		}
+		filter = func(q *gorm.DB) *gorm.DB {
+			return q.Where("(t.taken_time < ? OR (t.taken_time = ? AND t.id < ?))", before, before, id)
+		}
+	} else if req.Before != nil {
+		filter = func(q *gorm.DB) *gorm.DB { return q.Where("t.taken_time < ?", *req.Before) }
+	}
+
+	var items []TimelineItem
+	if err := timelineQuery(tx, user, filter, limit+1).Scan(&items).Error; err != nil {
+		return ListTimelineResp{}, fmt.Errorf("failed to list timeline, %v", err)
+	}
+
+	resp := ListTimelineResp{Groups: []TimelineGroup{}}
+	if len(items) > limit {
+		items = items[:limit]
+		last := items[len(items)-1]
+		resp.NextCursor = fmt.Sprintf("%d_%d", last.TakenTime.UnixMilli(), last.Id)
+	}
+	genTimelineTokens(rail, items)
+	resp.Groups = groupTimelineItems(items, layout)
+	return resp, nil
+}
+
+// List images and videos taken on the same day in previous years.
+func ListOnThisDay(rail miso.Rail, tx *gorm.DB, req ListOnThisDayReq, user common.User) (ListOnThisDayResp, error) {
+	day := time.Now()
+	if req.Date != nil {
+		day = req.Date.ToTime().In(time.Local)
+	}
+
+	var items []TimelineItem
+	err := timelineQuery(tx, user, func(q *gorm.DB) *gorm.DB {
+		return q.Where("MONTH(t.taken_time) = ? AND DAY(t.taken_time) = ? AND YEAR(t.taken_time) < ?", int(day.Month()), day.Day(), day.Year())
+	}, timelineLimit(req.Limit)).
+		Scan(&items).Error
+	if err != nil {
+		return ListOnThisDayResp{}, fmt.Errorf("failed to list photos on this day, %v", err)
+	}
+	genTimelineTokens(rail, items)
+	return ListOnThisDayResp{Groups: groupTimelineItems(items, "2006")}, nil
+}
+
+func timelineLimit(limit int) int {
+	if limit < 1 {
+		return timelineDefLimit
+	}
+	if limit > timelineMaxLimit {
+		return timelineMaxLimit
+	}
+	return limit
+}
+
+// Images and videos that are accessible to the user, taken_time is the capture time or upload time if it's unknown.
+//
+// Files owned by the user and files in the galleries shared with the user are queried separately, so that each
+// branch only scans the files of the user (or the galleries) using indexes instead of the whole file_info table.
+// The filter is applied to each branch, and the merged result is sorted by taken_time and id in descending order.
+func timelineQuery(tx *gorm.DB, user common.User, filter func(q *gorm.DB) *gorm.DB, limit int) *gorm.DB {
+	const cols = `fi.id, fi.uuid file_key, fi.name, fi.mime_type, fi.fstore_file_id, fi.thumbnail,
+		COALESCE(im.capture_time, fi.upload_time) taken_time, COALESCE(im.width, 0) width, COALESCE(im.height, 0) height`
+	const cond = `fi.file_type = ? AND fi.is_logic_deleted = 0 AND fi.is_del = 0 AND fi.hidden = 0
+		AND (fi.mime_type LIKE 'image/%' OR fi.mime_type LIKE 'video/%')`
+
+	branch := func(accessible *gorm.DB) *gorm.DB {
+		q := tx.Table("(?) t", accessible).Select("t.*")
+		if filter != nil {
+			q = filter(q)
+		}
+		return q.Order("t.taken_time DESC, t.id DESC").Limit(limit)
+	}
+
+	owned := branch(tx.Raw(`SELECT `+cols+`
+		FROM file_info fi
+		LEFT JOIN image_metadata im ON im.file_key = fi.uuid
+		WHERE fi.uploader_no = ? AND `+cond, user.UserNo, FileTypeFile))
+
+	// files owned by the user are already included above
+	inGalleries := branch(tx.Raw(`SELECT DISTINCT `+cols+`
+		FROM gallery_image gi
+		JOIN file_info fi ON fi.uuid = gi.file_key
+		LEFT JOIN image_metadata im ON im.file_key = fi.uuid
+		WHERE gi.gallery_no IN (
+			SELECT g.gallery_no FROM gallery g WHERE g.user_no = ? AND g.is_del = 0
+			UNION
+			SELECT ga.gallery_no FROM gallery_user_access ga
+			JOIN gallery g ON g.gallery_no = ga.gallery_no AND g.is_del = 0
+			WHERE ga.user_no = ? AND ga.is_del = 0
+		)
+		AND gi.is_del = 0 AND fi.uploader_no != ? AND `+cond, user.UserNo, user.UserNo, user.UserNo, FileTypeFile))
+
+	return tx.Table("((?) UNION ALL (?)) t", owned, inGalleries).
+		Select("t.*").
+		Order("t.taken_time DESC, t.id DESC").
+		Limit(limit)
+}
+
+func parseTimelineCursor(cursor string) (time.Time, int, error) {
+	ms, id, ok := strings.Cut(cursor, "_")
+	if !ok {
+		return time.Time{}, 0, fmt.Errorf("invalid cursor: %v", cursor)
+	}
+	msv, err := strconv.ParseInt(ms, 10, 64)
+	if err != nil {
+		return time.Time{}, 0, err
+	}
+	idv, err := strconv.Atoi(id)
+	if err != nil {
+		return time.Time{}, 0, err
+	}
+	return time.UnixMilli(msv), idv, nil
+}
+
+// Group the sorted items by the formatted taken time.
+func groupTimelineItems(items []TimelineItem, layout string) []TimelineGroup {
+	groups := []TimelineGroup{}
+	for _, it := range items {
+		key := it.TakenTime.ToTime().In(time.Local).Format(layout)
+		if len(groups) < 1 || groups[len(groups)-1].Key != key {
+			groups = append(groups, TimelineGroup{Key: key, Items: []TimelineItem{}})
+		}
+		g := &groups[len(groups)-1]
+		g.Items = append(g.Items, it)
+	}
+	return groups
+}
+
+// Generate temp tokens for the files and the thumbnails, images without thumbnails use the original as thumbnail.
+func genTimelineTokens(rail miso.Rail, items []TimelineItem) {
+	if len(items) < 1 {
+		return
+	}
+	// fstore_file_id -> name, originals are downloaded with the file name
+	fileIds := map[string]string{}
+	for i, it := range items {
+		if it.Thumbnail == "" && isImageMime(it.MimeType) {
+			items[i].Thumbnail = it.FstoreFileId
+		}
+		fileIds[it.FstoreFileId] = it.Name
+		if _, ok := fileIds[items[i].Thumbnail]; !ok && items[i].Thumbnail != "" {
+			fileIds[items[i].Thumbnail] = ""
+		}
+	}
+
+	awaitFutures := util.NewAwaitFutures[FstoreTmpToken](vfmPool)
+	for id, name := range fileIds {
+		GenFstoreTknBatch(rail, awaitFutures, id, name)
+	}
+	idTknMap := map[string]string{}
+	for _, fut := range awaitFutures.Await() {
+		t, err := fut.Get()
+		if err != nil {
+			rail.Errorf("Failed to get mini-fstore temp token for fstore_file_id: %v, %v", t.FileId, err)
+			continue
+		}
+		idTknMap[t.FileId] = t.TempKey
+	}
+	for i, it := range items {
+		items[i].FileTempToken = idTknMap[it.FstoreFileId]
+		items[i].ThumbnailToken = idTknMap[it.Thumbnail]
+	}
+}
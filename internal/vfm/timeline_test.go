@@ -0,0 +1,42 @@
+package vfm
+
+import (
+	"testing"
+	"time"
+
+	"github.com/curtisnewbie/miso/util"
+)
+
+func TestGroupTimelineItems(t *testing.T) {
+	at := func(s string) util.ETime {
+		tm, _ := time.ParseInLocation("2006-01-02 15:04", s, time.Local)
+		return util.ToETime(tm)
+	}
+	items := []TimelineItem{
+		{FileKey: "a", TakenTime: at("2023-05-06 23:00")},
+		{FileKey: "b", TakenTime: at("2023-05-06 01:00")},
+		{FileKey: "c", TakenTime: at("2023-05-01 10:00")},
+		{FileKey: "d", TakenTime: at("2023-04-30 10:00")},
+	}
+
+	days := groupTimelineItems(items, "2006-01-02")
+	if len(days) != 3 || days[0].Key != "2023-05-06" || len(days[0].Items) != 2 || days[2].Key != "2023-04-30" {
+		t.Errorf("unexpected day groups: %+v", days)
+	}
+	months := groupTimelineItems(items, "2006-01")
+	if len(months) != 2 || months[0].Key != "2023-05" || len(months[0].Items) != 3 {
+		t.Errorf("unexpected month groups: %+v", months)
+	}
+}
+
+func TestParseTimelineCursor(t *testing.T) {
+	tm, id, err := parseTimelineCursor("1683334089000_42")
+	if err != nil || tm.UnixMilli() != 1683334089000 || id != 42 {
+		t.Errorf("unexpected cursor: %v, %v, %v", tm, id, err)
+	}
+	for _, c := range []string{"", "abc", "1_x", "x_1"} {
+		if _, _, err := parseTimelineCursor(c); err == nil {
+			t.Errorf("%q: want error", c)
+		}
+	}
+}
@@ -8,6 +8,7 @@ import (
 	"github.com/curtisnewbie/miso/middleware/user-vault/common"
 	"github.com/curtisnewbie/miso/miso"
 	"github.com/curtisnewbie/miso/util"
+	vfmapi "github.com/curtisnewbie/vfm/api"
 	"gorm.io/gorm"
 )
 
@@ -68,7 +69,13 @@ func CreateVerFile(rail miso.Rail, db *gorm.DB, req ApiCreateVerFileReq, user co
 			SaveVerFileLogReq{VerFileId: verFileId, FileKey: fk, Username: user.Username}); err != nil {
 			return err
 		}
-		return nil
+		return RecordActivity(rail, tx, Activity{
+			RefType: ActRefVerFile,
+			RefKey:  verFileId,
+			FileKey: fk,
+			Action:  ActionCreate,
+			Detail:  fmt.Sprintf("Created versioned file '%v'", f.Name),
+		}, user)
 	})
 	if err != nil {
 		return res, err
@@ -134,7 +141,7 @@ func UpdateVerFile(rail miso.Rail, db *gorm.DB, req ApiUpdateVerFileReq, user co
 		return nil
 	}
 
-	return db.Transaction(func(tx *gorm.DB) error {
+	err = db.Transaction(func(tx *gorm.DB) error {
 		svlr := SaveVerFileLogReq{VerFileId: req.VerFileId, FileKey: fk, Username: user.Username}
 		if err := SaveVerFileLog(rail, tx, svlr); err != nil {
 			return err
@@ -155,8 +162,28 @@ func UpdateVerFile(rail miso.Rail, db *gorm.DB, req ApiUpdateVerFileReq, user co
 
 		rail.Infof("Versioned file %v updated using %v", req.VerFileId, f.Uuid)
 
-		return nil
+		return RecordActivity(rail, tx, Activity{
+			RefType: ActRefVerFile,
+			RefKey:  req.VerFileId,
+			FileKey: f.Uuid,
+			Action:  ActionUpdate,
+			Detail:  fmt.Sprintf("Uploaded new version '%v'", f.Name),
+		}, user)
 	})
+	if err != nil {
+		return err
+	}
+
+	publishVersionedFileUpdated(rail, vfmapi.VersionedFileUpdatedEvent{
+		VerFileId:        req.VerFileId,
+		Name:             f.Name,
+		FileKey:          f.Uuid,
+		PrevFileKey:      uvf.FileKey,
+		SizeInBytes:      f.SizeInBytes,
+		OperatorUserNo:   user.UserNo,
+		OperatorUsername: user.Username,
+	})
+	return nil
 
 }
 
@@ -271,7 +298,13 @@ func DelVerFile(rail miso.Rail, db *gorm.DB, req ApiDelVerFileReq, user common.U
 				return fmt.Errorf("failed to delete file in versioend_file_log, %v, %v, %w", req.VerFileId, fk, err)
 			}
 		}
-		return nil
+		return RecordActivity(rail, tx, Activity{
+			RefType: ActRefVerFile,
+			RefKey:  req.VerFileId,
+			FileKey: uvf.FileKey,
+			Action:  ActionDelete,
+			Detail:  "Deleted versioned file",
+		}, user)
 	})
 }
 
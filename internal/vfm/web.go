@@ -2,6 +2,7 @@ package vfm
 
 import (
 	"bytes"
+	"fmt"
 	"io"
 	"net/http"
 	"net/url"
@@ -14,6 +15,7 @@ import (
 	"github.com/curtisnewbie/miso/miso"
 	"github.com/curtisnewbie/miso/util"
 	vault "github.com/curtisnewbie/user-vault/api"
+	"github.com/curtisnewbie/vfm/api"
 	"github.com/skip2/go-qrcode"
 	"gorm.io/gorm"
 )
@@ -82,21 +84,23 @@ type BatchMoveIntoDirReq struct {
 func BatchMoveFileToDirEp(inb *miso.Inbound, req BatchMoveIntoDirReq) (any, error) {
 	rail := inb.Rail()
 	user := common.GetUser(rail)
-	db := mysql.GetMySQL()
-	for _, r := range req.Instructions {
-		if err := MoveFileToDir(rail, db, r, user); err != nil {
-			return nil, err
-		}
+	res, err := BatchMoveFiles(rail, mysql.GetMySQL(), BatchMoveFileReq{Mode: BatchModeAllOrNothing, Items: req.Instructions}, user)
+	if err != nil {
+		return nil, err
 	}
-	return nil, nil
+	return nil, res.FirstErr()
 }
 
 // misoapi-http: POST /open/api/file/make-dir
 // misoapi-desc: User make directory
+// misoapi-header-doc: Idempotency-Key: optional key, retries with the same key replay the first response
 // misoapi-resource: ref(ManageFilesResource)
 func MakeDirEp(inb *miso.Inbound, req MakeDirReq) (string, error) {
 	rail := inb.Rail()
-	return MakeDir(rail, mysql.GetMySQL(), req, common.GetUser(rail))
+	user := common.GetUser(rail)
+	return RunIdempotent(inb, mysql.GetMySQL(), req, user, func() (string, error) {
+		return MakeDir(rail, mysql.GetMySQL(), req, user)
+	})
 }
 
 // misoapi-http: GET /open/api/file/dir/list
@@ -142,14 +146,11 @@ func BatchDeleteFileEp(inb *miso.Inbound, req BatchDeleteFileReq) (any, error) {
 	rail := inb.Rail()
 	user := common.GetUser(rail)
 	if len(req.FileKeys) < 31 {
-		for i := range req.FileKeys {
-			fk := req.FileKeys[i]
-			if err := DeleteFile(rail, mysql.GetMySQL(), DeleteFileReq{fk}, user, nil); err != nil {
-				rail.Errorf("failed to delete file, fileKey: %v, %v", fk, err)
-				return nil, err
-			}
+		res, err := BatchDeleteFiles(rail, mysql.GetMySQL(), BatchDeleteFilesReq{Mode: BatchModeAllOrNothing, FileKeys: req.FileKeys}, user)
+		if err != nil {
+			return nil, err
 		}
-		return nil, nil
+		return nil, res.FirstErr()
 	}
 
 	// too many file keys, delete files asynchronously
@@ -165,13 +166,44 @@ func BatchDeleteFileEp(inb *miso.Inbound, req BatchDeleteFileReq) (any, error) {
 	return nil, nil
 }
 
+// misoapi-http: POST /open/api/file/batch/move
+// misoapi-desc: User move files into directories in batch, in ALL_OR_NOTHING mode, nothing is changed if any item fails; in BEST_EFFORT mode, each item is applied independently
+// misoapi-resource: ref(ManageFilesResource)
+func ApiBatchMoveFiles(inb *miso.Inbound, req BatchMoveFileReq) (BatchRes, error) {
+	return BatchMoveFiles(inb.Rail(), mysql.GetMySQL(), req, common.GetUser(inb.Rail()))
+}
+
+// misoapi-http: POST /open/api/file/batch/delete
+// misoapi-desc: User delete files in batch, in ALL_OR_NOTHING mode, nothing is changed if any item fails; in BEST_EFFORT mode, each item is applied independently
+// misoapi-resource: ref(ManageFilesResource)
+func ApiBatchDeleteFiles(inb *miso.Inbound, req BatchDeleteFilesReq) (BatchRes, error) {
+	return BatchDeleteFiles(inb.Rail(), mysql.GetMySQL(), req, common.GetUser(inb.Rail()))
+}
+
+// misoapi-http: POST /open/api/file/batch/copy
+// misoapi-desc: User copy files into directory in batch, in ALL_OR_NOTHING mode, nothing is changed if any item fails; in BEST_EFFORT mode, each item is applied independently
+// misoapi-resource: ref(ManageFilesResource)
+func ApiBatchCopyFiles(inb *miso.Inbound, req BatchCopyFilesReq) (BatchRes, error) {
+	return BatchCopyFiles(inb.Rail(), mysql.GetMySQL(), req, common.GetUser(inb.Rail()))
+}
+
+// misoapi-http: POST /open/api/file/batch/rename
+// misoapi-desc: User rename files in batch, in ALL_OR_NOTHING mode, nothing is changed if any item fails; in BEST_EFFORT mode, each item is applied independently
+// misoapi-resource: ref(ManageFilesResource)
+func ApiBatchRenameFiles(inb *miso.Inbound, req BatchRenameFilesReq) (BatchRes, error) {
+	return BatchRenameFiles(inb.Rail(), mysql.GetMySQL(), req, common.GetUser(inb.Rail()))
+}
+
 // misoapi-http: POST /open/api/file/create
-// misoapi-desc: User create file
+// misoapi-desc: User create file, the file key is returned
+// misoapi-header-doc: Idempotency-Key: optional key, retries with the same key replay the first response
 // misoapi-resource: ref(ManageFilesResource)
-func CreateFileEp(inb *miso.Inbound, req CreateFileReq) (any, error) {
+func CreateFileEp(inb *miso.Inbound, req CreateFileReq) (string, error) {
 	rail := inb.Rail()
-	_, err := CreateFile(rail, mysql.GetMySQL(), req, common.GetUser(rail))
-	return nil, err
+	user := common.GetUser(rail)
+	return RunIdempotent(inb, mysql.GetMySQL(), req, user, func() (string, error) {
+		return CreateFile(rail, mysql.GetMySQL(), req, user)
+	})
 }
 
 // misoapi-http: POST /open/api/file/info/update
@@ -190,6 +222,14 @@ func GenFileTknEp(inb *miso.Inbound, req GenerateTempTokenReq) (string, error) {
 	return GenTempToken(rail, mysql.GetMySQL(), req, common.GetUser(rail))
 }
 
+// misoapi-http: POST /open/api/file/thumbnail/regenerate
+// misoapi-desc: User regenerate thumbnail of the image or video, the failed attempts are reset
+// misoapi-resource: ref(ManageFilesResource)
+func ApiRegenerateThumbnail(inb *miso.Inbound, req RegenerateThumbnailReq) (any, error) {
+	rail := inb.Rail()
+	return nil, RegenerateThumbnail(rail, mysql.GetMySQL(), req, common.GetUser(rail))
+}
+
 // misoapi-http: POST /open/api/file/unpack
 // misoapi-desc: User unpack zip
 // misoapi-resource: ref(ManageFilesResource)
@@ -249,10 +289,14 @@ func ListVFoldersEp(inb *miso.Inbound, req ListVFolderReq) (ListVFolderRes, erro
 
 // misoapi-http: POST /open/api/vfolder/create
 // misoapi-desc: User create virtual folder
+// misoapi-header-doc: Idempotency-Key: optional key, retries with the same key replay the first response
 // misoapi-resource: ref(ManageFilesResource)
 func CreateVFolderEp(inb *miso.Inbound, req CreateVFolderReq) (string, error) {
 	rail := inb.Rail()
-	return CreateVFolder(rail, mysql.GetMySQL(), req, common.GetUser(rail))
+	user := common.GetUser(rail)
+	return RunIdempotent(inb, mysql.GetMySQL(), req, user, func() (string, error) {
+		return CreateVFolder(rail, mysql.GetMySQL(), req, user)
+	})
 }
 
 // misoapi-http: POST /open/api/vfolder/file/add
@@ -356,7 +400,7 @@ func ListGalleriesEp(inb *miso.Inbound, cmd ListGalleriesCmd) (miso.PageRes[VGal
 }
 
 // misoapi-http: POST /open/api/gallery/access/grant
-// misoapi-desc: Grant access to the galleries
+// misoapi-desc: Grant access to the galleries, either as VIEWER or CONTRIBUTOR, role of the existing access is updated
 // misoapi-resource: ref(ManageFilesResource)
 func GranteGalleryAccessEp(inb *miso.Inbound, cmd PermitGalleryAccessCmd) (any, error) {
 	rail := inb.Rail()
@@ -392,8 +436,72 @@ func ListGalleryImagesEp(inb *miso.Inbound, cmd ListGalleryImagesCmd) (*ListGall
 	return ListGalleryImages(rail, mysql.GetMySQL(), cmd, common.GetUser(rail))
 }
 
+// misoapi-http: POST /open/api/gallery/image/remove
+// misoapi-desc: Remove images from gallery, the owner can remove any image, contributors can only remove images added by themselves, the files are not deleted
+// misoapi-resource: ref(ManageFilesResource)
+func RemoveGalleryImagesEp(inb *miso.Inbound, req RemoveGalleryImagesReq) (any, error) {
+	rail := inb.Rail()
+	return nil, RemoveGalleryImages(rail, mysql.GetMySQL(), req, common.GetUser(rail))
+}
+
+// misoapi-http: POST /open/api/gallery/image/reorder
+// misoapi-desc: Reorder images in gallery, the listed images are placed first in the given order, the others are ordered by capture time
+// misoapi-resource: ref(ManageFilesResource)
+func ReorderGalleryImagesEp(inb *miso.Inbound, req ReorderGalleryImagesReq) (any, error) {
+	rail := inb.Rail()
+	return nil, ReorderGalleryImages(rail, mysql.GetMySQL(), req, common.GetUser(rail))
+}
+
+// misoapi-http: POST /open/api/gallery/cover
+// misoapi-desc: Choose the cover image of gallery
+// misoapi-resource: ref(ManageFilesResource)
+func SetGalleryCoverEp(inb *miso.Inbound, req SetGalleryCoverReq) (any, error) {
+	rail := inb.Rail()
+	return nil, SetGalleryCover(rail, mysql.GetMySQL(), req, common.GetUser(rail))
+}
+
+// misoapi-http: GET /open/api/gallery/auto/pref/list
+// misoapi-desc: List preferences of automatic gallery creation for directories
+// misoapi-resource: ref(ManageFilesResource)
+func ListAutoGalleryPrefsEp(inb *miso.Inbound) (AutoGalleryPrefs, error) {
+	rail := inb.Rail()
+	return ListAutoGalleryPrefs(rail, mysql.GetMySQL(), common.GetUser(rail))
+}
+
+// misoapi-http: POST /open/api/gallery/auto/pref/update
+// misoapi-desc: Turn automatic gallery creation on or off for a directory, or update the default of the user
+// misoapi-resource: ref(ManageFilesResource)
+func UpdateAutoGalleryPrefEp(inb *miso.Inbound, req UpdateAutoGalleryPrefReq) (any, error) {
+	rail := inb.Rail()
+	return nil, UpdateAutoGalleryPref(rail, mysql.GetMySQL(), req, common.GetUser(rail))
+}
+
+// misoapi-http: POST /open/api/gallery/auto/clean
+// misoapi-desc: Delete galleries created automatically for directories where automatic gallery creation is disabled, the files are not deleted
+// misoapi-resource: ref(ManageFilesResource)
+func CleanAutoGalleriesEp(inb *miso.Inbound, req CleanAutoGalleriesReq) ([]VGalleryBrief, error) {
+	rail := inb.Rail()
+	return CleanAutoGalleries(rail, mysql.GetMySQL(), req, common.GetUser(rail))
+}
+
+// misoapi-http: POST /open/api/timeline
+// misoapi-desc: List user's images and videos (including those in accessible galleries) by capture time, grouped by day or month
+// misoapi-resource: ref(ManageFilesResource)
+func ListTimelineEp(inb *miso.Inbound, req ListTimelineReq) (ListTimelineResp, error) {
+	rail := inb.Rail()
+	return ListTimeline(rail, mysql.GetMySQL(), req, common.GetUser(rail))
+}
+
+// misoapi-http: POST /open/api/timeline/on-this-day
+// misoapi-desc: List user's images and videos taken on the same day in previous years
+// misoapi-resource: ref(ManageFilesResource)
+func ListOnThisDayEp(inb *miso.Inbound, req ListOnThisDayReq) (ListOnThisDayResp, error) {
+	rail := inb.Rail()
+	return ListOnThisDay(rail, mysql.GetMySQL(), req, common.GetUser(rail))
+}
+
 // misoapi-http: POST /open/api/gallery/image/transfer
-// misoapi-desc: Host selected images on gallery
+// misoapi-desc: Host selected images on gallery, both the owner and the contributors can add images that they own
 // misoapi-resource: ref(ManageFilesResource)
 func TransferGalleryImageEp(inb *miso.Inbound, cmd TransferGalleryImageReq) (any, error) {
 	rail := inb.Rail()
@@ -452,9 +560,13 @@ func ApiCreateVersionedFile(inb *miso.Inbound, req ApiCreateVerFileReq) (ApiCrea
 
 // misoapi-http: POST /open/api/versioned-file/update
 // misoapi-desc: Update versioned file
+// misoapi-header-doc: Idempotency-Key: optional key, retries with the same key replay the first response
 // misoapi-resource: ref(ManageFilesResource)
 func ApiUpdateVersionedFile(inb *miso.Inbound, req ApiUpdateVerFileReq) (any, error) {
-	return nil, UpdateVerFile(inb.Rail(), mysql.GetMySQL(), req, common.GetUser(inb.Rail()))
+	user := common.GetUser(inb.Rail())
+	return RunIdempotent(inb, mysql.GetMySQL(), req, user, func() (any, error) {
+		return nil, UpdateVerFile(inb.Rail(), mysql.GetMySQL(), req, user)
+	})
 }
 
 // misoapi-http: POST /open/api/versioned-file/delete
@@ -464,18 +576,305 @@ func ApiDelVersionedFile(inb *miso.Inbound, req ApiDelVerFileReq) (any, error) {
 	return nil, DelVerFile(inb.Rail(), mysql.GetMySQL(), req, common.GetUser(inb.Rail()))
 }
 
+// misoapi-http: POST /open/api/notification/list
+// misoapi-desc: List notifications
+// misoapi-resource: ref(ManageFilesResource)
+func ApiListNotifications(inb *miso.Inbound, req ListNotifiReq) (miso.PageRes[ListedNotifi], error) {
+	return ListNotifications(inb.Rail(), mysql.GetMySQL(), req, common.GetUser(inb.Rail()))
+}
+
+// misoapi-http: GET /open/api/notification/count-unread
+// misoapi-desc: Count unread notifications
+// misoapi-resource: ref(ManageFilesResource)
+func ApiCountUnreadNotifications(inb *miso.Inbound) (int, error) {
+	return CountUnreadNotifications(inb.Rail(), mysql.GetMySQL(), common.GetUser(inb.Rail()))
+}
+
+// misoapi-http: POST /open/api/notification/read
+// misoapi-desc: Mark notification as read
+// misoapi-resource: ref(ManageFilesResource)
+func ApiMarkNotificationRead(inb *miso.Inbound, req MarkNotifiReadReq) (any, error) {
+	return nil, MarkNotificationRead(inb.Rail(), mysql.GetMySQL(), req, common.GetUser(inb.Rail()))
+}
+
+// misoapi-http: POST /open/api/notification/read-all
+// misoapi-desc: Mark all notifications as read
+// misoapi-resource: ref(ManageFilesResource)
+func ApiMarkAllNotificationsRead(inb *miso.Inbound) (any, error) {
+	return nil, MarkAllNotificationsRead(inb.Rail(), mysql.GetMySQL(), common.GetUser(inb.Rail()))
+}
+
+// misoapi-http: GET /open/api/notification/pref/list
+// misoapi-desc: List notification preferences
+// misoapi-resource: ref(ManageFilesResource)
+func ApiListNotifiPrefs(inb *miso.Inbound) ([]NotifiPref, error) {
+	return ListNotifiPrefs(inb.Rail(), mysql.GetMySQL(), common.GetUser(inb.Rail()))
+}
+
+// misoapi-http: POST /open/api/notification/pref/update
+// misoapi-desc: Mute or unmute notification type
+// misoapi-resource: ref(ManageFilesResource)
+func ApiUpdateNotifiPref(inb *miso.Inbound, req UpdateNotifiPrefReq) (any, error) {
+	return nil, UpdateNotifiPref(inb.Rail(), mysql.GetMySQL(), req, common.GetUser(inb.Rail()))
+}
+
+// misoapi-http: POST /open/api/notification/subscribe
+// misoapi-desc: Subscribe to changes of a directory or vfolder
+// misoapi-resource: ref(ManageFilesResource)
+func ApiSubscribe(inb *miso.Inbound, req SubscribeReq) (any, error) {
+	return nil, Subscribe(inb.Rail(), mysql.GetMySQL(), req, common.GetUser(inb.Rail()))
+}
+
+// misoapi-http: POST /open/api/notification/unsubscribe
+// misoapi-desc: Unsubscribe from changes of a directory or vfolder
+// misoapi-resource: ref(ManageFilesResource)
+func ApiUnsubscribe(inb *miso.Inbound, req SubscribeReq) (any, error) {
+	return nil, Unsubscribe(inb.Rail(), mysql.GetMySQL(), req, common.GetUser(inb.Rail()))
+}
+
+// misoapi-http: POST /open/api/notification/subscription/list
+// misoapi-desc: List subscriptions
+// misoapi-resource: ref(ManageFilesResource)
+func ApiListSubscriptions(inb *miso.Inbound, req ListSubscriptionReq) (miso.PageRes[ListedSubscription], error) {
+	return ListSubscriptions(inb.Rail(), mysql.GetMySQL(), req, common.GetUser(inb.Rail()))
+}
+
+// misoapi-http: POST /open/api/activity/file
+// misoapi-desc: List activities of a file
+// misoapi-resource: ref(ManageFilesResource)
+func ApiListFileActivities(inb *miso.Inbound, req ListFileActivityReq) (miso.PageRes[ListedActivity], error) {
+	return ListFileActivities(inb.Rail(), mysql.GetMySQL(), req, common.GetUser(inb.Rail()))
+}
+
+// misoapi-http: POST /open/api/activity/user
+// misoapi-desc: List activities operated by current user
+// misoapi-resource: ref(ManageFilesResource)
+func ApiListUserActivities(inb *miso.Inbound, req ListUserActivityReq) (miso.PageRes[ListedActivity], error) {
+	return ListUserActivities(inb.Rail(), mysql.GetMySQL(), req, common.GetUser(inb.Rail()))
+}
+
+// misoapi-http: POST /open/api/activity/vfolder
+// misoapi-desc: List activities inside a vfolder, only the owner of the vfolder can do so
+// misoapi-resource: ref(ManageFilesResource)
+func ApiListVFolderActivities(inb *miso.Inbound, req ListVFolderActivityReq) (miso.PageRes[ListedActivity], error) {
+	return ListVFolderActivities(inb.Rail(), mysql.GetMySQL(), req, common.GetUser(inb.Rail()))
+}
+
+// misoapi-http: POST /open/api/webhook/create
+// misoapi-desc: Register webhook on a directory, a vfolder or user's whole space
+// misoapi-resource: ref(ManageFilesResource)
+func ApiCreateWebhook(inb *miso.Inbound, req CreateWebhookReq) (CreateWebhookRes, error) {
+	return CreateWebhook(inb.Rail(), mysql.GetMySQL(), req, common.GetUser(inb.Rail()))
+}
+
+// misoapi-http: POST /open/api/webhook/update
+// misoapi-desc: Update webhook
+// misoapi-resource: ref(ManageFilesResource)
+func ApiUpdateWebhook(inb *miso.Inbound, req UpdateWebhookReq) (any, error) {
+	return nil, UpdateWebhook(inb.Rail(), mysql.GetMySQL(), req, common.GetUser(inb.Rail()))
+}
+
+// misoapi-http: POST /open/api/webhook/delete
+// misoapi-desc: Delete webhook
+// misoapi-resource: ref(ManageFilesResource)
+func ApiDeleteWebhook(inb *miso.Inbound, req DeleteWebhookReq) (any, error) {
+	return nil, DeleteWebhook(inb.Rail(), mysql.GetMySQL(), req, common.GetUser(inb.Rail()))
+}
+
+// misoapi-http: POST /open/api/webhook/list
+// misoapi-desc: List webhooks
+// misoapi-resource: ref(ManageFilesResource)
+func ApiListWebhooks(inb *miso.Inbound, req ListWebhookReq) (miso.PageRes[ListedWebhook], error) {
+	return ListWebhooks(inb.Rail(), mysql.GetMySQL(), req, common.GetUser(inb.Rail()))
+}
+
+// misoapi-http: POST /open/api/webhook/delivery/list
+// misoapi-desc: List webhook deliveries
+// misoapi-resource: ref(ManageFilesResource)
+func ApiListWebhookDeliveries(inb *miso.Inbound, req ListWebhookDeliveryReq) (miso.PageRes[ListedWebhookDelivery], error) {
+	return ListWebhookDeliveries(inb.Rail(), mysql.GetMySQL(), req, common.GetUser(inb.Rail()))
+}
+
+// misoapi-http: POST /open/api/s3/access-key/create
+// misoapi-desc: Create access key for the S3-compatible gateway, the secret key is returned only once
+// misoapi-resource: ref(ManageFilesResource)
+func ApiCreateS3AccessKey(inb *miso.Inbound, req CreateS3AccessKeyReq) (CreateS3AccessKeyRes, error) {
+	return CreateS3AccessKey(inb.Rail(), mysql.GetMySQL(), req, common.GetUser(inb.Rail()))
+}
+
+// misoapi-http: POST /open/api/s3/access-key/list
+// misoapi-desc: List access keys for the S3-compatible gateway
+// misoapi-resource: ref(ManageFilesResource)
+func ApiListS3AccessKeys(inb *miso.Inbound, req ListS3AccessKeyReq) (miso.PageRes[ListedS3AccessKey], error) {
+	return ListS3AccessKeys(inb.Rail(), mysql.GetMySQL(), req, common.GetUser(inb.Rail()))
+}
+
+// misoapi-http: POST /open/api/s3/access-key/delete
+// misoapi-desc: Delete access key for the S3-compatible gateway
+// misoapi-resource: ref(ManageFilesResource)
+func ApiDeleteS3AccessKey(inb *miso.Inbound, req DeleteS3AccessKeyReq) (any, error) {
+	return nil, DeleteS3AccessKey(inb.Rail(), mysql.GetMySQL(), req, common.GetUser(inb.Rail()))
+}
+
+// misoapi-http: POST /open/api/job/list
+// misoapi-desc: List user's background jobs
+// misoapi-resource: ref(ManageFilesResource)
+func ApiListJobs(inb *miso.Inbound, req ListJobReq) (miso.PageRes[ListedJob], error) {
+	return ListJobs(inb.Rail(), mysql.GetMySQL(), req, common.GetUser(inb.Rail()))
+}
+
+// misoapi-http: GET /open/api/job/get
+// misoapi-desc: Get user's background job
+// misoapi-query-doc: jobNo: job no
+// misoapi-resource: ref(ManageFilesResource)
+func ApiGetJob(inb *miso.Inbound, req GetJobReq) (ListedJob, error) {
+	return GetJob(inb.Rail(), mysql.GetMySQL(), req, common.GetUser(inb.Rail()))
+}
+
+// misoapi-http: POST /open/api/job/cancel
+// misoapi-desc: Cancel user's background job, running job stops at next checkpoint
+// misoapi-resource: ref(ManageFilesResource)
+func ApiCancelJob(inb *miso.Inbound, req CancelJobReq) (any, error) {
+	return nil, CancelJob(inb.Rail(), mysql.GetMySQL(), req, common.GetUser(inb.Rail()))
+}
+
+// misoapi-http: GET /remote/user/file/info
+// misoapi-desc: Internal endpoint, fetch file info
+// misoapi-query-doc: fileKey: file key
+func FetchFileInfoInternalEp(rail miso.Rail, db *gorm.DB, req api.FetchFileInfoReq) (api.FileInfoResp, error) {
+	if req.FileKey == "" {
+		return api.FileInfoResp{}, miso.NewErrf("fileKey is required")
+	}
+	return FetchFileInfoInternal(rail, db, req)
+}
+
+// misoapi-http: GET /remote/user/file/owner/validation
+// misoapi-desc: Internal endpoint, validate whether the file is owned by the user
+// misoapi-query-doc: fileKey: file key
+// misoapi-query-doc: userNo: user_no of the expected owner
+func ValidateFileOwnerEp(rail miso.Rail, db *gorm.DB, req api.ValidateFileOwnerReq) (bool, error) {
+	if req.FileKey == "" || req.UserNo == "" {
+		return false, miso.NewErrf("fileKey and userNo are required")
+	}
+	return ValidateFileOwner(rail, db, req)
+}
+
+// misoapi-http: GET /remote/user/file/indir/list
+// misoapi-desc: Internal endpoint, list file keys of files in the directory, directories are excluded
+// misoapi-query-doc: fileKey: file key of the directory
+// misoapi-query-doc: limit: page size, at most 100
+// misoapi-query-doc: page: page number, starts from 1
+func ListFilesInDirEp(rail miso.Rail, db *gorm.DB, req api.ListFilesInDirReq) ([]string, error) {
+	if req.FileKey == "" {
+		return nil, miso.NewErrf("fileKey is required")
+	}
+	return ListFilesInDir(rail, db, req)
+}
+
 // misoapi-http: POST /compensate/thumbnail
-// misoapi-desc: Compensate thumbnail generation
+// misoapi-desc: Detect media types of files that are not yet detected, and compensate thumbnail generation
 func CompensateThumbnailEp(rail miso.Rail, db *gorm.DB) (any, error) {
 	return nil, CompensateThumbnail(rail, db)
 }
 
+// misoapi-http: POST /compensate/thumbnail/failure/report
+// misoapi-desc: List files that have failed thumbnail generation permanently
+func ListThumbnailFailuresEp(rail miso.Rail, db *gorm.DB, req ListThumbnailFailureReq) (miso.PageRes[ThumbnailFailure], error) {
+	return ListThumbnailFailures(rail, db, req)
+}
+
+// misoapi-http: POST /compensate/thumbnail/variant
+// misoapi-desc: Compensate thumbnail variants generation for images that have thumbnails but don't have any variant
+func CompensateThumbnailVariantsEp(rail miso.Rail, db *gorm.DB) (any, error) {
+	return nil, CompensateThumbnailVariants(rail, db)
+}
+
+// misoapi-http: POST /compensate/preview
+// misoapi-desc: Compensate previews of documents, PDFs without thumbnails are rendered and excerpts are generated for text files
+func CompensatePreviewEp(rail miso.Rail, db *gorm.DB) (any, error) {
+	return nil, CompensatePreview(rail, db)
+}
+
+// misoapi-http: POST /compensate/image/metadata
+// misoapi-desc: Compensate EXIF/XMP metadata extraction for images that don't have metadata
+func CompensateImageMetadataEp(rail miso.Rail, db *gorm.DB) (any, error) {
+	return nil, CompensateImageMetadata(rail, db)
+}
+
+// misoapi-http: POST /compensate/gallery/dir
+// misoapi-desc: Bring directory galleries in sync with their directories, i.e., remove images moved out, follow directory names and delete or detach galleries of deleted directories
+func CompensateDirGalleriesEp(rail miso.Rail, db *gorm.DB) (any, error) {
+	return nil, CompensateDirGalleries(rail, db)
+}
+
 // misoapi-http: POST /compensate/dir/calculate-size
-// misoapi-desc: Calculate size of all directories recursively
+// misoapi-desc: Recompute size, file count and sub-directory count of all directories, and fix the mismatched ones
 func ImMemBatchCalcDirSizeEp(rail miso.Rail, db *gorm.DB) (any, error) {
 	return nil, ImMemBatchCalcDirSize(rail, mysql.GetMySQL())
 }
 
+// misoapi-http: POST /compensate/dir/verify-size
+// misoapi-desc: Compare the stored size, file count and sub-directory count of all directories with a full recompute, nothing is changed
+func VerifyDirSizeEp(rail miso.Rail, db *gorm.DB) (DirSizeReport, error) {
+	return VerifyDirSize(rail, db, false)
+}
+
+// misoapi-http: POST /gc/physic-delete/run
+// misoapi-desc: Trigger physical deletion GC asynchronously, returns the runNo
+func TriggerPhysicDeleteGcEp(rail miso.Rail, db *gorm.DB) (string, error) {
+	return TriggerPhysicDeleteGc(rail, db)
+}
+
+// misoapi-http: POST /gc/physic-delete/dry-run
+// misoapi-desc: Run physical deletion GC in dry-run mode, report the files that would be processed
+func DryRunPhysicDeleteGcEp(rail miso.Rail, db *gorm.DB) (PhysicDeleteGcReport, error) {
+	return RunPhysicDeleteGc(rail, db, true)
+}
+
+// misoapi-http: POST /gc/physic-delete/report
+// misoapi-desc: List physical deletion GC reports
+func ListGcRunsEp(rail miso.Rail, db *gorm.DB, req ListGcRunReq) (miso.PageRes[GcRun], error) {
+	return ListGcRuns(rail, db, req)
+}
+
+// misoapi-http: POST /compensate/reconcile/fstore
+// misoapi-desc: Trigger reconciliation between file_info and mini-fstore asynchronously, returns the runNo
+func TriggerReconcileFstoreEp(rail miso.Rail, db *gorm.DB) (string, error) {
+	return TriggerReconcileFstore(rail, db)
+}
+
+// misoapi-http: POST /compensate/reconcile/fstore/report
+// misoapi-desc: List reconciliation reports
+func ListReconcileRunsEp(rail miso.Rail, db *gorm.DB, req ListReconcileRunReq) (miso.PageRes[ReconcileRun], error) {
+	return ListReconcileRuns(rail, db, req)
+}
+
+// misoapi-http: GET /compensate/reconcile/fstore/report/download
+// misoapi-desc: Download dangling references found in the reconciliation run as csv
+// misoapi-query-doc: runNo: Reconciliation runNo
+func DownloadReconcileReportEp(inb *miso.Inbound) {
+	w, r := inb.Unwrap()
+	rail := inb.Rail()
+	runNo := r.URL.Query().Get("runNo")
+	if util.IsBlankStr(runNo) {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	var buf bytes.Buffer
+	if err := WriteReconcileReport(rail, mysql.GetMySQL(), runNo, &buf); err != nil {
+		rail.Errorf("Failed to write reconciliation report, runNo: %v, %v", runNo, err)
+		inb.HandleResult(nil, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/csv")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"reconcile_%v.csv\"", runNo))
+	if _, err := io.Copy(w, &buf); err != nil {
+		rail.Errorf("Failed to transfer reconciliation report, runNo: %v, %v", runNo, err)
+	}
+}
+
 type ListBookmarksReq struct {
 	Name *string
 
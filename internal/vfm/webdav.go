@@ -0,0 +1,720 @@
+package vfm
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/curtisnewbie/miso/middleware/mysql"
+	"github.com/curtisnewbie/miso/middleware/redis"
+	"github.com/curtisnewbie/miso/middleware/user-vault/common"
+	"github.com/curtisnewbie/miso/miso"
+	"github.com/curtisnewbie/miso/util"
+	vault "github.com/curtisnewbie/user-vault/api"
+	"github.com/gin-gonic/gin"
+	"golang.org/x/net/webdav"
+	"gorm.io/gorm"
+)
+
+const (
+	PropWebDavEnabled = "vfm.webdav.enabled"
+
+	WebDavPrefix = "/dav"
+
+	// name of the read-only top-level collection that contains user's vfolders
+	WebDavVFolderDir = "VFolders"
+)
+
+var (
+	webDavMethods = []string{
+		http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodDelete, http.MethodOptions,
+		"PROPFIND", "PROPPATCH", "MKCOL", "COPY", "MOVE", "LOCK", "UNLOCK",
+	}
+
+	webDavLockSystem = webdav.NewMemLS()
+
+	// authenticated users, key is the sha256 of the credentials
+	webDavUserCache = redis.NewRCache[common.User]("vfm:webdav:user", redis.RCacheConfig{Exp: 5 * time.Minute, NoSync: true})
+
+	// client for streaming files from / to mini-fstore, requests are not limited by the default timeout
+	webDavFstoreClient = &http.Client{Transport: miso.MisoDefaultClient.Transport}
+)
+
+func init() {
+	miso.SetDefProp(PropWebDavEnabled, true)
+}
+
+// Register WebDAV routes.
+//
+// WebDAV methods (e.g., PROPFIND, MKCOL) are not supported by miso's routes, so they are registered on gin directly.
+func RegisterWebDavRoutes(rail miso.Rail) error {
+	if !miso.GetPropBool(PropWebDavEnabled) {
+		return nil
+	}
+	miso.PreProcessGin(func(rail miso.Rail, engine *gin.Engine) {
+		handler := func(c *gin.Context) {
+			ServeWebDav(miso.BuildRail(c), mysql.GetMySQL(), c.Writer, c.Request)
+		}
+		for _, m := range webDavMethods {
+			engine.Handle(m, WebDavPrefix, handler)
+			engine.Handle(m, WebDavPrefix+"/*path", handler)
+		}
+	})
+	rail.Infof("WebDAV enabled, prefix: %v", WebDavPrefix)
+	return nil
+}
+
+// Serve WebDAV request, user is authenticated using basic auth with user-vault's password or user key.
+//
+// The user must also be permitted to manage files, as the requests don't go through the gateway.
+func ServeWebDav(rail miso.Rail, db *gorm.DB, w http.ResponseWriter, r *http.Request) {
+	user, ok, err := webDavAuth(rail, r)
+	if err != nil {
+		rail.Errorf("Failed to authenticate WebDAV request, %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if !ok {
+		w.Header().Set("WWW-Authenticate", `Basic realm="vfm"`)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	permitted, err := CheckManageFilesAccess(rail, user.UserNo)
+	if err != nil {
+		rail.Errorf("Failed to check WebDAV user's access, userNo: %v, %v", user.UserNo, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if !permitted {
+		rail.Infof("WebDAV request rejected, user %v is not permitted to manage files", user.Username)
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	putLen := int64(-1)
+	if r.Method == http.MethodPut {
+		putLen = r.ContentLength
+	}
+	h := &webdav.Handler{
+		Prefix:     WebDavPrefix,
+		FileSystem: &davFs{rail: rail, db: db, user: user, putLen: putLen, cache: map[string]*davFileInfo{}},
+		LockSystem: webDavLockSystem,
+		Logger: func(r *http.Request, err error) {
+			if err != nil {
+				rail.Warnf("WebDAV %v %v failed, user: %v, %v", r.Method, r.URL.Path, user.Username, err)
+			}
+		},
+	}
+	h.ServeHTTP(w, r)
+}
+
+func webDavAuth(rail miso.Rail, r *http.Request) (common.User, bool, error) {
+	username, password, ok := r.BasicAuth()
+	if !ok || username == "" || password == "" {
+		return common.User{}, false, nil
+	}
+	sum := sha256.Sum256([]byte(username + ":" + password))
+	key := hex.EncodeToString(sum[:])
+	u, err := webDavUserCache.Get(rail, key, nil)
+	if err == nil {
+		return u, true, nil
+	}
+	if !errors.Is(err, miso.NoneErr) {
+		return common.User{}, false, err
+	}
+
+	u, ok, err = userVaultLogin(rail, username, password)
+	if err != nil || !ok {
+		if err == nil {
+			rail.Infof("WebDAV login rejected, username: %v", username)
+		}
+		return common.User{}, false, err
+	}
+	if err := webDavUserCache.Put(rail, key, u); err != nil {
+		rail.Errorf("Failed to cache WebDAV user, username: %v, %v", username, err)
+	}
+	return u, true, nil
+}
+
+// Login with user-vault, password can also be the user key, false is returned if the login is rejected.
+func userVaultLogin(rail miso.Rail, username string, password string) (common.User, bool, error) {
+	var lr miso.GnResp[string]
+	err := miso.NewDynTClient(rail, "/open/api/user/login", vault.ServiceName).
+		PostJson(map[string]string{"username": username, "password": password}).
+		Json(&lr)
+	if err != nil {
+		return common.User{}, false, fmt.Errorf("failed to login (user-vault), %v", err)
+	}
+	if lr.Error {
+		return common.User{}, false, nil
+	}
+
+	var ur miso.GnResp[struct {
+		UserNo   string `json:"userNo"`
+		Username string `json:"username"`
+		RoleNo   string `json:"roleNo"`
+	}]
+	err = miso.NewDynTClient(rail, "/open/api/token/user", vault.ServiceName).
+		AddQueryParams("token", lr.Data).
+		Get().
+		Json(&ur)
+	if err != nil {
+		return common.User{}, false, fmt.Errorf("failed to fetch token user (user-vault), %v", err)
+	}
+	if ur.Error {
+		return common.User{}, false, nil
+	}
+	return common.User{UserNo: ur.Data.UserNo, Username: ur.Data.Username, RoleNo: ur.Data.RoleNo}, true, nil
+}
+
+// os.FileInfo of the resource in WebDAV.
+type davFileInfo struct {
+	name     string
+	size     int64
+	modTime  time.Time
+	dir      bool
+	readOnly bool
+	file     *FileInfo // nil for virtual collections
+	folderNo string    // for vfolder collection
+}
+
+func (d *davFileInfo) Name() string       { return d.name }
+func (d *davFileInfo) Size() int64        { return d.size }
+func (d *davFileInfo) ModTime() time.Time { return d.modTime }
+func (d *davFileInfo) IsDir() bool        { return d.dir }
+func (d *davFileInfo) Sys() any           { return nil }
+
+func (d *davFileInfo) Mode() os.FileMode {
+	var m os.FileMode = 0644
+	if d.readOnly {
+		m = 0444
+	}
+	if d.dir {
+		m = os.ModeDir | 0755
+	}
+	return m
+}
+
+// Content type is the detected media type, or guessed by the file extension if it's not yet detected.
+func (d *davFileInfo) ContentType(ctx context.Context) (string, error) {
+	if d.file != nil && d.file.MimeType != "" && d.file.MimeType != MimeOctetStream {
+		return d.file.MimeType, nil
+	}
+	if ct := mime.TypeByExtension(path.Ext(d.name)); ct != "" {
+		return ct, nil
+	}
+	return "application/octet-stream", nil
+}
+
+// Whether it's a file_info record that can be modified.
+func (d *davFileInfo) writable() bool {
+	return d.file != nil && !d.readOnly
+}
+
+func newDavFileInfo(f FileInfo, readOnly bool) *davFileInfo {
+	return &davFileInfo{
+		name:     f.Name,
+		size:     f.SizeInBytes,
+		modTime:  f.UpdateTime.ToTime(),
+		dir:      f.FileType == FileTypeDir,
+		readOnly: readOnly,
+		file:     &f,
+	}
+}
+
+// webdav.FileSystem backed by file_info, created for each request.
+//
+// Paths are mapped to the parent_file hierarchy of user's files, vfolders are mapped to a read-only top-level collection.
+type davFs struct {
+	rail   miso.Rail
+	db     *gorm.DB
+	user   common.User
+	putLen int64 // content length of PUT request, -1 if unknown
+
+	// resolved resources, cleared whenever something is changed
+	cache map[string]*davFileInfo
+}
+
+func (d *davFs) Mkdir(ctx context.Context, name string, perm os.FileMode) error {
+	name = davCleanPath(name)
+	if _, err := d.stat(name); err == nil {
+		return os.ErrExist
+	}
+	parent, err := d.stat(path.Dir(name))
+	if err != nil {
+		return err
+	}
+	if !parent.dir {
+		return os.ErrNotExist
+	}
+	if parent.readOnly {
+		return os.ErrPermission
+	}
+	defer d.invalidate()
+	_, err = MakeDir(d.rail, d.db, MakeDirReq{ParentFile: parent.fileKey(), Name: path.Base(name)}, d.user)
+	return err
+}
+
+func (d *davFs) OpenFile(ctx context.Context, name string, flag int, perm os.FileMode) (webdav.File, error) {
+	name = davCleanPath(name)
+	if flag&(os.O_WRONLY|os.O_RDWR|os.O_CREATE|os.O_TRUNC|os.O_APPEND) == 0 {
+		fi, err := d.stat(name)
+		if err != nil {
+			return nil, err
+		}
+		return &davFile{fs: d, name: name, info: fi}, nil
+	}
+
+	// files are immutable in mini-fstore, opening file for writing always uploads a new file
+	if flag&os.O_APPEND != 0 || flag&os.O_TRUNC == 0 {
+		return nil, os.ErrPermission
+	}
+	parent, err := d.stat(path.Dir(name))
+	if err != nil {
+		return nil, err
+	}
+	if !parent.dir {
+		return nil, os.ErrNotExist
+	}
+	if parent.readOnly || name == "/"+WebDavVFolderDir {
+		return nil, os.ErrPermission
+	}
+	var prev *FileInfo
+	if fi, err := d.stat(name); err == nil {
+		if fi.dir || !fi.writable() {
+			return nil, os.ErrPermission
+		}
+		prev = fi.file
+	} else if flag&os.O_CREATE == 0 {
+		return nil, err
+	}
+	return &davUpload{fs: d, parentKey: parent.fileKey(), name: path.Base(name), prev: prev}, nil
+}
+
+func (d *davFs) RemoveAll(ctx context.Context, name string) error {
+	name = davCleanPath(name)
+	fi, err := d.stat(name)
+	if err != nil {
+		return err
+	}
+	if !fi.writable() {
+		return os.ErrPermission
+	}
+	defer d.invalidate()
+
+	req := DeleteFileReq{Uuid: fi.file.Uuid}
+	if fi.dir {
+		if err := TruncateDir(d.rail, d.db, req, d.user, false); err != nil {
+			return err
+		}
+	}
+	return DeleteFile(d.rail, d.db, req, d.user, nil)
+}
+
+func (d *davFs) Rename(ctx context.Context, oldName, newName string) error {
+	oldName = davCleanPath(oldName)
+	newName = davCleanPath(newName)
+	if strings.HasPrefix(newName, oldName+"/") {
+		return os.ErrPermission // moving dir into itself
+	}
+	src, err := d.stat(oldName)
+	if err != nil {
+		return err
+	}
+	if !src.writable() {
+		return os.ErrPermission
+	}
+	parent, err := d.stat(path.Dir(newName))
+	if err != nil {
+		return err
+	}
+	if !parent.dir {
+		return os.ErrNotExist
+	}
+	if parent.readOnly || newName == "/"+WebDavVFolderDir {
+		return os.ErrPermission
+	}
+	defer d.invalidate()
+
+	if src.file.ParentFile != parent.fileKey() {
+		err := MoveFileToDir(d.rail, d.db, MoveIntoDirReq{Uuid: src.file.Uuid, ParentFileUuid: parent.fileKey()}, d.user)
+		if err != nil {
+			return err
+		}
+	}
+	if name := path.Base(newName); name != src.name {
+		res, err := BatchRenameFiles(d.rail, d.db, BatchRenameFilesReq{
+			Mode:  BatchModeAllOrNothing,
+			Items: []BatchRenameItem{{FileKey: src.file.Uuid, Name: name}},
+		}, d.user)
+		if err != nil {
+			return err
+		}
+		return res.FirstErr()
+	}
+	return nil
+}
+
+func (d *davFs) Stat(ctx context.Context, name string) (os.FileInfo, error) {
+	return d.stat(davCleanPath(name))
+}
+
+func (d *davFs) invalidate() {
+	d.cache = map[string]*davFileInfo{}
+}
+
+func (d *davFs) stat(name string) (*davFileInfo, error) {
+	if fi, ok := d.cache[name]; ok {
+		return fi, nil
+	}
+	fi, err := d.resolve(name)
+	if err != nil {
+		return nil, err
+	}
+	d.cache[name] = fi
+	return fi, nil
+}
+
+func (d *davFs) resolve(name string) (*davFileInfo, error) {
+	if name == "/" {
+		return &davFileInfo{name: "/", dir: true, modTime: time.Now()}, nil
+	}
+	parentName := path.Dir(name)
+	base := path.Base(name)
+	if parentName == "/" && base == WebDavVFolderDir {
+		return &davFileInfo{name: WebDavVFolderDir, dir: true, readOnly: true, modTime: time.Now()}, nil
+	}
+
+	parent, err := d.stat(parentName)
+	if err != nil {
+		return nil, err
+	}
+	if !parent.dir {
+		return nil, os.ErrNotExist
+	}
+
+	var f FileInfo
+	var t *gorm.DB
+	switch {
+	case parent.folderNo != "": // file in vfolder
+		t = d.db.Raw(`SELECT fi.* FROM file_info fi
+			JOIN file_vfolder fv ON (fi.uuid = fv.uuid AND fv.is_del = 0)
+			WHERE fv.folder_no = ? AND fi.name = ? AND fi.file_type = ? AND fi.hidden = 0
+			AND fi.is_logic_deleted = 0 AND fi.is_del = 0
+			ORDER BY fi.id DESC LIMIT 1`, parent.folderNo, base, FileTypeFile).Scan(&f)
+	case parent.readOnly: // vfolder
+		var vf ListedVFolder
+		t = newListVFoldersQuery(d.rail, d.db, ListVFolderReq{}, d.user.UserNo).
+			Select("f.folder_no, f.name, f.update_time").
+			Where("f.name = ?", base).
+			Order("f.id DESC").
+			Limit(1).
+			Scan(&vf)
+		if t.Error != nil {
+			return nil, fmt.Errorf("failed to find vfolder, name: %v, %v", base, t.Error)
+		}
+		if t.RowsAffected < 1 {
+			return nil, os.ErrNotExist
+		}
+		return &davFileInfo{name: vf.Name, dir: true, readOnly: true, modTime: vf.UpdateTime.ToTime(), folderNo: vf.FolderNo}, nil
+	default:
+		cf, err := findChildFile(d.rail, d.db, parent.fileKey(), base, d.user.UserNo)
+		if err != nil {
+			return nil, err
+		}
+		if cf == nil {
+			return nil, os.ErrNotExist
+		}
+		return newDavFileInfo(*cf, false), nil
+	}
+	if t.Error != nil {
+		return nil, fmt.Errorf("failed to find file, name: %v, %v", name, t.Error)
+	}
+	if t.RowsAffected < 1 {
+		return nil, os.ErrNotExist
+	}
+	return newDavFileInfo(f, parent.readOnly), nil
+}
+
+// List resources in the collection, the latest one is returned if there are multiple files with the same name.
+func (d *davFs) readdir(dir *davFileInfo, dirName string) ([]os.FileInfo, error) {
+	var files []FileInfo
+	var err error
+	seen := util.NewSet[string]()
+	switch {
+	case dir.folderNo != "":
+		err = d.db.Raw(`SELECT fi.* FROM file_info fi
+			JOIN file_vfolder fv ON (fi.uuid = fv.uuid AND fv.is_del = 0)
+			WHERE fv.folder_no = ? AND fi.file_type = ? AND fi.hidden = 0 AND fi.is_logic_deleted = 0 AND fi.is_del = 0
+			ORDER BY fi.id DESC`, dir.folderNo, FileTypeFile).Scan(&files).Error
+	case dir.readOnly:
+		var vfolders []ListedVFolder
+		err = newListVFoldersQuery(d.rail, d.db, ListVFolderReq{}, d.user.UserNo).
+			Select("f.folder_no, f.name, f.update_time").
+			Order("f.id DESC").
+			Scan(&vfolders).Error
+		if err != nil {
+			return nil, fmt.Errorf("failed to list vfolders, %v", err)
+		}
+		res := make([]os.FileInfo, 0, len(vfolders))
+		for _, vf := range vfolders {
+			fi := &davFileInfo{name: vf.Name, dir: true, readOnly: true, modTime: vf.UpdateTime.ToTime(), folderNo: vf.FolderNo}
+			res = d.addDirEntry(res, &seen, dirName, fi)
+		}
+		return res, nil
+	default:
+		files, err = listChildFiles(d.rail, d.db, dir.fileKey(), d.user.UserNo)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to list files, dir: %v, %v", dirName, err)
+	}
+
+	res := make([]os.FileInfo, 0, len(files)+1)
+	if dirName == "/" {
+		res = d.addDirEntry(res, &seen, dirName, &davFileInfo{name: WebDavVFolderDir, dir: true, readOnly: true, modTime: time.Now()})
+	}
+	for _, f := range files {
+		res = d.addDirEntry(res, &seen, dirName, newDavFileInfo(f, dir.readOnly))
+	}
+	return res, nil
+}
+
+func (d *davFs) addDirEntry(entries []os.FileInfo, seen *util.Set[string], dirName string, fi *davFileInfo) []os.FileInfo {
+	if !seen.Add(fi.name) {
+		return entries // shadowed by the latest one with the same name
+	}
+	d.cache[path.Join(dirName, fi.name)] = fi
+	return append(entries, fi)
+}
+
+// Key of the file, empty for root collection.
+func (d *davFileInfo) fileKey() string {
+	if d.file == nil {
+		return ""
+	}
+	return d.file.Uuid
+}
+
+func davCleanPath(name string) string {
+	return path.Clean("/" + name)
+}
+
+// webdav.File for reading, file content is streamed from mini-fstore.
+type davFile struct {
+	fs       *davFs
+	name     string
+	info     *davFileInfo
+	offset   int64
+	body     io.ReadCloser
+	children []os.FileInfo
+	listed   bool
+}
+
+func (f *davFile) Read(p []byte) (int, error) {
+	if f.info.dir {
+		return 0, os.ErrInvalid
+	}
+	if f.offset >= f.info.size {
+		return 0, io.EOF
+	}
+	if f.body == nil {
+		body, err := openFstoreStream(f.fs.rail, f.info.file.FstoreFileId, f.info.name, f.offset)
+		if err != nil {
+			return 0, err
+		}
+		f.body = body
+	}
+	n, err := f.body.Read(p)
+	f.offset += int64(n)
+	return n, err
+}
+
+func (f *davFile) Seek(offset int64, whence int) (int64, error) {
+	var n int64
+	switch whence {
+	case io.SeekStart:
+		n = offset
+	case io.SeekCurrent:
+		n = f.offset + offset
+	case io.SeekEnd:
+		n = f.info.size + offset
+	default:
+		return 0, os.ErrInvalid
+	}
+	if n < 0 {
+		return 0, os.ErrInvalid
+	}
+	if n != f.offset && f.body != nil {
+		f.body.Close()
+		f.body = nil // reopened at the new offset on next read
+	}
+	f.offset = n
+	return n, nil
+}
+
+func (f *davFile) Readdir(count int) ([]os.FileInfo, error) {
+	if !f.info.dir {
+		return nil, os.ErrInvalid
+	}
+	if !f.listed {
+		children, err := f.fs.readdir(f.info, f.name)
+		if err != nil {
+			return nil, err
+		}
+		f.children = children
+		f.listed = true
+	}
+	if count <= 0 {
+		res := f.children
+		f.children = nil
+		return res, nil
+	}
+	if len(f.children) < 1 {
+		return nil, io.EOF
+	}
+	if count > len(f.children) {
+		count = len(f.children)
+	}
+	res := f.children[:count]
+	f.children = f.children[count:]
+	return res, nil
+}
+
+func (f *davFile) Stat() (os.FileInfo, error) {
+	return f.info, nil
+}
+
+func (f *davFile) Write(p []byte) (int, error) {
+	return 0, os.ErrPermission
+}
+
+func (f *davFile) Close() error {
+	if f.body != nil {
+		return f.body.Close()
+	}
+	return nil
+}
+
+// Stream file from mini-fstore starting from the offset.
+func openFstoreStream(rail miso.Rail, fileId string, name string, offset int64) (io.ReadCloser, error) {
+	tkn, err := GetFstoreTmpToken(rail, fileId, name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate mini-fstore temp token, fileId: %v, %v", fileId, err)
+	}
+	r := miso.NewDynTClient(rail, "/file/stream", "fstore").
+		UseClient(webDavFstoreClient).
+		AddQueryParams("key", tkn).
+		AddHeader("Range", fmt.Sprintf("bytes=%d-", offset)).
+		Get()
+	if r.Err != nil {
+		return nil, fmt.Errorf("failed to stream mini-fstore file, fileId: %v, %v", fileId, r.Err)
+	}
+	return r.Resp.Body, nil
+}
+
+// Upload file to mini-fstore, returns the uploadFileId.
+func uploadFstoreStream(rail miso.Rail, name string, dat io.Reader) (string, error) {
+	var res miso.GnResp[string]
+	err := miso.NewDynTClient(rail, "/file", "fstore").
+		UseClient(webDavFstoreClient).
+		AddHeaders(map[string]string{"filename": name}).
+		Put(dat).
+		Json(&res)
+	if err != nil {
+		return "", fmt.Errorf("failed to upload mini-fstore file, name: %v, %v", name, err)
+	}
+	return res.Res()
+}
+
+type davUploadResult struct {
+	uploadFileId string
+	err          error
+}
+
+// webdav.File for writing, content is streamed to mini-fstore, the file is created when it's closed.
+type davUpload struct {
+	fs        *davFs
+	parentKey string
+	name      string
+	prev      *FileInfo // file overwritten
+	size      int64
+	pw        *io.PipeWriter
+	done      chan davUploadResult
+}
+
+func (u *davUpload) start() {
+	pr, pw := io.Pipe()
+	u.pw = pw
+	u.done = make(chan davUploadResult, 1)
+	go func() {
+		id, err := uploadFstoreStream(u.fs.rail, u.name, pr)
+		pr.CloseWithError(io.ErrClosedPipe) // in case the upload failed halfway
+		u.done <- davUploadResult{uploadFileId: id, err: err}
+	}()
+}
+
+func (u *davUpload) Write(p []byte) (int, error) {
+	if u.pw == nil {
+		u.start()
+	}
+	n, err := u.pw.Write(p)
+	u.size += int64(n)
+	return n, err
+}
+
+func (u *davUpload) Close() error {
+	if u.pw == nil {
+		u.start()
+	}
+	if u.fs.putLen >= 0 && u.size != u.fs.putLen {
+		u.pw.CloseWithError(io.ErrUnexpectedEOF)
+		<-u.done
+		return fmt.Errorf("request body is incomplete, expected: %v, received: %v", u.fs.putLen, u.size)
+	}
+	u.pw.Close()
+	res := <-u.done
+	if res.err != nil {
+		return res.err
+	}
+
+	defer u.fs.invalidate()
+	_, err := CreateFile(u.fs.rail, u.fs.db, CreateFileReq{
+		Filename:         u.name,
+		FakeFstoreFileId: res.uploadFileId,
+		ParentFile:       u.parentKey,
+	}, u.fs.user)
+	if err != nil {
+		return err
+	}
+	if u.prev != nil {
+		if err := DeleteFile(u.fs.rail, u.fs.db, DeleteFileReq{Uuid: u.prev.Uuid}, u.fs.user, nil); err != nil {
+			u.fs.rail.Errorf("Failed to delete overwritten file %v, %v", u.prev.Uuid, err)
+		}
+	}
+	return nil
+}
+
+func (u *davUpload) Read(p []byte) (int, error) {
+	return 0, os.ErrPermission
+}
+
+func (u *davUpload) Seek(offset int64, whence int) (int64, error) {
+	return 0, os.ErrPermission
+}
+
+func (u *davUpload) Readdir(count int) ([]os.FileInfo, error) {
+	return nil, os.ErrInvalid
+}
+
+func (u *davUpload) Stat() (os.FileInfo, error) {
+	return &davFileInfo{name: u.name, size: u.size, modTime: time.Now()}, nil
+}
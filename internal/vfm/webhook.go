@@ -0,0 +1,560 @@
+package vfm
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/curtisnewbie/miso/encoding"
+	"github.com/curtisnewbie/miso/middleware/mysql"
+	"github.com/curtisnewbie/miso/middleware/rabbit"
+	"github.com/curtisnewbie/miso/middleware/redis"
+	"github.com/curtisnewbie/miso/middleware/user-vault/common"
+	"github.com/curtisnewbie/miso/miso"
+	"github.com/curtisnewbie/miso/util"
+	"gorm.io/gorm"
+)
+
+const (
+	PropWebhookMaxAttempts  = "vfm.webhook.max-attempts"
+	PropWebhookTimeout      = "vfm.webhook.timeout"       // in seconds
+	PropWebhookRetryBackoff = "vfm.webhook.retry-backoff" // base backoff in seconds, doubled on each attempt
+	PropWebhookAllowPrivate = "vfm.webhook.allow-private-network"
+
+	WebhookScopeUser    = "USER"    // all files of the user
+	WebhookScopeDir     = "DIR"     // files in the directory
+	WebhookScopeVFolder = "VFOLDER" // files in the vfolder
+
+	FileEvtCreated = "FILE_CREATED"
+	FileEvtMoved   = "FILE_MOVED"
+	FileEvtRenamed = "FILE_RENAMED"
+	FileEvtDeleted = "FILE_DELETED"
+
+	DeliveryPending = "PENDING"
+	DeliveryRetry   = "RETRY"
+	DeliverySuccess = "SUCCESS"
+	DeliveryFailed  = "FAILED"
+
+	HeaderWebhookEvent     = "X-Vfm-Event"
+	HeaderWebhookDelivery  = "X-Vfm-Delivery"
+	HeaderWebhookTimestamp = "X-Vfm-Timestamp"
+	HeaderWebhookSignature = "X-Vfm-Signature"
+)
+
+var (
+	webhookEvents = util.NewSet[string]()
+
+	WebhookDeliveryPipeline = rabbit.NewEventPipeline[WebhookDeliveryEvent]("event.bus.vfm.webhook.delivery").
+				MaxRetry(3)
+)
+
+func init() {
+	miso.SetDefProp(PropWebhookMaxAttempts, 6)
+	miso.SetDefProp(PropWebhookTimeout, 10)
+	miso.SetDefProp(PropWebhookRetryBackoff, 30)
+	miso.SetDefProp(PropWebhookAllowPrivate, false)
+	webhookEvents.AddAll([]string{FileEvtCreated, FileEvtMoved, FileEvtRenamed, FileEvtDeleted})
+}
+
+type CreateWebhookReq struct {
+	ScopeType string   `json:"scopeType" valid:"notEmpty" desc:"scope type: USER, DIR, VFOLDER"`
+	ScopeKey  string   `json:"scopeKey" desc:"directory file key or vfolder no, not needed for USER scope"`
+	Url       string   `json:"url" valid:"notEmpty" desc:"url that receives the POST request"`
+	Events    []string `json:"events" desc:"subscribed events: FILE_CREATED, FILE_MOVED, FILE_RENAMED, FILE_DELETED"`
+}
+
+type CreateWebhookRes struct {
+	WebhookNo string `json:"webhookNo"`
+	Secret    string `json:"secret" desc:"secret used to sign the payload, it's only returned once"`
+}
+
+func CreateWebhook(rail miso.Rail, db *gorm.DB, req CreateWebhookReq, user common.User) (CreateWebhookRes, error) {
+	var res CreateWebhookRes
+	if err := checkWebhookUrl(req.Url); err != nil {
+		return res, err
+	}
+	events, err := checkWebhookEvents(req.Events)
+	if err != nil {
+		return res, err
+	}
+
+	switch req.ScopeType {
+	case WebhookScopeUser:
+		req.ScopeKey = ""
+	case WebhookScopeDir:
+		f, err := findFile(rail, db, req.ScopeKey)
+		if err != nil {
+			return res, err
+		}
+		if f == nil || f.IsLogicDeleted == LDelY {
+			return res, miso.NewErrf("File not found")
+		}
+		if f.FileType != FileTypeDir {
+			return res, miso.NewErrf("Not a directory")
+		}
+		if f.UploaderNo != user.UserNo {
+			return res, miso.NewErrf("Not permitted")
+		}
+	case WebhookScopeVFolder:
+		if _, err := findVFolder(rail, db, req.ScopeKey, user.UserNo); err != nil {
+			return res, miso.NewErrf("VFolder not found").WithInternalMsg("%v", err)
+		}
+	default:
+		return res, miso.NewErrf("Illegal scope type")
+	}
+
+	res.WebhookNo = util.GenIdP("whk_")
+	res.Secret = util.ERand(32)
+	err = db.Exec(`INSERT INTO webhook (webhook_no, user_no, scope_type, scope_key, url, secret, events, create_by)
+		VALUES (?,?,?,?,?,?,?,?)`,
+		res.WebhookNo, user.UserNo, req.ScopeType, req.ScopeKey, req.Url, res.Secret, strings.Join(events, ","), user.Username).
+		Error
+	if err != nil {
+		return res, fmt.Errorf("failed to save webhook, %v", err)
+	}
+	rail.Infof("Webhook %v created by %v, scope: %v %v", res.WebhookNo, user.Username, req.ScopeType, req.ScopeKey)
+	return res, nil
+}
+
+type UpdateWebhookReq struct {
+	WebhookNo string   `json:"webhookNo" valid:"notEmpty"`
+	Url       string   `json:"url" valid:"notEmpty"`
+	Events    []string `json:"events"`
+	Enabled   bool     `json:"enabled"`
+}
+
+func UpdateWebhook(rail miso.Rail, db *gorm.DB, req UpdateWebhookReq, user common.User) error {
+	if err := checkWebhookUrl(req.Url); err != nil {
+		return err
+	}
+	events, err := checkWebhookEvents(req.Events)
+	if err != nil {
+		return err
+	}
+	t := db.Exec(`UPDATE webhook SET url = ?, events = ?, enabled = ?, update_by = ?
+		WHERE webhook_no = ? AND user_no = ? AND is_del = 0`,
+		req.Url, strings.Join(events, ","), req.Enabled, user.Username, req.WebhookNo, user.UserNo)
+	if t.Error != nil {
+		return fmt.Errorf("failed to update webhook, %v", t.Error)
+	}
+	if t.RowsAffected < 1 {
+		return miso.NewErrf("Webhook not found")
+	}
+	return nil
+}
+
+type DeleteWebhookReq struct {
+	WebhookNo string `json:"webhookNo" valid:"notEmpty"`
+}
+
+func DeleteWebhook(rail miso.Rail, db *gorm.DB, req DeleteWebhookReq, user common.User) error {
+	return db.Exec(`UPDATE webhook SET is_del = 1, update_by = ? WHERE webhook_no = ? AND user_no = ?`,
+		user.Username, req.WebhookNo, user.UserNo).Error
+}
+
+type ListWebhookReq struct {
+	Paging miso.Paging `json:"paging"`
+}
+
+type ListedWebhook struct {
+	WebhookNo  string     `json:"webhookNo"`
+	ScopeType  string     `json:"scopeType"`
+	ScopeKey   string     `json:"scopeKey"`
+	Url        string     `json:"url"`
+	Events     string     `json:"events" desc:"subscribed events, separated by comma"`
+	Enabled    bool       `json:"enabled"`
+	CreateTime util.ETime `json:"createTime"`
+	UpdateTime util.ETime `json:"updateTime"`
+}
+
+func ListWebhooks(rail miso.Rail, db *gorm.DB, req ListWebhookReq, user common.User) (miso.PageRes[ListedWebhook], error) {
+	return mysql.NewPageQuery[ListedWebhook]().
+		WithPage(req.Paging).
+		WithBaseQuery(func(tx *gorm.DB) *gorm.DB {
+			return tx.Table("webhook").Where("user_no = ? AND is_del = 0", user.UserNo)
+		}).
+		WithSelectQuery(func(tx *gorm.DB) *gorm.DB {
+			return tx.Select("webhook_no, scope_type, scope_key, url, events, enabled, create_time, update_time").
+				Order("id DESC")
+		}).
+		Exec(rail, db)
+}
+
+type ListWebhookDeliveryReq struct {
+	Paging    miso.Paging `json:"paging"`
+	WebhookNo string      `json:"webhookNo" valid:"notEmpty"`
+}
+
+type ListedWebhookDelivery struct {
+	DeliveryNo    string      `json:"deliveryNo"`
+	Event         string      `json:"event"`
+	Payload       string      `json:"payload"`
+	Status        string      `json:"status" desc:"PENDING, RETRY, SUCCESS, FAILED"`
+	Attempts      int         `json:"attempts"`
+	HttpStatus    int         `json:"httpStatus" desc:"http status of the last attempt"`
+	ErrMsg        string      `json:"errMsg" desc:"error message of the last attempt"`
+	NextRetryTime *util.ETime `json:"nextRetryTime"`
+	CreateTime    util.ETime  `json:"createTime"`
+	UpdateTime    util.ETime  `json:"updateTime"`
+}
+
+func ListWebhookDeliveries(rail miso.Rail, db *gorm.DB, req ListWebhookDeliveryReq, user common.User) (miso.PageRes[ListedWebhookDelivery], error) {
+	var id int
+	if err := db.Raw(`SELECT id FROM webhook WHERE webhook_no = ? AND user_no = ?`, req.WebhookNo, user.UserNo).
+		Scan(&id).Error; err != nil {
+		return miso.PageRes[ListedWebhookDelivery]{}, fmt.Errorf("failed to query webhook, %v", err)
+	}
+	if id < 1 {
+		return miso.PageRes[ListedWebhookDelivery]{}, miso.NewErrf("Webhook not found")
+	}
+
+	return mysql.NewPageQuery[ListedWebhookDelivery]().
+		WithPage(req.Paging).
+		WithBaseQuery(func(tx *gorm.DB) *gorm.DB {
+			return tx.Table("webhook_delivery").Where("webhook_no = ?", req.WebhookNo)
+		}).
+		WithSelectQuery(func(tx *gorm.DB) *gorm.DB {
+			return tx.Select("delivery_no, event, payload, status, attempts, http_status, err_msg, next_retry_time, create_time, update_time").
+				Order("id DESC")
+		}).
+		Exec(rail, db)
+}
+
+func checkWebhookUrl(u string) error {
+	pu, err := url.Parse(u)
+	if err != nil || (pu.Scheme != "http" && pu.Scheme != "https") || pu.Host == "" {
+		return miso.NewErrf("Illegal webhook url")
+	}
+
+	// the resolved addresses are checked again when the webhook is delivered
+	if !miso.GetPropBool(PropWebhookAllowPrivate) {
+		host := pu.Hostname()
+		if strings.EqualFold(host, "localhost") || strings.HasSuffix(strings.ToLower(host), ".localhost") {
+			return miso.NewErrf("Webhook url must not point to internal network")
+		}
+		if ip := net.ParseIP(host); ip != nil && !isPublicIP(ip) {
+			return miso.NewErrf("Webhook url must not point to internal network")
+		}
+	}
+	return nil
+}
+
+var (
+	// CGNAT range, not covered by net.IP.IsPrivate
+	_sharedAddrSpace = &net.IPNet{IP: net.IPv4(100, 64, 0, 0), Mask: net.CIDRMask(10, 32)}
+)
+
+// Whether the ip is a public address, i.e., not loopback, private, link-local (including cloud metadata), etc.
+func isPublicIP(ip net.IP) bool {
+	return !(ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() || ip.IsUnspecified() || _sharedAddrSpace.Contains(ip))
+}
+
+// Reject connections to non-public addresses, the check is done on the resolved address right before it's dialed,
+// so that it also covers redirects and DNS rebinding.
+func webhookDialControl(network string, address string, c syscall.RawConn) error {
+	if miso.GetPropBool(PropWebhookAllowPrivate) {
+		return nil
+	}
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || !isPublicIP(ip) {
+		return fmt.Errorf("connection to non-public address %v is not allowed", host)
+	}
+	return nil
+}
+
+func checkWebhookEvents(events []string) ([]string, error) {
+	events = util.Distinct(events)
+	if len(events) < 1 {
+		return nil, miso.NewErrf("Please select at least one event")
+	}
+	for _, e := range events {
+		if !webhookEvents.Has(e) {
+			return nil, miso.NewErrf("Illegal webhook event: %v", e)
+		}
+	}
+	return events, nil
+}
+
+// File lifecycle event derived from binlog, it triggers webhooks and domain events.
+type FileLifecycleEvent struct {
+	Event          string
+	FileKey        string
+	PrevParentFile string // parent file before the file is moved
+	PrevName       string // name before the file is renamed
+}
+
+type WebhookPayload struct {
+	DeliveryNo string          `json:"deliveryNo"`
+	WebhookNo  string          `json:"webhookNo"`
+	Event      string          `json:"event"`
+	Time       int64           `json:"time" desc:"when the event happened (epoch milliseconds)"`
+	File       WebhookFileInfo `json:"file"`
+}
+
+type WebhookFileInfo struct {
+	FileKey        string `json:"fileKey"`
+	Name           string `json:"name"`
+	FileType       string `json:"fileType"`
+	SizeInBytes    int64  `json:"sizeInBytes"`
+	ParentFile     string `json:"parentFile"`
+	PrevParentFile string `json:"prevParentFile,omitempty"`
+	PrevName       string `json:"prevName,omitempty"`
+	UploaderNo     string `json:"uploaderNo"`
+}
+
+type matchedWebhook struct {
+	WebhookNo string
+	Events    string
+}
+
+// Find webhooks that are interested in the file event, create webhook_delivery records and deliver them asynchronously.
+//
+// Failures are only logged, webhooks should never break the binlog listeners.
+func TriggerFileWebhooks(rail miso.Rail, db *gorm.DB, evt FileLifecycleEvent) {
+	f, err := findFile(rail, db, evt.FileKey)
+	if err != nil {
+		rail.Errorf("failed to find file, %v, %v", evt.FileKey, err)
+		return
+	}
+	if f == nil {
+		return
+	}
+
+	dirs := []string{}
+	if f.ParentFile != "" {
+		dirs = append(dirs, f.ParentFile)
+	}
+	if evt.PrevParentFile != "" {
+		dirs = append(dirs, evt.PrevParentFile)
+	}
+	if len(dirs) < 1 {
+		dirs = append(dirs, "") // avoid empty IN ()
+	}
+
+	var matched []matchedWebhook
+	err = db.Raw(`SELECT w.webhook_no, w.events FROM webhook w
+		WHERE w.is_del = 0 AND w.enabled = 1 AND (
+			(w.scope_type = ? AND w.user_no = ?)
+			OR (w.scope_type = ? AND w.scope_key IN ? AND w.user_no = ?)
+			OR (w.scope_type = ? AND w.scope_key IN (
+				SELECT fv.folder_no FROM file_vfolder fv
+				LEFT JOIN user_vfolder uv ON fv.folder_no = uv.folder_no
+				WHERE fv.uuid = ? AND fv.is_del = 0 AND uv.user_no = w.user_no AND uv.is_del = 0
+			))
+		)`,
+		WebhookScopeUser, f.UploaderNo,
+		WebhookScopeDir, dirs, f.UploaderNo,
+		WebhookScopeVFolder, f.Uuid).
+		Scan(&matched).Error
+	if err != nil {
+		rail.Errorf("failed to query webhooks for file %v, %v", f.Uuid, err)
+		return
+	}
+
+	now := time.Now()
+	for _, m := range matched {
+		if !webhookSubscribed(m.Events, evt.Event) {
+			continue
+		}
+		payload := WebhookPayload{
+			DeliveryNo: util.GenIdP("whd_"),
+			WebhookNo:  m.WebhookNo,
+			Event:      evt.Event,
+			Time:       now.UnixMilli(),
+			File: WebhookFileInfo{
+				FileKey:        f.Uuid,
+				Name:           f.Name,
+				FileType:       f.FileType,
+				SizeInBytes:    f.SizeInBytes,
+				ParentFile:     f.ParentFile,
+				PrevParentFile: evt.PrevParentFile,
+				PrevName:       evt.PrevName,
+				UploaderNo:     f.UploaderNo,
+			},
+		}
+		if err := createWebhookDelivery(rail, db, payload); err != nil {
+			rail.Errorf("failed to create webhook delivery, %+v, %v", payload, err)
+		}
+	}
+}
+
+func webhookSubscribed(events string, event string) bool {
+	for _, e := range strings.Split(events, ",") {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+func createWebhookDelivery(rail miso.Rail, db *gorm.DB, payload WebhookPayload) error {
+	body, err := encoding.SWriteJson(payload)
+	if err != nil {
+		return err
+	}
+	// the delivery is picked up by RetryWebhookDeliveries if the event is lost or not consumed before next_retry_time
+	err = db.Exec(`INSERT INTO webhook_delivery (delivery_no, webhook_no, event, payload, status, next_retry_time) VALUES (?,?,?,?,?,?)`,
+		payload.DeliveryNo, payload.WebhookNo, payload.Event, body, DeliveryPending, time.Now().Add(webhookBackoff(1))).Error
+	if err != nil {
+		return fmt.Errorf("failed to save webhook_delivery, %v", err)
+	}
+	return WebhookDeliveryPipeline.Send(rail, WebhookDeliveryEvent{DeliveryNo: payload.DeliveryNo})
+}
+
+type WebhookDeliveryEvent struct {
+	DeliveryNo string
+}
+
+func OnWebhookDeliveryEvent(rail miso.Rail, evt WebhookDeliveryEvent) error {
+	return DeliverWebhook(rail, mysql.GetMySQL(), evt.DeliveryNo)
+}
+
+type webhookDelivery struct {
+	DeliveryNo string
+	WebhookNo  string
+	Event      string
+	Payload    string
+	Status     string
+	Attempts   int
+	Url        string
+	Secret     string
+	Enabled    bool
+	IsDel      bool
+}
+
+// Deliver the webhook, the result of the attempt is recorded in webhook_delivery.
+//
+// If the attempt failed, the delivery is retried later by RetryWebhookDeliveries with exponential backoff.
+func DeliverWebhook(rail miso.Rail, db *gorm.DB, deliveryNo string) error {
+	return redis.RLockExec(rail, "vfm:webhook:delivery:"+deliveryNo, func() error {
+		var d webhookDelivery
+		t := db.Raw(`SELECT d.delivery_no, d.webhook_no, d.event, d.payload, d.status, d.attempts,
+				w.url, w.secret, w.enabled, w.is_del
+			FROM webhook_delivery d
+			LEFT JOIN webhook w ON d.webhook_no = w.webhook_no
+			WHERE d.delivery_no = ?`, deliveryNo).
+			Scan(&d)
+		if t.Error != nil {
+			return fmt.Errorf("failed to query webhook_delivery, %v, %v", deliveryNo, t.Error)
+		}
+		if t.RowsAffected < 1 {
+			rail.Warnf("Webhook delivery %v not found", deliveryNo)
+			return nil
+		}
+		if d.Status != DeliveryPending && d.Status != DeliveryRetry {
+			return nil
+		}
+		if d.IsDel || !d.Enabled {
+			return db.Exec(`UPDATE webhook_delivery SET status = ?, err_msg = ?, next_retry_time = NULL WHERE delivery_no = ?`,
+				DeliveryFailed, "Webhook disabled or deleted", deliveryNo).Error
+		}
+
+		attempts := d.Attempts + 1
+		httpStatus, err := postWebhook(rail, d.Url, d.Secret, d.Event, d.DeliveryNo, []byte(d.Payload))
+		if err == nil {
+			rail.Infof("Webhook %v delivered, deliveryNo: %v, attempts: %v", d.WebhookNo, deliveryNo, attempts)
+			return db.Exec(`UPDATE webhook_delivery SET status = ?, attempts = ?, http_status = ?, err_msg = '', next_retry_time = NULL
+				WHERE delivery_no = ?`, DeliverySuccess, attempts, httpStatus, deliveryNo).Error
+		}
+
+		rail.Warnf("Failed to deliver webhook %v, deliveryNo: %v, attempts: %v, %v", d.WebhookNo, deliveryNo, attempts, err)
+		errMsg := util.MaxLenStr(err.Error(), 1000)
+		if attempts >= miso.GetPropInt(PropWebhookMaxAttempts) {
+			return db.Exec(`UPDATE webhook_delivery SET status = ?, attempts = ?, http_status = ?, err_msg = ?, next_retry_time = NULL
+				WHERE delivery_no = ?`, DeliveryFailed, attempts, httpStatus, errMsg, deliveryNo).Error
+		}
+		nextRetry := time.Now().Add(webhookBackoff(attempts))
+		return db.Exec(`UPDATE webhook_delivery SET status = ?, attempts = ?, http_status = ?, err_msg = ?, next_retry_time = ?
+			WHERE delivery_no = ?`, DeliveryRetry, attempts, httpStatus, errMsg, nextRetry, deliveryNo).Error
+	})
+}
+
+// Exponential backoff based on the number of attempts made.
+func webhookBackoff(attempts int) time.Duration {
+	base := miso.GetPropDur(PropWebhookRetryBackoff, time.Second)
+	if attempts < 1 {
+		attempts = 1
+	}
+	if attempts > 10 {
+		attempts = 10
+	}
+	return base * time.Duration(1<<(attempts-1))
+}
+
+// Sign the webhook payload using HMAC-SHA256.
+//
+// The signed content is "${timestamp}.${body}", the signature is hex encoded and prefixed with "sha256=".
+//
+// Receivers should compute the signature the same way using the secret and compare it with the X-Vfm-Signature header.
+func SignWebhookPayload(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10)))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify webhook signature, receivers may use it to validate the requests.
+func VerifyWebhookSignature(secret string, timestamp int64, body []byte, signature string) bool {
+	return hmac.Equal([]byte(SignWebhookPayload(secret, timestamp, body)), []byte(signature))
+}
+
+func newWebhookClient() *http.Client {
+	dialer := &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second, Control: webhookDialControl}
+	return &http.Client{
+		Timeout: miso.GetPropDur(PropWebhookTimeout, time.Second),
+		Transport: &http.Transport{
+			DialContext:         dialer.DialContext,
+			TLSHandshakeTimeout: 10 * time.Second,
+		},
+	}
+}
+
+func postWebhook(rail miso.Rail, url string, secret string, event string, deliveryNo string, body []byte) (int, error) {
+	ts := time.Now().Unix()
+	r := miso.NewTClient(rail, url).
+		UseClient(newWebhookClient()).
+		Require2xx().
+		SetContentType("application/json").
+		AddHeader(HeaderWebhookEvent, event).
+		AddHeader(HeaderWebhookDelivery, deliveryNo).
+		AddHeader(HeaderWebhookTimestamp, strconv.FormatInt(ts, 10)).
+		AddHeader(HeaderWebhookSignature, SignWebhookPayload(secret, ts, body)).
+		PostBytes(body)
+	if r.Resp != nil {
+		defer r.Close()
+	}
+	return r.StatusCode, r.Err
+}
+
+// Retry webhook deliveries that are due, including pending ones that are never attempted (e.g., the event is lost).
+func RetryWebhookDeliveries(rail miso.Rail, db *gorm.DB) error {
+	var deliveryNos []string
+	err := db.Raw(`SELECT delivery_no FROM webhook_delivery
+		WHERE status IN ? AND next_retry_time <= ?
+		ORDER BY id ASC LIMIT 100`,
+		[]string{DeliveryPending, DeliveryRetry}, time.Now()).
+		Scan(&deliveryNos).Error
+	if err != nil {
+		return fmt.Errorf("failed to query webhook_delivery for retry, %v", err)
+	}
+	for _, dn := range deliveryNos {
+		if err := DeliverWebhook(rail, db, dn); err != nil {
+			rail.Errorf("failed to retry webhook delivery, %v, %v", dn, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,126 @@
+package vfm
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/curtisnewbie/miso/miso"
+)
+
+func TestPostWebhook(t *testing.T) {
+	miso.SetProp(PropWebhookAllowPrivate, true)
+	defer miso.SetProp(PropWebhookAllowPrivate, false)
+	rail := miso.EmptyRail()
+	secret := "test-secret"
+	body := []byte(`{"event":"FILE_CREATED"}`)
+
+	var received []byte
+	var header http.Header
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received, _ = io.ReadAll(r.Body)
+		header = r.Header
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	status, err := postWebhook(rail, srv.URL, secret, FileEvtCreated, "whd_123", body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status != http.StatusNoContent {
+		t.Fatalf("unexpected status: %v", status)
+	}
+	if string(received) != string(body) {
+		t.Fatalf("unexpected body: %s", received)
+	}
+	if header.Get(HeaderWebhookEvent) != FileEvtCreated {
+		t.Fatalf("unexpected event header: %v", header.Get(HeaderWebhookEvent))
+	}
+	if header.Get(HeaderWebhookDelivery) != "whd_123" {
+		t.Fatalf("unexpected delivery header: %v", header.Get(HeaderWebhookDelivery))
+	}
+	ts, err := strconv.ParseInt(header.Get(HeaderWebhookTimestamp), 10, 64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !VerifyWebhookSignature(secret, ts, received, header.Get(HeaderWebhookSignature)) {
+		t.Fatalf("signature mismatch: %v", header.Get(HeaderWebhookSignature))
+	}
+	if VerifyWebhookSignature("other-secret", ts, received, header.Get(HeaderWebhookSignature)) {
+		t.Fatal("signature should not match with a different secret")
+	}
+}
+
+func TestPostWebhookNon2xx(t *testing.T) {
+	miso.SetProp(PropWebhookAllowPrivate, true)
+	defer miso.SetProp(PropWebhookAllowPrivate, false)
+	rail := miso.EmptyRail()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer srv.Close()
+
+	status, err := postWebhook(rail, srv.URL, "secret", FileEvtDeleted, "whd_456", []byte(`{}`))
+	if err == nil {
+		t.Fatal("should fail for non 2xx response")
+	}
+	if status != http.StatusInternalServerError {
+		t.Fatalf("unexpected status: %v", status)
+	}
+}
+
+func TestWebhookBackoff(t *testing.T) {
+	base := miso.GetPropDur(PropWebhookRetryBackoff, time.Second)
+	if d := webhookBackoff(1); d != base {
+		t.Fatalf("unexpected backoff: %v", d)
+	}
+	if d := webhookBackoff(3); d != base*4 {
+		t.Fatalf("unexpected backoff: %v", d)
+	}
+}
+
+func TestWebhookSubscribed(t *testing.T) {
+	if !webhookSubscribed("FILE_CREATED,FILE_MOVED", FileEvtMoved) {
+		t.Fatal("should be subscribed")
+	}
+	if webhookSubscribed("FILE_CREATED,FILE_MOVED", FileEvtDeleted) {
+		t.Fatal("should not be subscribed")
+	}
+}
+
+func TestPostWebhookPrivateNetwork(t *testing.T) {
+	rail := miso.EmptyRail()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Fatal("request to loopback address should be rejected")
+	}))
+	defer srv.Close()
+
+	if _, err := postWebhook(rail, srv.URL, "secret", FileEvtCreated, "whd_789", []byte(`{}`)); err == nil {
+		t.Fatal("should fail for loopback address")
+	}
+}
+
+func TestCheckWebhookUrl(t *testing.T) {
+	for u, ok := range map[string]bool{
+		"https://example.com/hook":          true,
+		"http://93.184.216.34:8080/hook":    true,
+		"ftp://example.com":                 false,
+		"http://localhost:8080/hook":        false,
+		"http://127.0.0.1/hook":             false,
+		"http://10.0.0.1/hook":              false,
+		"http://192.168.1.1/hook":           false,
+		"http://169.254.169.254/latest":     false,
+		"http://100.64.0.1/hook":            false,
+		"http://[::1]/hook":                 false,
+		"http://[fd00:ec2::254]/hook":       false,
+		"http://[::ffff:127.0.0.1]:80/hook": false,
+	} {
+		if err := checkWebhookUrl(u); (err == nil) != ok {
+			t.Errorf("%v: want ok: %v, got %v", u, ok, err)
+		}
+	}
+}